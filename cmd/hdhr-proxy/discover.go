@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/attaebra/hdhr-proxy/internal/discovery"
+	"github.com/attaebra/hdhr-proxy/internal/utils"
+)
+
+// runDiscover implements "hdhr-proxy discover", which lists the HDHomeRun
+// devices answering on the LAN, and returns the exit code.
+func runDiscover(args []string) int {
+	flags := flag.NewFlagSet("discover", flag.ContinueOnError)
+	timeout := flags.Duration("timeout", discovery.DefaultTimeout, "How long to wait for devices to answer")
+	deviceID := flags.String("device-id", "", "Only list the device with this ID")
+	address := flags.String("address", "", "Send the request to this host:port instead of broadcasting")
+	asJSON := flags.Bool("json", false, "Print the devices as JSON")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	opts := discovery.Options{Timeout: *timeout, Client: utils.HTTPClient(*timeout)}
+	if *address != "" {
+		opts.Addresses = []string{*address}
+	}
+	devices, err := discovery.Discover(context.Background(), opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Discovery failed: %v\n", err)
+		return 1
+	}
+
+	if *deviceID != "" {
+		var matching []discovery.Device
+		for _, device := range devices {
+			if strings.EqualFold(device.ID, *deviceID) {
+				matching = append(matching, device)
+			}
+		}
+		devices = matching
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(devices)
+	} else if len(devices) > 0 {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "DEVICE ID\tADDRESS\tMODEL\tFIRMWARE\tTUNERS")
+		for _, d := range devices {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\n", d.ID, d.Address, d.Model, d.Firmware, d.TunerCount)
+		}
+		w.Flush()
+	}
+
+	if len(devices) == 0 {
+		fmt.Fprintln(os.Stderr, "No HDHomeRun found")
+		return 1
+	}
+	return 0
+}
@@ -6,14 +6,12 @@ import (
 	"context"
 	"flag"
 	"fmt"
-	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
 	"github.com/attaebra/hdhr-proxy/internal/config"
-	"github.com/attaebra/hdhr-proxy/internal/constants"
 	"github.com/attaebra/hdhr-proxy/internal/container"
 	"github.com/attaebra/hdhr-proxy/internal/logger"
 )
@@ -26,12 +24,16 @@ var (
 )
 
 func main() {
-	// Parse command line arguments.
-	hdhrIP := flag.String("hdhr-ip", "", "IP address of the HDHomeRun device")
-	appPort := flag.Int("app-port", constants.DefaultAPIPort, "Port for the API server")
-	mediaPort := flag.Int("media-port", constants.DefaultMediaPort, "Port for the media server (MUST be 5004 for client compatibility)")
-	ffmpegPath := flag.String("ffmpeg", "/usr/bin/ffmpeg", "Path to the FFmpeg binary")
-	logLevel := flag.String("log-level", "info", "Logging level: error, warn, info, debug")
+	// "hdhr-proxy discover" lists the devices on the LAN
+	if len(os.Args) > 1 && os.Args[1] == "discover" {
+		os.Exit(runDiscover(os.Args[2:]))
+	}
+
+	// Parse command line arguments. Every configuration key has a flag
+	// (e.g. --hdhr-ip, --max-total-streams, --ffmpeg-audio-bitrate).
+	configFlags := config.RegisterFlags(flag.CommandLine)
+	configFile := flag.String("config", os.Getenv(config.EnvConfigFile), "Path to a YAML config file")
+	printConfig := flag.Bool("print-config", false, "Print the effective configuration and exit")
 	showVersion := flag.Bool("version", false, "Show version information and exit")
 	flag.Parse()
 
@@ -43,14 +45,23 @@ func main() {
 		return
 	}
 
-	// Create configuration with defaults
-	cfg := config.DefaultConfig()
-
-	// Load configuration from command line flags
-	cfg.LoadFromFlags(hdhrIP, appPort, mediaPort, ffmpegPath, logLevel)
+	// Build the configuration: defaults < config file < environment < flags
+	cfg, err := config.Load(*configFile, configFlags)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Configuration error: %v\n", err)
+		os.Exit(2)
+	}
 
-	// Load configuration from environment variables
-	cfg.LoadFromEnvironment()
+	// Handle print-config flag
+	if *printConfig {
+		data, err := cfg.YAML()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to render configuration: %v\n", err)
+			os.Exit(1)
+		}
+		os.Stdout.Write(data)
+		return
+	}
 
 	// Set the logging level and initialize structured logger
 	logger.SetLevel(logger.LevelFromString(cfg.LogLevel))
@@ -80,37 +91,71 @@ func main() {
 		logger.Int("media_port", cfg.MediaPort),
 		logger.String("ffmpeg_path", cfg.FFmpegPath))
 
-	// Get servers from container
-	apiServer := container.GetAPIServer()
-	mediaServer := container.GetMediaServer()
+	// Start the HTTP servers, and the HTTPS servers when enabled
+	serveErrors := container.Start()
+
+	// Watch the config file for changes when enabled.
+	watchCtx, stopWatching := context.WithCancel(context.Background())
+	defer stopWatching()
+	fileChanged := make(chan struct{}, 1)
+	if *configFile != "" && cfg.ConfigWatchInterval > 0 {
+		logger.Info("👀 Watching config file for changes",
+			logger.String("path", *configFile),
+			logger.Duration("interval", cfg.ConfigWatchInterval))
+		go config.WatchFile(watchCtx, *configFile, cfg.ConfigWatchInterval, func() {
+			select {
+			case fileChanged <- struct{}{}:
+			default:
+			}
+		})
+	}
 
-	// Start the API server.
-	go func() {
-		logger.Info("🌐 Starting API server", logger.Int("port", cfg.APIPort))
-		if err := apiServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			logger.Fatal("❌ Error starting API server", logger.ErrorField("error", err))
-		}
-	}()
+	// Set up signal handling: SIGHUP reloads the configuration, SIGTERM drains
+	// before shutting down and SIGINT shuts down right away.
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
-	// Start the media server.
-	go func() {
-		logger.Info("📺 Starting media server", logger.Int("port", cfg.MediaPort))
-		if err := mediaServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			logger.Fatal("❌ Error starting media server", logger.ErrorField("error", err))
+	reload := func(reason string) {
+		logger.Info("🔄 Reloading configuration", logger.String("reason", reason))
+		updated, err := config.Load(*configFile, configFlags)
+		if err == nil {
+			err = updated.Validate()
+		}
+		if err != nil {
+			logger.Error("❌ Configuration reload failed, keeping current settings", logger.ErrorField("error", err))
+			return
 		}
-	}()
+		container.Reload(updated)
+	}
+
+	if drain := waitForShutdown(sigChan, fileChanged, serveErrors, container.Draining(), reload); drain {
+		container.StartDrain()
+		drainCtx, stopDraining := context.WithCancel(context.Background())
+		go func() {
+			// A second SIGINT or SIGTERM ends the drain early
+			for {
+				select {
+				case sig := <-sigChan:
+					if sig != syscall.SIGHUP {
+						logger.Warn("⏩ Drain interrupted, shutting down now")
+						stopDraining()
+						return
+					}
+				case <-drainCtx.Done():
+					return
+				}
+			}
+		}()
+		container.WaitForDrain(drainCtx)
+		stopDraining()
+	}
+
+	logger.Info("🛑 Graceful shutdown initiated...")
 
 	// Create a context for graceful shutdown.
 	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer shutdownCancel()
 
-	// Set up signal handling for graceful shutdown.
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	<-sigChan
-
-	logger.Info("🛑 Graceful shutdown initiated...")
-
 	// Gracefully shut down all components
 	if err := container.Shutdown(shutdownCtx); err != nil {
 		logger.Error("❌ Error during shutdown", logger.ErrorField("error", err))
@@ -118,3 +163,30 @@ func main() {
 
 	logger.Info("👋 HDHR Proxy shutdown complete - Goodbye!")
 }
+
+// waitForShutdown blocks until the proxy should exit, reloading the
+// configuration on SIGHUP or when the watched config file changes. It reports
+// whether to drain first, as for SIGTERM or a drain started through the admin
+// API, rather than stop right away for SIGINT. A server that fails to start
+// is fatal.
+func waitForShutdown(sigChan <-chan os.Signal, fileChanged <-chan struct{}, serveErrors <-chan error, draining <-chan struct{}, reload func(reason string)) bool {
+	for {
+		select {
+		case err := <-serveErrors:
+			logger.Fatal("❌ Error starting server", logger.ErrorField("error", err))
+		case <-draining:
+			return true
+		case sig := <-sigChan:
+			switch sig {
+			case syscall.SIGHUP:
+				reload("SIGHUP")
+			case syscall.SIGTERM:
+				return true
+			default:
+				return false
+			}
+		case <-fileChanged:
+			reload("config file changed")
+		}
+	}
+}
@@ -0,0 +1,159 @@
+// Package main runs a simulated HDHomeRun device, for trying the proxy and
+// its clients without tuner hardware.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/attaebra/hdhr-proxy/internal/discovery"
+	"github.com/attaebra/hdhr-proxy/internal/hdhrsim"
+	"github.com/attaebra/hdhr-proxy/internal/logger"
+)
+
+func main() {
+	listen := flag.String("listen", ":80", "Address for the device API (discover.json, lineup.json)")
+	mediaListen := flag.String("media-listen", ":5004", "Address for streams (/auto/v{channel})")
+	deviceID := flag.String("device-id", "1050ABCD", "Device ID reported in discover.json")
+	tuners := flag.Int("tuners", 4, "Number of tuners")
+	channels := flag.String("channels", "", "Comma-separated lineup as number:name:audio_codec, e.g. 5.1:NBC:AC4,7.1:ABC:AC3 (default 5.1 and 7.1)")
+	fixture := flag.String("fixture", "", "TS file looped for every channel (default a synthetic stream)")
+	fixtureDir := flag.String("fixture-dir", "", "Directory of <channel>.ts files, e.g. 5.1.ts, overriding --fixture per channel")
+	bitrate := flag.Int("bitrate", 2_500_000, "Bytes per second streamed, 0 for unlimited")
+	busy := flag.Bool("busy", false, "Answer every stream request with 503 all tuners in use")
+	startDelay := flag.Duration("start-delay", 0, "Delay before each stream's first bytes")
+	disconnectAfter := flag.Int64("disconnect-after", 0, "Drop each stream after this many bytes, 0 to never")
+	discoveryListen := flag.String("discovery-listen", "", "UDP address to answer HDHomeRun discovery on, e.g. :65001 (default disabled)")
+	logLevel := flag.String("log-level", "info", "Logging level (debug, info, warn, error)")
+	flag.Parse()
+
+	logger.SetLevel(logger.LevelFromString(*logLevel))
+
+	lineup, err := parseChannels(*channels, *fixtureDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid --channels: %v\n", err)
+		os.Exit(2)
+	}
+
+	_, mediaPort, err := net.SplitHostPort(*mediaListen)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid --media-listen: %v\n", err)
+		os.Exit(2)
+	}
+	port, _ := strconv.Atoi(mediaPort)
+
+	sim, err := hdhrsim.New(hdhrsim.Options{
+		DeviceID:   *deviceID,
+		TunerCount: *tuners,
+		Channels:   lineup,
+		Fixture:    *fixture,
+		MediaPort:  port,
+		Bitrate:    *bitrate,
+	})
+	if err != nil {
+		logger.Fatal("❌ Failed to create simulator", logger.ErrorField("error", err))
+	}
+	sim.SetAllTunersBusy(*busy)
+	sim.SetStartDelay(*startDelay)
+	sim.SetDisconnectAfter(*disconnectAfter)
+
+	servers := []*http.Server{
+		{Addr: *listen, Handler: sim, ReadHeaderTimeout: 10 * time.Second},
+		{Addr: *mediaListen, Handler: sim, ReadHeaderTimeout: 10 * time.Second},
+	}
+	for _, server := range servers {
+		go func() {
+			logger.Info("📡 Simulated HDHomeRun listening",
+				logger.String("address", server.Addr),
+				logger.String("device_id", *deviceID),
+				logger.Int("tuners", *tuners))
+			if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.Fatal("❌ Error starting simulator", logger.ErrorField("error", err))
+			}
+		}()
+	}
+
+	if *discoveryListen != "" {
+		conn, err := net.ListenPacket("udp4", *discoveryListen)
+		if err != nil {
+			logger.Fatal("❌ Failed to listen for discovery", logger.ErrorField("error", err))
+		}
+		defer conn.Close()
+		device := discovery.Device{ID: *deviceID, TunerCount: *tuners, BaseURL: advertisedURL(*listen)}
+		go func() {
+			if err := discovery.Respond(conn, device); err != nil {
+				logger.Error("❌ Discovery responder failed", logger.ErrorField("error", err))
+			}
+		}()
+		logger.Info("🔍 Answering discovery", logger.String("address", conn.LocalAddr().String()))
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	<-sigChan
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, server := range servers {
+		server.Shutdown(ctx)
+	}
+}
+
+// advertisedURL is the base URL announced in discovery replies. Without a
+// host in listen, clients use the address the reply came from, which only
+// works on port 80.
+func advertisedURL(listen string) string {
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil || host == "" {
+		return ""
+	}
+	return "http://" + net.JoinHostPort(host, port)
+}
+
+// parseChannels reads the --channels lineup, taking each channel's fixture
+// from fixtureDir when a matching file exists.
+func parseChannels(value, fixtureDir string) ([]hdhrsim.Channel, error) {
+	var channels []hdhrsim.Channel
+	if value == "" {
+		channels = append(channels, hdhrsim.DefaultChannels...)
+	}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		fields := strings.Split(entry, ":")
+		if len(fields) > 3 || fields[0] == "" {
+			return nil, fmt.Errorf("%q is not number[:name[:audio_codec]]", entry)
+		}
+		ch := hdhrsim.Channel{GuideNumber: fields[0], GuideName: "Channel " + fields[0], HD: true}
+		if len(fields) > 1 {
+			ch.GuideName = fields[1]
+		}
+		if len(fields) > 2 {
+			ch.AudioCodec = strings.ToUpper(fields[2])
+		}
+		channels = append(channels, ch)
+	}
+
+	if fixtureDir != "" {
+		for i := range channels {
+			path := filepath.Join(fixtureDir, channels[i].GuideNumber+".ts")
+			if _, err := os.Stat(path); err == nil {
+				channels[i].Fixture = path
+			}
+		}
+	}
+	return channels, nil
+}
@@ -0,0 +1,135 @@
+// Package access guards the proxy's HTTP endpoints with a client IP allowlist
+// and tokens.
+package access
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+
+	"github.com/attaebra/hdhr-proxy/internal/interfaces"
+	"github.com/attaebra/hdhr-proxy/internal/logger"
+	"github.com/attaebra/hdhr-proxy/internal/utils"
+)
+
+// Allowlist holds the networks allowed to reach a server. An empty allowlist
+// admits every client.
+type Allowlist []*net.IPNet
+
+// NewAllowlist parses IP addresses and CIDR ranges. Invalid entries are
+// skipped; config.Validate reports them.
+func NewAllowlist(entries []string) Allowlist {
+	var allowlist Allowlist
+	for _, entry := range entries {
+		if network := ParseNetwork(entry); network != nil {
+			allowlist = append(allowlist, network)
+		}
+	}
+	return allowlist
+}
+
+// Allows reports whether the client IP is on the allowlist.
+func (a Allowlist) Allows(clientIP string) bool {
+	if len(a) == 0 {
+		return true
+	}
+	ip := net.ParseIP(clientIP)
+	if ip == nil {
+		return false
+	}
+	for _, network := range a {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseNetwork parses an IP address or CIDR range into a network, or returns
+// nil if s is neither.
+func ParseNetwork(s string) *net.IPNet {
+	if _, network, err := net.ParseCIDR(s); err == nil {
+		return network
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil
+	}
+	bits := 128
+	if ip.To4() != nil {
+		ip = ip.To4()
+		bits = 32
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+}
+
+// RestrictNetworks answers 403 Forbidden to clients outside the allowlist.
+func RestrictNetworks(next http.Handler, allowlist Allowlist, server string, log interfaces.Logger) http.Handler {
+	if len(allowlist) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !allowlist.Allows(utils.ClientIP(r)) {
+			reject(r, server, "client not in allowed networks", log)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// OpenPaths serves requests for the given paths with open and every other
+// request with next, exempting endpoints such as health checks from the
+// restrictions wrapped around next.
+func OpenPaths(next, open http.Handler, paths ...string) http.Handler {
+	exempt := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		exempt[path] = true
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if exempt[r.URL.Path] {
+			open.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireToken answers 401 Unauthorized to requests that do not carry one of
+// tokens as a bearer token or "token" query parameter. With no tokens every
+// request is refused.
+func RequireToken(next http.Handler, tokens []string, realm string, log interfaces.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !validToken(utils.RequestToken(r), tokens) {
+			reject(r, realm, "missing or invalid token", log)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+realm+`"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validToken compares the token against each accepted token in constant time.
+func validToken(token string, tokens []string) bool {
+	if token == "" {
+		return false
+	}
+	valid := false
+	for _, accepted := range tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(accepted)) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
+
+// reject logs a refused request.
+func reject(r *http.Request, scope, reason string, log interfaces.Logger) {
+	log.Warn("🔒 Rejected request",
+		logger.String("scope", scope),
+		logger.String("reason", reason),
+		logger.String("method", r.Method),
+		logger.String("path", r.URL.Path),
+		logger.String("client_ip", utils.ClientIP(r)))
+}
@@ -0,0 +1,91 @@
+package access
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/attaebra/hdhr-proxy/internal/logger"
+)
+
+var ok = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+	w.WriteHeader(http.StatusOK)
+})
+
+func serve(h http.Handler, remoteAddr, target, bearer string) int {
+	req := httptest.NewRequest("GET", target, nil)
+	req.RemoteAddr = remoteAddr
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req)
+	return recorder.Code
+}
+
+func TestAllowlist(t *testing.T) {
+	allowlist := NewAllowlist([]string{"192.168.1.0/24", "10.0.0.5", "fd00::/8", "bogus"})
+	if len(allowlist) != 3 {
+		t.Fatalf("Expected invalid entries to be skipped, got %d networks", len(allowlist))
+	}
+
+	cases := map[string]bool{
+		"192.168.1.77": true,
+		"10.0.0.5":     true,
+		"10.0.0.6":     false,
+		"fd00::1":      true,
+		"not-an-ip":    false,
+	}
+	for ip, want := range cases {
+		if got := allowlist.Allows(ip); got != want {
+			t.Errorf("Allows(%q) = %v, want %v", ip, got, want)
+		}
+	}
+
+	if !Allowlist(nil).Allows("203.0.113.9") {
+		t.Error("Expected an empty allowlist to admit everyone")
+	}
+}
+
+func TestRestrictNetworks(t *testing.T) {
+	log := logger.NewZapLogger(logger.LevelDebug)
+	h := RestrictNetworks(ok, NewAllowlist([]string{"192.168.1.0/24"}), "media", log)
+
+	if code := serve(h, "192.168.1.20:5000", "/auto/v5.1", ""); code != http.StatusOK {
+		t.Errorf("Expected allowed client to pass, got %d", code)
+	}
+	if code := serve(h, "203.0.113.9:5000", "/auto/v5.1", ""); code != http.StatusForbidden {
+		t.Errorf("Expected 403 for a client outside the allowlist, got %d", code)
+	}
+}
+
+func TestOpenPaths(t *testing.T) {
+	log := logger.NewZapLogger(logger.LevelDebug)
+	restricted := RestrictNetworks(ok, NewAllowlist([]string{"192.168.1.0/24"}), "api", log)
+	h := OpenPaths(restricted, ok, "/healthz")
+
+	if code := serve(h, "127.0.0.1:5000", "/healthz", ""); code != http.StatusOK {
+		t.Errorf("Expected an open path to admit every client, got %d", code)
+	}
+	if code := serve(h, "127.0.0.1:5000", "/discover.json", ""); code != http.StatusForbidden {
+		t.Errorf("Expected other paths to stay restricted, got %d", code)
+	}
+}
+
+func TestRequireToken(t *testing.T) {
+	log := logger.NewZapLogger(logger.LevelDebug)
+	h := RequireToken(ok, []string{"living-room", "bedroom"}, "media", log)
+
+	if code := serve(h, "192.168.1.20:5000", "/auto/v5.1", "bedroom"); code != http.StatusOK {
+		t.Errorf("Expected bearer token to pass, got %d", code)
+	}
+	if code := serve(h, "192.168.1.20:5000", "/auto/v5.1?token=living-room", ""); code != http.StatusOK {
+		t.Errorf("Expected query token to pass, got %d", code)
+	}
+	if code := serve(h, "192.168.1.20:5000", "/auto/v5.1?token=wrong", ""); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a wrong token, got %d", code)
+	}
+	if code := serve(RequireToken(ok, nil, "admin", log), "192.168.1.20:5000", "/", "anything"); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 when no tokens are configured, got %d", code)
+	}
+}
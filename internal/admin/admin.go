@@ -0,0 +1,97 @@
+// Package admin provides the authenticated REST API for controlling active streams.
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/attaebra/hdhr-proxy/internal/access"
+	"github.com/attaebra/hdhr-proxy/internal/constants"
+	"github.com/attaebra/hdhr-proxy/internal/interfaces"
+	"github.com/attaebra/hdhr-proxy/internal/logger"
+	"github.com/attaebra/hdhr-proxy/internal/utils"
+)
+
+// Realm names the admin credentials in WWW-Authenticate challenges.
+const Realm = "hdhr-proxy admin"
+
+// Handler returns the admin API:
+//
+//	GET    /admin/sessions         list active sessions
+//	GET    /admin/sessions/{id}    session details with recent FFmpeg output
+//	DELETE /admin/sessions/{id}    stop a session's stream
+//	POST   /admin/lineup/refresh   re-read the channel lineup from the device
+//	POST   /admin/stop-all         stop every active stream
+//	GET    /admin/drain            drain state
+//	POST   /admin/drain            refuse new streams and exit once the running ones end
+//
+// The drain endpoints are only served when drainer is not nil. Every request
+// must carry token as a bearer token or a "token" query parameter.
+func Handler(streams interfaces.StreamAdmin, drainer interfaces.Drainer, token string, log interfaces.Logger) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /admin/sessions", func(w http.ResponseWriter, _ *http.Request) {
+		_ = utils.WriteJSONResponse(w, streams.Sessions())
+	})
+
+	mux.HandleFunc("GET /admin/sessions/{id}", func(w http.ResponseWriter, r *http.Request) {
+		info, ok := streams.Session(r.PathValue("id"))
+		if !ok {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		_ = utils.WriteJSONResponse(w, info)
+	})
+
+	mux.HandleFunc("DELETE /admin/sessions/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if !streams.StopSession(r.PathValue("id")) {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("POST /admin/lineup/refresh", func(w http.ResponseWriter, r *http.Request) {
+		if err := streams.RefreshLineup(r.Context()); err != nil {
+			log.Error("❌ Lineup refresh failed", logger.ErrorField("error", err))
+			http.Error(w, "Lineup refresh failed: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("POST /admin/stop-all", func(w http.ResponseWriter, _ *http.Request) {
+		streams.StopAllTranscoding()
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	if drainer != nil {
+		mux.HandleFunc("GET /admin/drain", func(w http.ResponseWriter, _ *http.Request) {
+			_ = utils.WriteJSONResponse(w, drainer.DrainStatus())
+		})
+
+		mux.HandleFunc("POST /admin/drain", func(w http.ResponseWriter, _ *http.Request) {
+			drainer.StartDrain()
+			w.Header().Set("Content-Type", constants.ContentTypeJSON)
+			w.WriteHeader(http.StatusAccepted)
+			_ = json.NewEncoder(w).Encode(drainer.DrainStatus())
+		})
+	}
+
+	return authorize(mux, token, log)
+}
+
+// authorize rejects requests that do not carry the admin token and logs the rest.
+func authorize(next http.Handler, token string, log interfaces.Logger) http.Handler {
+	var tokens []string
+	if token != "" {
+		tokens = []string{token}
+	}
+	return access.RequireToken(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.Info("🛠️  Admin request",
+			logger.String("method", r.Method),
+			logger.String("path", r.URL.Path),
+			logger.String("client_ip", utils.ClientIP(r)))
+		next.ServeHTTP(w, r)
+	}), tokens, Realm, log)
+}
@@ -0,0 +1,140 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/attaebra/hdhr-proxy/internal/interfaces"
+	"github.com/attaebra/hdhr-proxy/internal/logger"
+)
+
+// fakeStreams is a StreamAdmin with one session.
+type fakeStreams struct {
+	stopped    []string
+	stoppedAll bool
+	refreshErr error
+}
+
+func (f *fakeStreams) Sessions() []interfaces.SessionInfo {
+	return []interfaces.SessionInfo{{ID: "1", Channel: "5.1", Mode: "transcode"}}
+}
+
+func (f *fakeStreams) Session(id string) (interfaces.SessionInfo, bool) {
+	if id != "1" {
+		return interfaces.SessionInfo{}, false
+	}
+	return interfaces.SessionInfo{ID: "1", Channel: "5.1", FFmpegStderr: []string{"frame=1"}}, true
+}
+
+func (f *fakeStreams) StopSession(id string) bool {
+	if id != "1" {
+		return false
+	}
+	f.stopped = append(f.stopped, id)
+	return true
+}
+
+func (f *fakeStreams) RefreshLineup(context.Context) error { return f.refreshErr }
+func (f *fakeStreams) StopAllTranscoding()                 { f.stoppedAll = true }
+
+// fakeDrainer records whether a drain was started.
+type fakeDrainer struct {
+	draining bool
+}
+
+func (f *fakeDrainer) StartDrain() { f.draining = true }
+
+func (f *fakeDrainer) DrainStatus() interfaces.DrainStatus {
+	return interfaces.DrainStatus{Draining: f.draining, ActiveStreams: 1}
+}
+
+func serve(h http.Handler, method, target, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestAdminRequiresToken(t *testing.T) {
+	h := Handler(&fakeStreams{}, nil, "secret", logger.NewZapLogger(logger.LevelDebug))
+
+	if rec := serve(h, "GET", "/admin/sessions", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without a token, got %d", rec.Code)
+	}
+	if rec := serve(h, "GET", "/admin/sessions", "wrong"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 with a wrong token, got %d", rec.Code)
+	}
+	if rec := serve(h, "GET", "/admin/sessions?token=secret", ""); rec.Code != http.StatusOK {
+		t.Errorf("Expected 200 with a token query parameter, got %d", rec.Code)
+	}
+
+	open := Handler(&fakeStreams{}, nil, "", logger.NewZapLogger(logger.LevelDebug))
+	if rec := serve(open, "GET", "/admin/sessions", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 when no token is configured, got %d", rec.Code)
+	}
+}
+
+func TestAdminEndpoints(t *testing.T) {
+	streams := &fakeStreams{}
+	h := Handler(streams, nil, "secret", logger.NewZapLogger(logger.LevelDebug))
+
+	rec := serve(h, "GET", "/admin/sessions/1", "secret")
+	var info interfaces.SessionInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &info); err != nil || len(info.FFmpegStderr) != 1 {
+		t.Errorf("Expected session details with FFmpeg output, got %s (%v)", rec.Body.String(), err)
+	}
+	if rec := serve(h, "GET", "/admin/sessions/9", "secret"); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown session, got %d", rec.Code)
+	}
+
+	if rec := serve(h, "DELETE", "/admin/sessions/1", "secret"); rec.Code != http.StatusNoContent || len(streams.stopped) != 1 {
+		t.Errorf("Expected session to be stopped, got %d", rec.Code)
+	}
+	if rec := serve(h, "DELETE", "/admin/sessions/9", "secret"); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 stopping an unknown session, got %d", rec.Code)
+	}
+
+	if rec := serve(h, "POST", "/admin/stop-all", "secret"); rec.Code != http.StatusNoContent || !streams.stoppedAll {
+		t.Errorf("Expected all streams to be stopped, got %d", rec.Code)
+	}
+
+	if rec := serve(h, "POST", "/admin/lineup/refresh", "secret"); rec.Code != http.StatusNoContent {
+		t.Errorf("Expected lineup refresh to succeed, got %d", rec.Code)
+	}
+	streams.refreshErr = errors.New("device unreachable")
+	if rec := serve(h, "POST", "/admin/lineup/refresh", "secret"); rec.Code != http.StatusBadGateway {
+		t.Errorf("Expected 502 when the lineup refresh fails, got %d", rec.Code)
+	}
+}
+
+func TestAdminDrain(t *testing.T) {
+	drainer := &fakeDrainer{}
+	h := Handler(&fakeStreams{}, drainer, "secret", logger.NewZapLogger(logger.LevelDebug))
+
+	var status interfaces.DrainStatus
+	rec := serve(h, "GET", "/admin/drain", "secret")
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil || status.Draining {
+		t.Errorf("Expected no drain in progress, got %s (%v)", rec.Body.String(), err)
+	}
+
+	rec = serve(h, "POST", "/admin/drain", "secret")
+	if rec.Code != http.StatusAccepted || !drainer.draining {
+		t.Fatalf("Expected the drain to start, got %d", rec.Code)
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil || !status.Draining || status.ActiveStreams != 1 {
+		t.Errorf("Expected the drain status, got %s (%v)", rec.Body.String(), err)
+	}
+
+	// Without a drainer the endpoints are not served
+	h = Handler(&fakeStreams{}, nil, "secret", logger.NewZapLogger(logger.LevelDebug))
+	if rec := serve(h, "POST", "/admin/drain", "secret"); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 without a drainer, got %d", rec.Code)
+	}
+}
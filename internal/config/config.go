@@ -3,37 +3,137 @@ package config
 
 import (
 	"fmt"
+	"net/url"
 	"os"
 	"os/exec"
+	"strings"
 	"time"
 
 	"github.com/attaebra/hdhr-proxy/internal/constants"
+	"github.com/attaebra/hdhr-proxy/internal/media/ffmpeg"
 )
 
-// Config holds the application configuration.
+// Config holds the application configuration. Every field is addressed by its
+// yaml key in config files, by the upper-cased key in the environment (nested
+// keys joined with "_", so ffmpeg.audio_bitrate is FFMPEG_AUDIO_BITRATE) and by
+// the key with dashes on the command line.
 type Config struct {
 	// Server configuration
-	APIPort   int
-	MediaPort int
+	APIPort   int `yaml:"api_port"`
+	MediaPort int `yaml:"media_port"`
 
-	// HDHomeRun configuration
-	HDHomeRunIP string
+	// Optional HTTPS listeners (0 disables). The certificate comes from
+	// TLSCertFile/TLSKeyFile or is generated as a self-signed certificate kept
+	// in TLSDir (by default the user config directory), valid for TLSHosts
+	// and the machine's own names and addresses.
+	HTTPSAPIPort   int      `yaml:"https_api_port"`
+	HTTPSMediaPort int      `yaml:"https_media_port"`
+	TLSCertFile    string   `yaml:"tls_cert_file"`
+	TLSKeyFile     string   `yaml:"tls_key_file"`
+	TLSDir         string   `yaml:"tls_dir"`
+	TLSHosts       []string `yaml:"tls_hosts"`
+
+	// Public URLs advertised in discover.json, lineups, device.xml and the M3U
+	// playlist. Empty values are derived from each request, from the
+	// X-Forwarded-Host/Proto/Port headers when TrustForwardedHeaders is set.
+	PublicBaseURL         string `yaml:"public_base_url"`
+	PublicMediaURL        string `yaml:"public_media_url"`
+	TrustForwardedHeaders bool   `yaml:"trust_forwarded_headers"`
+
+	// HDHomeRun configuration. DeviceMediaPort is the device's streaming port,
+	// independent of the proxy's own MediaPort. Without HDHomeRunIP the device
+	// is discovered on the LAN, picked by DeviceID when there are several.
+	HDHomeRunIP     string `yaml:"hdhr_ip"`
+	DeviceID        string `yaml:"device_id"`
+	DeviceMediaPort int    `yaml:"hdhr_media_port"`
 
 	// FFmpeg configuration
-	FFmpegPath string
-	BufferSize string
+	FFmpegPath string `yaml:"ffmpeg_path"`
+	BufferSize string `yaml:"buffer_size"` // Overrides ffmpeg.buffer_size when set
+
+	// How long a stopped FFmpeg process has to flush its output and exit
+	// before it is killed
+	FFmpegStopGrace time.Duration `yaml:"ffmpeg_stop_grace"`
 
 	// HTTP client timeouts
-	HTTPClientTimeout   time.Duration
-	StreamClientTimeout time.Duration
+	HTTPClientTimeout   time.Duration `yaml:"http_client_timeout"`
+	StreamClientTimeout time.Duration `yaml:"stream_client_timeout"`
 
 	// Activity monitoring
-	ActivityCheckInterval time.Duration
-	MaxInactivityDuration time.Duration
+	ActivityCheckInterval time.Duration `yaml:"activity_check_interval"`
+	MaxInactivityDuration time.Duration `yaml:"max_inactivity_duration"`
+
+	// Admission control (0 means unlimited, except MaxTotalStreams where
+	// 0 means "use the device's TunerCount")
+	MaxConcurrentTranscodes int `yaml:"max_concurrent_transcodes"`
+	MaxStreamsPerClient     int `yaml:"max_streams_per_client"`
+	MaxTotalStreams         int `yaml:"max_total_streams"`
+
+	// Stream priority classes used for preemption when tuners are exhausted
+	PriorityClasses []PriorityClass `yaml:"priority_classes"`
+
+	// DVR configuration (recording is disabled when DVRDirectory is empty)
+	DVRDirectory string `yaml:"dvr_dir"`
+
+	// Timeshift configuration (disabled when TimeshiftMinutes is 0; an empty
+	// TimeshiftChannels list buffers every channel; buffers are kept in
+	// memory unless TimeshiftDir is set)
+	TimeshiftMinutes  int      `yaml:"timeshift_minutes"`
+	TimeshiftChannels []string `yaml:"timeshift_channels"`
+	TimeshiftDir      string   `yaml:"timeshift_dir"`
+
+	// Guide data for /epg.xml (the device's DeviceAuth is added to GuideURL)
+	GuideURL      string        `yaml:"guide_url"`
+	GuideCacheTTL time.Duration `yaml:"guide_cache_ttl"`
+
+	// How often the channel lineup is re-read from the device (0 reads it
+	// only at startup)
+	LineupRefreshInterval time.Duration `yaml:"lineup_refresh_interval"`
+
+	// Access control. Clients outside AllowedNetworks (IP addresses or CIDR
+	// ranges) are refused on both ports; an empty list admits everyone. When
+	// MediaTokens is set, media requests must carry one of the tokens.
+	AllowedNetworks []string `yaml:"allowed_networks"`
+	MediaTokens     []string `yaml:"media_tokens" secret:"true"`
+
+	// Admin API under /admin/ on the API port (disabled when AdminToken is
+	// empty). The token also protects the DVR API.
+	AdminToken string `yaml:"admin_token" secret:"true"`
+
+	// Outgoing webhooks receive lifecycle events as JSON POSTs (an empty
+	// WebhookEvents list sends every event type)
+	WebhookURLs   []string `yaml:"webhook_urls" secret:"true"`
+	WebhookEvents []string `yaml:"webhook_events"`
+
+	// MQTT state publishing with Home Assistant discovery (disabled when
+	// MQTTBroker is empty)
+	MQTTBroker          string `yaml:"mqtt_broker"`
+	MQTTUsername        string `yaml:"mqtt_username"`
+	MQTTPassword        string `yaml:"mqtt_password" secret:"true"`
+	MQTTTopicPrefix     string `yaml:"mqtt_topic_prefix"`
+	MQTTDiscoveryPrefix string `yaml:"mqtt_discovery_prefix"`
+
+	// How often the config file is checked for changes (0 reloads only on SIGHUP)
+	ConfigWatchInterval time.Duration `yaml:"config_watch_interval"`
+
+	// How long a drain (SIGTERM or POST /admin/drain) lets running streams
+	// continue before they are ended and the proxy exits. The container's
+	// stop timeout must be longer, or the proxy is killed mid-drain.
+	DrainTimeout time.Duration `yaml:"drain_timeout"`
+
+	// How often /readyz re-checks the device and self-tests FFmpeg
+	HealthCheckInterval time.Duration `yaml:"health_check_interval"`
+
+	// How often the device ID is re-checked so the proxy can follow the
+	// HDHomeRun to a new address (0 disables)
+	DeviceCheckInterval time.Duration `yaml:"device_check_interval"`
 
 	// Runtime configuration
-	LogLevel string
-	Debug    bool
+	LogLevel string `yaml:"log_level"`
+	Debug    bool   `yaml:"debug"`
+
+	// FFmpeg transcoding parameters
+	FFmpeg ffmpeg.Config `yaml:"ffmpeg"`
 }
 
 // DefaultConfig returns a configuration with sensible defaults.
@@ -42,10 +142,14 @@ func DefaultConfig() *Config {
 		// Server defaults
 		APIPort:   constants.DefaultAPIPort,
 		MediaPort: constants.DefaultMediaPort,
-		LogLevel:  "info",
+
+		// Device defaults
+		DeviceMediaPort: constants.DefaultDeviceMediaPort,
+		LogLevel:        "info",
 
 		// FFmpeg defaults
-		FFmpegPath: "/usr/bin/ffmpeg",
+		FFmpegPath:      "/usr/bin/ffmpeg",
+		FFmpegStopGrace: 5 * time.Second,
 
 		// HTTP Client defaults
 		HTTPClientTimeout:   30 * time.Second,
@@ -55,78 +159,212 @@ func DefaultConfig() *Config {
 		ActivityCheckInterval: 30 * time.Second,
 		MaxInactivityDuration: 2 * time.Minute,
 
-		// FFmpeg defaults
-		BufferSize: "2048k",
+		// Guide defaults
+		GuideURL:      constants.DefaultGuideURL,
+		GuideCacheTTL: time.Hour,
+
+		// MQTT defaults
+		MQTTTopicPrefix:     "hdhr-proxy",
+		MQTTDiscoveryPrefix: "homeassistant",
+
+		// Drain defaults
+		DrainTimeout: 30 * time.Minute,
+
+		// Health check defaults
+		HealthCheckInterval: 30 * time.Second,
+
+		// Device tracking defaults
+		DeviceCheckInterval: time.Minute,
+
+		// FFmpeg transcoding defaults
+		FFmpeg: *ffmpeg.New(),
 	}
 }
 
-// LoadFromEnvironment loads configuration from environment variables and command line flags.
-func (c *Config) LoadFromEnvironment() {
-	// Load from environment variables
-	if hdhrIP := os.Getenv("HDHR_IP"); hdhrIP != "" {
-		c.HDHomeRunIP = hdhrIP
+// FFmpegConfig returns a copy of the FFmpeg parameters with top-level overrides applied.
+func (c *Config) FFmpegConfig() *ffmpeg.Config {
+	cfg := c.FFmpeg
+	if c.BufferSize != "" {
+		cfg.BufferSize = c.BufferSize
 	}
+	return &cfg
+}
 
-	if logLevel := os.Getenv("LOG_LEVEL"); logLevel != "" {
-		c.LogLevel = logLevel
+// Validate ensures the configuration is valid. Errors name the offending key.
+func (c *Config) Validate() error {
+	if c.APIPort <= 0 || c.APIPort > 65535 {
+		return fmt.Errorf("api_port: invalid port %d", c.APIPort)
 	}
 
-	if ffmpegPath := os.Getenv("FFMPEG_PATH"); ffmpegPath != "" {
-		c.FFmpegPath = ffmpegPath
+	if c.MediaPort <= 0 || c.MediaPort > 65535 {
+		return fmt.Errorf("media_port: invalid port %d", c.MediaPort)
 	}
 
-	// HTTP client settings are now handled directly in utils/http.go
-}
+	if c.DeviceMediaPort <= 0 || c.DeviceMediaPort > 65535 {
+		return fmt.Errorf("hdhr_media_port: invalid port %d", c.DeviceMediaPort)
+	}
 
-// LoadFromFlags loads configuration from command line flags.
-func (c *Config) LoadFromFlags(hdhrIP *string, appPort *int, mediaPort *int, ffmpegPath *string, logLevel *string) {
-	if hdhrIP != nil && *hdhrIP != "" {
-		c.HDHomeRunIP = *hdhrIP
+	for _, port := range []struct {
+		key   string
+		value int
+	}{
+		{"https_api_port", c.HTTPSAPIPort},
+		{"https_media_port", c.HTTPSMediaPort},
+	} {
+		if port.value < 0 || port.value > 65535 {
+			return fmt.Errorf("%s: invalid port %d", port.key, port.value)
+		}
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		return fmt.Errorf("tls_cert_file: tls_cert_file and tls_key_file must be set together")
 	}
 
-	if appPort != nil {
-		c.APIPort = *appPort
+	nonNegative := []struct {
+		key   string
+		value int64
+	}{
+		{"http_client_timeout", int64(c.HTTPClientTimeout)},
+		{"stream_client_timeout", int64(c.StreamClientTimeout)},
+		{"max_concurrent_transcodes", int64(c.MaxConcurrentTranscodes)},
+		{"max_streams_per_client", int64(c.MaxStreamsPerClient)},
+		{"max_total_streams", int64(c.MaxTotalStreams)},
+		{"timeshift_minutes", int64(c.TimeshiftMinutes)},
+		{"lineup_refresh_interval", int64(c.LineupRefreshInterval)},
+		{"config_watch_interval", int64(c.ConfigWatchInterval)},
+		{"ffmpeg_stop_grace", int64(c.FFmpegStopGrace)},
+		{"drain_timeout", int64(c.DrainTimeout)},
+		{"device_check_interval", int64(c.DeviceCheckInterval)},
+	}
+	for _, setting := range nonNegative {
+		if setting.value < 0 {
+			return fmt.Errorf("%s: must not be negative", setting.key)
+		}
 	}
 
-	if mediaPort != nil {
-		c.MediaPort = *mediaPort
+	positive := []struct {
+		key   string
+		value time.Duration
+	}{
+		{"activity_check_interval", c.ActivityCheckInterval},
+		{"max_inactivity_duration", c.MaxInactivityDuration},
+		{"guide_cache_ttl", c.GuideCacheTTL},
+		{"health_check_interval", c.HealthCheckInterval},
+	}
+	for _, setting := range positive {
+		if setting.value <= 0 {
+			return fmt.Errorf("%s: must be a positive duration, got %s", setting.key, setting.value)
+		}
 	}
 
-	if ffmpegPath != nil && *ffmpegPath != "" {
-		c.FFmpegPath = *ffmpegPath
+	switch strings.ToLower(c.LogLevel) {
+	case "debug", "info", "warn", "warning", "error":
+	default:
+		return fmt.Errorf("log_level: unknown level %q (expected debug, info, warn or error)", c.LogLevel)
 	}
 
-	if logLevel != nil && *logLevel != "" {
-		c.LogLevel = *logLevel
+	if err := validatePriorityClasses(c.PriorityClasses); err != nil {
+		return fmt.Errorf("priority_classes: %w", err)
 	}
-}
 
-// Validate ensures the configuration is valid.
-func (c *Config) Validate() error {
-	if c.HDHomeRunIP == "" {
-		return fmt.Errorf("HDHomeRun IP address is required")
+	for _, public := range []struct {
+		key   string
+		value string
+	}{
+		{"public_base_url", c.PublicBaseURL},
+		{"public_media_url", c.PublicMediaURL},
+	} {
+		if public.value == "" {
+			continue
+		}
+		if u, err := url.Parse(public.value); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%s: invalid URL %q (expected http:// or https://)", public.key, public.value)
+		}
 	}
 
-	if c.APIPort <= 0 || c.APIPort > 65535 {
-		return fmt.Errorf("invalid API port: %d", c.APIPort)
+	for _, network := range c.AllowedNetworks {
+		if !isIPOrCIDR(network) {
+			return fmt.Errorf("allowed_networks: invalid network %q", network)
+		}
+	}
+	for _, token := range c.MediaTokens {
+		if token == "" {
+			return fmt.Errorf("media_tokens: empty token")
+		}
+		if token == c.AdminToken {
+			return fmt.Errorf("media_tokens: must differ from admin_token")
+		}
 	}
 
-	if c.MediaPort <= 0 || c.MediaPort > 65535 {
-		return fmt.Errorf("invalid media port: %d", c.MediaPort)
+	for _, rawURL := range c.WebhookURLs {
+		if u, err := url.Parse(rawURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("webhook_urls: invalid URL %q (expected http:// or https://)", rawURL)
+		}
+	}
+
+	if c.MQTTBroker != "" {
+		topics := []struct {
+			key   string
+			value string
+		}{
+			{"mqtt_topic_prefix", c.MQTTTopicPrefix},
+			{"mqtt_discovery_prefix", c.MQTTDiscoveryPrefix},
+		}
+		for _, topic := range topics {
+			if topic.value == "" || strings.ContainsAny(topic.value, "+#") {
+				return fmt.Errorf("%s: invalid topic prefix %q", topic.key, topic.value)
+			}
+		}
+	}
+
+	if err := validateFFmpegParameters(&c.FFmpeg); err != nil {
+		return err
 	}
 
 	if c.FFmpegPath == "" {
-		return fmt.Errorf("FFmpeg path is required")
+		return fmt.Errorf("ffmpeg_path: FFmpeg path is required")
 	}
 
 	// Validate FFmpeg executable exists and is executable
 	if err := validateFFmpegExecutable(c.FFmpegPath); err != nil {
-		return fmt.Errorf("FFmpeg validation failed: %w", err)
+		return fmt.Errorf("ffmpeg_path: %w", err)
 	}
 
 	return nil
 }
 
+// validateFFmpegParameters checks that the FFmpeg parameters BuildArgs always
+// passes are set, since an empty value would shift FFmpeg's arguments.
+func validateFFmpegParameters(cfg *ffmpeg.Config) error {
+	required := []struct {
+		key   string
+		value string
+	}{
+		{"ffmpeg.input_source", cfg.InputSource},
+		{"ffmpeg.output_target", cfg.OutputTarget},
+		{"ffmpeg.video_codec", cfg.VideoCodec},
+		{"ffmpeg.audio_codec", cfg.AudioCodec},
+		{"ffmpeg.audio_bitrate", cfg.AudioBitrate},
+		{"ffmpeg.audio_channels", cfg.AudioChannels},
+		{"ffmpeg.buffer_size", cfg.BufferSize},
+		{"ffmpeg.max_rate", cfg.MaxRate},
+		{"ffmpeg.preset", cfg.Preset},
+		{"ffmpeg.tune", cfg.Tune},
+		{"ffmpeg.thread_queue_size", cfg.ThreadQueueSize},
+		{"ffmpeg.max_muxing_queue_size", cfg.MaxMuxingQueueSize},
+		{"ffmpeg.threads", cfg.Threads},
+		{"ffmpeg.format", cfg.Format},
+		{"ffmpeg.error_detection", cfg.ErrorDetection},
+		{"ffmpeg.skip_frame", cfg.SkipFrame},
+		{"ffmpeg.strict_level", cfg.StrictLevel},
+	}
+	for _, param := range required {
+		if strings.TrimSpace(param.value) == "" {
+			return fmt.Errorf("%s: must not be empty", param.key)
+		}
+	}
+	return nil
+}
+
 // validateFFmpegExecutable checks if the FFmpeg executable exists and is usable.
 func validateFFmpegExecutable(path string) error {
 	// Check if file exists
@@ -0,0 +1,308 @@
+package config
+
+import (
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeConfigFile(t, `
+hdhr_ip: 10.0.0.5
+api_port: 8080
+max_total_streams: 2
+activity_check_interval: 10s
+timeshift_channels: [5.1, 7.1]
+priority_classes:
+  - name: dvr
+    priority: 100
+    clients: [192.168.1.10]
+ffmpeg:
+  audio_bitrate: 256k
+  preset: veryfast
+`)
+
+	t.Setenv("MAX_TOTAL_STREAMS", "3")
+	t.Setenv("FFMPEG_PRESET", "fast")
+	t.Setenv("TIMESHIFT_CHANNELS", "9.1, 10.1")
+	t.Setenv("HDHR_MEDIA_PORT", "15004")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags := RegisterFlags(fs)
+	if err := fs.Parse([]string{"--max-total-streams", "4", "--app-port", "9090"}); err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+
+	cfg, err := Load(path, flags)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	// Flags beat environment beats file beats defaults
+	if cfg.MaxTotalStreams != 4 {
+		t.Errorf("Expected flag to win for max_total_streams, got %d", cfg.MaxTotalStreams)
+	}
+	if cfg.APIPort != 9090 {
+		t.Errorf("Expected --app-port alias to set api_port, got %d", cfg.APIPort)
+	}
+	if cfg.FFmpeg.Preset != "fast" {
+		t.Errorf("Expected environment to win for ffmpeg.preset, got %q", cfg.FFmpeg.Preset)
+	}
+	if strings.Join(cfg.TimeshiftChannels, ",") != "9.1,10.1" {
+		t.Errorf("Expected environment list to replace file list, got %v", cfg.TimeshiftChannels)
+	}
+	if cfg.HDHomeRunIP != "10.0.0.5" || cfg.ActivityCheckInterval != 10*time.Second || cfg.FFmpeg.AudioBitrate != "256k" {
+		t.Errorf("Expected file values to apply, got %+v", cfg)
+	}
+	if len(cfg.PriorityClasses) != 1 || cfg.PriorityClasses[0].Priority != 100 {
+		t.Errorf("Expected priority classes from file, got %+v", cfg.PriorityClasses)
+	}
+	if cfg.DeviceMediaPort != 15004 {
+		t.Errorf("Expected environment to set hdhr_media_port independently of media_port, got %d", cfg.DeviceMediaPort)
+	}
+	if cfg.MediaPort != 5004 || cfg.FFmpeg.AudioCodec != "eac3" {
+		t.Errorf("Expected defaults for unset keys, got media_port=%d audio_codec=%q", cfg.MediaPort, cfg.FFmpeg.AudioCodec)
+	}
+}
+
+func TestLoadFileErrorsNameKey(t *testing.T) {
+	cases := map[string]string{
+		"api_port: abc\n":                          "api_port (line 1): invalid integer",
+		"ffmpeg:\n  bogus: 1\n":                    "ffmpeg.bogus (line 2): unknown key",
+		"guide_cache_ttl: forever\n":               "guide_cache_ttl (line 1): invalid duration",
+		"hdhr_ip:\n  nested: true\n":               "hdhr_ip (line 1): unknown section",
+		"timeshift_minutes: [1, 2]\n":              "timeshift_minutes (line 1)",
+		"priority_classes: 'x:notanint:1.2.3.4'\n": "priority_classes (line 1)",
+	}
+	for content, want := range cases {
+		_, err := Load(writeConfigFile(t, content), nil)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error containing %q for %q, got %v", want, content, err)
+		}
+	}
+}
+
+func TestValidateNamesKey(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.HDHomeRunIP = "10.0.0.5"
+
+	cfg.GuideCacheTTL = 0
+	if err := cfg.Validate(); err == nil || !strings.HasPrefix(err.Error(), "guide_cache_ttl:") {
+		t.Errorf("Expected guide_cache_ttl error, got %v", err)
+	}
+
+	cfg = DefaultConfig()
+	cfg.HDHomeRunIP = "10.0.0.5"
+	cfg.FFmpeg.Preset = ""
+	if err := cfg.Validate(); err == nil || !strings.HasPrefix(err.Error(), "ffmpeg.preset:") {
+		t.Errorf("Expected ffmpeg.preset error, got %v", err)
+	}
+
+	cfg = DefaultConfig()
+	cfg.HDHomeRunIP = "10.0.0.5"
+	cfg.PriorityClasses = []PriorityClass{{Name: "x", Clients: []string{"not-an-ip"}}}
+	if err := cfg.Validate(); err == nil || !strings.HasPrefix(err.Error(), "priority_classes:") {
+		t.Errorf("Expected priority_classes error, got %v", err)
+	}
+
+	cfg = DefaultConfig()
+	cfg.HDHomeRunIP = "10.0.0.5"
+	cfg.WebhookURLs = []string{"ftp://example.com/hook"}
+	if err := cfg.Validate(); err == nil || !strings.HasPrefix(err.Error(), "webhook_urls:") {
+		t.Errorf("Expected webhook_urls error, got %v", err)
+	}
+
+	cfg = DefaultConfig()
+	cfg.HDHomeRunIP = "10.0.0.5"
+	cfg.PublicMediaURL = "tv.example.com:15004"
+	if err := cfg.Validate(); err == nil || !strings.HasPrefix(err.Error(), "public_media_url:") {
+		t.Errorf("Expected public_media_url error, got %v", err)
+	}
+
+	cfg = DefaultConfig()
+	cfg.HDHomeRunIP = "10.0.0.5"
+	cfg.AllowedNetworks = []string{"192.168.1.0/24", "10.0.0"}
+	if err := cfg.Validate(); err == nil || !strings.HasPrefix(err.Error(), "allowed_networks:") {
+		t.Errorf("Expected allowed_networks error, got %v", err)
+	}
+
+	cfg = DefaultConfig()
+	cfg.HDHomeRunIP = "10.0.0.5"
+	cfg.AdminToken = "shared"
+	cfg.MediaTokens = []string{"shared"}
+	if err := cfg.Validate(); err == nil || !strings.HasPrefix(err.Error(), "media_tokens:") {
+		t.Errorf("Expected media_tokens error, got %v", err)
+	}
+
+	cfg = DefaultConfig()
+	cfg.HDHomeRunIP = "10.0.0.5"
+	cfg.MQTTBroker = "broker:1883"
+	cfg.MQTTTopicPrefix = "hdhr/#"
+	if err := cfg.Validate(); err == nil || !strings.HasPrefix(err.Error(), "mqtt_topic_prefix:") {
+		t.Errorf("Expected mqtt_topic_prefix error, got %v", err)
+	}
+
+	cfg = DefaultConfig()
+	cfg.HDHomeRunIP = "10.0.0.5"
+	cfg.FFmpegStopGrace = -time.Second
+	if err := cfg.Validate(); err == nil || !strings.HasPrefix(err.Error(), "ffmpeg_stop_grace:") {
+		t.Errorf("Expected ffmpeg_stop_grace error, got %v", err)
+	}
+
+	cfg = DefaultConfig()
+	cfg.HDHomeRunIP = "10.0.0.5"
+	cfg.DrainTimeout = -time.Minute
+	if err := cfg.Validate(); err == nil || !strings.HasPrefix(err.Error(), "drain_timeout:") {
+		t.Errorf("Expected drain_timeout error, got %v", err)
+	}
+
+	cfg = DefaultConfig()
+	cfg.HDHomeRunIP = "10.0.0.5"
+	cfg.HealthCheckInterval = 0
+	if err := cfg.Validate(); err == nil || !strings.HasPrefix(err.Error(), "health_check_interval:") {
+		t.Errorf("Expected health_check_interval error, got %v", err)
+	}
+
+	cfg = DefaultConfig()
+	cfg.HDHomeRunIP = "10.0.0.5"
+	cfg.DeviceCheckInterval = -time.Second
+	if err := cfg.Validate(); err == nil || !strings.HasPrefix(err.Error(), "device_check_interval:") {
+		t.Errorf("Expected device_check_interval error, got %v", err)
+	}
+
+	// Without hdhr_ip the device is discovered
+	cfg = DefaultConfig()
+	cfg.DeviceID = "1050abcd"
+	if err := cfg.Validate(); err != nil && strings.HasPrefix(err.Error(), "hdhr_ip:") {
+		t.Errorf("Expected discovery by device ID to be valid, got %v", err)
+	}
+}
+
+func TestYAMLRoundTrip(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.HDHomeRunIP = "10.0.0.5"
+	cfg.MaxInactivityDuration = 5 * time.Minute
+	cfg.PriorityClasses = []PriorityClass{{Name: "dvr", Priority: 100, Tokens: []string{"rec"}}}
+
+	cfg.AdminToken = "admin-secret"
+	cfg.MQTTPassword = "mqtt-secret"
+	cfg.MediaTokens = []string{"media-secret"}
+	cfg.WebhookURLs = []string{"https://hooks.example.com/webhook-secret"}
+
+	data, err := cfg.YAML()
+	if err != nil {
+		t.Fatalf("YAML failed: %v", err)
+	}
+	for _, secret := range []string{"admin-secret", "mqtt-secret", "media-secret", "webhook-secret"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("Expected %q to be redacted:\n%s", secret, data)
+		}
+	}
+	if cfg.AdminToken != "admin-secret" || cfg.MediaTokens[0] != "media-secret" {
+		t.Error("Expected redaction to leave the config itself unchanged")
+	}
+
+	loaded, err := Load(writeConfigFile(t, string(data)), nil)
+	if err != nil {
+		t.Fatalf("Printed config did not load: %v\n%s", err, data)
+	}
+	if loaded.MaxInactivityDuration != 5*time.Minute || loaded.HDHomeRunIP != "10.0.0.5" ||
+		len(loaded.PriorityClasses) != 1 || loaded.PriorityClasses[0].Tokens[0] != "rec" {
+		t.Errorf("Config did not round trip:\n%s", data)
+	}
+}
+
+func TestFFmpegConfigBufferSizeOverride(t *testing.T) {
+	cfg := DefaultConfig()
+	if cfg.FFmpegConfig().BufferSize != cfg.FFmpeg.BufferSize {
+		t.Error("Expected ffmpeg.buffer_size without a top-level override")
+	}
+	cfg.BufferSize = "8192k"
+	if got := cfg.FFmpegConfig().BufferSize; got != "8192k" {
+		t.Errorf("Expected buffer_size override, got %q", got)
+	}
+}
+
+func TestReloaded(t *testing.T) {
+	running := DefaultConfig()
+	running.HDHomeRunIP = "10.0.0.5"
+
+	updated := DefaultConfig()
+	updated.HDHomeRunIP = "10.0.0.6"
+	updated.APIPort = 9090
+	updated.LogLevel = "debug"
+	updated.MaxTotalStreams = 2
+	updated.FFmpeg.AudioBitrate = "256k"
+	updated.PriorityClasses = []PriorityClass{{Name: "dvr", Priority: 100}}
+
+	next, applied, restart := running.Reloaded(updated)
+
+	if strings.Join(applied, ",") != "max_total_streams,priority_classes,log_level,ffmpeg.audio_bitrate" {
+		t.Errorf("Unexpected applied keys: %v", applied)
+	}
+	if strings.Join(restart, ",") != "api_port,hdhr_ip" {
+		t.Errorf("Unexpected restart keys: %v", restart)
+	}
+
+	// Reloadable settings take their new values; the rest keep running values
+	if next.LogLevel != "debug" || next.MaxTotalStreams != 2 || next.FFmpeg.AudioBitrate != "256k" {
+		t.Errorf("Expected reloadable settings to apply, got %+v", next)
+	}
+	if next.APIPort != DefaultConfig().APIPort || next.HDHomeRunIP != "10.0.0.5" {
+		t.Errorf("Expected restart-only settings to keep running values, got api_port=%d hdhr_ip=%s",
+			next.APIPort, next.HDHomeRunIP)
+	}
+
+	// The running config is not modified
+	if running.LogLevel == "debug" || running.FFmpeg.AudioBitrate == "256k" {
+		t.Errorf("Expected the running config to be left untouched, got %+v", running)
+	}
+
+	// A second reload with the same file reports only the pending restart keys
+	_, applied, restart = next.Reloaded(updated)
+	if len(applied) != 0 || len(restart) != 2 {
+		t.Errorf("Expected no new changes, got applied=%v restart=%v", applied, restart)
+	}
+}
+
+func TestWatchFile(t *testing.T) {
+	path := writeConfigFile(t, "log_level: info\n")
+	changed := make(chan struct{}, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go WatchFile(ctx, path, 10*time.Millisecond, func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	})
+
+	select {
+	case <-changed:
+		t.Fatal("Expected no change before the file is modified")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := os.WriteFile(path, []byte("log_level: debug\n"), 0o600); err != nil {
+		t.Fatalf("Failed to update config file: %v", err)
+	}
+	select {
+	case <-changed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the change to be reported")
+	}
+}
@@ -0,0 +1,372 @@
+package config
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvConfigFile names the environment variable holding the config file path.
+const EnvConfigFile = "CONFIG_FILE"
+
+// flagAliases keeps the original command line flag names working.
+var flagAliases = map[string]string{
+	"app-port": "api_port",
+	"ffmpeg":   "ffmpeg_path",
+}
+
+// redacted replaces secret values in printed configurations.
+const redacted = "<redacted>"
+
+// setting is a configurable field addressed by its key.
+type setting struct {
+	key    string // Dotted yaml path, e.g. ffmpeg.audio_bitrate
+	value  reflect.Value
+	secret bool // Tagged secret:"true", e.g. tokens and passwords
+}
+
+// envName returns the environment variable for the setting.
+func (s setting) envName() string {
+	return strings.ToUpper(strings.ReplaceAll(s.key, ".", "_"))
+}
+
+// flagName returns the command line flag for the setting.
+func (s setting) flagName() string {
+	return strings.NewReplacer(".", "-", "_", "-").Replace(s.key)
+}
+
+var (
+	durationType      = reflect.TypeOf(time.Duration(0))
+	priorityClassType = reflect.TypeOf([]PriorityClass(nil))
+)
+
+// settings lists every configurable field of the config in declaration order.
+func (c *Config) settings() []setting {
+	var list []setting
+	collectSettings(reflect.ValueOf(c).Elem(), "", &list)
+	return list
+}
+
+// collectSettings walks a struct, descending into nested sections.
+func collectSettings(v reflect.Value, prefix string, list *[]setting) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if !field.IsExported() || name == "" || name == "-" {
+			continue
+		}
+		key := prefix + name
+		if field.Type.Kind() == reflect.Struct && field.Type != durationType {
+			collectSettings(v.Field(i), key+".", list)
+			continue
+		}
+		*list = append(*list, setting{key: key, value: v.Field(i), secret: field.Tag.Get("secret") == "true"})
+	}
+}
+
+// lookup returns the setting for a key.
+func (c *Config) lookup(key string) (setting, bool) {
+	for _, s := range c.settings() {
+		if s.key == key {
+			return s, true
+		}
+	}
+	return setting{}, false
+}
+
+// set parses a string value into a setting. Lists are comma separated and
+// priority classes use the PRIORITY_CLASSES format.
+func (s setting) set(value string) error {
+	v := s.value
+	switch {
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q (examples: 30s, 2m, 1h)", value)
+		}
+		v.SetInt(int64(d))
+	case v.Type() == priorityClassType:
+		classes, err := ParsePriorityClasses(value)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(classes))
+	case v.Kind() == reflect.String:
+		v.SetString(value)
+	case v.Kind() == reflect.Int:
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", value)
+		}
+		v.SetInt(int64(n))
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", value)
+		}
+		v.SetBool(b)
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String:
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported setting type %s", v.Type())
+	}
+	return nil
+}
+
+// setNode assigns a YAML node to a setting.
+func (s setting) setNode(node *yaml.Node) error {
+	switch {
+	case node.Kind == yaml.ScalarNode && node.Tag == "!!null":
+		return nil // An empty value keeps the default
+	case node.Kind == yaml.ScalarNode:
+		return s.set(node.Value)
+	case node.Kind == yaml.SequenceNode && s.value.Type() == priorityClassType:
+		var classes []PriorityClass
+		if err := node.Decode(&classes); err != nil {
+			return err
+		}
+		s.value.Set(reflect.ValueOf(classes))
+		return nil
+	case node.Kind == yaml.SequenceNode && s.value.Kind() == reflect.Slice:
+		var items []string
+		if err := node.Decode(&items); err != nil {
+			return err
+		}
+		s.value.Set(reflect.ValueOf(items))
+		return nil
+	default:
+		return fmt.Errorf("expected a value, got a %s", nodeKindName(node.Kind))
+	}
+}
+
+// nodeKindName describes a YAML node kind for error messages.
+func nodeKindName(kind yaml.Kind) string {
+	switch kind {
+	case yaml.MappingNode:
+		return "mapping"
+	case yaml.SequenceNode:
+		return "list"
+	default:
+		return "scalar"
+	}
+}
+
+// LoadFile applies settings from a YAML config file. Unknown keys and invalid
+// values are reported with their key and line number.
+func (c *Config) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil // Empty file
+	}
+	if err := c.applyMapping(doc.Content[0], ""); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// applyMapping applies a YAML mapping whose keys are relative to prefix.
+func (c *Config) applyMapping(node *yaml.Node, prefix string) error {
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: expected a mapping of settings", node.Line)
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		keyNode, valueNode := node.Content[i], node.Content[i+1]
+		key := prefix + keyNode.Value
+
+		if valueNode.Kind == yaml.MappingNode {
+			if !c.isSection(key) {
+				return fmt.Errorf("%s (line %d): unknown section", key, keyNode.Line)
+			}
+			if err := c.applyMapping(valueNode, key+"."); err != nil {
+				return err
+			}
+			continue
+		}
+
+		s, ok := c.lookup(key)
+		if !ok {
+			return fmt.Errorf("%s (line %d): unknown key", key, keyNode.Line)
+		}
+		if err := s.setNode(valueNode); err != nil {
+			return fmt.Errorf("%s (line %d): %w", key, valueNode.Line, err)
+		}
+	}
+	return nil
+}
+
+// isSection reports whether key names a nested group of settings.
+func (c *Config) isSection(key string) bool {
+	for _, s := range c.settings() {
+		if strings.HasPrefix(s.key, key+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadFromEnvironment applies settings from environment variables. Each key
+// maps to its upper-cased name, e.g. HDHR_IP or FFMPEG_AUDIO_BITRATE.
+func (c *Config) LoadFromEnvironment() error {
+	for _, s := range c.settings() {
+		value, ok := os.LookupEnv(s.envName())
+		if !ok || value == "" {
+			continue
+		}
+		if err := s.set(value); err != nil {
+			return fmt.Errorf("%s (environment %s): %w", s.key, s.envName(), err)
+		}
+	}
+	return nil
+}
+
+// Flags collects command line overrides for configuration keys. Only flags
+// that were given on the command line are applied.
+type Flags struct {
+	values map[string]string
+	order  []string
+}
+
+// RegisterFlags defines a flag for every configuration key on fs.
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{values: make(map[string]string)}
+	defaults := DefaultConfig()
+
+	register := func(name, key string) {
+		s, _ := defaults.lookup(key)
+		usage := fmt.Sprintf("Set %s (default %s)", key, formatValue(s.value))
+		record := func(value string) error {
+			if _, seen := f.values[key]; !seen {
+				f.order = append(f.order, key)
+			}
+			f.values[key] = value
+			return nil
+		}
+		if s.value.Kind() == reflect.Bool {
+			fs.BoolFunc(name, usage, record)
+		} else {
+			fs.Func(name, usage, record)
+		}
+	}
+
+	for _, s := range defaults.settings() {
+		register(s.flagName(), s.key)
+	}
+	for alias, key := range flagAliases {
+		register(alias, key)
+	}
+	return f
+}
+
+// ApplyFlags applies command line overrides in the order they were given.
+func (c *Config) ApplyFlags(f *Flags) error {
+	if f == nil {
+		return nil
+	}
+	for _, key := range f.order {
+		s, _ := c.lookup(key)
+		if err := s.set(f.values[key]); err != nil {
+			return fmt.Errorf("%s (flag --%s): %w", key, s.flagName(), err)
+		}
+	}
+	return nil
+}
+
+// Load builds the effective configuration with the precedence
+// defaults < config file < environment < command line flags.
+// An empty path skips the config file.
+func Load(path string, flags *Flags) (*Config, error) {
+	c := DefaultConfig()
+
+	if path != "" {
+		if err := c.LoadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.LoadFromEnvironment(); err != nil {
+		return nil, err
+	}
+	if err := c.ApplyFlags(flags); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// YAML renders the configuration in config file format with secrets such as
+// tokens and passwords redacted.
+func (c *Config) YAML() ([]byte, error) {
+	printed := *c
+	for _, s := range printed.settings() {
+		if s.secret {
+			redact(s.value)
+		}
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&printed); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// redact replaces a set string, or every element of a string list, with a
+// placeholder. Lists are replaced rather than modified, as copies of the
+// config share them.
+func redact(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.String:
+		if v.String() != "" {
+			v.SetString(redacted)
+		}
+	case reflect.Slice:
+		placeholders := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			placeholders.Index(i).SetString(redacted)
+		}
+		v.Set(placeholders)
+	}
+}
+
+// formatValue renders a setting value for flag usage text.
+func formatValue(v reflect.Value) string {
+	switch {
+	case v.Type() == durationType:
+		return time.Duration(v.Int()).String()
+	case v.Kind() == reflect.Slice:
+		if v.Len() == 0 {
+			return "none"
+		}
+		return fmt.Sprint(v.Interface())
+	case v.Kind() == reflect.String && v.String() == "":
+		return "unset"
+	default:
+		return fmt.Sprint(v.Interface())
+	}
+}
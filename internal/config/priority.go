@@ -0,0 +1,86 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// PriorityClass assigns a stream priority to clients matched by IP/CIDR or API token.
+// When every tuner is busy, a request from a higher priority class preempts the
+// lowest priority active stream.
+type PriorityClass struct {
+	Name     string   `yaml:"name"`
+	Priority int      `yaml:"priority"`
+	Clients  []string `yaml:"clients,omitempty"` // IP addresses or CIDR ranges
+	Tokens   []string `yaml:"tokens,omitempty"`  // API tokens passed as ?token= or "Authorization: Bearer"
+}
+
+// ParsePriorityClasses parses the PRIORITY_CLASSES format:
+//
+//	name:priority:match,match;name:priority:match
+//
+// where each match is an IP address, a CIDR range or token=<value>.
+// For example "dvr:100:192.168.1.10,token=rec;tablet:10:192.168.1.50/32".
+func ParsePriorityClasses(value string) ([]PriorityClass, error) {
+	var classes []PriorityClass
+
+	for _, entry := range strings.Split(value, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("priority class %q: expected name:priority:matches", entry)
+		}
+
+		priority, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("priority class %q: invalid priority %q", parts[0], parts[1])
+		}
+
+		class := PriorityClass{Name: parts[0], Priority: priority}
+		for _, match := range strings.Split(parts[2], ",") {
+			match = strings.TrimSpace(match)
+			switch {
+			case match == "":
+				continue
+			case strings.HasPrefix(match, "token="):
+				class.Tokens = append(class.Tokens, strings.TrimPrefix(match, "token="))
+			default:
+				class.Clients = append(class.Clients, match)
+			}
+		}
+
+		classes = append(classes, class)
+	}
+
+	return classes, nil
+}
+
+// validatePriorityClasses checks that every class has a name and valid client matches.
+func validatePriorityClasses(classes []PriorityClass) error {
+	for _, class := range classes {
+		if class.Name == "" {
+			return fmt.Errorf("priority class is missing a name")
+		}
+		for _, client := range class.Clients {
+			if !isIPOrCIDR(client) {
+				return fmt.Errorf("priority class %q: invalid client %q", class.Name, client)
+			}
+		}
+	}
+	return nil
+}
+
+// isIPOrCIDR reports whether s is an IP address or a CIDR range.
+func isIPOrCIDR(s string) bool {
+	if net.ParseIP(s) != nil {
+		return true
+	}
+	_, _, err := net.ParseCIDR(s)
+	return err == nil
+}
@@ -0,0 +1,75 @@
+package config
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// reloadableKeys are the settings that take effect without a restart. Every
+// ffmpeg.* key is reloadable as well; new transcodes pick up the parameters.
+var reloadableKeys = map[string]bool{
+	"log_level":                 true,
+	"buffer_size":               true,
+	"max_inactivity_duration":   true,
+	"max_concurrent_transcodes": true,
+	"max_streams_per_client":    true,
+	"max_total_streams":         true,
+	"priority_classes":          true,
+	"timeshift_channels":        true,
+	"lineup_refresh_interval":   true,
+}
+
+// Reloadable reports whether a key takes effect without a restart.
+func Reloadable(key string) bool {
+	return reloadableKeys[key] || strings.HasPrefix(key, "ffmpeg.")
+}
+
+// Reloaded returns a copy of c with the reloadable settings that differ in
+// updated applied, leaving c untouched for goroutines still reading it. It
+// also returns the keys it applied and the keys that changed but only take
+// effect after a restart; those keep their running values in the copy.
+func (c *Config) Reloaded(updated *Config) (next *Config, applied, restart []string) {
+	copied := *c
+	for _, s := range copied.settings() {
+		value, _ := updated.lookup(s.key)
+		if reflect.DeepEqual(s.value.Interface(), value.value.Interface()) {
+			continue
+		}
+		if !Reloadable(s.key) {
+			restart = append(restart, s.key)
+			continue
+		}
+		s.value.Set(value.value)
+		applied = append(applied, s.key)
+	}
+	return &copied, applied, restart
+}
+
+// WatchFile polls path every interval and calls changed when its modification
+// time or size changes. It returns when ctx is canceled. A file that is
+// briefly missing, e.g. while an editor replaces it, is not reported.
+func WatchFile(ctx context.Context, path string, interval time.Duration, changed func()) {
+	last, _ := os.Stat(path)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			info, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			if last == nil || !info.ModTime().Equal(last.ModTime()) || info.Size() != last.Size() {
+				last = info
+				changed()
+			}
+		}
+	}
+}
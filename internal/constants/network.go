@@ -6,8 +6,12 @@ const (
 	// DefaultAPIPort is the standard HTTP port for API endpoints.
 	DefaultAPIPort = 80
 
-	// DefaultMediaPort is the port for streaming endpoints (MUST be 5004 for HDHomeRun compatibility).
+	// DefaultMediaPort is the proxy's port for streaming endpoints. It matches
+	// the device's port so clients that assume 5004 keep working.
 	DefaultMediaPort = 5004
+
+	// DefaultDeviceMediaPort is the port HDHomeRun devices serve streams on.
+	DefaultDeviceMediaPort = 5004
 )
 
 // HTTP content types.
@@ -15,3 +19,33 @@ const (
 	// ContentTypeJSON is the MIME type for JSON responses.
 	ContentTypeJSON = "application/json"
 )
+
+// HDHomeRun error reporting.
+const (
+	// HeaderHDHomeRunError is the response header HDHomeRun devices use to report tuning errors.
+	HeaderHDHomeRunError = "X-HDHomeRun-Error"
+
+	// HDHomeRunErrorSystemBusy is reported when the proxy refuses a stream for capacity reasons.
+	HDHomeRunErrorSystemBusy = "803 System Busy"
+
+	// HDHomeRunErrorAllTunersInUse is reported when every tuner is busy.
+	HDHomeRunErrorAllTunersInUse = "805 All Tuners In Use"
+)
+
+// Guide data.
+const (
+	// DefaultGuideURL is the SiliconDust guide API queried with the device's DeviceAuth.
+	DefaultGuideURL = "https://api.hdhomerun.com/api/guide.php"
+
+	// ContentTypeXML is the MIME type for XMLTV responses.
+	ContentTypeXML = "application/xml"
+
+	// ContentTypeM3U is the MIME type for M3U playlists.
+	ContentTypeM3U = "audio/x-mpegurl"
+)
+
+// Dashboard and event streams.
+const (
+	// ContentTypeEventStream is the MIME type for Server-Sent Events.
+	ContentTypeEventStream = "text/event-stream"
+)
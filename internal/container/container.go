@@ -3,23 +3,42 @@ package container
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
 	"time"
 
+	"github.com/attaebra/hdhr-proxy/internal/access"
+	"github.com/attaebra/hdhr-proxy/internal/admin"
 	"github.com/attaebra/hdhr-proxy/internal/config"
+	"github.com/attaebra/hdhr-proxy/internal/dashboard"
+	"github.com/attaebra/hdhr-proxy/internal/discovery"
+	"github.com/attaebra/hdhr-proxy/internal/dvr"
+	"github.com/attaebra/hdhr-proxy/internal/epg"
+	"github.com/attaebra/hdhr-proxy/internal/events"
+	"github.com/attaebra/hdhr-proxy/internal/health"
 	"github.com/attaebra/hdhr-proxy/internal/interfaces"
 	"github.com/attaebra/hdhr-proxy/internal/logger"
-	"github.com/attaebra/hdhr-proxy/internal/media/ffmpeg"
+	"github.com/attaebra/hdhr-proxy/internal/media/process"
+	"github.com/attaebra/hdhr-proxy/internal/media/session"
 	"github.com/attaebra/hdhr-proxy/internal/media/stream"
+	"github.com/attaebra/hdhr-proxy/internal/media/timeshift"
 	"github.com/attaebra/hdhr-proxy/internal/media/transcoder"
+	"github.com/attaebra/hdhr-proxy/internal/mqtt"
 	"github.com/attaebra/hdhr-proxy/internal/proxy"
+	"github.com/attaebra/hdhr-proxy/internal/publicurl"
+	"github.com/attaebra/hdhr-proxy/internal/tlscert"
 	"github.com/attaebra/hdhr-proxy/internal/utils"
 )
 
 // Container manages all application dependencies.
 type Container struct {
-	config            *config.Config
+	config            *config.Config // Replaced, never modified, by Reload; see currentConfig
+	configMutex       sync.RWMutex
 	logger            interfaces.Logger
 	httpClient        interfaces.Client
 	streamClient      interfaces.Client
@@ -27,17 +46,75 @@ type Container struct {
 	ffmpegConfig      interfaces.Config
 	securityValidator interfaces.SecurityValidator
 	hdhrProxy         interfaces.Proxy
+	publicURLs        *publicurl.Resolver
+	sessions          *session.Registry
+	ffmpegRunner      interfaces.ProcessRunner
 	transcoder        interfaces.Transcoder
+	dvr               *dvr.DVR
+	events            *events.Bus
+	stopWebhooks      context.CancelFunc
+	mqtt              *mqtt.Publisher
+	health            *health.Monitor
+	guide             *epg.Guide
+	discovered        bool               // hdhr_ip was found by discovery rather than configured
+	discoveredID      string             // The ID of the discovered device
+	deviceOffline     bool               // The device or its lineup was unreachable at startup
+	stopConnect       context.CancelFunc // Stops retrying an offline device
+	stopDeviceWatch   context.CancelFunc // Stops re-checking the device ID
+	background        sync.WaitGroup     // Device goroutines awaited by Shutdown
 
-	// HTTP servers
-	apiServer   *http.Server
-	mediaServer *http.Server
+	// HTTP servers, plus the HTTPS servers when enabled
+	apiServer      *http.Server
+	mediaServer    *http.Server
+	apiTLSServer   *http.Server
+	mediaTLSServer *http.Server
+	stopTLSWatch   context.CancelFunc
+
+	// Drain mode, started once by SIGTERM or the admin API
+	drainOnce    sync.Once
+	drainStarted chan struct{}
+	drainMutex   sync.Mutex
+	drainSince   time.Time
+
+	options Options
+}
+
+// Ensure Container can be drained through the admin API.
+var _ interfaces.Drainer = (*Container)(nil)
+
+// drainPollInterval is how often WaitForDrain checks for running streams.
+const drainPollInterval = 250 * time.Millisecond
+
+// Backoff bounds for retrying a device that was offline at startup.
+const (
+	deviceRetryMin = time.Second
+	deviceRetryMax = time.Minute
+)
+
+// Options replace the container's connections to the outside world, so the
+// whole application can run inside tests. The device is reached at hdhr_ip,
+// which may include a port, and hdhr_media_port. The zero value listens on the
+// configured ports, runs FFmpeg from ffmpeg_path and, without hdhr_ip,
+// broadcasts discovery requests on the LAN.
+type Options struct {
+	APIListener        net.Listener             // Serves the API instead of api_port
+	MediaListener      net.Listener             // Serves media instead of media_port
+	FFmpegRunner       interfaces.ProcessRunner // Starts FFmpeg instead of the default process runner
+	DiscoveryAddresses []string                 // Receive discovery requests instead of the LAN broadcast addresses
 }
 
 // Initialize sets up all dependencies using dependency injection.
 func Initialize(cfg *config.Config) (*Container, error) {
+	return InitializeWithOptions(cfg, Options{})
+}
+
+// InitializeWithOptions sets up all dependencies, replacing the listeners and
+// FFmpeg runner given in opts.
+func InitializeWithOptions(cfg *config.Config, opts Options) (*Container, error) {
 	container := &Container{
-		config: cfg,
+		config:       cfg,
+		options:      opts,
+		drainStarted: make(chan struct{}),
 	}
 
 	// Initialize logger first
@@ -52,6 +129,10 @@ func Initialize(cfg *config.Config) (*Container, error) {
 		return nil, fmt.Errorf("failed to initialize HTTP clients: %w", err)
 	}
 
+	if err := container.discoverDevice(); err != nil {
+		return nil, fmt.Errorf("failed to discover HDHomeRun: %w", err)
+	}
+
 	if err := container.initializeFFmpegConfig(); err != nil {
 		return nil, fmt.Errorf("failed to initialize FFmpeg config: %w", err)
 	}
@@ -68,10 +149,30 @@ func Initialize(cfg *config.Config) (*Container, error) {
 		return nil, fmt.Errorf("failed to initialize proxy: %w", err)
 	}
 
+	if err := container.initializeSessions(); err != nil {
+		return nil, fmt.Errorf("failed to initialize session registry: %w", err)
+	}
+
+	if err := container.initializeEvents(); err != nil {
+		return nil, fmt.Errorf("failed to initialize events: %w", err)
+	}
+
 	if err := container.initializeTranscoder(); err != nil {
 		return nil, fmt.Errorf("failed to initialize transcoder: %w", err)
 	}
 
+	container.initializeGuide()
+	container.initializeAdmin()
+	container.initializeDashboard()
+	container.initializeMQTT()
+	container.initializeHealth()
+	container.initializeDeviceConnection()
+	container.initializeDeviceWatch()
+
+	if err := container.initializeDVR(); err != nil {
+		return nil, fmt.Errorf("failed to initialize DVR: %w", err)
+	}
+
 	if err := container.initializeServers(); err != nil {
 		return nil, fmt.Errorf("failed to initialize servers: %w", err)
 	}
@@ -102,10 +203,40 @@ func (c *Container) initializeHTTPClients() error {
 	return nil
 }
 
+// discoverDevice finds the HDHomeRun on the LAN when no hdhr_ip is
+// configured, by device_id when set, and uses its address.
+func (c *Container) discoverDevice() error {
+	if c.config.DeviceID != "" && !discovery.ValidDeviceID(c.config.DeviceID) {
+		return fmt.Errorf("device_id: must be 8 hexadecimal digits, got %q", c.config.DeviceID)
+	}
+	if c.config.HDHomeRunIP != "" {
+		return nil
+	}
+
+	c.logger.Info("🔍 Discovering HDHomeRun on the LAN", logger.String("device_id", c.config.DeviceID))
+	device, err := discovery.Find(context.Background(), discovery.Options{
+		Addresses: c.options.DiscoveryAddresses,
+		Client:    c.httpClient,
+	}, c.config.DeviceID)
+	if err != nil {
+		return err
+	}
+
+	c.config.HDHomeRunIP = device.Address
+	c.discovered = true
+	c.discoveredID = device.ID
+	c.logger.Info("🔍 Discovered HDHomeRun",
+		logger.String("device_id", device.ID),
+		logger.String("address", device.Address),
+		logger.String("model", device.Model),
+		logger.String("firmware", device.Firmware))
+	return nil
+}
+
 // initializeFFmpegConfig creates the FFmpeg configuration.
 func (c *Container) initializeFFmpegConfig() error {
 	// Use FFmpeg configuration with built-in AC4 error resilience
-	c.ffmpegConfig = ffmpeg.New()
+	c.ffmpegConfig = c.config.FFmpegConfig()
 
 	c.logger.Debug("🎬 Initialized FFmpeg config with AC4 error resilience")
 	return nil
@@ -135,13 +266,39 @@ func (c *Container) initializeProxy() error {
 	// Use dependency injection for the proxy
 	c.hdhrProxy = proxy.New(
 		c.config.HDHomeRunIP,
+		c.config.DeviceMediaPort,
 		c.httpClient,
 		c.logger,
 	)
 
-	// Fetch the device ID from the HDHomeRun
-	if err := c.hdhrProxy.FetchDeviceID(); err != nil {
-		return fmt.Errorf("failed to fetch HDHomeRun device ID: %w", err)
+	// Advertise the URLs clients can reach, which differ from the listening
+	// addresses behind reverse proxies and port mappings
+	c.publicURLs = &publicurl.Resolver{
+		BaseURL:         c.config.PublicBaseURL,
+		MediaURL:        c.config.PublicMediaURL,
+		MediaPort:       c.config.MediaPort,
+		SecureMediaPort: c.config.HTTPSMediaPort,
+		TrustForwarded:  c.config.TrustForwardedHeaders,
+	}
+	c.hdhrProxy.SetPublicURLs(c.publicURLs)
+
+	// Follow the configured or discovered device. Otherwise the proxy locks
+	// onto the first device ID it fetches.
+	if id := c.config.DeviceID; id != "" {
+		c.hdhrProxy.LockDeviceID(id)
+	} else if c.discoveredID != "" {
+		c.hdhrProxy.LockDeviceID(c.discoveredID)
+	}
+
+	// Fetch the device ID from the HDHomeRun. An unreachable device, e.g.
+	// one still booting after a power outage, is retried in the background
+	// by initializeDeviceConnection.
+	if err := c.hdhrProxy.FetchDeviceID(context.Background()); err != nil {
+		c.deviceOffline = true
+		c.logger.Warn("⚠️  HDHomeRun not reachable, starting degraded",
+			logger.String("hdhr_ip", c.config.HDHomeRunIP),
+			logger.ErrorField("error", err))
+		return nil
 	}
 
 	c.logger.Info("📡 HDHomeRun proxy initialized",
@@ -149,10 +306,75 @@ func (c *Container) initializeProxy() error {
 	return nil
 }
 
+// initializeSessions creates the session registry that enforces admission limits.
+func (c *Container) initializeSessions() error {
+	limits := c.sessionLimits()
+	c.sessions = session.NewRegistry(limits)
+
+	c.logger.Info("🚦 Initialized admission control",
+		logger.Int("max_transcodes", limits.MaxTranscodes),
+		logger.Int("max_per_client", limits.MaxPerClient),
+		logger.Int("max_total", limits.MaxTotal))
+	return nil
+}
+
+// initializeEvents creates the lifecycle event bus, serves it as /events on the
+// API server and starts the configured webhooks.
+func (c *Container) initializeEvents() error {
+	types, err := events.ParseTypes(c.config.WebhookEvents)
+	if err != nil {
+		return fmt.Errorf("webhook_events: %w", err)
+	}
+
+	c.events = events.NewBus()
+	c.hdhrProxy.Handle("/events", c.events.Handler(c.logger))
+
+	if len(c.config.WebhookURLs) > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		c.stopWebhooks = cancel
+		events.StartWebhooks(ctx, c.events, c.config.WebhookURLs, types, c.httpClient, c.logger)
+	}
+
+	c.logger.Debug("📣 Event stream registered",
+		logger.String("path", "/events"),
+		logger.Int("webhooks", len(c.config.WebhookURLs)))
+	return nil
+}
+
+// currentConfig returns the running configuration. Code that may run while
+// a reload happens must read settings through it rather than c.config.
+func (c *Container) currentConfig() *config.Config {
+	c.configMutex.RLock()
+	defer c.configMutex.RUnlock()
+	return c.config
+}
+
+// sessionLimits returns the admission limits for the current configuration.
+func (c *Container) sessionLimits() session.Limits {
+	cfg := c.currentConfig()
+	limits := session.Limits{
+		MaxTranscodes: cfg.MaxConcurrentTranscodes,
+		MaxPerClient:  cfg.MaxStreamsPerClient,
+		MaxTotal:      cfg.MaxTotalStreams,
+	}
+
+	// Default the total stream limit to the number of tuners on the device
+	if limits.MaxTotal == 0 {
+		limits.MaxTotal = c.hdhrProxy.TunerCount()
+	}
+	return limits
+}
+
 // initializeTranscoder creates the media transcoder with dependency injection.
 func (c *Container) initializeTranscoder() error {
 	c.logger.Debug("🎵 Creating transcoder with dependency injection")
 
+	// The health monitor self-tests FFmpeg through the same runner
+	c.ffmpegRunner = c.options.FFmpegRunner
+	if c.ffmpegRunner == nil {
+		c.ffmpegRunner = process.NewRunner(c.config.FFmpegStopGrace)
+	}
+
 	// Create transcoder dependencies struct
 	deps := &transcoder.Dependencies{
 		Config:            c.config,
@@ -163,6 +385,19 @@ func (c *Container) initializeTranscoder() error {
 		StreamHelper:      c.streamer,
 		HDHRProxy:         c.hdhrProxy,
 		SecurityValidator: c.securityValidator,
+		Sessions:          c.sessions,
+		Classifier:        session.NewClassifier(c.config.PriorityClasses),
+		Events:            c.events,
+		Runner:            c.ffmpegRunner,
+	}
+
+	// Timeshift buffering is optional and sized in minutes
+	if c.config.TimeshiftMinutes > 0 {
+		deps.Timeshift = timeshift.NewManager(time.Duration(c.config.TimeshiftMinutes)*time.Minute, c.config.TimeshiftChannels, c.config.TimeshiftDir)
+		c.logger.Info("⏪ Timeshift buffering enabled",
+			logger.Int("minutes", c.config.TimeshiftMinutes),
+			logger.Any("channels", c.config.TimeshiftChannels),
+			logger.String("dir", c.config.TimeshiftDir))
 	}
 
 	// Create transcoder with dependency injection
@@ -171,17 +406,318 @@ func (c *Container) initializeTranscoder() error {
 	if err != nil {
 		return fmt.Errorf("failed to create transcoder: %w", err)
 	}
+	if deps.Timeshift != nil {
+		c.transcoder.RegisterStatusProvider(deps.Timeshift)
+	}
 
 	c.logger.Debug("✨ Transcoder initialized with dependency injection")
 	return nil
 }
 
+// initializeGuide exposes the XMLTV guide and matching M3U playlist on the API server.
+func (c *Container) initializeGuide() {
+	c.guide = epg.New(c.config.HDHomeRunIP, c.config.GuideURL, c.config.GuideCacheTTL, c.httpClient, c.logger)
+	handler := c.guide.Handler(c.publicURLs)
+	c.hdhrProxy.Handle("/epg.xml", handler)
+	c.hdhrProxy.Handle("/lineup.m3u", handler)
+
+	c.logger.Debug("📰 Guide endpoints registered",
+		logger.String("guide_url", c.config.GuideURL),
+		logger.Duration("cache_ttl", c.config.GuideCacheTTL))
+}
+
+// initializeAdmin exposes the admin API on the API server when a token is configured.
+func (c *Container) initializeAdmin() {
+	if c.config.AdminToken == "" {
+		c.logger.Debug("🛠️  Admin API disabled (no admin token configured)")
+		return
+	}
+
+	streams, ok := c.transcoder.(interfaces.StreamAdmin)
+	if !ok {
+		c.logger.Warn("⚠️  Transcoder does not support administration, admin API disabled")
+		return
+	}
+
+	c.hdhrProxy.Handle("/admin/", admin.Handler(streams, c, c.config.AdminToken, c.logger))
+	c.logger.Info("🛠️  Admin API enabled", logger.String("path", "/admin/"))
+}
+
+// initializeDashboard serves the web dashboard on the API server.
+func (c *Container) initializeDashboard() {
+	reporter, ok := c.transcoder.(interfaces.StatusReporter)
+	if !ok {
+		c.logger.Warn("⚠️  Transcoder does not report status, dashboard disabled")
+		return
+	}
+
+	c.hdhrProxy.Handle("/dashboard/", dashboard.Handler(reporter, dashboard.DefaultInterval, c.logger))
+	c.logger.Debug("📈 Dashboard registered", logger.String("path", "/dashboard/"))
+}
+
+// initializeMQTT starts publishing state to the MQTT broker when one is configured.
+func (c *Container) initializeMQTT() {
+	if c.config.MQTTBroker == "" {
+		c.logger.Debug("📡 MQTT disabled (no broker configured)")
+		return
+	}
+
+	reporter, ok := c.transcoder.(interfaces.StatusReporter)
+	if !ok {
+		c.logger.Warn("⚠️  Transcoder does not report status, MQTT disabled")
+		return
+	}
+
+	c.mqtt = mqtt.NewPublisher(mqtt.Settings{
+		Broker:          c.config.MQTTBroker,
+		Username:        c.config.MQTTUsername,
+		Password:        c.config.MQTTPassword,
+		TopicPrefix:     c.config.MQTTTopicPrefix,
+		DiscoveryPrefix: c.config.MQTTDiscoveryPrefix,
+	}, reporter, c.events, c.logger)
+	c.mqtt.Start()
+	c.logger.Info("📡 MQTT publisher enabled", logger.String("broker", c.config.MQTTBroker))
+}
+
+// initializeHealth starts probing the device and FFmpeg in the background.
+// initializeServers serves the results as /healthz and /readyz.
+func (c *Container) initializeHealth() {
+	reporter, ok := c.transcoder.(interfaces.StatusReporter)
+	if !ok {
+		c.logger.Warn("⚠️  Transcoder does not report status, health checks disabled")
+		return
+	}
+
+	c.health = health.New(health.Options{
+		Interval:   c.config.HealthCheckInterval,
+		Device:     c.hdhrProxy.FetchDeviceID,
+		Status:     reporter,
+		FFmpegPath: c.config.FFmpegPath,
+		Runner:     c.ffmpegRunner,
+	}, c.logger)
+	c.health.Start()
+	c.logger.Debug("💓 Health checks registered",
+		logger.Duration("interval", c.config.HealthCheckInterval))
+}
+
+// initializeDeviceConnection starts the proxy degraded when the device or its
+// lineup could not be reached at startup: API and media requests get 503s
+// while the device is retried in the background with backoff.
+func (c *Container) initializeDeviceConnection() {
+	if !c.deviceOffline {
+		if reporter, ok := c.transcoder.(interfaces.StatusReporter); ok && len(reporter.Status().Lineup) == 0 {
+			c.deviceOffline = true
+			c.logger.Warn("⚠️  HDHomeRun lineup not loaded, starting degraded")
+		}
+	}
+	if !c.deviceOffline {
+		return
+	}
+
+	c.hdhrProxy.SetDeviceOffline(true)
+	c.sessions.SetDeviceOffline(true)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.stopConnect = cancel
+	c.background.Add(1)
+	go func() {
+		defer c.background.Done()
+		c.connectDevice(ctx)
+	}()
+}
+
+// connectDevice retries the device ID and lineup with exponential backoff
+// until both load, then leaves degraded mode.
+func (c *Container) connectDevice(ctx context.Context) {
+	streams, _ := c.transcoder.(interfaces.StreamAdmin)
+	delay := deviceRetryMin
+	for attempt := 1; ; attempt++ {
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+
+		err := c.hdhrProxy.FetchDeviceID(ctx)
+		if err == nil && streams != nil {
+			err = streams.RefreshLineup(ctx)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			break
+		}
+
+		delay = min(delay*2, deviceRetryMax)
+		c.logger.Debug("📡 HDHomeRun still not reachable",
+			logger.Int("attempt", attempt),
+			logger.Duration("retry_in", delay),
+			logger.ErrorField("error", err))
+	}
+
+	// The default stream limit follows the tuner count, unknown until now
+	c.sessions.SetLimits(c.sessionLimits())
+	c.sessions.SetDeviceOffline(false)
+	c.hdhrProxy.SetDeviceOffline(false)
+	if c.health != nil {
+		c.health.Refresh()
+	}
+	c.logger.Info("✅ HDHomeRun reachable, leaving degraded mode",
+		logger.String("device_id", c.hdhrProxy.DeviceID()),
+		logger.Int("tuners", c.hdhrProxy.TunerCount()))
+}
+
+// initializeDeviceWatch re-checks the device ID every device_check_interval,
+// so the proxy follows the HDHomeRun when DHCP hands it a new address.
+func (c *Container) initializeDeviceWatch() {
+	if c.config.DeviceCheckInterval == 0 {
+		c.logger.Debug("📍 Device tracking disabled (no device check interval configured)")
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.stopDeviceWatch = cancel
+	c.background.Add(1)
+	go func() {
+		defer c.background.Done()
+		c.watchDevice(ctx)
+	}()
+	c.logger.Debug("📍 Device tracking enabled",
+		logger.Duration("interval", c.config.DeviceCheckInterval))
+}
+
+// watchDevice fetches discover.json from the device's address and, when
+// another device or none answers there, looks for the locked device ID on the
+// LAN.
+func (c *Container) watchDevice(ctx context.Context) {
+	ticker := time.NewTicker(c.currentConfig().DeviceCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		err := c.hdhrProxy.FetchDeviceID(ctx)
+		deviceID := c.hdhrProxy.LockedDeviceID()
+		if err == nil || deviceID == "" || ctx.Err() != nil {
+			continue
+		}
+
+		c.logger.Debug("📍 HDHomeRun not found at its address, re-discovering",
+			logger.String("device_id", deviceID),
+			logger.String("hdhr_ip", c.hdhrProxy.GetHDHRIP()),
+			logger.ErrorField("error", err))
+		device, err := discovery.Find(ctx, discovery.Options{
+			Addresses: c.options.DiscoveryAddresses,
+			Client:    c.httpClient,
+		}, deviceID)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			c.logger.Warn("⚠️  HDHomeRun not found on the LAN",
+				logger.String("device_id", deviceID),
+				logger.ErrorField("error", err))
+			continue
+		}
+		if device.Address != c.hdhrProxy.GetHDHRIP() {
+			c.moveDevice(ctx, device.Address)
+		}
+	}
+}
+
+// moveDevice points the proxy, the transcoder and the guide at the device's
+// new address, then reloads the device ID and lineup from it. Streams already
+// running keep their connection to the old address.
+func (c *Container) moveDevice(ctx context.Context, address string) {
+	previous := c.hdhrProxy.GetHDHRIP()
+	c.hdhrProxy.SetHDHRIP(address)
+	if impl, ok := c.transcoder.(*transcoder.Impl); ok {
+		impl.SetDeviceAddress(address)
+	}
+	if c.guide != nil {
+		c.guide.SetDeviceAddress(address)
+	}
+
+	c.logger.Warn("📍 HDHomeRun moved",
+		logger.String("device_id", c.hdhrProxy.LockedDeviceID()),
+		logger.String("from", previous),
+		logger.String("to", address))
+	c.events.Publish(events.Event{
+		Type:    events.DeviceMoved,
+		Details: map[string]interface{}{"from": previous, "to": address},
+	})
+
+	err := c.hdhrProxy.FetchDeviceID(ctx)
+	if streams, ok := c.transcoder.(interfaces.StreamAdmin); ok && err == nil {
+		err = streams.RefreshLineup(ctx)
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		c.logger.Warn("⚠️  Failed to reload the HDHomeRun at its new address", logger.ErrorField("error", err))
+	}
+	if c.health != nil {
+		c.health.Refresh()
+	}
+}
+
+// initializeDVR creates the recording scheduler when a recordings directory is configured.
+func (c *Container) initializeDVR() error {
+	if c.config.DVRDirectory == "" {
+		c.logger.Debug("📼 DVR disabled (no recordings directory configured)")
+		return nil
+	}
+
+	recorder, err := dvr.New(c.config.DVRDirectory, c.transcoder, c.logger)
+	if err != nil {
+		return err
+	}
+	c.dvr = recorder
+
+	// Expose the DVR API and status section, then start scheduling. Recordings
+	// tune channels and write files to disk, so the API needs the admin token;
+	// without one, stored schedules still run but cannot be changed.
+	if c.config.AdminToken != "" {
+		handler := access.RequireToken(c.dvr.Handler(), []string{c.config.AdminToken}, admin.Realm, c.logger)
+		c.hdhrProxy.Handle("/dvr/", handler)
+		c.logger.Info("📼 DVR API enabled", logger.String("path", "/dvr/"))
+	} else {
+		c.logger.Warn("⚠️  DVR API disabled (no admin token configured), set admin_token to manage recordings")
+	}
+	c.transcoder.RegisterStatusProvider(c.dvr)
+	c.dvr.Start()
+	return nil
+}
+
 // initializeServers creates the HTTP servers.
 func (c *Container) initializeServers() error {
+	allowlist := access.NewAllowlist(c.config.AllowedNetworks)
+	apiHandler := access.RestrictNetworks(c.hdhrProxy.APIHandler(), allowlist, "api", c.logger)
+
+	// Health checks answer every client, so the container's own healthcheck
+	// on localhost passes whatever networks are allowed
+	if c.health != nil {
+		apiHandler = access.OpenPaths(apiHandler, c.health.Handler(), "/healthz", "/readyz")
+	}
+
+	// Media requests need a token when media tokens are configured
+	mediaHandler := c.transcoder.MediaHandler()
+	if len(c.config.MediaTokens) > 0 {
+		mediaHandler = access.RequireToken(mediaHandler, c.config.MediaTokens, "hdhr-proxy media", c.logger)
+	}
+	mediaHandler = access.RestrictNetworks(mediaHandler, allowlist, "media", c.logger)
+
 	// Create API server
 	c.apiServer = &http.Server{
 		Addr:         fmt.Sprintf("0.0.0.0:%d", c.config.APIPort),
-		Handler:      c.hdhrProxy.APIHandler(),
+		Handler:      apiHandler,
 		ReadTimeout:  30 * time.Second,
 		WriteTimeout: 30 * time.Second,
 		IdleTimeout:  120 * time.Second,
@@ -190,7 +726,7 @@ func (c *Container) initializeServers() error {
 	// Create media server
 	c.mediaServer = &http.Server{
 		Addr:         fmt.Sprintf("0.0.0.0:%d", c.config.MediaPort),
-		Handler:      c.transcoder.MediaHandler(),
+		Handler:      mediaHandler,
 		ReadTimeout:  30 * time.Second,
 		WriteTimeout: 0, // No write timeout for streaming
 		IdleTimeout:  120 * time.Second,
@@ -198,39 +734,311 @@ func (c *Container) initializeServers() error {
 
 	c.logger.Debug("🚀 Initialized servers",
 		logger.Int("api_port", c.config.APIPort),
-		logger.Int("media_port", c.config.MediaPort))
+		logger.Int("media_port", c.config.MediaPort),
+		logger.Any("allowed_networks", c.config.AllowedNetworks),
+		logger.Int("media_tokens", len(c.config.MediaTokens)))
+
+	return c.initializeTLSServers(apiHandler, mediaHandler)
+}
+
+// initializeTLSServers creates the HTTPS servers when an HTTPS port is
+// configured, sharing the certificate and reloading it when its files change.
+func (c *Container) initializeTLSServers(apiHandler, mediaHandler http.Handler) error {
+	if c.config.HTTPSAPIPort == 0 && c.config.HTTPSMediaPort == 0 {
+		return nil
+	}
+
+	source, err := tlscert.New(tlscert.Settings{
+		CertFile: c.config.TLSCertFile,
+		KeyFile:  c.config.TLSKeyFile,
+		Dir:      c.tlsDir(),
+		Hosts:    c.config.TLSHosts,
+	}, c.logger)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c.stopTLSWatch = cancel
+	source.Watch(ctx)
+
+	if c.config.HTTPSAPIPort > 0 {
+		c.apiTLSServer = &http.Server{
+			Addr:         fmt.Sprintf("0.0.0.0:%d", c.config.HTTPSAPIPort),
+			Handler:      apiHandler,
+			TLSConfig:    source.TLSConfig(),
+			ReadTimeout:  30 * time.Second,
+			WriteTimeout: 30 * time.Second,
+			IdleTimeout:  120 * time.Second,
+		}
+	}
+
+	if c.config.HTTPSMediaPort > 0 {
+		c.mediaTLSServer = &http.Server{
+			Addr:         fmt.Sprintf("0.0.0.0:%d", c.config.HTTPSMediaPort),
+			Handler:      mediaHandler,
+			TLSConfig:    source.TLSConfig(),
+			ReadTimeout:  30 * time.Second,
+			WriteTimeout: 0, // No write timeout for streaming
+			IdleTimeout:  120 * time.Second,
+		}
+	}
+
+	c.logger.Info("🔐 HTTPS enabled",
+		logger.Int("https_api_port", c.config.HTTPSAPIPort),
+		logger.Int("https_media_port", c.config.HTTPSMediaPort))
 	return nil
 }
 
+// tlsDir returns where a self-signed certificate is kept.
+func (c *Container) tlsDir() string {
+	if c.config.TLSDir != "" {
+		return c.config.TLSDir
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "hdhr-proxy", "tls")
+}
+
+// Start serves the API and media servers, and the HTTPS servers when enabled,
+// in the background. Errors other than a shutdown are sent on the returned channel.
+func (c *Container) Start() <-chan error {
+	errs := make(chan error, 4)
+	serve := func(name, addr string, run func() error) {
+		c.logger.Info("🌐 Starting "+name+" server", logger.String("address", addr))
+		go func() {
+			if err := run(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				errs <- fmt.Errorf("%s server: %w", name, err)
+			}
+		}()
+	}
+
+	plain := []struct {
+		name     string
+		server   *http.Server
+		listener net.Listener
+	}{
+		{"API", c.apiServer, c.options.APIListener},
+		{"media", c.mediaServer, c.options.MediaListener},
+	}
+	for _, s := range plain {
+		server, listener := s.server, s.listener
+		if listener != nil {
+			serve(s.name, listener.Addr().String(), func() error { return server.Serve(listener) })
+		} else {
+			serve(s.name, server.Addr, server.ListenAndServe)
+		}
+	}
+
+	for _, s := range []struct {
+		name   string
+		server *http.Server
+	}{
+		{"HTTPS API", c.apiTLSServer},
+		{"HTTPS media", c.mediaTLSServer},
+	} {
+		if server := s.server; server != nil {
+			serve(s.name, server.Addr, func() error { return server.ListenAndServeTLS("", "") })
+		}
+	}
+	return errs
+}
+
 // GetAPIServer returns the API server.
 func (c *Container) GetAPIServer() *http.Server {
 	return c.apiServer
 }
 
+// GetAPITLSServer returns the HTTPS API server, or nil when it is disabled.
+func (c *Container) GetAPITLSServer() *http.Server {
+	return c.apiTLSServer
+}
+
+// GetMediaTLSServer returns the HTTPS media server, or nil when it is disabled.
+func (c *Container) GetMediaTLSServer() *http.Server {
+	return c.mediaTLSServer
+}
+
 // GetMediaServer returns the media server.
 func (c *Container) GetMediaServer() *http.Server {
 	return c.mediaServer
 }
 
+// Reload applies the reloadable settings of updated to the running
+// components and returns the changed keys that need a restart to take
+// effect. Streams already running keep the settings they started with.
+func (c *Container) Reload(updated *config.Config) []string {
+	running := c.currentConfig()
+
+	// A discovered address is not a change to hdhr_ip
+	if c.discovered && updated.HDHomeRunIP == "" {
+		updated.HDHomeRunIP = running.HDHomeRunIP
+	}
+	next, applied, restart := running.Reloaded(updated)
+
+	for _, key := range restart {
+		c.logger.Warn("⚠️  Setting changed but requires a restart", logger.String("key", key))
+	}
+	if len(applied) == 0 {
+		c.logger.Info("🔄 Configuration reloaded, no reloadable settings changed")
+		return restart
+	}
+
+	// Swap in the new settings; goroutines holding the old ones keep a
+	// consistent copy
+	c.configMutex.Lock()
+	c.config = next
+	c.configMutex.Unlock()
+
+	level := logger.LevelFromString(next.LogLevel)
+	logger.SetLevel(level)
+	if zapLogger, ok := c.logger.(*logger.ZapLogger); ok {
+		zapLogger.SetLevel(level)
+	}
+
+	c.sessions.SetLimits(c.sessionLimits())
+	if impl, ok := c.transcoder.(*transcoder.Impl); ok {
+		impl.Reload(next)
+	}
+
+	c.logger.Info("🔄 Configuration reloaded", logger.Any("applied", applied))
+	return restart
+}
+
+// StartDrain stops admitting new streams and makes discovery report the
+// proxy as unavailable. Running streams continue; main exits once they end or
+// drain_timeout passes. Starting a drain again is a no-op.
+func (c *Container) StartDrain() {
+	c.drainOnce.Do(func() {
+		c.drainMutex.Lock()
+		c.drainSince = time.Now()
+		c.drainMutex.Unlock()
+
+		c.sessions.SetDraining(true)
+		c.hdhrProxy.SetDraining(true)
+		c.logger.Info("🚰 Draining: refusing new streams until the running ones end",
+			logger.Int("active_streams", len(c.sessions.List())),
+			logger.Duration("drain_timeout", c.currentConfig().DrainTimeout))
+		close(c.drainStarted)
+	})
+}
+
+// Draining returns a channel that is closed when a drain starts.
+func (c *Container) Draining() <-chan struct{} {
+	return c.drainStarted
+}
+
+// DrainStatus reports whether a drain is in progress and when it ends.
+func (c *Container) DrainStatus() interfaces.DrainStatus {
+	status := interfaces.DrainStatus{ActiveStreams: len(c.sessions.List())}
+
+	c.drainMutex.Lock()
+	defer c.drainMutex.Unlock()
+	if !c.drainSince.IsZero() {
+		since := c.drainSince
+		deadline := since.Add(c.currentConfig().DrainTimeout)
+		status.Draining = true
+		status.Since = &since
+		status.Deadline = &deadline
+	}
+	return status
+}
+
+// WaitForDrain blocks until every stream has ended, the drain deadline has
+// passed or ctx is done, and reports whether the streams all ended.
+func (c *Container) WaitForDrain(ctx context.Context) bool {
+	status := c.DrainStatus()
+	if !status.Draining {
+		return status.ActiveStreams == 0
+	}
+
+	ctx, cancel := context.WithDeadline(ctx, *status.Deadline)
+	defer cancel()
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for {
+		active := len(c.sessions.List())
+		if active == 0 {
+			c.logger.Info("✅ Drain complete, all streams ended")
+			return true
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			c.logger.Warn("⏰ Drain ended with streams still running",
+				logger.Int("active_streams", active),
+				logger.ErrorField("reason", context.Cause(ctx)))
+			return false
+		}
+	}
+}
+
 // Shutdown performs graceful shutdown of all components.
 func (c *Container) Shutdown(ctx context.Context) error {
 	c.logger.Info("🛑 Shutting down container...")
 
+	// Stop the DVR so recordings in progress are finalized
+	if c.dvr != nil {
+		c.dvr.Stop()
+	}
+
 	// Shutdown transcoder first to stop ongoing streams
 	if c.transcoder != nil {
 		c.transcoder.Shutdown()
 	}
 
+	// Stop retrying an offline device
+	if c.stopConnect != nil {
+		c.stopConnect()
+	}
+
+	// Stop following the device to new addresses
+	if c.stopDeviceWatch != nil {
+		c.stopDeviceWatch()
+	}
+	c.background.Wait()
+
+	// Stop probing the device and FFmpeg
+	if c.health != nil {
+		c.health.Stop()
+	}
+
+	// Mark the proxy offline on the MQTT broker
+	if c.mqtt != nil {
+		c.mqtt.Stop()
+	}
+
+	// Stop webhook delivery
+	if c.stopWebhooks != nil {
+		c.stopWebhooks()
+	}
+
 	// Shutdown servers
-	if c.apiServer != nil {
-		if err := c.apiServer.Shutdown(ctx); err != nil {
-			c.logger.Error("❌ Error shutting down API server", logger.ErrorField("error", err))
-		}
+	if c.stopTLSWatch != nil {
+		c.stopTLSWatch()
 	}
 
-	if c.mediaServer != nil {
-		if err := c.mediaServer.Shutdown(ctx); err != nil {
-			c.logger.Error("❌ Error shutting down media server", logger.ErrorField("error", err))
+	servers := []struct {
+		name   string
+		server *http.Server
+	}{
+		{"API", c.apiServer},
+		{"media", c.mediaServer},
+		{"HTTPS API", c.apiTLSServer},
+		{"HTTPS media", c.mediaTLSServer},
+	}
+	for _, s := range servers {
+		if s.server == nil {
+			continue
+		}
+		if err := s.server.Shutdown(ctx); err != nil {
+			// Close the connections of clients that did not let their
+			// streams end in time, e.g. paused players
+			c.logger.Error("❌ Error shutting down "+s.name+" server", logger.ErrorField("error", err))
+			s.server.Close()
 		}
 	}
 
@@ -0,0 +1,84 @@
+// Package dashboard serves the embedded web dashboard for monitoring live streams.
+package dashboard
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/attaebra/hdhr-proxy/internal/constants"
+	"github.com/attaebra/hdhr-proxy/internal/interfaces"
+	"github.com/attaebra/hdhr-proxy/internal/logger"
+	"github.com/attaebra/hdhr-proxy/internal/utils"
+)
+
+// DefaultInterval is how often the dashboard receives a status update.
+const DefaultInterval = 2 * time.Second
+
+//go:embed static/index.html
+var indexHTML []byte
+
+// Handler returns the dashboard endpoints:
+//
+//	GET /dashboard/         the dashboard page
+//	GET /dashboard/status   the current status as JSON
+//	GET /dashboard/events   status updates as Server-Sent Events every interval
+//
+// The page's stop and refresh buttons call the admin API with the admin token.
+func Handler(reporter interfaces.StatusReporter, interval time.Duration, log interfaces.Logger) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /dashboard/{$}", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write(indexHTML)
+	})
+
+	mux.HandleFunc("GET /dashboard/status", func(w http.ResponseWriter, _ *http.Request) {
+		_ = utils.WriteJSONResponse(w, reporter.Status())
+	})
+
+	mux.HandleFunc("GET /dashboard/events", func(w http.ResponseWriter, r *http.Request) {
+		// Event streams outlive the API server's write timeout
+		rc := http.NewResponseController(w)
+		_ = rc.SetWriteDeadline(time.Time{})
+
+		w.Header().Set("Content-Type", constants.ContentTypeEventStream)
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+
+		log.Debug("📈 Dashboard client connected", logger.String("client_ip", utils.ClientIP(r)))
+		defer log.Debug("📈 Dashboard client disconnected", logger.String("client_ip", utils.ClientIP(r)))
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			if err := writeEvent(w, "status", reporter.Status()); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+
+			select {
+			case <-r.Context().Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+
+	return mux
+}
+
+// writeEvent writes a single Server-Sent Event with a JSON payload.
+func writeEvent(w http.ResponseWriter, event string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	return err
+}
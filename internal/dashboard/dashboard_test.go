@@ -0,0 +1,86 @@
+package dashboard
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/attaebra/hdhr-proxy/internal/interfaces"
+	"github.com/attaebra/hdhr-proxy/internal/logger"
+)
+
+// fakeReporter returns a status whose session count grows with each call.
+type fakeReporter struct {
+	calls atomic.Int32
+}
+
+func (f *fakeReporter) Status() interfaces.Status {
+	n := int(f.calls.Add(1))
+	status := interfaces.Status{
+		Device: interfaces.DeviceStatus{IP: "192.168.1.100", DeviceID: "ABCDEF12", TunerCount: 2},
+		Lineup: []interfaces.ChannelStatus{{GuideNumber: "5.1", GuideName: "WABC", AC4: true}},
+	}
+	for i := 0; i < n; i++ {
+		status.Sessions = append(status.Sessions, interfaces.SessionInfo{ID: "s", Channel: "5.1"})
+	}
+	return status
+}
+
+func TestDashboardPageAndStatus(t *testing.T) {
+	h := Handler(&fakeReporter{}, time.Second, logger.NewZapLogger(logger.LevelDebug))
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, httptest.NewRequest("GET", "/dashboard/", nil))
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), "EventSource") {
+		t.Errorf("Expected the embedded dashboard page, got %d", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, httptest.NewRequest("GET", "/dashboard/status", nil))
+	var status interfaces.Status
+	if err := json.Unmarshal(recorder.Body.Bytes(), &status); err != nil || status.Device.DeviceID != "ABCDEF12" {
+		t.Errorf("Expected JSON status, got %s (%v)", recorder.Body.String(), err)
+	}
+}
+
+func TestDashboardEvents(t *testing.T) {
+	server := httptest.NewServer(Handler(&fakeReporter{}, 10*time.Millisecond, logger.NewZapLogger(logger.LevelDebug)))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL+"/dashboard/events", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Expected an event stream, got %q", ct)
+	}
+
+	// Each update carries a fresh status
+	scanner := bufio.NewScanner(resp.Body)
+	var updates []interfaces.Status
+	for len(updates) < 2 && scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		var status interfaces.Status
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &status); err != nil {
+			t.Fatalf("Invalid event payload %q: %v", line, err)
+		}
+		updates = append(updates, status)
+	}
+	if len(updates) != 2 || len(updates[1].Sessions) <= len(updates[0].Sessions) {
+		t.Errorf("Expected successive status updates, got %+v", updates)
+	}
+}
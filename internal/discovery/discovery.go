@@ -0,0 +1,436 @@
+// Package discovery finds HDHomeRun devices on the LAN with the SiliconDust
+// UDP discovery protocol, so the proxy can follow a device by its ID when
+// DHCP hands it a new address.
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/attaebra/hdhr-proxy/internal/interfaces"
+)
+
+// Port is the UDP port devices answer discovery requests on.
+const Port = 65001
+
+// DefaultTimeout is how long Discover collects replies.
+const DefaultTimeout = 2 * time.Second
+
+// Packet types.
+const (
+	typeDiscoverRequest uint16 = 0x0002
+	typeDiscoverReply   uint16 = 0x0003
+)
+
+// Tags of the tag-length-value fields in discovery packets.
+const (
+	tagDeviceType uint8 = 0x01
+	tagDeviceID   uint8 = 0x02
+	tagTunerCount uint8 = 0x10
+	tagLineupURL  uint8 = 0x27
+	tagBaseURL    uint8 = 0x2A
+)
+
+const (
+	deviceTypeTuner    uint32 = 0x00000001
+	deviceTypeWildcard uint32 = 0xFFFFFFFF
+	deviceIDWildcard   uint32 = 0xFFFFFFFF
+)
+
+// Device is an HDHomeRun that answered discovery.
+type Device struct {
+	ID         string `json:"device_id"`
+	IP         string `json:"ip"`      // Address the reply came from
+	Address    string `json:"address"` // Host, with a port when not 80, serving the device API
+	TunerCount int    `json:"tuner_count"`
+	BaseURL    string `json:"base_url,omitempty"`
+	LineupURL  string `json:"lineup_url,omitempty"`
+	Model      string `json:"model,omitempty"`    // From discover.json
+	Firmware   string `json:"firmware,omitempty"` // From discover.json
+}
+
+// Options configure Discover.
+type Options struct {
+	// Addresses requests are sent to. Empty broadcasts on every IPv4
+	// interface.
+	Addresses []string
+	// How long replies are collected. Zero uses DefaultTimeout.
+	Timeout time.Duration
+	// Fetches discover.json for the model and firmware. Nil skips it.
+	Client interfaces.Client
+}
+
+// Discover broadcasts a discovery request and returns the devices that answer
+// before the timeout, sorted by ID.
+func Discover(ctx context.Context, opts Options) ([]Device, error) {
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	targets := opts.Addresses
+	if len(targets) == 0 {
+		targets = broadcastAddresses()
+	}
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open discovery socket: %w", err)
+	}
+	defer conn.Close()
+
+	request := EncodeRequest()
+	sent := 0
+	for _, target := range targets {
+		addr, err := net.ResolveUDPAddr("udp4", target)
+		if err != nil {
+			return nil, fmt.Errorf("invalid discovery address %q: %w", target, err)
+		}
+		if _, err := conn.WriteTo(request, addr); err == nil {
+			sent++
+		}
+	}
+	if sent == 0 {
+		return nil, errors.New("failed to send discovery request")
+	}
+
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetReadDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() { conn.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	found := make(map[string]Device)
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			break
+		}
+		device, err := ParseReply(buf[:n])
+		if err != nil {
+			continue
+		}
+		device.IP = from.IP.String()
+		device.Address = apiAddress(device)
+		found[device.ID] = device
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	devices := make([]Device, 0, len(found))
+	for _, device := range found {
+		if opts.Client != nil {
+			describe(opts.Client, &device)
+		}
+		devices = append(devices, device)
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].ID < devices[j].ID })
+	return devices, nil
+}
+
+// Find discovers the device with deviceID. An empty deviceID picks the only
+// device on the LAN and fails when there are several.
+func Find(ctx context.Context, opts Options, deviceID string) (Device, error) {
+	devices, err := Discover(ctx, opts)
+	if err != nil {
+		return Device{}, err
+	}
+
+	if deviceID == "" {
+		switch len(devices) {
+		case 0:
+			return Device{}, errors.New("no HDHomeRun found")
+		case 1:
+			return devices[0], nil
+		}
+		ids := make([]string, len(devices))
+		for i, device := range devices {
+			ids[i] = device.ID
+		}
+		return Device{}, fmt.Errorf("found %d HDHomeRuns (%s), set device_id to pick one", len(devices), strings.Join(ids, ", "))
+	}
+
+	for _, device := range devices {
+		if strings.EqualFold(device.ID, deviceID) {
+			return device, nil
+		}
+	}
+	return Device{}, fmt.Errorf("no HDHomeRun with device ID %s found (%d other devices answered)", strings.ToUpper(deviceID), len(devices))
+}
+
+// ValidDeviceID reports whether id is eight hexadecimal digits.
+func ValidDeviceID(id string) bool {
+	if len(id) != 8 {
+		return false
+	}
+	_, err := strconv.ParseUint(id, 16, 32)
+	return err == nil
+}
+
+// EncodeRequest builds a request any tuner answers.
+func EncodeRequest() []byte {
+	var payload bytes.Buffer
+	writeUint32(&payload, tagDeviceType, deviceTypeTuner)
+	writeUint32(&payload, tagDeviceID, deviceIDWildcard)
+	return encodePacket(typeDiscoverRequest, payload.Bytes())
+}
+
+// EncodeReply builds the reply a device sends for d.
+func EncodeReply(d Device) ([]byte, error) {
+	id, err := strconv.ParseUint(d.ID, 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid device ID %q", d.ID)
+	}
+
+	var payload bytes.Buffer
+	writeUint32(&payload, tagDeviceType, deviceTypeTuner)
+	writeUint32(&payload, tagDeviceID, uint32(id))
+	writeField(&payload, tagTunerCount, []byte{byte(d.TunerCount)})
+	if d.BaseURL != "" {
+		writeField(&payload, tagBaseURL, []byte(d.BaseURL))
+	}
+	if d.LineupURL != "" {
+		writeField(&payload, tagLineupURL, []byte(d.LineupURL))
+	}
+	return encodePacket(typeDiscoverReply, payload.Bytes()), nil
+}
+
+// ParseReply decodes a discovery reply. IP and Address are left to the caller,
+// which knows where the reply came from.
+func ParseReply(packet []byte) (Device, error) {
+	payload, err := decodePacket(packet, typeDiscoverReply)
+	if err != nil {
+		return Device{}, err
+	}
+
+	var d Device
+	err = eachField(payload, func(tag uint8, value []byte) {
+		switch tag {
+		case tagDeviceID:
+			if len(value) == 4 {
+				d.ID = fmt.Sprintf("%08X", binary.BigEndian.Uint32(value))
+			}
+		case tagTunerCount:
+			if len(value) == 1 {
+				d.TunerCount = int(value[0])
+			}
+		case tagBaseURL:
+			d.BaseURL = string(value)
+		case tagLineupURL:
+			d.LineupURL = string(value)
+		}
+	})
+	if err != nil {
+		return Device{}, err
+	}
+	if d.ID == "" {
+		return Device{}, errors.New("reply has no device ID")
+	}
+	return d, nil
+}
+
+// Respond answers the discovery requests arriving on conn as device d, like
+// the hardware does, until conn is closed.
+func Respond(conn net.PacketConn, d Device) error {
+	reply, err := EncodeReply(d)
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		if !matchesRequest(buf[:n], d) {
+			continue
+		}
+		conn.WriteTo(reply, from)
+	}
+}
+
+// matchesRequest reports whether a request asks for a tuner with d's ID or
+// any ID.
+func matchesRequest(packet []byte, d Device) bool {
+	payload, err := decodePacket(packet, typeDiscoverRequest)
+	if err != nil {
+		return false
+	}
+
+	id, _ := strconv.ParseUint(d.ID, 16, 32)
+	matches := true
+	eachField(payload, func(tag uint8, value []byte) {
+		if len(value) != 4 {
+			return
+		}
+		v := binary.BigEndian.Uint32(value)
+		switch tag {
+		case tagDeviceType:
+			matches = matches && (v == deviceTypeTuner || v == deviceTypeWildcard)
+		case tagDeviceID:
+			matches = matches && (v == uint32(id) || v == deviceIDWildcard)
+		}
+	})
+	return matches
+}
+
+// encodePacket frames a payload: type and length, big-endian, then the
+// payload and a little-endian CRC-32 of everything before it.
+func encodePacket(packetType uint16, payload []byte) []byte {
+	packet := binary.BigEndian.AppendUint16(nil, packetType)
+	packet = binary.BigEndian.AppendUint16(packet, uint16(len(payload)))
+	packet = append(packet, payload...)
+	return binary.LittleEndian.AppendUint32(packet, crc32.ChecksumIEEE(packet))
+}
+
+// decodePacket checks a packet's framing and returns its payload.
+func decodePacket(packet []byte, packetType uint16) ([]byte, error) {
+	if len(packet) < 8 {
+		return nil, errors.New("packet too short")
+	}
+	body, sum := packet[:len(packet)-4], binary.LittleEndian.Uint32(packet[len(packet)-4:])
+	if crc32.ChecksumIEEE(body) != sum {
+		return nil, errors.New("packet checksum mismatch")
+	}
+	if t := binary.BigEndian.Uint16(body); t != packetType {
+		return nil, fmt.Errorf("unexpected packet type 0x%04x", t)
+	}
+	if length := int(binary.BigEndian.Uint16(body[2:])); length != len(body)-4 {
+		return nil, errors.New("packet length mismatch")
+	}
+	return body[4:], nil
+}
+
+// eachField calls fn for every tag-length-value field in payload. Lengths
+// above 127 take two bytes, the first with its top bit set.
+func eachField(payload []byte, fn func(tag uint8, value []byte)) error {
+	for len(payload) > 0 {
+		if len(payload) < 2 {
+			return errors.New("truncated field")
+		}
+		tag, length, rest := payload[0], int(payload[1]), payload[2:]
+		if length&0x80 != 0 {
+			if len(rest) < 1 {
+				return errors.New("truncated field length")
+			}
+			length = length&0x7F | int(rest[0])<<7
+			rest = rest[1:]
+		}
+		if len(rest) < length {
+			return errors.New("truncated field value")
+		}
+		fn(tag, rest[:length])
+		payload = rest[length:]
+	}
+	return nil
+}
+
+func writeField(b *bytes.Buffer, tag uint8, value []byte) {
+	b.WriteByte(tag)
+	if len(value) > 127 {
+		b.WriteByte(byte(len(value)&0x7F) | 0x80)
+		b.WriteByte(byte(len(value) >> 7))
+	} else {
+		b.WriteByte(byte(len(value)))
+	}
+	b.Write(value)
+}
+
+func writeUint32(b *bytes.Buffer, tag uint8, v uint32) {
+	writeField(b, tag, binary.BigEndian.AppendUint32(nil, v))
+}
+
+// apiAddress returns where the device API is served: the host of its base
+// URL, falling back to the address the reply came from.
+func apiAddress(d Device) string {
+	if u, err := url.Parse(d.BaseURL); err == nil && u.Host != "" {
+		if u.Port() == "80" {
+			return u.Hostname()
+		}
+		return u.Host
+	}
+	return d.IP
+}
+
+// describe fills in the model and firmware from the device's discover.json.
+// Devices that do not answer keep them empty.
+func describe(client interfaces.Client, d *Device) {
+	resp, err := client.Get("http://" + d.Address + "/discover.json")
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return
+	}
+
+	var info struct {
+		ModelNumber     string
+		FirmwareName    string
+		FirmwareVersion string
+		TunerCount      int
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return
+	}
+	d.Model = info.ModelNumber
+	d.Firmware = strings.TrimSpace(info.FirmwareName + " " + info.FirmwareVersion)
+	if d.TunerCount == 0 {
+		d.TunerCount = info.TunerCount
+	}
+}
+
+// broadcastAddresses returns the limited broadcast address and the broadcast
+// address of every IPv4 interface that is up, so requests reach every LAN
+// the host is attached to.
+func broadcastAddresses() []string {
+	targets := []string{net.JoinHostPort(net.IPv4bcast.String(), strconv.Itoa(Port))}
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return targets
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagBroadcast == 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipnet, ok := addr.(*net.IPNet)
+			if !ok || ipnet.IP.To4() == nil {
+				continue
+			}
+			ip, mask := ipnet.IP.To4(), net.IP(ipnet.Mask).To4()
+			if mask == nil {
+				continue
+			}
+			broadcast := make(net.IP, 4)
+			for i := range broadcast {
+				broadcast[i] = ip[i] | ^mask[i]
+			}
+			targets = append(targets, net.JoinHostPort(broadcast.String(), strconv.Itoa(Port)))
+		}
+	}
+	return targets
+}
@@ -0,0 +1,166 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// respond starts a local UDP responder answering as d and returns its address.
+func respond(t *testing.T, d Device) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go Respond(conn, d)
+	return conn.LocalAddr().String()
+}
+
+func options(addresses ...string) Options {
+	return Options{Addresses: addresses, Timeout: 200 * time.Millisecond}
+}
+
+func TestReplyRoundTrip(t *testing.T) {
+	want := Device{
+		ID:         "1050ABCD",
+		TunerCount: 4,
+		BaseURL:    "http://192.168.1.20:80",
+		LineupURL:  "http://192.168.1.20:80/lineup.json?" + strings.Repeat("x", 200),
+	}
+	packet, err := EncodeReply(want)
+	if err != nil {
+		t.Fatalf("EncodeReply failed: %v", err)
+	}
+
+	got, err := ParseReply(packet)
+	if err != nil {
+		t.Fatalf("ParseReply failed: %v", err)
+	}
+	if got != want {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+
+	packet[6] ^= 0xFF
+	if _, err := ParseReply(packet); err == nil {
+		t.Error("Expected a corrupted reply to be rejected")
+	}
+	if _, err := ParseReply(EncodeRequest()); err == nil {
+		t.Error("Expected a request to be rejected as a reply")
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	addresses := []string{
+		respond(t, Device{ID: "1050ABCD", TunerCount: 2}),
+		respond(t, Device{ID: "10A00001", TunerCount: 4}),
+	}
+
+	devices, err := Discover(context.Background(), options(addresses...))
+	if err != nil {
+		t.Fatalf("Discover failed: %v", err)
+	}
+	if len(devices) != 2 || devices[0].ID != "1050ABCD" || devices[1].ID != "10A00001" {
+		t.Fatalf("Expected both devices sorted by ID, got %+v", devices)
+	}
+	if devices[0].IP != "127.0.0.1" || devices[0].Address != "127.0.0.1" || devices[0].TunerCount != 2 {
+		t.Errorf("Unexpected device %+v", devices[0])
+	}
+
+	tests := []struct {
+		deviceID string
+		want     string
+		err      string
+	}{
+		{"10a00001", "10A00001", ""},
+		{"", "", "found 2 HDHomeRuns (1050ABCD, 10A00001)"},
+		{"12345678", "", "no HDHomeRun with device ID 12345678"},
+	}
+	for _, tt := range tests {
+		device, err := Find(context.Background(), options(addresses...), tt.deviceID)
+		switch {
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("Find(%q): expected error %q, got %v", tt.deviceID, tt.err, err)
+		case tt.err == "" && (err != nil || device.ID != tt.want):
+			t.Errorf("Find(%q): expected %s, got %+v, %v", tt.deviceID, tt.want, device, err)
+		}
+	}
+
+	// A single device is picked without an ID
+	device, err := Find(context.Background(), options(addresses[0]), "")
+	if err != nil || device.ID != "1050ABCD" {
+		t.Errorf("Expected the only device to be picked, got %+v, %v", device, err)
+	}
+}
+
+func TestDiscoverDescribesDevice(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"ModelNumber":"HDHR5-4K","FirmwareName":"hdhomerun5_atsc3","FirmwareVersion":"20240101","TunerCount":4}`)
+	}))
+	defer api.Close()
+	address := respond(t, Device{ID: "1050ABCD", BaseURL: api.URL})
+
+	opts := options(address)
+	opts.Client = api.Client()
+	device, err := Find(context.Background(), opts, "1050ABCD")
+	if err != nil {
+		t.Fatalf("Find failed: %v", err)
+	}
+	if device.Address != strings.TrimPrefix(api.URL, "http://") {
+		t.Errorf("Expected the API address from the base URL, got %q", device.Address)
+	}
+	if device.Model != "HDHR5-4K" || device.Firmware != "hdhomerun5_atsc3 20240101" || device.TunerCount != 4 {
+		t.Errorf("Expected details from discover.json, got %+v", device)
+	}
+}
+
+func TestDiscoverNoDevices(t *testing.T) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := Find(context.Background(), options(conn.LocalAddr().String()), ""); err == nil || err.Error() != "no HDHomeRun found" {
+		t.Errorf("Expected no devices to be found, got %v", err)
+	}
+}
+
+func TestRespondIgnoresOtherDevices(t *testing.T) {
+	d := Device{ID: "1050ABCD"}
+	other := encodeRequestFor(0x12345678)
+	if matchesRequest(other, d) {
+		t.Error("Expected a request for another device ID to be ignored")
+	}
+	if !matchesRequest(encodeRequestFor(0x1050ABCD), d) || !matchesRequest(EncodeRequest(), d) {
+		t.Error("Expected requests for the device or any device to be answered")
+	}
+}
+
+func encodeRequestFor(id uint32) []byte {
+	var payload strings.Builder
+	payload.Write([]byte{tagDeviceType, 4, 0, 0, 0, 1})
+	payload.Write([]byte{tagDeviceID, 4, byte(id >> 24), byte(id >> 16), byte(id >> 8), byte(id)})
+	return encodePacket(typeDiscoverRequest, []byte(payload.String()))
+}
+
+func TestValidDeviceID(t *testing.T) {
+	for id, want := range map[string]bool{
+		"1050ABCD":  true,
+		"1050abcd":  true,
+		"1050ABC":   false,
+		"1050ABCDE": false,
+		"1050ABCG":  false,
+		"":          false,
+	} {
+		if got := ValidDeviceID(id); got != want {
+			t.Errorf("ValidDeviceID(%q) = %v, want %v", id, got, want)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package dvr
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/attaebra/hdhr-proxy/internal/constants"
+	"github.com/attaebra/hdhr-proxy/internal/logger"
+	"github.com/attaebra/hdhr-proxy/internal/utils"
+)
+
+// scheduleView is the API representation of a schedule.
+type scheduleView struct {
+	Schedule
+	Next *time.Time `json:"next,omitempty"`
+}
+
+// Handler returns the REST API for managing recordings:
+//
+//	GET    /dvr/schedules       list schedules
+//	POST   /dvr/schedules       create a schedule
+//	GET    /dvr/schedules/{id}  get a schedule
+//	DELETE /dvr/schedules/{id}  delete a schedule
+//	GET    /dvr/recordings      list active and recent recordings
+func (d *DVR) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /dvr/schedules", func(w http.ResponseWriter, _ *http.Request) {
+		schedules := d.Schedules()
+		views := make([]scheduleView, 0, len(schedules))
+		for _, s := range schedules {
+			views = append(views, d.view(s))
+		}
+		_ = utils.WriteJSONResponse(w, views)
+	})
+
+	mux.HandleFunc("POST /dvr/schedules", func(w http.ResponseWriter, r *http.Request) {
+		var s Schedule
+		decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&s); err != nil {
+			http.Error(w, "Invalid schedule: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		created, err := d.AddSchedule(s)
+		if err != nil {
+			d.logger.Warn("⚠️  Rejected DVR schedule", logger.ErrorField("error", err))
+			http.Error(w, "Invalid schedule: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", constants.ContentTypeJSON)
+		w.WriteHeader(http.StatusCreated)
+		_ = utils.WriteJSONResponse(w, d.view(created))
+	})
+
+	mux.HandleFunc("GET /dvr/schedules/{id}", func(w http.ResponseWriter, r *http.Request) {
+		s, ok := d.Schedule(r.PathValue("id"))
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_ = utils.WriteJSONResponse(w, d.view(s))
+	})
+
+	mux.HandleFunc("DELETE /dvr/schedules/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if !d.RemoveSchedule(r.PathValue("id")) {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("GET /dvr/recordings", func(w http.ResponseWriter, _ *http.Request) {
+		_ = utils.WriteJSONResponse(w, d.Recordings())
+	})
+
+	return mux
+}
+
+// view adds the next occurrence to a schedule for API output.
+func (d *DVR) view(s Schedule) scheduleView {
+	v := scheduleView{Schedule: s}
+	if next, ok := s.NextOccurrence(d.now()); ok {
+		v.Next = &next
+	}
+	return v
+}
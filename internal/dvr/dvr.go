@@ -0,0 +1,313 @@
+package dvr
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/attaebra/hdhr-proxy/internal/interfaces"
+	"github.com/attaebra/hdhr-proxy/internal/logger"
+)
+
+const (
+	// checkInterval is how often the scheduler looks for recordings to start.
+	checkInterval = time.Second
+
+	// maxHistory is the number of finished recordings kept for the API and status page.
+	maxHistory = 50
+)
+
+// DVR schedules recordings and runs them through the transcoder pipeline.
+type DVR struct {
+	dir        string
+	store      *Store
+	transcoder interfaces.Transcoder
+	logger     interfaces.Logger
+
+	mutex     sync.Mutex
+	schedules map[string]*Schedule
+	active    map[string]Recording // Recordings in progress by schedule ID
+	history   []Recording          // Most recent finished recordings, newest last
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	now     func() time.Time
+	started bool
+}
+
+// Ensure DVR can contribute to the status page.
+var _ interfaces.StatusProvider = (*DVR)(nil)
+
+// New creates a DVR that writes recordings to dir and loads persisted schedules.
+func New(dir string, transcoder interfaces.Transcoder, log interfaces.Logger) (*DVR, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create recordings directory %s: %w", dir, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &DVR{
+		dir:        dir,
+		store:      NewStore(dir),
+		transcoder: transcoder,
+		logger:     log,
+		schedules:  make(map[string]*Schedule),
+		active:     make(map[string]Recording),
+		ctx:        ctx,
+		cancel:     cancel,
+		now:        time.Now,
+	}
+
+	schedules, err := d.store.Load()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	for i := range schedules {
+		s := schedules[i]
+		d.schedules[s.ID] = &s
+	}
+
+	d.logger.Info("📼 DVR initialized",
+		logger.String("directory", dir),
+		logger.Int("schedules", len(schedules)))
+	return d, nil
+}
+
+// Start begins the scheduling loop.
+func (d *DVR) Start() {
+	d.mutex.Lock()
+	if d.started {
+		d.mutex.Unlock()
+		return
+	}
+	d.started = true
+	d.mutex.Unlock()
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		ticker := time.NewTicker(checkInterval)
+		defer ticker.Stop()
+
+		for {
+			d.checkSchedules()
+			select {
+			case <-ticker.C:
+			case <-d.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the scheduling loop and finalizes recordings in progress.
+func (d *DVR) Stop() {
+	d.cancel()
+	d.wg.Wait()
+	d.logger.Info("📼 DVR stopped")
+}
+
+// checkSchedules starts recordings whose window has begun and drops one-off
+// schedules that can no longer run.
+func (d *DVR) checkSchedules() {
+	now := d.now()
+
+	d.mutex.Lock()
+	var due []Schedule
+	var occurrences []time.Time
+	changed := false
+	for id, s := range d.schedules {
+		if _, recording := d.active[id]; recording {
+			continue
+		}
+		if occurrence, ok := s.ActiveOccurrence(now); ok {
+			s.LastRun = occurrence
+			due = append(due, *s)
+			occurrences = append(occurrences, occurrence)
+			changed = true
+			continue
+		}
+		if _, ok := s.NextOccurrence(now); !ok {
+			d.logger.Info("🗑️  Removing finished schedule",
+				logger.String("schedule_id", id),
+				logger.String("channel", s.Channel))
+			delete(d.schedules, id)
+			changed = true
+		}
+	}
+	d.mutex.Unlock()
+
+	if changed {
+		d.persist()
+	}
+
+	for i, s := range due {
+		d.wg.Add(1)
+		go func(s Schedule, occurrence time.Time) {
+			defer d.wg.Done()
+			rec := d.record(s, occurrence)
+			d.finish(rec)
+		}(s, occurrences[i])
+	}
+}
+
+// setActive records a recording as in progress.
+func (d *DVR) setActive(rec Recording) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.active[rec.ScheduleID] = rec
+}
+
+// finish moves a recording from the active set to the history.
+func (d *DVR) finish(rec Recording) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	delete(d.active, rec.ScheduleID)
+	d.history = append(d.history, rec)
+	if len(d.history) > maxHistory {
+		d.history = d.history[len(d.history)-maxHistory:]
+	}
+}
+
+// persist writes the current schedules to the store. Callers race to persist
+// after releasing d.mutex, so the snapshot is taken under the store's lock.
+func (d *DVR) persist() {
+	if err := d.store.SaveSnapshot(d.Schedules); err != nil {
+		d.logger.Error("❌ Failed to persist DVR schedules", logger.ErrorField("error", err))
+	}
+}
+
+// AddSchedule validates and stores a new schedule.
+func (d *DVR) AddSchedule(s Schedule) (Schedule, error) {
+	if err := s.Validate(); err != nil {
+		return Schedule{}, err
+	}
+	if _, ok := s.NextOccurrence(d.now()); !ok {
+		return Schedule{}, fmt.Errorf("schedule has already ended")
+	}
+
+	s.ID = newID()
+	s.LastRun = time.Time{}
+
+	d.mutex.Lock()
+	d.schedules[s.ID] = &s
+	d.mutex.Unlock()
+	d.persist()
+
+	d.logger.Info("🗓️  Recording scheduled",
+		logger.String("schedule_id", s.ID),
+		logger.String("channel", s.Channel),
+		logger.String("start", s.Start.Format(time.RFC3339)),
+		logger.Duration("duration", time.Duration(s.Duration)),
+		logger.Any("days", s.Days))
+	return s, nil
+}
+
+// RemoveSchedule deletes a schedule. A recording in progress is not interrupted.
+func (d *DVR) RemoveSchedule(id string) bool {
+	d.mutex.Lock()
+	_, exists := d.schedules[id]
+	delete(d.schedules, id)
+	d.mutex.Unlock()
+
+	if exists {
+		d.persist()
+	}
+	return exists
+}
+
+// Schedule returns a schedule by ID.
+func (d *DVR) Schedule(id string) (Schedule, bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	s, ok := d.schedules[id]
+	if !ok {
+		return Schedule{}, false
+	}
+	return *s, true
+}
+
+// Schedules returns all schedules ordered by start time.
+func (d *DVR) Schedules() []Schedule {
+	d.mutex.Lock()
+	list := make([]Schedule, 0, len(d.schedules))
+	for _, s := range d.schedules {
+		list = append(list, *s)
+	}
+	d.mutex.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Start.Before(list[j].Start)
+	})
+	return list
+}
+
+// Recordings returns recordings in progress followed by recent finished ones, newest first.
+func (d *DVR) Recordings() []Recording {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	list := make([]Recording, 0, len(d.active)+len(d.history))
+	for _, rec := range d.active {
+		list = append(list, rec)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Start.After(list[j].Start)
+	})
+	for i := len(d.history) - 1; i >= 0; i-- {
+		list = append(list, d.history[i])
+	}
+	return list
+}
+
+// WriteStatus writes the DVR section of the status page.
+func (d *DVR) WriteStatus(w io.Writer) {
+	now := d.now()
+	schedules := d.Schedules()
+	recordings := d.Recordings()
+
+	fmt.Fprintf(w, "DVR Recordings (%s)\n", d.dir)
+	fmt.Fprintf(w, "-----------------------------------\n")
+	fmt.Fprintf(w, "Schedules: %d\n", len(schedules))
+
+	for _, rec := range recordings {
+		if rec.State != StateRecording {
+			continue
+		}
+		fmt.Fprintf(w, "Recording: %-10s %s (until %s)\n",
+			rec.Channel, rec.Name, rec.End.Format(time.RFC3339))
+	}
+
+	for _, s := range schedules {
+		if next, ok := s.NextOccurrence(now); ok {
+			fmt.Fprintf(w, "Next:      %-10s %s at %s for %s\n",
+				s.Channel, s.Name, next.Format(time.RFC3339), time.Duration(s.Duration))
+		}
+	}
+
+	for _, rec := range recordings {
+		if rec.State == StateRecording {
+			continue
+		}
+		fmt.Fprintf(w, "Finished:  %-10s %s %s (%d bytes)\n",
+			rec.Channel, rec.Name, rec.State, rec.Bytes)
+	}
+}
+
+// newID returns a random schedule identifier.
+func newID() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(buf)
+}
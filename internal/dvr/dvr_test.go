@@ -0,0 +1,338 @@
+package dvr
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/attaebra/hdhr-proxy/internal/interfaces"
+	"github.com/attaebra/hdhr-proxy/internal/logger"
+)
+
+// fakeTranscoder streams fixed data until the request context ends, or refuses
+// the stream when status is set.
+type fakeTranscoder struct {
+	status int
+}
+
+func (f *fakeTranscoder) ServeChannel(w http.ResponseWriter, r *http.Request, _ string, _ string) error {
+	if f.status != 0 {
+		http.Error(w, "busy", f.status)
+		return nil
+	}
+	for {
+		if _, err := w.Write([]byte("ts-data")); err != nil {
+			return err
+		}
+		select {
+		case <-r.Context().Done():
+			return r.Context().Err()
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func (f *fakeTranscoder) TranscodeChannel(http.ResponseWriter, *http.Request, string) error {
+	return nil
+}
+
+func (f *fakeTranscoder) DirectStreamChannel(http.ResponseWriter, *http.Request, string) error {
+	return nil
+}
+
+func (f *fakeTranscoder) MediaHandler() http.Handler                         { return http.NotFoundHandler() }
+func (f *fakeTranscoder) RegisterStatusProvider(_ interfaces.StatusProvider) {}
+func (f *fakeTranscoder) StopAllTranscoding()                                {}
+func (f *fakeTranscoder) Shutdown()                                          {}
+
+func newTestDVR(t *testing.T, transcoder interfaces.Transcoder) *DVR {
+	t.Helper()
+	d, err := New(t.TempDir(), transcoder, logger.NewZapLogger(logger.LevelDebug))
+	if err != nil {
+		t.Fatalf("Failed to create DVR: %v", err)
+	}
+	t.Cleanup(d.Stop)
+	return d
+}
+
+func TestScheduleOccurrences(t *testing.T) {
+	start := time.Date(2024, 3, 4, 20, 0, 0, 0, time.UTC) // A Monday
+
+	oneOff := Schedule{Channel: "5.1", Start: start, Duration: Duration(time.Hour)}
+	if _, ok := oneOff.ActiveOccurrence(start.Add(-time.Minute)); ok {
+		t.Error("One-off schedule should not be active before its start")
+	}
+	if occurrence, ok := oneOff.ActiveOccurrence(start.Add(30 * time.Minute)); !ok || !occurrence.Equal(start) {
+		t.Errorf("Expected one-off schedule to be active mid-window, got %v %v", occurrence, ok)
+	}
+	oneOff.LastRun = start
+	if _, ok := oneOff.NextOccurrence(start.Add(30 * time.Minute)); ok {
+		t.Error("One-off schedule should not run again after recording")
+	}
+
+	weekly := Schedule{Channel: "5.1", Start: start, Duration: Duration(time.Hour), Days: []string{"Wednesday"}, TimeZone: "UTC"}
+	if err := weekly.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	next, ok := weekly.NextOccurrence(start)
+	expected := time.Date(2024, 3, 6, 20, 0, 0, 0, time.UTC)
+	if !ok || !next.Equal(expected) {
+		t.Errorf("Expected next weekly occurrence %v, got %v", expected, next)
+	}
+	if _, ok := weekly.ActiveOccurrence(start.Add(10 * time.Minute)); ok {
+		t.Error("Weekly schedule should not run on a Monday")
+	}
+
+	// A late-night recording that spans midnight is still active the next day
+	late := Schedule{Channel: "5.1", Start: start.Add(3*time.Hour + 30*time.Minute), Duration: Duration(time.Hour), Days: []string{"daily"}, TimeZone: "UTC"}
+	if err := late.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	if _, ok := late.ActiveOccurrence(time.Date(2024, 3, 5, 0, 10, 0, 0, time.UTC)); !ok {
+		t.Error("Expected occurrence spanning midnight to be active")
+	}
+}
+
+func TestScheduleAcrossDaylightSaving(t *testing.T) {
+	// Daily at 19:00 New York time, created in winter (UTC-5) and stored as JSON
+	created := Schedule{
+		Channel:  "5.1",
+		Start:    time.Date(2024, 3, 8, 19, 0, 0, 0, time.FixedZone("EST", -5*60*60)),
+		Duration: Duration(time.Hour),
+		Days:     []string{"daily"},
+		TimeZone: "America/New_York",
+	}
+	if err := created.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	data, err := json.Marshal(created)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	var s Schedule
+	if err := json.Unmarshal(data, &s); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+
+	// Clocks move forward on March 10; the recording still starts at 19:00 (UTC-4)
+	next, ok := s.NextOccurrence(time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC))
+	expected := time.Date(2024, 3, 10, 23, 0, 0, 0, time.UTC)
+	if !ok || !next.Equal(expected) {
+		t.Errorf("Expected the occurrence after the change at %v, got %v", expected, next)
+	}
+	if _, ok := s.ActiveOccurrence(time.Date(2024, 3, 10, 23, 30, 0, 0, time.UTC)); !ok {
+		t.Error("Expected the occurrence after the change to be active at 19:30 local time")
+	}
+}
+
+func TestScheduleValidate(t *testing.T) {
+	invalid := []Schedule{
+		{Start: time.Now(), Duration: Duration(time.Minute)},
+		{Channel: "5.1", Duration: Duration(time.Minute)},
+		{Channel: "5.1", Start: time.Now()},
+		{Channel: "5.1", Start: time.Now(), Duration: Duration(time.Minute), Profile: "fancy"},
+		{Channel: "5.1", Start: time.Now(), Duration: Duration(time.Minute), Days: []string{"someday"}},
+		{Channel: "5.1", Start: time.Now(), Duration: Duration(time.Minute), TimeZone: "Mars/Olympus_Mons"},
+	}
+	for i, s := range invalid {
+		if err := s.Validate(); err == nil {
+			t.Errorf("Expected schedule %d to be invalid", i)
+		}
+	}
+}
+
+func TestStoreRoundTrip(t *testing.T) {
+	store := NewStore(t.TempDir())
+
+	loaded, err := store.Load()
+	if err != nil || len(loaded) != 0 {
+		t.Fatalf("Expected empty store, got %v (%v)", loaded, err)
+	}
+
+	start := time.Date(2024, 3, 4, 20, 0, 0, 0, time.UTC)
+	if err := store.Save([]Schedule{{ID: "a", Channel: "5.1", Start: start, Duration: Duration(time.Hour)}}); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	loaded, err = store.Load()
+	if err != nil || len(loaded) != 1 {
+		t.Fatalf("Expected one schedule, got %v (%v)", loaded, err)
+	}
+	if loaded[0].ID != "a" || !loaded[0].Start.Equal(start) || loaded[0].Duration != Duration(time.Hour) {
+		t.Errorf("Schedule did not round trip: %+v", loaded[0])
+	}
+}
+
+func TestRecordingFinalizesAtomically(t *testing.T) {
+	transcoder := &fakeTranscoder{}
+	d := newTestDVR(t, transcoder)
+
+	if _, err := d.AddSchedule(Schedule{
+		Name:     "News",
+		Channel:  "5.1",
+		Start:    time.Now(),
+		Duration: Duration(200 * time.Millisecond),
+	}); err != nil {
+		t.Fatalf("AddSchedule failed: %v", err)
+	}
+
+	d.checkSchedules()
+	d.wg.Wait()
+
+	recordings := d.Recordings()
+	if len(recordings) != 1 {
+		t.Fatalf("Expected one recording, got %d", len(recordings))
+	}
+	rec := recordings[0]
+	if rec.State != StateCompleted {
+		t.Errorf("Expected completed recording, got %s (%s)", rec.State, rec.Error)
+	}
+	if !strings.HasSuffix(rec.File, ".ts") {
+		t.Errorf("Expected .ts file, got %s", rec.File)
+	}
+	if info, err := os.Stat(rec.File); err != nil || info.Size() == 0 {
+		t.Errorf("Expected non-empty recording file: %v", err)
+	}
+	if _, err := os.Stat(rec.File + ".part"); !os.IsNotExist(err) {
+		t.Error("Expected .part file to be renamed")
+	}
+
+	// The finished one-off schedule is removed and the removal persisted
+	d.checkSchedules()
+	if len(d.Schedules()) != 0 {
+		t.Error("Expected finished one-off schedule to be removed")
+	}
+}
+
+func TestRecordingRefused(t *testing.T) {
+	d := newTestDVR(t, &fakeTranscoder{status: http.StatusServiceUnavailable})
+
+	if _, err := d.AddSchedule(Schedule{Channel: "5.1", Start: time.Now(), Duration: Duration(time.Minute)}); err != nil {
+		t.Fatalf("AddSchedule failed: %v", err)
+	}
+
+	d.checkSchedules()
+	d.wg.Wait()
+
+	rec := d.Recordings()[0]
+	if rec.State != StateFailed {
+		t.Errorf("Expected failed recording, got %s", rec.State)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(d.dir, "*.ts*"))
+	if len(files) != 0 {
+		t.Errorf("Expected no recording files, got %v", files)
+	}
+}
+
+func TestSchedulesPersistAcrossRestarts(t *testing.T) {
+	dir := t.TempDir()
+	log := logger.NewZapLogger(logger.LevelDebug)
+
+	first, err := New(dir, &fakeTranscoder{}, log)
+	if err != nil {
+		t.Fatalf("Failed to create DVR: %v", err)
+	}
+	created, err := first.AddSchedule(Schedule{
+		Channel:  "7.1",
+		Start:    time.Now().Add(time.Hour),
+		Duration: Duration(time.Hour),
+		Days:     []string{"mon", "fri"},
+	})
+	if err != nil {
+		t.Fatalf("AddSchedule failed: %v", err)
+	}
+	first.Stop()
+
+	second, err := New(dir, &fakeTranscoder{}, log)
+	if err != nil {
+		t.Fatalf("Failed to reopen DVR: %v", err)
+	}
+	defer second.Stop()
+
+	if _, ok := second.Schedule(created.ID); !ok {
+		t.Error("Expected schedule to be loaded from the store")
+	}
+}
+
+func TestConcurrentSavesKeepLatest(t *testing.T) {
+	dir := t.TempDir()
+	d, err := New(dir, &fakeTranscoder{}, logger.NewZapLogger(logger.LevelDebug))
+	if err != nil {
+		t.Fatalf("Failed to create DVR: %v", err)
+	}
+	defer d.Stop()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s, err := d.AddSchedule(Schedule{Channel: "5.1", Start: time.Now().Add(time.Hour), Duration: Duration(time.Hour)})
+			if err == nil && i%2 == 0 {
+				d.RemoveSchedule(s.ID)
+			}
+		}()
+	}
+	wg.Wait()
+
+	stored, err := NewStore(dir).Load()
+	if err != nil {
+		t.Fatalf("Expected an intact store, got %v", err)
+	}
+	if len(stored) != len(d.Schedules()) {
+		t.Errorf("Expected the store to hold the latest %d schedules, got %d", len(d.Schedules()), len(stored))
+	}
+}
+
+func TestAPI(t *testing.T) {
+	d := newTestDVR(t, &fakeTranscoder{})
+	handler := d.Handler()
+
+	body := `{"name":"Game","channel":"5.1","start":"` + time.Now().Add(time.Hour).Format(time.RFC3339) +
+		`","duration":"2h","profile":"transcode","days":["sat"]}`
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("POST", "/dvr/schedules", bytes.NewBufferString(body)))
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	var created scheduleView
+	if err := json.NewDecoder(recorder.Body).Decode(&created); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if created.ID == "" || created.Duration != Duration(2*time.Hour) || created.Next == nil {
+		t.Errorf("Unexpected created schedule: %+v", created)
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/dvr/schedules/"+created.ID, nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Expected 200 for schedule lookup, got %d", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("POST", "/dvr/schedules", bytes.NewBufferString(`{"channel":"5.1"}`)))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid schedule, got %d", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("DELETE", "/dvr/schedules/"+created.ID, nil))
+	if recorder.Code != http.StatusNoContent {
+		t.Errorf("Expected 204 for delete, got %d", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/dvr/schedules/"+created.ID, nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 after delete, got %d", recorder.Code)
+	}
+}
@@ -0,0 +1,239 @@
+package dvr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/attaebra/hdhr-proxy/internal/logger"
+)
+
+// Recording states.
+const (
+	StateRecording = "recording"
+	StateCompleted = "completed"
+	StatePartial   = "partial" // The stream ended before the scheduled end time
+	StateFailed    = "failed"
+)
+
+// recorderClientAddr is the client address recordings use for admission control,
+// so the DVR can be given its own priority class.
+const recorderClientAddr = "127.0.0.1:0"
+
+// Recording describes a recording in progress or a finished one.
+type Recording struct {
+	ScheduleID string    `json:"schedule_id"`
+	Name       string    `json:"name"`
+	Channel    string    `json:"channel"`
+	Profile    string    `json:"profile"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	File       string    `json:"file"`
+	State      string    `json:"state"`
+	Bytes      int64     `json:"bytes"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// fileResponseWriter adapts a file to http.ResponseWriter so recordings can use
+// the same streaming pipeline as media clients.
+type fileResponseWriter struct {
+	mutex  sync.Mutex
+	file   *os.File
+	header http.Header
+	status int
+	bytes  int64
+	closed bool
+}
+
+func newFileResponseWriter(file *os.File) *fileResponseWriter {
+	return &fileResponseWriter{file: file, header: make(http.Header)}
+}
+
+// Header returns the (unused) response headers.
+func (f *fileResponseWriter) Header() http.Header {
+	return f.header
+}
+
+// WriteHeader records the status code. Error responses are not written to disk.
+func (f *fileResponseWriter) WriteHeader(status int) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.status == 0 {
+		f.status = status
+	}
+}
+
+// Write appends stream data to the file.
+func (f *fileResponseWriter) Write(p []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.status == 0 {
+		f.status = http.StatusOK
+	}
+	if f.status >= http.StatusBadRequest {
+		return len(p), nil // Discard error bodies
+	}
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+
+	n, err := f.file.Write(p)
+	f.bytes += int64(n)
+	return n, err
+}
+
+// Close flushes and closes the file. Late writes from stream goroutines are rejected.
+func (f *fileResponseWriter) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+
+	syncErr := f.file.Sync()
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	return syncErr
+}
+
+// result returns the response status and number of bytes written.
+func (f *fileResponseWriter) result() (int, int64) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.status, f.bytes
+}
+
+// record captures one occurrence of a schedule to disk. Data is written to a
+// .part file that is renamed to .ts only once the recording is finished.
+func (d *DVR) record(s Schedule, occurrence time.Time) Recording {
+	end := occurrence.Add(time.Duration(s.Duration))
+	finalPath := filepath.Join(d.dir, recordingFileName(s, occurrence))
+	partPath := finalPath + ".part"
+
+	rec := Recording{
+		ScheduleID: s.ID,
+		Name:       s.Name,
+		Channel:    s.Channel,
+		Profile:    s.Profile,
+		Start:      occurrence,
+		End:        end,
+		File:       finalPath,
+		State:      StateRecording,
+	}
+	d.setActive(rec)
+
+	d.logger.Info("⏺️  Recording started",
+		logger.String("schedule_id", s.ID),
+		logger.String("channel", s.Channel),
+		logger.String("profile", s.Profile),
+		logger.String("file", finalPath),
+		logger.Duration("remaining", time.Until(end)))
+
+	file, err := os.Create(partPath)
+	if err != nil {
+		rec.State, rec.Error = StateFailed, err.Error()
+		d.logger.Error("❌ Failed to create recording file", logger.ErrorField("error", err))
+		return rec
+	}
+
+	ctx, cancel := context.WithDeadline(d.ctx, end)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "/auto/v"+s.Channel, nil)
+	if err != nil {
+		file.Close()
+		os.Remove(partPath)
+		rec.State, rec.Error = StateFailed, err.Error()
+		return rec
+	}
+	req.RemoteAddr = recorderClientAddr
+	req.Header.Set("User-Agent", "hdhr-proxy-dvr")
+
+	w := newFileResponseWriter(file)
+	streamErr := d.transcoder.ServeChannel(w, req, s.Channel, s.Profile)
+	if err := w.Close(); err != nil && streamErr == nil {
+		streamErr = err
+	}
+
+	status, bytes := w.result()
+	rec.Bytes = bytes
+
+	// Nothing usable was recorded: drop the partial file
+	if status >= http.StatusBadRequest || bytes == 0 {
+		os.Remove(partPath)
+		rec.State = StateFailed
+		rec.Error = describeFailure(status, streamErr)
+		d.logger.Error("❌ Recording failed",
+			logger.String("schedule_id", s.ID),
+			logger.String("channel", s.Channel),
+			logger.String("error", rec.Error))
+		return rec
+	}
+
+	if err := os.Rename(partPath, finalPath); err != nil {
+		rec.State, rec.Error = StateFailed, err.Error()
+		d.logger.Error("❌ Failed to finalize recording", logger.ErrorField("error", err))
+		return rec
+	}
+
+	// Ending at the deadline is the normal way for a recording to finish
+	rec.State = StateCompleted
+	if ctx.Err() == nil || !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		rec.State = StatePartial
+		if streamErr != nil {
+			rec.Error = streamErr.Error()
+		}
+	}
+
+	d.logger.Info("💾 Recording finished",
+		logger.String("schedule_id", s.ID),
+		logger.String("channel", s.Channel),
+		logger.String("state", rec.State),
+		logger.Int64("bytes", bytes),
+		logger.String("file", finalPath))
+	return rec
+}
+
+// describeFailure explains why a recording produced no data.
+func describeFailure(status int, err error) string {
+	switch {
+	case err != nil:
+		return err.Error()
+	case status >= http.StatusBadRequest:
+		return fmt.Sprintf("stream refused with HTTP status %d", status)
+	default:
+		return "no data received"
+	}
+}
+
+// recordingFileName builds a filesystem-safe file name for an occurrence.
+func recordingFileName(s Schedule, occurrence time.Time) string {
+	name := s.Name
+	if name == "" {
+		name = "recording"
+	}
+	return fmt.Sprintf("%s_%s_%s.ts",
+		sanitizeFileName(name), sanitizeFileName(s.Channel), occurrence.Format("20060102-150405"))
+}
+
+// sanitizeFileName replaces characters that are unsafe in file names.
+func sanitizeFileName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-':
+			return r
+		default:
+			return '_'
+		}
+	}, s)
+}
@@ -0,0 +1,215 @@
+// Package dvr implements scheduled recordings of channels to disk.
+package dvr
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	_ "time/tzdata" // Zone names resolve in images without a zoneinfo database
+
+	"github.com/attaebra/hdhr-proxy/internal/media/transcoder"
+)
+
+// Duration is a time.Duration that is encoded in JSON as a string such as "1h30m".
+type Duration time.Duration
+
+// MarshalJSON encodes the duration as a Go duration string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON accepts a Go duration string or a number of seconds.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		parsed, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q: %w", s, err)
+		}
+		*d = Duration(parsed)
+		return nil
+	}
+
+	var seconds float64
+	if err := json.Unmarshal(data, &seconds); err != nil {
+		return fmt.Errorf("duration must be a string like \"30m\" or a number of seconds")
+	}
+	*d = Duration(time.Duration(seconds * float64(time.Second)))
+	return nil
+}
+
+// weekdays maps the accepted day names to time.Weekday values.
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// Schedule describes a one-off or weekly recurring recording.
+type Schedule struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name"`
+	Channel  string    `json:"channel"`
+	Start    time.Time `json:"start"`
+	Duration Duration  `json:"duration"`
+	Profile  string    `json:"profile"`
+	Days     []string  `json:"days,omitempty"`      // Weekdays to repeat on; empty for a one-off recording
+	TimeZone string    `json:"time_zone,omitempty"` // IANA zone whose wall clock recurring occurrences follow
+	LastRun  time.Time `json:"last_run,omitempty"`  // Start of the most recently recorded occurrence
+}
+
+// Validate checks the schedule and normalizes its profile and days.
+func (s *Schedule) Validate() error {
+	if s.Channel == "" {
+		return fmt.Errorf("channel is required")
+	}
+	if s.Start.IsZero() {
+		return fmt.Errorf("start is required")
+	}
+	if s.Duration <= 0 {
+		return fmt.Errorf("duration must be positive")
+	}
+
+	// The start's UTC offset changes with daylight saving time, so recurring
+	// occurrences follow a named zone, the server's by default
+	if s.TimeZone == "" {
+		s.TimeZone = time.Local.String()
+	}
+	if _, err := loadZone(s.TimeZone); err != nil {
+		return fmt.Errorf("unknown time_zone %q", s.TimeZone)
+	}
+
+	switch s.Profile {
+	case "":
+		s.Profile = transcoder.ProfileAuto
+	case transcoder.ProfileAuto, transcoder.ProfileDirect, transcoder.ProfileTranscode:
+	default:
+		return fmt.Errorf("unknown profile %q (use auto, direct or transcode)", s.Profile)
+	}
+
+	var days []string
+	for _, day := range s.Days {
+		day = strings.ToLower(strings.TrimSpace(day))
+		if day == "daily" {
+			days = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+			break
+		}
+		if len(day) > 3 {
+			day = day[:3]
+		}
+		if _, ok := weekdays[day]; !ok {
+			return fmt.Errorf("unknown day %q", day)
+		}
+		days = append(days, day)
+	}
+	s.Days = days
+
+	return nil
+}
+
+// Recurring reports whether the schedule repeats.
+func (s *Schedule) Recurring() bool {
+	return len(s.Days) > 0
+}
+
+// runsOn reports whether a recurring schedule runs on the given weekday.
+func (s *Schedule) runsOn(day time.Weekday) bool {
+	for _, name := range s.Days {
+		if weekdays[name] == day {
+			return true
+		}
+	}
+	return false
+}
+
+// zones caches loaded time zones, as occurrences are computed every second.
+var zones sync.Map
+
+// loadZone returns the time zone with the given IANA name.
+func loadZone(name string) (*time.Location, error) {
+	if loc, ok := zones.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	zones.Store(name, loc)
+	return loc, nil
+}
+
+// location returns the zone occurrences are computed in, falling back to the
+// start's fixed offset for schedules without a zone.
+func (s *Schedule) location() *time.Location {
+	if s.TimeZone != "" {
+		if loc, err := loadZone(s.TimeZone); err == nil {
+			return loc
+		}
+	}
+	return s.Start.Location()
+}
+
+// occurrenceOn returns the occurrence start on the date of t, at the
+// schedule's wall-clock time of day in its zone.
+func (s *Schedule) occurrenceOn(t time.Time) time.Time {
+	loc := s.location()
+	start := s.Start.In(loc)
+	t = t.In(loc)
+	return time.Date(t.Year(), t.Month(), t.Day(),
+		start.Hour(), start.Minute(), start.Second(), 0, loc)
+}
+
+// ActiveOccurrence returns the start of the occurrence whose window contains now
+// and that has not been recorded yet.
+func (s *Schedule) ActiveOccurrence(now time.Time) (time.Time, bool) {
+	duration := time.Duration(s.Duration)
+
+	if !s.Recurring() {
+		if !now.Before(s.Start) && now.Before(s.Start.Add(duration)) && s.Start.After(s.LastRun) {
+			return s.Start, true
+		}
+		return time.Time{}, false
+	}
+
+	// Check yesterday too so occurrences spanning midnight are found
+	for _, offset := range []int{-1, 0} {
+		occurrence := s.occurrenceOn(now.AddDate(0, 0, offset))
+		if occurrence.Before(s.Start) || !s.runsOn(occurrence.Weekday()) {
+			continue
+		}
+		if !now.Before(occurrence) && now.Before(occurrence.Add(duration)) && occurrence.After(s.LastRun) {
+			return occurrence, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// NextOccurrence returns the start of the next occurrence that has not ended
+// by now, or false if the schedule will not run again.
+func (s *Schedule) NextOccurrence(now time.Time) (time.Time, bool) {
+	duration := time.Duration(s.Duration)
+
+	if !s.Recurring() {
+		if now.Before(s.Start.Add(duration)) && s.Start.After(s.LastRun) {
+			return s.Start, true
+		}
+		return time.Time{}, false
+	}
+
+	for offset := -1; offset <= 7; offset++ {
+		occurrence := s.occurrenceOn(now.AddDate(0, 0, offset))
+		if occurrence.Before(s.Start) || !s.runsOn(occurrence.Weekday()) {
+			continue
+		}
+		if now.Before(occurrence.Add(duration)) && occurrence.After(s.LastRun) {
+			return occurrence, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,73 @@
+package dvr
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// schedulesFile is the name of the schedule store inside the recordings directory.
+const schedulesFile = "schedules.json"
+
+// Store persists schedules as a JSON file so they survive restarts.
+type Store struct {
+	path  string
+	mutex sync.Mutex // Serializes saves, which share the temporary file
+}
+
+// NewStore creates a store backed by a JSON file in dir.
+func NewStore(dir string) *Store {
+	return &Store{path: filepath.Join(dir, schedulesFile)}
+}
+
+// Load reads all schedules. A missing file yields an empty list.
+func (s *Store) Load() ([]Schedule, error) {
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schedule store: %w", err)
+	}
+
+	var schedules []Schedule
+	if err := json.Unmarshal(data, &schedules); err != nil {
+		return nil, fmt.Errorf("failed to parse schedule store %s: %w", s.path, err)
+	}
+	return schedules, nil
+}
+
+// Save replaces the stored schedules. The file is written to a temporary
+// path and renamed so a crash never leaves a truncated store behind.
+func (s *Store) Save(schedules []Schedule) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.save(schedules)
+}
+
+// SaveSnapshot saves the schedules returned by snapshot, taken while holding
+// the save lock so an older snapshot never replaces a newer one.
+func (s *Store) SaveSnapshot(snapshot func() []Schedule) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.save(snapshot())
+}
+
+// save writes the schedules. s.mutex must be held.
+func (s *Store) save(schedules []Schedule) error {
+	data, err := json.MarshalIndent(schedules, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode schedules: %w", err)
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write schedule store: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to replace schedule store: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,818 @@
+// Package e2e boots the whole proxy on ephemeral ports against a simulated
+// HDHomeRun and a fake FFmpeg, and drives it over HTTP like a media client.
+package e2e
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/attaebra/hdhr-proxy/internal/config"
+	"github.com/attaebra/hdhr-proxy/internal/container"
+	"github.com/attaebra/hdhr-proxy/internal/discovery"
+	"github.com/attaebra/hdhr-proxy/internal/hdhrsim"
+	"github.com/attaebra/hdhr-proxy/internal/interfaces"
+	"github.com/attaebra/hdhr-proxy/internal/media/process"
+)
+
+// fakeFFmpeg is the path of the FFmpeg stand-in built by TestMain.
+var fakeFFmpeg string
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "e2e")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create temp dir: %v\n", err)
+		os.Exit(1)
+	}
+	fakeFFmpeg = filepath.Join(dir, "ffmpeg")
+	build := exec.Command("go", "build", "-o", fakeFFmpeg, "../media/transcoder/testdata/fakeffmpeg")
+	build.Stderr = os.Stderr
+	if err := build.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to build fake ffmpeg: %v\n", err)
+		os.RemoveAll(dir)
+		os.Exit(1)
+	}
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// app is a running proxy and the simulated device behind it.
+type app struct {
+	sim          *hdhrsim.Simulator
+	container    *container.Container
+	apiURL       string
+	mediaURL     string
+	ffmpegRuns   atomic.Int32
+	deviceDown   atomic.Bool // The device drops every connection, as if powered off
+	deviceHung   atomic.Bool // The device accepts requests but never answers
+	hungRequests atomic.Int32
+	deviceAPI    *httptest.Server
+	discovery    net.PacketConn // Answers discovery requests as the device
+	shutdownOnce sync.Once
+}
+
+// start boots the proxy against a two-tuner simulated device. The device
+// serves its API and streams on separate ports, like the real hardware.
+// configure adjusts the proxy's settings before it starts.
+func start(t *testing.T, configure ...func(*config.Config)) *app {
+	t.Helper()
+	return launch(t, false, configure...)
+}
+
+// startDeviceDown boots the proxy while the simulated device is unreachable.
+// Clear deviceDown to bring the device up.
+func startDeviceDown(t *testing.T, configure ...func(*config.Config)) *app {
+	t.Helper()
+	return launch(t, true, configure...)
+}
+
+func launch(t *testing.T, deviceDown bool, configure ...func(*config.Config)) *app {
+	t.Helper()
+	a := &app{}
+	a.deviceDown.Store(deviceDown)
+
+	deviceAPI := httptest.NewUnstartedServer(nil)
+	deviceMedia := httptest.NewUnstartedServer(nil)
+	sim, err := hdhrsim.New(hdhrsim.Options{
+		DeviceID:   "1050ABCD",
+		TunerCount: 2,
+		MediaPort:  port(t, deviceMedia.Listener),
+		Channels: []hdhrsim.Channel{
+			{GuideNumber: "5.1", GuideName: "NBC", AudioCodec: "AC4"},
+			{GuideNumber: "7.1", GuideName: "ABC", AudioCodec: "AC3"},
+			{GuideNumber: "9.1", GuideName: "PBS", AudioCodec: "AC3"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create simulator: %v", err)
+	}
+	a.sim = sim
+	a.deviceAPI = deviceAPI
+	for _, server := range []*httptest.Server{deviceAPI, deviceMedia} {
+		server.Config.Handler = a.unlessDown(sim)
+		server.Start()
+		t.Cleanup(server.Close)
+	}
+
+	// The device also answers discovery, for configurations without hdhr_ip
+	a.respond(t, "127.0.0.1:0")
+
+	apiListener := listen(t)
+	mediaListener := listen(t)
+
+	cfg := config.DefaultConfig()
+	cfg.HDHomeRunIP = strings.TrimPrefix(deviceAPI.URL, "http://")
+	cfg.DeviceMediaPort = port(t, deviceMedia.Listener)
+	cfg.APIPort = port(t, apiListener)
+	cfg.MediaPort = port(t, mediaListener)
+	cfg.FFmpegPath = fakeFFmpeg
+	cfg.LogLevel = "error"
+	for _, fn := range configure {
+		fn(cfg)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Invalid configuration: %v", err)
+	}
+
+	a.container, err = container.InitializeWithOptions(cfg, container.Options{
+		APIListener:        apiListener,
+		MediaListener:      mediaListener,
+		FFmpegRunner:       countingRunner{runner: process.NewRunner(process.DefaultGrace), runs: &a.ffmpegRuns},
+		DiscoveryAddresses: []string{a.discovery.LocalAddr().String()},
+	})
+	if err != nil {
+		t.Fatalf("Failed to initialize container: %v", err)
+	}
+	serveErrors := a.container.Start()
+	t.Cleanup(func() {
+		a.shutdown(t)
+		select {
+		case err := <-serveErrors:
+			t.Errorf("Server failed: %v", err)
+		default:
+		}
+	})
+
+	a.apiURL = "http://" + apiListener.Addr().String()
+	a.mediaURL = "http://" + mediaListener.Addr().String()
+	return a
+}
+
+// respond answers discovery requests on address with the device's API URL.
+func (a *app) respond(t *testing.T, address string) {
+	t.Helper()
+	conn, err := net.ListenPacket("udp4", address)
+	if err != nil {
+		t.Fatalf("Failed to listen for discovery: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	a.discovery = conn
+	go discovery.Respond(conn, discovery.Device{ID: "1050ABCD", TunerCount: 2, BaseURL: a.deviceAPI.URL})
+}
+
+// moveDevice serves the device's API on a new port, as if DHCP had handed it
+// a new address, and returns the new address.
+func (a *app) moveDevice(t *testing.T) string {
+	t.Helper()
+	a.deviceAPI.Close()
+	a.deviceAPI = httptest.NewServer(a.unlessDown(a.sim))
+	t.Cleanup(a.deviceAPI.Close)
+
+	address := a.discovery.LocalAddr().String()
+	a.discovery.Close()
+	a.respond(t, address)
+	return strings.TrimPrefix(a.deviceAPI.URL, "http://")
+}
+
+// unlessDown drops connections while the device is down and holds requests
+// until the client gives up while it is hung.
+func (a *app) unlessDown(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.deviceHung.Load() {
+			a.hungRequests.Add(1)
+			<-r.Context().Done()
+			return
+		}
+		if a.deviceDown.Load() {
+			if conn, _, err := http.NewResponseController(w).Hijack(); err == nil {
+				conn.Close()
+			}
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// countingRunner counts the FFmpeg processes the proxy starts for streams,
+// leaving out the health monitor's self-tests.
+type countingRunner struct {
+	runner interfaces.ProcessRunner
+	runs   *atomic.Int32
+}
+
+func (r countingRunner) Start(ctx context.Context, path string, args ...string) (interfaces.TranscodeProcess, error) {
+	if !slices.Contains(args, "-version") && !slices.Contains(args, "lavfi") {
+		r.runs.Add(1)
+	}
+	return r.runner.Start(ctx, path, args...)
+}
+
+// shutdown stops the proxy gracefully, once.
+func (a *app) shutdown(t *testing.T) {
+	t.Helper()
+	a.shutdownOnce.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := a.container.Shutdown(ctx); err != nil {
+			t.Errorf("Shutdown failed: %v", err)
+		}
+	})
+}
+
+func listen(t *testing.T) net.Listener {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	return listener
+}
+
+func port(t *testing.T, listener net.Listener) int {
+	t.Helper()
+	_, p, _ := net.SplitHostPort(listener.Addr().String())
+	n, err := strconv.Atoi(p)
+	if err != nil {
+		t.Fatalf("Invalid listener address %s", listener.Addr())
+	}
+	return n
+}
+
+// tune opens a channel on the proxy's media server.
+func (a *app) tune(t *testing.T, channel string) *http.Response {
+	t.Helper()
+	resp, err := http.Get(a.mediaURL + "/auto/v" + channel)
+	if err != nil {
+		t.Fatalf("Failed to tune %s: %v", channel, err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// readPackets reads n TS packets from a stream and checks their sync bytes.
+func readPackets(t *testing.T, body io.Reader, n int) {
+	t.Helper()
+	data := make([]byte, n*hdhrsim.PacketSize)
+	if _, err := io.ReadFull(body, data); err != nil {
+		t.Fatalf("Failed to read stream: %v", err)
+	}
+	for i := 0; i < len(data); i += hdhrsim.PacketSize {
+		if data[i] != 0x47 {
+			t.Fatalf("Expected a TS sync byte at offset %d, got %#x", i, data[i])
+		}
+	}
+}
+
+func getJSON(t *testing.T, url string, v any) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s failed: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s returned %d", url, resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("Failed to decode %s: %v", url, err)
+	}
+}
+
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDiscoveryRewriting(t *testing.T) {
+	a := start(t)
+
+	var discover map[string]any
+	getJSON(t, a.apiURL+"/discover.json", &discover)
+	if discover["DeviceID"] != "DCBA0501" {
+		t.Errorf("Expected the reversed device ID, got %v", discover["DeviceID"])
+	}
+	if discover["BaseURL"] != a.apiURL || discover["LineupURL"] != a.apiURL+"/lineup.json" {
+		t.Errorf("Expected device URLs to point at the proxy, got %v and %v", discover["BaseURL"], discover["LineupURL"])
+	}
+
+	var lineup []map[string]any
+	getJSON(t, a.apiURL+"/lineup.json", &lineup)
+	if len(lineup) != 3 {
+		t.Fatalf("Expected 3 channels, got %v", lineup)
+	}
+	if want := a.mediaURL + "/auto/v5.1"; lineup[0]["URL"] != want {
+		t.Errorf("Expected channel URL %s, got %v", want, lineup[0]["URL"])
+	}
+	if lineup[0]["AudioCodec"] != "AC3" {
+		t.Errorf("Expected AC4 to be advertised as AC3, got %v", lineup[0]["AudioCodec"])
+	}
+}
+
+func TestDirectStreaming(t *testing.T) {
+	a := start(t)
+
+	resp := a.tune(t, "7.1")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", resp.StatusCode)
+	}
+	readPackets(t, resp.Body, 100)
+
+	if a.ffmpegRuns.Load() != 0 {
+		t.Errorf("Expected an AC3 channel to stream without FFmpeg, got %d runs", a.ffmpegRuns.Load())
+	}
+	if a.sim.TunersInUse() != 1 {
+		t.Errorf("Expected one tuner in use, got %d", a.sim.TunersInUse())
+	}
+
+	resp.Body.Close()
+	waitFor(t, "the tuner to be released", func() bool { return a.sim.TunersInUse() == 0 })
+}
+
+func TestTranscoding(t *testing.T) {
+	a := start(t)
+
+	resp := a.tune(t, "5.1")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", resp.StatusCode)
+	}
+	readPackets(t, resp.Body, 100)
+
+	if a.ffmpegRuns.Load() != 1 {
+		t.Errorf("Expected the AC4 channel to run FFmpeg once, got %d", a.ffmpegRuns.Load())
+	}
+}
+
+func TestConcurrentClients(t *testing.T) {
+	a := start(t)
+
+	first := a.tune(t, "5.1")
+	second := a.tune(t, "7.1")
+	readPackets(t, first.Body, 10)
+	readPackets(t, second.Body, 10)
+
+	// Both tuners are taken, so a third client is refused
+	third := a.tune(t, "9.1")
+	third.Body.Close()
+	if third.StatusCode != http.StatusServiceUnavailable || third.Header.Get("X-HDHomeRun-Error") != "805 All Tuners In Use" {
+		t.Errorf("Expected 503 all tuners in use, got %d %q", third.StatusCode, third.Header.Get("X-HDHomeRun-Error"))
+	}
+
+	// Once a client leaves, its tuner goes to the next one
+	second.Body.Close()
+	waitFor(t, "the tuner to be released", func() bool { return a.sim.TunersInUse() == 1 })
+	var retry *http.Response
+	waitFor(t, "the third client to be admitted", func() bool {
+		retry = a.tune(t, "9.1")
+		if retry.StatusCode != http.StatusOK {
+			retry.Body.Close()
+			return false
+		}
+		return true
+	})
+	readPackets(t, retry.Body, 10)
+
+	// The device running out of tuners on its own is passed through
+	retry.Body.Close()
+	waitFor(t, "the tuner to be released", func() bool { return a.sim.TunersInUse() == 1 })
+	a.sim.SetAllTunersBusy(true)
+	busy := a.tune(t, "9.1")
+	busy.Body.Close()
+	if busy.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected the device's 503 to be passed through, got %d", busy.StatusCode)
+	}
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	a := start(t)
+
+	// Clients keep watching until the proxy ends their streams
+	var watching sync.WaitGroup
+	for _, channel := range []string{"7.1", "5.1"} {
+		resp := a.tune(t, channel)
+		readPackets(t, resp.Body, 10)
+		watching.Add(1)
+		go func() {
+			defer watching.Done()
+			io.Copy(io.Discard, resp.Body)
+		}()
+	}
+
+	began := time.Now()
+	a.shutdown(t)
+	if elapsed := time.Since(began); elapsed > 2*time.Second {
+		t.Errorf("Expected shutdown to end the streams promptly, took %v", elapsed)
+	}
+
+	streamsEnded := make(chan struct{})
+	go func() {
+		watching.Wait()
+		close(streamsEnded)
+	}()
+	select {
+	case <-streamsEnded:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Streams were not ended by the shutdown")
+	}
+	waitFor(t, "the tuners to be released", func() bool { return a.sim.TunersInUse() == 0 })
+
+	if _, err := http.Get(a.apiURL + "/discover.json"); err == nil {
+		t.Error("Expected the API server to be closed")
+	}
+}
+
+func TestDrain(t *testing.T) {
+	a := start(t, func(cfg *config.Config) { cfg.AdminToken = "secret" })
+
+	watching := a.tune(t, "7.1")
+	readPackets(t, watching.Body, 10)
+
+	req, _ := http.NewRequest(http.MethodPost, a.apiURL+"/admin/drain", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to start drain: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("Expected 202, got %d", resp.StatusCode)
+	}
+	select {
+	case <-a.container.Draining():
+	default:
+		t.Fatal("Expected the admin API to start a drain")
+	}
+
+	// New tunes are refused and discovery reports the proxy unavailable
+	refused := a.tune(t, "9.1")
+	refused.Body.Close()
+	if refused.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected new tunes to be refused, got %d", refused.StatusCode)
+	}
+	discover, err := http.Get(a.apiURL + "/discover.json")
+	if err != nil {
+		t.Fatalf("Discovery failed: %v", err)
+	}
+	discover.Body.Close()
+	if discover.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected discovery to report the proxy unavailable, got %d", discover.StatusCode)
+	}
+
+	// The running stream continues, and the drain ends when it does
+	readPackets(t, watching.Body, 10)
+	drained := make(chan bool, 1)
+	go func() { drained <- a.container.WaitForDrain(context.Background()) }()
+	select {
+	case <-drained:
+		t.Fatal("Expected the drain to wait for the running stream")
+	case <-time.After(300 * time.Millisecond):
+	}
+	watching.Body.Close()
+	select {
+	case ok := <-drained:
+		if !ok {
+			t.Error("Expected the drain to complete")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the drain to end with the last stream")
+	}
+}
+
+func TestDrainDeadline(t *testing.T) {
+	a := start(t, func(cfg *config.Config) { cfg.DrainTimeout = 200 * time.Millisecond })
+
+	resp := a.tune(t, "7.1")
+	readPackets(t, resp.Body, 10)
+	go io.Copy(io.Discard, resp.Body)
+
+	a.container.StartDrain()
+	began := time.Now()
+	if a.container.WaitForDrain(context.Background()) {
+		t.Error("Expected the drain to end at the deadline with the stream running")
+	}
+	if elapsed := time.Since(began); elapsed < 200*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("Expected the drain to last until the deadline, took %v", elapsed)
+	}
+	if status := a.container.DrainStatus(); !status.Draining || status.ActiveStreams != 1 {
+		t.Errorf("Unexpected drain status %+v", status)
+	}
+}
+
+func TestHealthChecks(t *testing.T) {
+	a := start(t)
+
+	var report struct {
+		Status string `json:"status"`
+		Checks []struct {
+			Name   string `json:"name"`
+			Status string `json:"status"`
+			Error  string `json:"error"`
+		} `json:"checks"`
+	}
+	getJSON(t, a.apiURL+"/healthz", &report)
+	if report.Status != "ok" || len(report.Checks) != 2 {
+		t.Errorf("Expected a passing liveness report, got %+v", report)
+	}
+
+	// The first probe runs in the background
+	waitFor(t, "readiness", func() bool {
+		resp, err := http.Get(a.apiURL + "/readyz")
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusOK
+	})
+
+	a.container.StartDrain()
+	resp, err := http.Get(a.apiURL + "/readyz")
+	if err != nil {
+		t.Fatalf("GET /readyz failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 while draining, got %d", resp.StatusCode)
+	}
+	json.NewDecoder(resp.Body).Decode(&report)
+	for _, check := range report.Checks {
+		if check.Name == "capacity" && check.Error != "draining" {
+			t.Errorf("Expected the capacity check to report draining, got %+v", check)
+		}
+	}
+}
+
+func TestHealthChecksOutsideAllowlist(t *testing.T) {
+	a := start(t, func(cfg *config.Config) {
+		cfg.AllowedNetworks = []string{"192.168.0.0/16"}
+	})
+
+	for path, want := range map[string]int{
+		"/healthz":       http.StatusOK,
+		"/discover.json": http.StatusForbidden,
+	} {
+		resp, err := http.Get(a.apiURL + path)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("Expected %d from %s over loopback, got %d", want, path, resp.StatusCode)
+		}
+	}
+}
+
+func TestDegradedStart(t *testing.T) {
+	a := startDeviceDown(t)
+
+	for _, url := range []string{a.apiURL + "/discover.json", a.mediaURL + "/auto/v7.1"} {
+		resp, err := http.Get(url)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", url, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("Expected 503 from %s while the device is down, got %d %q", url, resp.StatusCode, body)
+		}
+	}
+	resp, err := http.Get(a.apiURL + "/readyz")
+	if err != nil {
+		t.Fatalf("GET /readyz failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected the proxy not to be ready while the device is down, got %d", resp.StatusCode)
+	}
+
+	// The device boots; the proxy picks it up on its own
+	a.deviceDown.Store(false)
+	waitFor(t, "readiness", func() bool {
+		resp, err := http.Get(a.apiURL + "/readyz")
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusOK
+	})
+
+	var discover map[string]any
+	getJSON(t, a.apiURL+"/discover.json", &discover)
+	if discover["DeviceID"] != "DCBA0501" {
+		t.Errorf("Expected the device ID to be fetched once the device is up, got %v", discover["DeviceID"])
+	}
+	var status struct {
+		Limits struct {
+			MaxTotal int `json:"max_total"`
+		} `json:"limits"`
+	}
+	getJSON(t, a.apiURL+"/dashboard/status", &status)
+	if status.Limits.MaxTotal != 2 {
+		t.Errorf("Expected the stream limit to follow the tuner count, got %d", status.Limits.MaxTotal)
+	}
+
+	tuned := a.tune(t, "7.1")
+	defer tuned.Body.Close()
+	readPackets(t, tuned.Body, 10)
+}
+
+func TestShutdownWhileConnecting(t *testing.T) {
+	a := startDeviceDown(t, func(cfg *config.Config) {
+		cfg.HTTPClientTimeout = time.Minute
+	})
+
+	// Let the health monitor's first device probe fail, so the next request
+	// to the device is the retry
+	waitFor(t, "the first device probe", func() bool {
+		resp, err := http.Get(a.apiURL + "/readyz")
+		if err != nil {
+			return false
+		}
+		defer resp.Body.Close()
+		var report struct {
+			Checks []struct {
+				Name  string `json:"name"`
+				Error string `json:"error"`
+			} `json:"checks"`
+		}
+		json.NewDecoder(resp.Body).Decode(&report)
+		for _, check := range report.Checks {
+			if check.Name == "device" && check.Error != "not checked yet" {
+				return true
+			}
+		}
+		return false
+	})
+
+	// The device comes up hung, so the retry waits on it
+	a.deviceHung.Store(true)
+	a.deviceDown.Store(false)
+	waitFor(t, "a retry", func() bool { return a.hungRequests.Load() > 0 })
+
+	began := time.Now()
+	a.shutdown(t)
+	if elapsed := time.Since(began); elapsed > 2*time.Second {
+		t.Errorf("Expected shutdown to abandon the retry, took %v", elapsed)
+	}
+}
+
+func TestShutdownWhileWatching(t *testing.T) {
+	a := start(t, func(cfg *config.Config) {
+		cfg.DeviceCheckInterval = 10 * time.Millisecond
+		cfg.HTTPClientTimeout = time.Minute
+	})
+
+	// The device hangs, so the next re-check waits on it
+	a.deviceHung.Store(true)
+	waitFor(t, "a re-check", func() bool { return a.hungRequests.Load() > 0 })
+
+	began := time.Now()
+	a.shutdown(t)
+	if elapsed := time.Since(began); elapsed > 2*time.Second {
+		t.Errorf("Expected shutdown to abandon the re-check, took %v", elapsed)
+	}
+}
+
+func TestReloadWhileConnecting(t *testing.T) {
+	a := startDeviceDown(t, func(cfg *config.Config) {
+		cfg.DeviceCheckInterval = 10 * time.Millisecond
+	})
+
+	// Reload while the proxy reconnects to the device and re-checks it,
+	// both of which read the settings
+	a.deviceDown.Store(false)
+	ready := false
+	for i := 0; !ready || i < 20; i++ {
+		updated := config.DefaultConfig()
+		updated.LogLevel = "error"
+		updated.MaxTotalStreams = 1 + i%2
+		a.container.Reload(updated)
+
+		resp, err := http.Get(a.apiURL + "/readyz")
+		if err == nil {
+			resp.Body.Close()
+			ready = resp.StatusCode == http.StatusOK
+		}
+		if i > 500 {
+			t.Fatal("Timed out waiting for readiness")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	updated := config.DefaultConfig()
+	updated.LogLevel = "error"
+	updated.MaxTotalStreams = 1
+	a.container.Reload(updated)
+	var status struct {
+		Limits struct {
+			MaxTotal int `json:"max_total"`
+		} `json:"limits"`
+	}
+	getJSON(t, a.apiURL+"/dashboard/status", &status)
+	if status.Limits.MaxTotal != 1 {
+		t.Errorf("Expected the reloaded stream limit, got %d", status.Limits.MaxTotal)
+	}
+}
+
+func TestDiscoveryByDeviceID(t *testing.T) {
+	a := start(t, func(cfg *config.Config) {
+		cfg.HDHomeRunIP = ""
+		cfg.DeviceID = "1050abcd"
+	})
+
+	var discover map[string]any
+	getJSON(t, a.apiURL+"/discover.json", &discover)
+	if discover["DeviceID"] != "DCBA0501" {
+		t.Errorf("Expected the discovered device to be proxied, got %v", discover["DeviceID"])
+	}
+
+	tuned := a.tune(t, "7.1")
+	defer tuned.Body.Close()
+	readPackets(t, tuned.Body, 10)
+}
+
+func TestDeviceMoved(t *testing.T) {
+	a := start(t, func(cfg *config.Config) {
+		cfg.DeviceCheckInterval = 50 * time.Millisecond
+	})
+	moved := a.moveDevice(t)
+
+	var status struct {
+		Device struct {
+			IP string `json:"ip"`
+		} `json:"device"`
+	}
+	waitFor(t, "the proxy to follow the device", func() bool {
+		getJSON(t, a.apiURL+"/dashboard/status", &status)
+		return status.Device.IP == moved
+	})
+
+	var discover map[string]any
+	getJSON(t, a.apiURL+"/discover.json", &discover)
+	if discover["DeviceID"] != "DCBA0501" {
+		t.Errorf("Expected the device to be proxied from its new address, got %v", discover["DeviceID"])
+	}
+
+	tuned := a.tune(t, "7.1")
+	defer tuned.Body.Close()
+	readPackets(t, tuned.Body, 10)
+}
+
+func TestInvalidWiringSettings(t *testing.T) {
+	tests := []struct {
+		key       string
+		configure func(*config.Config)
+	}{
+		{"device_id:", func(cfg *config.Config) { cfg.DeviceID = "1050ABC" }},
+		{"webhook_events:", func(cfg *config.Config) { cfg.WebhookEvents = []string{"stream_started", "bogus"} }},
+	}
+	for _, tt := range tests {
+		cfg := config.DefaultConfig()
+		cfg.HDHomeRunIP = "127.0.0.1:1"
+		cfg.FFmpegPath = fakeFFmpeg
+		cfg.LogLevel = "error"
+		tt.configure(cfg)
+
+		_, err := container.InitializeWithOptions(cfg, container.Options{})
+		if err == nil || !strings.Contains(err.Error(), tt.key) {
+			t.Errorf("Expected a %s error, got %v", tt.key, err)
+		}
+	}
+}
+
+func TestDVRRequiresAdminToken(t *testing.T) {
+	open := start(t, func(cfg *config.Config) { cfg.DVRDirectory = t.TempDir() })
+	resp, err := http.Get(open.apiURL + "/dvr/schedules")
+	if err != nil {
+		t.Fatalf("GET /dvr/schedules failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected the DVR API to be disabled without an admin token, got %d", resp.StatusCode)
+	}
+
+	protected := start(t, func(cfg *config.Config) {
+		cfg.DVRDirectory = t.TempDir()
+		cfg.AdminToken = "secret"
+	})
+	for token, want := range map[string]int{"": http.StatusUnauthorized, "secret": http.StatusOK} {
+		resp, err := http.Get(protected.apiURL + "/dvr/schedules?token=" + token)
+		if err != nil {
+			t.Fatalf("GET /dvr/schedules failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("Expected %d with token %q, got %d", want, token, resp.StatusCode)
+		}
+	}
+}
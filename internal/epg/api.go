@@ -0,0 +1,67 @@
+package epg
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/attaebra/hdhr-proxy/internal/constants"
+	"github.com/attaebra/hdhr-proxy/internal/logger"
+	"github.com/attaebra/hdhr-proxy/internal/publicurl"
+	"github.com/attaebra/hdhr-proxy/internal/utils"
+)
+
+// Handler returns the guide endpoints for the API server:
+//
+//	GET /epg.xml     XMLTV guide for the lineup
+//	GET /lineup.m3u  M3U playlist whose tvg-id values match the guide
+//
+// Playlist entries point at the proxy's public media URL and carry the token
+// the playlist was requested with, if any.
+func (g *Guide) Handler(urls *publicurl.Resolver) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /epg.xml", func(w http.ResponseWriter, _ *http.Request) {
+		data, err := g.XMLTV()
+		if err != nil {
+			g.logger.Error("❌ Failed to build guide", logger.ErrorField("error", err))
+			http.Error(w, "Guide data unavailable", http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", constants.ContentTypeXML)
+		_, _ = w.Write(data)
+	})
+
+	mux.HandleFunc("GET /lineup.m3u", func(w http.ResponseWriter, r *http.Request) {
+		lineup, err := g.Lineup()
+		if err != nil {
+			g.logger.Error("❌ Failed to build playlist", logger.ErrorField("error", err))
+			http.Error(w, "Lineup unavailable", http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", constants.ContentTypeM3U)
+		writeM3U(w, lineup, urls.Base(r), urls.Media(r), utils.RequestToken(r))
+	})
+
+	return mux
+}
+
+// writeM3U writes an extended M3U playlist for the lineup. The playlist
+// references the XMLTV guide at baseURL so clients can pick it up
+// automatically. A non-empty token is added to each stream URL.
+func writeM3U(w io.Writer, lineup []lineupChannel, baseURL, mediaURL, token string) {
+	query := ""
+	if token != "" {
+		query = "?token=" + url.QueryEscape(token)
+	}
+
+	fmt.Fprintf(w, "#EXTM3U url-tvg=\"%s/epg.xml\"\n", baseURL)
+	for _, channel := range lineup {
+		fmt.Fprintf(w, "#EXTINF:-1 tvg-id=\"%s\" tvg-chno=\"%s\" tvg-name=\"%s\",%s\n",
+			ChannelID(channel.GuideNumber), channel.GuideNumber,
+			strings.ReplaceAll(channel.GuideName, "\"", "'"), channel.GuideName)
+		fmt.Fprintf(w, "%s/auto/v%s%s\n", mediaURL, channel.GuideNumber, query)
+	}
+}
@@ -0,0 +1,237 @@
+// Package epg serves an XMLTV guide and a matching M3U playlist built from the
+// HDHomeRun lineup and SiliconDust guide data, for IPTV clients that do not
+// speak the HDHomeRun protocol.
+package epg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/attaebra/hdhr-proxy/internal/interfaces"
+	"github.com/attaebra/hdhr-proxy/internal/logger"
+	"github.com/attaebra/hdhr-proxy/internal/utils"
+)
+
+// lineupChannel is a channel from the device's lineup.json.
+type lineupChannel struct {
+	GuideNumber string `json:"GuideNumber"`
+	GuideName   string `json:"GuideName"`
+}
+
+// guideChannel is a channel in the SiliconDust guide response.
+type guideChannel struct {
+	GuideNumber string         `json:"GuideNumber"`
+	GuideName   string         `json:"GuideName"`
+	Affiliate   string         `json:"Affiliate"`
+	ImageURL    string         `json:"ImageURL"`
+	Guide       []guideProgram `json:"Guide"`
+}
+
+// guideProgram is a single airing in the SiliconDust guide response.
+type guideProgram struct {
+	StartTime       int64    `json:"StartTime"`
+	EndTime         int64    `json:"EndTime"`
+	Title           string   `json:"Title"`
+	EpisodeTitle    string   `json:"EpisodeTitle"`
+	EpisodeNumber   string   `json:"EpisodeNumber"`
+	Synopsis        string   `json:"Synopsis"`
+	OriginalAirdate int64    `json:"OriginalAirdate"`
+	ImageURL        string   `json:"ImageURL"`
+	Filter          []string `json:"Filter"`
+}
+
+// ChannelID returns the XMLTV channel ID and M3U tvg-id for a guide number.
+func ChannelID(guideNumber string) string {
+	return guideNumber
+}
+
+// Guide fetches and caches guide data for the device's lineup.
+type Guide struct {
+	hdhrIP   atomic.Pointer[string] // Follows the device to new addresses
+	guideURL string
+	cacheTTL time.Duration
+	client   interfaces.Client
+	logger   interfaces.Logger
+
+	mutex     sync.Mutex
+	xmltv     []byte
+	fetchedAt time.Time
+	lineup    []lineupChannel
+	lineupAt  time.Time
+}
+
+// New creates a guide for the device at hdhrIP. guideURL is queried with the
+// device's DeviceAuth as a query parameter, and results are cached for cacheTTL.
+func New(hdhrIP, guideURL string, cacheTTL time.Duration, client interfaces.Client, log interfaces.Logger) *Guide {
+	g := &Guide{
+		guideURL: guideURL,
+		cacheTTL: cacheTTL,
+		client:   client,
+		logger:   log,
+	}
+	g.SetDeviceAddress(hdhrIP)
+	return g
+}
+
+// SetDeviceAddress points the guide at the device's new address.
+func (g *Guide) SetDeviceAddress(hdhrIP string) {
+	g.hdhrIP.Store(&hdhrIP)
+}
+
+// XMLTV returns the cached XMLTV document, refreshing it when it is older than
+// the cache TTL. A stale copy is returned if the refresh fails.
+func (g *Guide) XMLTV() ([]byte, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if g.xmltv != nil && time.Since(g.fetchedAt) < g.cacheTTL {
+		return g.xmltv, nil
+	}
+
+	if err := g.refresh(); err != nil {
+		if g.xmltv != nil {
+			g.logger.Warn("⚠️  Guide refresh failed, serving cached guide",
+				logger.Duration("age", time.Since(g.fetchedAt)),
+				logger.ErrorField("error", err))
+			return g.xmltv, nil
+		}
+		return nil, err
+	}
+	return g.xmltv, nil
+}
+
+// Lineup returns the device lineup, fetching it if the guide has not been loaded.
+func (g *Guide) Lineup() ([]lineupChannel, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if g.lineup != nil && time.Since(g.lineupAt) < g.cacheTTL {
+		return g.lineup, nil
+	}
+
+	lineup, err := g.fetchLineup()
+	if err != nil {
+		if g.lineup != nil {
+			return g.lineup, nil
+		}
+		return nil, err
+	}
+	g.lineup = lineup
+	g.lineupAt = time.Now()
+	return lineup, nil
+}
+
+// refresh fetches the lineup and guide and re-renders the XMLTV document.
+// The caller must hold the mutex.
+func (g *Guide) refresh() error {
+	defer utils.TimeOperation("Fetch guide data")()
+
+	lineup, err := g.fetchLineup()
+	if err != nil {
+		return err
+	}
+
+	// DeviceAuth rotates, so read it fresh for every guide request
+	deviceAuth, err := g.fetchDeviceAuth()
+	if err != nil {
+		return err
+	}
+
+	guide, err := g.fetchGuide(deviceAuth)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := renderXMLTV(&buf, lineup, guide); err != nil {
+		return fmt.Errorf("failed to render XMLTV: %w", err)
+	}
+
+	programmes := 0
+	for _, channel := range guide {
+		programmes += len(channel.Guide)
+	}
+	g.logger.Info("📰 Guide data refreshed",
+		logger.Int("channels", len(lineup)),
+		logger.Int("programmes", programmes))
+
+	g.xmltv = buf.Bytes()
+	g.fetchedAt = time.Now()
+	g.lineup = lineup
+	g.lineupAt = g.fetchedAt
+	return nil
+}
+
+// fetchDeviceAuth reads the DeviceAuth token from the device's discover.json.
+func (g *Guide) fetchDeviceAuth() (string, error) {
+	var discovery struct {
+		DeviceAuth string `json:"DeviceAuth"`
+	}
+	hdhrIP := *g.hdhrIP.Load()
+	if err := g.getJSON("http://"+hdhrIP+"/discover.json", &discovery); err != nil {
+		return "", err
+	}
+	if discovery.DeviceAuth == "" {
+		return "", fmt.Errorf("HDHomeRun at %s did not report a DeviceAuth", hdhrIP)
+	}
+	return discovery.DeviceAuth, nil
+}
+
+// fetchLineup reads the channel lineup from the device.
+func (g *Guide) fetchLineup() ([]lineupChannel, error) {
+	var lineup []lineupChannel
+	if err := g.getJSON("http://"+*g.hdhrIP.Load()+"/lineup.json", &lineup); err != nil {
+		return nil, err
+	}
+	return lineup, nil
+}
+
+// fetchGuide queries the guide API with the device's DeviceAuth.
+func (g *Guide) fetchGuide(deviceAuth string) ([]guideChannel, error) {
+	guideURL, err := url.Parse(g.guideURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid guide URL %q: %w", g.guideURL, err)
+	}
+	query := guideURL.Query()
+	query.Set("DeviceAuth", deviceAuth)
+	guideURL.RawQuery = query.Encode()
+
+	var guide []guideChannel
+	if err := g.getJSON(guideURL.String(), &guide); err != nil {
+		return nil, err
+	}
+	return guide, nil
+}
+
+// getJSON fetches a URL and decodes its JSON body into v.
+func (g *Guide) getJSON(target string, v interface{}) error {
+	resp, err := g.client.Get(target)
+	if err != nil {
+		return utils.LogAndWrapError(err, "failed to fetch %s", redact(target))
+	}
+	defer utils.CloseWithLogging(resp.Body, "response body")
+
+	if resp.StatusCode != http.StatusOK {
+		return utils.LogAndWrapError(fmt.Errorf("HTTP status %d", resp.StatusCode), "invalid response from %s", redact(target))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return utils.LogAndWrapError(err, "failed to parse response from %s", redact(target))
+	}
+	return nil
+}
+
+// redact strips the query string so DeviceAuth does not end up in logs.
+func redact(target string) string {
+	u, err := url.Parse(target)
+	if err != nil {
+		return target
+	}
+	u.RawQuery = ""
+	return u.String()
+}
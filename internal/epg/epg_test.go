@@ -0,0 +1,185 @@
+package epg
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/attaebra/hdhr-proxy/internal/logger"
+	"github.com/attaebra/hdhr-proxy/internal/publicurl"
+	"github.com/attaebra/hdhr-proxy/internal/utils"
+)
+
+// mockSources serves a device's discover.json and lineup.json plus a stand-in guide API.
+type mockSources struct {
+	server      *httptest.Server
+	guideHits   atomic.Int32
+	guideStatus atomic.Int32
+}
+
+func newMockSources(t *testing.T) *mockSources {
+	t.Helper()
+	m := &mockSources{}
+	start := time.Date(2024, 3, 4, 20, 0, 0, 0, time.UTC).Unix()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/discover.json", func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{"DeviceID": "1234ABCD", "DeviceAuth": "secret-auth"})
+	})
+	mux.HandleFunc("/lineup.json", func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode([]lineupChannel{
+			{GuideNumber: "5.1", GuideName: "WABC"},
+			{GuideNumber: "7.1", GuideName: "WXYZ \"HD\""},
+		})
+	})
+	mux.HandleFunc("/guide", func(w http.ResponseWriter, r *http.Request) {
+		m.guideHits.Add(1)
+		if status := m.guideStatus.Load(); status != 0 {
+			w.WriteHeader(int(status))
+			return
+		}
+		if r.URL.Query().Get("DeviceAuth") != "secret-auth" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		_ = json.NewEncoder(w).Encode([]guideChannel{
+			{
+				GuideNumber: "5.1",
+				Affiliate:   "ABC",
+				ImageURL:    "http://img/abc.png",
+				Guide: []guideProgram{{
+					StartTime:       start,
+					EndTime:         start + 3600,
+					Title:           "News & Weather",
+					EpisodeTitle:    "Evening",
+					EpisodeNumber:   "S01E02",
+					OriginalAirdate: start - 7*24*3600,
+					Filter:          []string{"News"},
+				}},
+			},
+			{GuideNumber: "99.1", Guide: []guideProgram{{StartTime: start, EndTime: start + 60, Title: "Not in lineup"}}},
+		})
+	})
+
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+func (m *mockSources) newGuide(ttl time.Duration) *Guide {
+	host := strings.TrimPrefix(m.server.URL, "http://")
+	return New(host, m.server.URL+"/guide", ttl, utils.HTTPClient(5*time.Second), logger.NewZapLogger(logger.LevelDebug))
+}
+
+func TestXMLTV(t *testing.T) {
+	sources := newMockSources(t)
+	guide := sources.newGuide(time.Hour)
+
+	recorder := httptest.NewRecorder()
+	guide.Handler(&publicurl.Resolver{MediaPort: 5004}).ServeHTTP(recorder, httptest.NewRequest("GET", "/epg.xml", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	var doc xmltvDocument
+	if err := xml.Unmarshal(recorder.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("Invalid XMLTV: %v", err)
+	}
+	if len(doc.Channels) != 2 || doc.Channels[0].ID != "5.1" || doc.Channels[0].Icon == nil {
+		t.Errorf("Unexpected channels: %+v", doc.Channels)
+	}
+	if len(doc.Programmes) != 1 {
+		t.Fatalf("Expected guide entries outside the lineup to be dropped, got %d programmes", len(doc.Programmes))
+	}
+
+	p := doc.Programmes[0]
+	if p.Channel != "5.1" || p.Start != "20240304200000 +0000" || p.Stop != "20240304210000 +0000" {
+		t.Errorf("Unexpected programme timing: %+v", p)
+	}
+	if p.Title != "News & Weather" || p.SubTitle != "Evening" || p.EpisodeNum == nil || p.PreviouslyShown == nil {
+		t.Errorf("Unexpected programme details: %+v", p)
+	}
+}
+
+func TestXMLTVCachesAndServesStale(t *testing.T) {
+	sources := newMockSources(t)
+	guide := sources.newGuide(time.Hour)
+
+	for i := 0; i < 3; i++ {
+		if _, err := guide.XMLTV(); err != nil {
+			t.Fatalf("XMLTV failed: %v", err)
+		}
+	}
+	if hits := sources.guideHits.Load(); hits != 1 {
+		t.Errorf("Expected one guide fetch within the TTL, got %d", hits)
+	}
+
+	// Expire the cache and fail the upstream; the cached guide is still served
+	guide.fetchedAt = time.Now().Add(-2 * time.Hour)
+	sources.guideStatus.Store(http.StatusInternalServerError)
+	if data, err := guide.XMLTV(); err != nil || len(data) == 0 {
+		t.Errorf("Expected stale guide on refresh failure, got %v", err)
+	}
+
+	// Without a cached copy the failure is reported
+	fresh := sources.newGuide(time.Hour)
+	recorder := httptest.NewRecorder()
+	fresh.Handler(&publicurl.Resolver{MediaPort: 5004}).ServeHTTP(recorder, httptest.NewRequest("GET", "/epg.xml", nil))
+	if recorder.Code != http.StatusBadGateway {
+		t.Errorf("Expected 502 without guide data, got %d", recorder.Code)
+	}
+}
+
+func TestGuideFollowsDeviceAddress(t *testing.T) {
+	sources := newMockSources(t)
+	guide := New("127.0.0.1:1", sources.server.URL+"/guide", time.Hour, utils.HTTPClient(5*time.Second), logger.NewZapLogger(logger.LevelDebug))
+	if _, err := guide.XMLTV(); err == nil {
+		t.Fatal("Expected the guide to fail without a device")
+	}
+
+	guide.SetDeviceAddress(strings.TrimPrefix(sources.server.URL, "http://"))
+	if _, err := guide.XMLTV(); err != nil {
+		t.Errorf("Expected the guide to be fetched from the new address, got %v", err)
+	}
+}
+
+func TestM3UMatchesGuideIDs(t *testing.T) {
+	sources := newMockSources(t)
+	guide := sources.newGuide(time.Hour)
+
+	req := httptest.NewRequest("GET", "/lineup.m3u", nil)
+	req.Host = "proxy.local:8080"
+	recorder := httptest.NewRecorder()
+	guide.Handler(&publicurl.Resolver{MediaPort: 5004}).ServeHTTP(recorder, req)
+
+	body := recorder.Body.String()
+	for _, want := range []string{
+		"#EXTM3U url-tvg=\"http://proxy.local:8080/epg.xml\"",
+		"tvg-id=\"5.1\"",
+		"http://proxy.local:5004/auto/v5.1",
+		"tvg-name=\"WXYZ 'HD'\"",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected playlist to contain %q, got:\n%s", want, body)
+		}
+	}
+}
+
+func TestM3UCarriesMediaToken(t *testing.T) {
+	sources := newMockSources(t)
+	guide := sources.newGuide(time.Hour)
+
+	req := httptest.NewRequest("GET", "/lineup.m3u?token=bed+room", nil)
+	req.Host = "proxy.local"
+	recorder := httptest.NewRecorder()
+	guide.Handler(&publicurl.Resolver{MediaPort: 5004}).ServeHTTP(recorder, req)
+
+	if want := "http://proxy.local:5004/auto/v5.1?token=bed+room\n"; !strings.Contains(recorder.Body.String(), want) {
+		t.Errorf("Expected playlist to contain %q, got:\n%s", want, recorder.Body.String())
+	}
+}
@@ -0,0 +1,133 @@
+package epg
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+	"time"
+)
+
+// xmltvTimeFormat is the timestamp layout used by XMLTV.
+const xmltvTimeFormat = "20060102150405 -0700"
+
+// xmltvDocument is the root <tv> element of an XMLTV file.
+type xmltvDocument struct {
+	XMLName       xml.Name         `xml:"tv"`
+	GeneratorName string           `xml:"generator-info-name,attr"`
+	Channels      []xmltvChannel   `xml:"channel"`
+	Programmes    []xmltvProgramme `xml:"programme"`
+}
+
+type xmltvChannel struct {
+	ID           string     `xml:"id,attr"`
+	DisplayNames []string   `xml:"display-name"`
+	Icon         *xmltvIcon `xml:"icon,omitempty"`
+}
+
+type xmltvIcon struct {
+	Src string `xml:"src,attr"`
+}
+
+type xmltvProgramme struct {
+	Start           string                `xml:"start,attr"`
+	Stop            string                `xml:"stop,attr"`
+	Channel         string                `xml:"channel,attr"`
+	Title           string                `xml:"title"`
+	SubTitle        string                `xml:"sub-title,omitempty"`
+	Desc            string                `xml:"desc,omitempty"`
+	Categories      []string              `xml:"category"`
+	EpisodeNum      *xmltvEpisodeNum      `xml:"episode-num,omitempty"`
+	Icon            *xmltvIcon            `xml:"icon,omitempty"`
+	PreviouslyShown *xmltvPreviouslyShown `xml:"previously-shown,omitempty"`
+}
+
+type xmltvEpisodeNum struct {
+	System string `xml:"system,attr"`
+	Value  string `xml:",chardata"`
+}
+
+type xmltvPreviouslyShown struct {
+	Start string `xml:"start,attr,omitempty"`
+}
+
+// renderXMLTV writes the guide for a lineup as an XMLTV document. Channels are
+// identified by ChannelID so they match the proxy's M3U playlist; guide entries
+// for channels outside the lineup are dropped.
+func renderXMLTV(w io.Writer, lineup []lineupChannel, guide []guideChannel) error {
+	doc := xmltvDocument{GeneratorName: "hdhr-proxy"}
+
+	byNumber := make(map[string]guideChannel, len(guide))
+	for _, channel := range guide {
+		byNumber[channel.GuideNumber] = channel
+	}
+
+	for _, channel := range lineup {
+		id := ChannelID(channel.GuideNumber)
+		entry := xmltvChannel{
+			ID:           id,
+			DisplayNames: []string{channel.GuideName, channel.GuideNumber},
+		}
+
+		g, ok := byNumber[channel.GuideNumber]
+		if ok {
+			if g.Affiliate != "" && g.Affiliate != channel.GuideName {
+				entry.DisplayNames = append(entry.DisplayNames, g.Affiliate)
+			}
+			if g.ImageURL != "" {
+				entry.Icon = &xmltvIcon{Src: g.ImageURL}
+			}
+		}
+		doc.Channels = append(doc.Channels, entry)
+
+		for _, program := range g.Guide {
+			doc.Programmes = append(doc.Programmes, programme(id, program))
+		}
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// programme converts a SiliconDust guide entry to an XMLTV programme.
+func programme(channelID string, p guideProgram) xmltvProgramme {
+	out := xmltvProgramme{
+		Start:    formatXMLTVTime(p.StartTime),
+		Stop:     formatXMLTVTime(p.EndTime),
+		Channel:  channelID,
+		Title:    p.Title,
+		SubTitle: p.EpisodeTitle,
+		Desc:     p.Synopsis,
+	}
+	for _, filter := range p.Filter {
+		if filter = strings.TrimSpace(filter); filter != "" {
+			out.Categories = append(out.Categories, filter)
+		}
+	}
+	if p.EpisodeNumber != "" {
+		out.EpisodeNum = &xmltvEpisodeNum{System: "onscreen", Value: p.EpisodeNumber}
+	}
+	if p.ImageURL != "" {
+		out.Icon = &xmltvIcon{Src: p.ImageURL}
+	}
+	if p.OriginalAirdate > 0 {
+		// Repeats carry the date they first aired
+		aired := time.Unix(p.OriginalAirdate, 0).UTC()
+		if aired.Before(time.Unix(p.StartTime, 0).UTC().Truncate(24 * time.Hour)) {
+			out.PreviouslyShown = &xmltvPreviouslyShown{Start: aired.Format("20060102")}
+		}
+	}
+	return out
+}
+
+// formatXMLTVTime formats a Unix timestamp for XMLTV.
+func formatXMLTVTime(unix int64) string {
+	return time.Unix(unix, 0).UTC().Format(xmltvTimeFormat)
+}
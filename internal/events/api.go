@@ -0,0 +1,80 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/attaebra/hdhr-proxy/internal/constants"
+	"github.com/attaebra/hdhr-proxy/internal/interfaces"
+	"github.com/attaebra/hdhr-proxy/internal/logger"
+	"github.com/attaebra/hdhr-proxy/internal/utils"
+)
+
+// keepAliveInterval is how often an idle event stream sends a comment so
+// proxies and clients do not time the connection out.
+const keepAliveInterval = 30 * time.Second
+
+// Handler returns the event stream for the API server:
+//
+//	GET /events              every event as Server-Sent Events
+//	GET /events?types=a,b    only the listed event types
+func (b *Bus) Handler(log interfaces.Logger) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /events", func(w http.ResponseWriter, r *http.Request) {
+		var types []Type
+		if filter := r.URL.Query().Get("types"); filter != "" {
+			var err error
+			if types, err = ParseTypes(strings.Split(filter, ",")); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		// Event streams outlive the API server's write timeout
+		rc := http.NewResponseController(w)
+		_ = rc.SetWriteDeadline(time.Time{})
+
+		events, cancel := b.Subscribe(types...)
+		defer cancel()
+
+		w.Header().Set("Content-Type", constants.ContentTypeEventStream)
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		_ = rc.Flush()
+
+		clientIP := utils.ClientIP(r)
+		log.Debug("📣 Event stream client connected", logger.String("client_ip", clientIP))
+		defer log.Debug("📣 Event stream client disconnected", logger.String("client_ip", clientIP))
+
+		keepAlive := time.NewTicker(keepAliveInterval)
+		defer keepAlive.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-keepAlive.C:
+				if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+					return
+				}
+			case e := <-events:
+				data, err := json.Marshal(e)
+				if err != nil {
+					continue
+				}
+				if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data); err != nil {
+					return
+				}
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	})
+
+	return mux
+}
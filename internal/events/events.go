@@ -0,0 +1,148 @@
+// Package events publishes proxy lifecycle events to subscribers such as the
+// /events stream and outgoing webhooks.
+package events
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Type identifies what happened.
+type Type string
+
+// Event types.
+const (
+	StreamStarted     Type = "stream_started"     // A client's stream was tuned and, when transcoding, FFmpeg started
+	StreamEnded       Type = "stream_ended"       // A client's stream finished
+	StreamFailed      Type = "stream_failed"      // A client was admitted but its stream could not be started; Details carry reason
+	TranscodeStarted  Type = "transcode_started"  // An FFmpeg process started for a stream
+	FFmpegExited      Type = "ffmpeg_exited"      // An FFmpeg process exited; Details carry exit_code and reason
+	AC4ErrorBurst     Type = "ac4_error_burst"    // AC4 decoding errors crossed the burst threshold
+	UpstreamReconnect Type = "upstream_reconnect" // A channel was re-tuned after its upstream feed dropped
+	LineupChanged     Type = "lineup_changed"     // The device lineup differs from the previous read
+	TunerBusy         Type = "tuner_busy"         // A stream was refused by the admission limits
+	DeviceMoved       Type = "device_moved"       // The HDHomeRun answered at a new address; Details carry from and to
+)
+
+// Types lists every event type.
+var Types = []Type{
+	StreamStarted, StreamEnded, StreamFailed, TranscodeStarted, FFmpegExited,
+	AC4ErrorBurst, UpstreamReconnect, LineupChanged, TunerBusy,
+	DeviceMoved,
+}
+
+// Event is a single lifecycle event.
+type Event struct {
+	ID        uint64                 `json:"id"`
+	Type      Type                   `json:"type"`
+	Time      time.Time              `json:"time"`
+	Channel   string                 `json:"channel,omitempty"`
+	ClientIP  string                 `json:"client_ip,omitempty"`
+	SessionID string                 `json:"session_id,omitempty"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+}
+
+// subscriberBuffer is how many events a subscriber may fall behind before
+// events are dropped for it.
+const subscriberBuffer = 64
+
+// subscriber receives events of the types it asked for.
+type subscriber struct {
+	events chan Event
+	types  map[Type]bool // nil means every type
+}
+
+// Bus fans events out to subscribers. Publishing never blocks: a subscriber
+// that falls behind misses events rather than stalling a stream. A nil *Bus
+// discards events.
+type Bus struct {
+	mutex       sync.Mutex
+	subscribers map[*subscriber]struct{}
+	nextID      uint64
+	dropped     atomic.Int64
+}
+
+// NewBus creates an event bus.
+func NewBus() *Bus {
+	return &Bus{subscribers: make(map[*subscriber]struct{})}
+}
+
+// Publish stamps an event and delivers it to every interested subscriber.
+func (b *Bus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.nextID++
+	e.ID = b.nextID
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	for sub := range b.subscribers {
+		if sub.types != nil && !sub.types[e.Type] {
+			continue
+		}
+		select {
+		case sub.events <- e:
+		default:
+			b.dropped.Add(1)
+		}
+	}
+}
+
+// Subscribe returns a channel of events of the given types, or of every type
+// when none are given. Calling cancel unsubscribes and closes the channel.
+func (b *Bus) Subscribe(types ...Type) (<-chan Event, func()) {
+	sub := &subscriber{events: make(chan Event, subscriberBuffer)}
+	if len(types) > 0 {
+		sub.types = make(map[Type]bool, len(types))
+		for _, t := range types {
+			sub.types[t] = true
+		}
+	}
+
+	b.mutex.Lock()
+	b.subscribers[sub] = struct{}{}
+	b.mutex.Unlock()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			b.mutex.Lock()
+			delete(b.subscribers, sub)
+			b.mutex.Unlock()
+			close(sub.events)
+		})
+	}
+	return sub.events, cancel
+}
+
+// Dropped returns how many events were not delivered to slow subscribers.
+func (b *Bus) Dropped() int64 {
+	return b.dropped.Load()
+}
+
+// ParseTypes converts event type names, rejecting unknown ones.
+func ParseTypes(names []string) ([]Type, error) {
+	known := make(map[Type]bool, len(Types))
+	for _, t := range Types {
+		known[t] = true
+	}
+
+	types := make([]Type, 0, len(names))
+	for _, name := range names {
+		t := Type(strings.TrimSpace(name))
+		if !known[t] {
+			return nil, fmt.Errorf("unknown event type %q", name)
+		}
+		types = append(types, t)
+	}
+	return types, nil
+}
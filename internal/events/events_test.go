@@ -0,0 +1,151 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/attaebra/hdhr-proxy/internal/logger"
+	"github.com/attaebra/hdhr-proxy/internal/utils"
+)
+
+func receive(t *testing.T, events <-chan Event) Event {
+	t.Helper()
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for event")
+		return Event{}
+	}
+}
+
+func TestBusFiltersAndDrops(t *testing.T) {
+	bus := NewBus()
+	all, cancelAll := bus.Subscribe()
+	busy, cancelBusy := bus.Subscribe(TunerBusy)
+	defer cancelBusy()
+
+	bus.Publish(Event{Type: StreamStarted, Channel: "5.1"})
+	bus.Publish(Event{Type: TunerBusy, Channel: "7.1"})
+
+	if e := receive(t, all); e.Type != StreamStarted || e.ID != 1 || e.Time.IsZero() {
+		t.Errorf("Unexpected first event: %+v", e)
+	}
+	if e := receive(t, all); e.Type != TunerBusy || e.ID != 2 {
+		t.Errorf("Unexpected second event: %+v", e)
+	}
+	if e := receive(t, busy); e.Type != TunerBusy {
+		t.Errorf("Expected only tuner_busy events, got %+v", e)
+	}
+
+	// A subscriber that stops reading misses events instead of blocking publishers
+	cancelAll()
+	for i := 0; i < subscriberBuffer+3; i++ {
+		bus.Publish(Event{Type: TunerBusy})
+	}
+	if dropped := bus.Dropped(); dropped != 3 {
+		t.Errorf("Expected 3 dropped events, got %d", dropped)
+	}
+
+	var nilBus *Bus
+	nilBus.Publish(Event{Type: StreamStarted}) // Must not panic
+}
+
+func TestParseTypes(t *testing.T) {
+	if types, err := ParseTypes([]string{"stream_started", " tuner_busy"}); err != nil || len(types) != 2 {
+		t.Errorf("Expected two types, got %v (%v)", types, err)
+	}
+	if _, err := ParseTypes([]string{"bogus"}); err == nil {
+		t.Error("Expected unknown event type to be rejected")
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	bus := NewBus()
+	server := httptest.NewServer(bus.Handler(logger.NewZapLogger(logger.LevelDebug)))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL+"/events?types=ffmpeg_exited", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// The subscription exists once the headers arrive
+	bus.Publish(Event{Type: StreamStarted})
+	bus.Publish(Event{Type: FFmpegExited, Channel: "5.1", Details: map[string]interface{}{"exit_code": 1}})
+
+	scanner := bufio.NewScanner(resp.Body)
+	var lines []string
+	for scanner.Scan() && scanner.Text() != "" {
+		lines = append(lines, scanner.Text())
+	}
+	if len(lines) != 3 || lines[1] != "event: ffmpeg_exited" {
+		t.Fatalf("Unexpected event: %q", lines)
+	}
+	var e Event
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(lines[2], "data: ")), &e); err != nil || e.Details["exit_code"] != float64(1) {
+		t.Errorf("Unexpected payload %q (%v)", lines[2], err)
+	}
+
+	recorder := httptest.NewRecorder()
+	bus.Handler(logger.NewZapLogger(logger.LevelDebug)).ServeHTTP(recorder, httptest.NewRequest("GET", "/events?types=bogus", nil))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown type filter, got %d", recorder.Code)
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	webhookRetryDelay = time.Millisecond
+	defer func() { webhookRetryDelay = time.Second }()
+
+	var attempts atomic.Int32
+	delivered := make(chan Event, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var e Event
+		_ = json.NewDecoder(r.Body).Decode(&e)
+		delivered <- e
+	}))
+	defer server.Close()
+
+	var rejected atomic.Int32
+	permanent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		rejected.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer permanent.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bus := NewBus()
+	StartWebhooks(ctx, bus, []string{server.URL, permanent.URL}, []Type{LineupChanged},
+		utils.HTTPClient(5*time.Second), logger.NewZapLogger(logger.LevelDebug))
+
+	bus.Publish(Event{Type: StreamStarted})
+	bus.Publish(Event{Type: LineupChanged})
+
+	if e := receive(t, delivered); e.Type != LineupChanged {
+		t.Errorf("Expected lineup_changed delivery, got %+v", e)
+	}
+	if n := attempts.Load(); n != 3 {
+		t.Errorf("Expected delivery on the third attempt, got %d attempts", n)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := rejected.Load(); n != 1 {
+		t.Errorf("Expected a 4xx response not to be retried, got %d attempts", n)
+	}
+}
@@ -0,0 +1,118 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/attaebra/hdhr-proxy/internal/constants"
+	"github.com/attaebra/hdhr-proxy/internal/interfaces"
+	"github.com/attaebra/hdhr-proxy/internal/logger"
+	"github.com/attaebra/hdhr-proxy/internal/utils"
+)
+
+// Webhook delivery retries failed POSTs with exponential backoff.
+const webhookAttempts = 4
+
+// webhookRetryDelay is the delay before the first retry; it doubles after each attempt.
+var webhookRetryDelay = time.Second
+
+// StartWebhooks POSTs events of the given types (every type when none are
+// given) as JSON to each URL until ctx is canceled. Network errors and 5xx
+// responses are retried; other responses are final.
+func StartWebhooks(ctx context.Context, bus *Bus, urls []string, types []Type, client interfaces.Client, log interfaces.Logger) {
+	for _, url := range urls {
+		events, cancel := bus.Subscribe(types...)
+		go func(url string) {
+			defer cancel()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case e := <-events:
+					deliver(ctx, client, url, e, log)
+				}
+			}
+		}(url)
+
+		log.Info("🪝 Webhook registered", logger.String("url", url), logger.Any("types", types))
+	}
+}
+
+// deliver POSTs a single event, retrying transient failures.
+func deliver(ctx context.Context, client interfaces.Client, url string, e Event, log interfaces.Logger) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		log.Error("❌ Failed to encode webhook event", logger.ErrorField("error", err))
+		return
+	}
+
+	delay := webhookRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := post(ctx, client, url, body)
+		if err == nil {
+			return
+		}
+
+		if isPermanent(err) || attempt == webhookAttempts {
+			log.Warn("⚠️  Webhook delivery failed",
+				logger.String("url", url),
+				logger.String("event", string(e.Type)),
+				logger.Int("attempts", attempt),
+				logger.ErrorField("error", err))
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// permanentError is a delivery failure that retrying will not fix.
+type permanentError struct {
+	err error
+}
+
+// Error implements the error interface.
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+// isPermanent reports whether a delivery error should not be retried.
+func isPermanent(err error) bool {
+	var permanent *permanentError
+	return errors.As(err, &permanent)
+}
+
+// post sends one webhook request.
+func post(ctx context.Context, client interfaces.Client, url string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return &permanentError{err: err}
+	}
+	req.Header.Set("Content-Type", constants.ContentTypeJSON)
+	req.Header.Set("User-Agent", "hdhr-proxy/1.0")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer utils.CloseWithLogging(resp.Body, "webhook response body")
+
+	err = fmt.Errorf("webhook returned HTTP %d", resp.StatusCode)
+	switch {
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return err
+	case resp.StatusCode >= 300:
+		return &permanentError{err: err}
+	}
+	return nil
+}
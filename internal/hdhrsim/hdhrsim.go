@@ -0,0 +1,354 @@
+// Package hdhrsim emulates an HDHomeRun tuner for integration tests and demos.
+// It serves the device's discovery and lineup endpoints and streams looped
+// MPEG-TS fixtures from /auto/v{channel}, and can be told to run out of
+// tuners, start streams slowly or drop them part way through.
+package hdhrsim
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PacketSize is the size of an MPEG-TS packet.
+const PacketSize = 188
+
+// chunkSize is how much of the fixture is written at a time, seven packets as
+// in a UDP-sized HDHomeRun datagram.
+const chunkSize = 7 * PacketSize
+
+// ErrorAllTunersInUse is the X-HDHomeRun-Error a device sends with a 503 when
+// every tuner is busy.
+const ErrorAllTunersInUse = "805 All Tuners In Use"
+
+// Channel is a lineup entry.
+type Channel struct {
+	GuideNumber string
+	GuideName   string
+	VideoCodec  string
+	AudioCodec  string
+	HD          bool
+	Fixture     string // TS file looped for this channel; empty uses the default stream
+}
+
+// DefaultChannels is the lineup used when Options lists none, with one ATSC 3.0
+// channel carrying AC4 audio and one ATSC 1.0 channel carrying AC3.
+var DefaultChannels = []Channel{
+	{GuideNumber: "5.1", GuideName: "NBC", VideoCodec: "HEVC", AudioCodec: "AC4", HD: true},
+	{GuideNumber: "7.1", GuideName: "ABC", VideoCodec: "MPEG2", AudioCodec: "AC3", HD: true},
+}
+
+// Options describe the simulated device.
+type Options struct {
+	DeviceID   string    // Defaults to "1050ABCD"
+	TunerCount int       // Defaults to 4
+	Channels   []Channel // Defaults to DefaultChannels
+	Fixture    string    // TS file for channels without their own; empty uses SyntheticTS
+	MediaPort  int       // Port advertised in lineup URLs; 0 uses the port of the request
+	Bitrate    int       // Bytes per second streamed; 0 streams as fast as the client reads
+}
+
+// Simulator is an http.Handler emulating an HDHomeRun device.
+type Simulator struct {
+	opts     Options
+	fixtures map[string][]byte // Keyed by guide number
+
+	mu              sync.Mutex
+	tunersInUse     int
+	streams         int // Streams started since creation
+	allTunersBusy   bool
+	startDelay      time.Duration
+	disconnectAfter int64
+}
+
+// New creates a simulator, reading its fixture files.
+func New(opts Options) (*Simulator, error) {
+	if opts.DeviceID == "" {
+		opts.DeviceID = "1050ABCD"
+	}
+	if opts.TunerCount <= 0 {
+		opts.TunerCount = 4
+	}
+	if len(opts.Channels) == 0 {
+		opts.Channels = DefaultChannels
+	}
+
+	fallback := SyntheticTS(1000)
+	if opts.Fixture != "" {
+		data, err := readFixture(opts.Fixture)
+		if err != nil {
+			return nil, err
+		}
+		fallback = data
+	}
+
+	s := &Simulator{
+		opts:     opts,
+		fixtures: make(map[string][]byte, len(opts.Channels)),
+	}
+	for _, ch := range opts.Channels {
+		s.fixtures[ch.GuideNumber] = fallback
+		if ch.Fixture != "" {
+			data, err := readFixture(ch.Fixture)
+			if err != nil {
+				return nil, err
+			}
+			s.fixtures[ch.GuideNumber] = data
+		}
+	}
+	return s, nil
+}
+
+// readFixture loads a TS file, which must hold whole packets.
+func readFixture(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fixture: %w", err)
+	}
+	if len(data) == 0 || len(data)%PacketSize != 0 || data[0] != 0x47 {
+		return nil, fmt.Errorf("fixture %s is not an MPEG-TS file", path)
+	}
+	return data, nil
+}
+
+// SetAllTunersBusy makes every stream request fail with 503 as if the tuners
+// were in use by other clients.
+func (s *Simulator) SetAllTunersBusy(busy bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.allTunersBusy = busy
+}
+
+// SetStartDelay delays the first bytes of each new stream, like a tuner
+// locking onto a weak signal.
+func (s *Simulator) SetStartDelay(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.startDelay = d
+}
+
+// SetDisconnectAfter aborts each new stream's connection after n bytes; 0
+// streams until the client goes away.
+func (s *Simulator) SetDisconnectAfter(n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.disconnectAfter = n
+}
+
+// TunersInUse returns the number of streams currently being served.
+func (s *Simulator) TunersInUse() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tunersInUse
+}
+
+// Streams returns the number of streams started so far.
+func (s *Simulator) Streams() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.streams
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Simulator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/discover.json":
+		s.serveDiscover(w, r)
+	case r.URL.Path == "/lineup.json":
+		s.serveLineupJSON(w, r)
+	case r.URL.Path == "/lineup.xml":
+		s.serveLineupXML(w, r)
+	case r.URL.Path == "/lineup_status.json":
+		writeJSON(w, map[string]any{
+			"ScanInProgress": 0,
+			"ScanPossible":   1,
+			"Source":         "Antenna",
+			"SourceList":     []string{"Antenna"},
+		})
+	case strings.HasPrefix(r.URL.Path, "/auto/v"):
+		s.serveStream(w, r, strings.TrimPrefix(r.URL.Path, "/auto/v"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Simulator) serveDiscover(w http.ResponseWriter, r *http.Request) {
+	base := "http://" + r.Host
+	writeJSON(w, map[string]any{
+		"FriendlyName":    "HDHomeRun Simulator",
+		"ModelNumber":     "HDHR5-4K",
+		"FirmwareName":    "hdhomerun5_atsc3",
+		"FirmwareVersion": "20240101",
+		"DeviceID":        s.opts.DeviceID,
+		"DeviceAuth":      "simulator",
+		"TunerCount":      s.opts.TunerCount,
+		"LocalIP":         hostname(r.Host),
+		"BaseURL":         base,
+		"LineupURL":       base + "/lineup.json",
+	})
+}
+
+// lineupEntry is a channel as the device lists it.
+type lineupEntry struct {
+	GuideNumber string `json:"GuideNumber"`
+	GuideName   string `json:"GuideName"`
+	VideoCodec  string `json:"VideoCodec,omitempty" xml:",omitempty"`
+	AudioCodec  string `json:"AudioCodec,omitempty" xml:",omitempty"`
+	HD          int    `json:"HD,omitempty" xml:",omitempty"`
+	URL         string `json:"URL"`
+}
+
+func (s *Simulator) lineup(r *http.Request) []lineupEntry {
+	host, port := hostname(r.Host), s.opts.MediaPort
+	if port == 0 {
+		_, requestPort, _ := net.SplitHostPort(r.Host)
+		port, _ = strconv.Atoi(requestPort)
+	}
+	media := "http://" + host
+	if port != 0 && port != 80 {
+		media = "http://" + net.JoinHostPort(host, strconv.Itoa(port))
+	}
+
+	entries := make([]lineupEntry, 0, len(s.opts.Channels))
+	for _, ch := range s.opts.Channels {
+		entry := lineupEntry{
+			GuideNumber: ch.GuideNumber,
+			GuideName:   ch.GuideName,
+			VideoCodec:  ch.VideoCodec,
+			AudioCodec:  ch.AudioCodec,
+			URL:         media + "/auto/v" + ch.GuideNumber,
+		}
+		if ch.HD {
+			entry.HD = 1
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func (s *Simulator) serveLineupJSON(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.lineup(r))
+}
+
+func (s *Simulator) serveLineupXML(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write([]byte(xml.Header))
+	xml.NewEncoder(w).Encode(struct {
+		XMLName  xml.Name      `xml:"Lineup"`
+		Programs []lineupEntry `xml:"Program"`
+	}{Programs: s.lineup(r)})
+}
+
+// serveStream loops the channel's fixture until the client disconnects or the
+// configured disconnect point is reached.
+func (s *Simulator) serveStream(w http.ResponseWriter, r *http.Request, channel string) {
+	fixture, ok := s.fixtures[channel]
+	if !ok {
+		w.Header().Set("X-HDHomeRun-Error", "801 Unknown Channel")
+		http.Error(w, "Unknown Channel", http.StatusNotFound)
+		return
+	}
+
+	s.mu.Lock()
+	if s.allTunersBusy || s.tunersInUse >= s.opts.TunerCount {
+		s.mu.Unlock()
+		w.Header().Set("X-HDHomeRun-Error", ErrorAllTunersInUse)
+		http.Error(w, "All Tuners In Use", http.StatusServiceUnavailable)
+		return
+	}
+	s.tunersInUse++
+	s.streams++
+	startDelay, disconnectAfter := s.startDelay, s.disconnectAfter
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.tunersInUse--
+		s.mu.Unlock()
+	}()
+
+	if startDelay > 0 {
+		select {
+		case <-time.After(startDelay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "video/mp2t")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+
+	var interval time.Duration
+	if s.opts.Bitrate > 0 {
+		interval = time.Duration(chunkSize) * time.Second / time.Duration(s.opts.Bitrate)
+	}
+
+	var written int64
+	for offset := 0; ; offset = (offset + chunkSize) % len(fixture) {
+		chunk := fixture[offset:min(offset+chunkSize, len(fixture))]
+		if disconnectAfter > 0 && written+int64(len(chunk)) > disconnectAfter {
+			chunk = chunk[:disconnectAfter-written]
+		}
+		n, err := w.Write(chunk)
+		written += int64(n)
+		if err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if disconnectAfter > 0 && written >= disconnectAfter {
+			// Drop the connection without finishing the response, as a
+			// device does when it loses the signal
+			panic(http.ErrAbortHandler)
+		}
+
+		if interval > 0 {
+			select {
+			case <-time.After(interval):
+			case <-r.Context().Done():
+				return
+			}
+		} else if r.Context().Err() != nil {
+			return
+		}
+	}
+}
+
+// SyntheticTS returns packets MPEG-TS packets on PID 0x100 with valid
+// continuity counters, enough for the proxy's direct streaming path. Real
+// transcoding needs a fixture recorded from a device.
+func SyntheticTS(packets int) []byte {
+	data := make([]byte, packets*PacketSize)
+	for i := 0; i < packets; i++ {
+		packet := data[i*PacketSize : (i+1)*PacketSize]
+		packet[0] = 0x47
+		packet[1] = 0x01 // PID 0x100
+		packet[2] = 0x00
+		packet[3] = 0x10 | byte(i&0x0F) // Payload only, continuity counter
+		for j := 4; j < PacketSize; j++ {
+			packet[j] = 0xFF
+		}
+	}
+	return data
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// hostname returns the host of a host[:port] string.
+func hostname(hostport string) string {
+	if host, _, err := net.SplitHostPort(hostport); err == nil {
+		return host
+	}
+	return hostport
+}
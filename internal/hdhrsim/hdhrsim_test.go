@@ -0,0 +1,187 @@
+package hdhrsim
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newServer(t *testing.T, opts Options) (*Simulator, *httptest.Server) {
+	t.Helper()
+	sim, err := New(opts)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	server := httptest.NewServer(sim)
+	t.Cleanup(server.Close)
+	return sim, server
+}
+
+func TestDiscoveryAndLineup(t *testing.T) {
+	_, server := newServer(t, Options{DeviceID: "ABCDEF12", TunerCount: 2, MediaPort: 5004})
+
+	var discover map[string]any
+	getJSON(t, server.URL+"/discover.json", &discover)
+	if discover["DeviceID"] != "ABCDEF12" || discover["TunerCount"] != float64(2) || discover["BaseURL"] != server.URL {
+		t.Errorf("Unexpected discover.json: %v", discover)
+	}
+
+	var lineup []map[string]any
+	getJSON(t, server.URL+"/lineup.json", &lineup)
+	if len(lineup) != 2 || lineup[0]["AudioCodec"] != "AC4" {
+		t.Fatalf("Unexpected lineup: %v", lineup)
+	}
+	if want := "http://127.0.0.1:5004/auto/v5.1"; lineup[0]["URL"] != want {
+		t.Errorf("Expected URL %s, got %v", want, lineup[0]["URL"])
+	}
+
+	resp, err := http.Get(server.URL + "/lineup.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), "<Program><GuideNumber>7.1</GuideNumber>") {
+		t.Errorf("Unexpected lineup.xml: %s", body)
+	}
+}
+
+func TestStreamLoopsFixture(t *testing.T) {
+	fixture := filepath.Join(t.TempDir(), "5.1.ts")
+	if err := os.WriteFile(fixture, SyntheticTS(3), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sim, server := newServer(t, Options{Channels: []Channel{{GuideNumber: "5.1", Fixture: fixture}}})
+
+	resp, err := http.Get(server.URL + "/auto/v5.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "video/mp2t" {
+		t.Errorf("Expected video/mp2t, got %q", resp.Header.Get("Content-Type"))
+	}
+
+	// Ten packets from a three packet fixture means it looped
+	data := make([]byte, 10*PacketSize)
+	if _, err := io.ReadFull(resp.Body, data); err != nil {
+		t.Fatalf("Failed to read stream: %v", err)
+	}
+	for i := 0; i < len(data); i += PacketSize {
+		if data[i] != 0x47 {
+			t.Fatalf("Expected sync byte at offset %d", i)
+		}
+	}
+	if sim.TunersInUse() != 1 {
+		t.Errorf("Expected one tuner in use, got %d", sim.TunersInUse())
+	}
+}
+
+func TestTunersBusy(t *testing.T) {
+	sim, server := newServer(t, Options{TunerCount: 1})
+
+	first, err := http.Get(server.URL + "/auto/v5.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Body.Close()
+
+	second, err := http.Get(server.URL + "/auto/v7.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second.Body.Close()
+	if second.StatusCode != http.StatusServiceUnavailable || second.Header.Get("X-HDHomeRun-Error") != ErrorAllTunersInUse {
+		t.Errorf("Expected 503 all tuners in use, got %d %q", second.StatusCode, second.Header.Get("X-HDHomeRun-Error"))
+	}
+
+	// Releasing the tuner frees it for the next client
+	first.Body.Close()
+	waitFor(t, func() bool { return sim.TunersInUse() == 0 })
+
+	sim.SetAllTunersBusy(true)
+	third, err := http.Get(server.URL + "/auto/v7.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	third.Body.Close()
+	if third.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected forced 503, got %d", third.StatusCode)
+	}
+
+	unknown, err := http.Get(server.URL + "/auto/v99.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unknown.Body.Close()
+	if unknown.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown channel, got %d", unknown.StatusCode)
+	}
+}
+
+func TestDisconnectAndStartDelay(t *testing.T) {
+	sim, server := newServer(t, Options{})
+	sim.SetStartDelay(50 * time.Millisecond)
+	sim.SetDisconnectAfter(5 * PacketSize)
+
+	start := time.Now()
+	resp, err := http.Get(server.URL + "/auto/v5.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Expected the stream to start after the delay, took %v", elapsed)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err == nil || errors.Is(err, io.EOF) {
+		t.Errorf("Expected the connection to be dropped, got %v", err)
+	}
+	if len(data) != 5*PacketSize {
+		t.Errorf("Expected %d bytes before the disconnect, got %d", 5*PacketSize, len(data))
+	}
+	if sim.Streams() != 1 {
+		t.Errorf("Expected one stream, got %d", sim.Streams())
+	}
+}
+
+func TestInvalidFixture(t *testing.T) {
+	fixture := filepath.Join(t.TempDir(), "bad.ts")
+	if err := os.WriteFile(fixture, []byte("not a transport stream"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(Options{Fixture: fixture}); err == nil {
+		t.Error("Expected an error for an invalid fixture")
+	}
+}
+
+func getJSON(t *testing.T, url string, v any) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("Failed to decode %s: %v", url, err)
+	}
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for condition")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,407 @@
+// Package health serves liveness and readiness checks for container
+// orchestrators: /healthz reports whether the process is responsive and
+// /readyz whether it can take new streams.
+package health
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/attaebra/hdhr-proxy/internal/constants"
+	"github.com/attaebra/hdhr-proxy/internal/interfaces"
+	"github.com/attaebra/hdhr-proxy/internal/logger"
+	"github.com/attaebra/hdhr-proxy/internal/media/session"
+)
+
+// Check statuses.
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// Check names.
+const (
+	CheckHeartbeat  = "heartbeat"  // The monitor goroutine answers a ping
+	CheckTranscoder = "transcoder" // The transcoder returns a status snapshot
+	CheckDevice     = "device"     // The device answered discover.json recently
+	CheckLineup     = "lineup"     // The channel lineup is loaded
+	CheckFFmpeg     = "ffmpeg"     // FFmpeg passed its last self-test
+	CheckCapacity   = "capacity"   // A new stream would be admitted
+)
+
+const (
+	// DefaultInterval is how often the device is probed and FFmpeg self-tested.
+	DefaultInterval = 30 * time.Second
+
+	// responseTimeout bounds how long a goroutine may take to answer before it
+	// is considered stuck.
+	responseTimeout = 2 * time.Second
+
+	// selfTestTimeout bounds each FFmpeg self-test command.
+	selfTestTimeout = 15 * time.Second
+
+	// staleProbes is how many probe intervals a device answer stays fresh.
+	staleProbes = 3
+)
+
+// selfTestArgs transcode a fraction of a second of generated audio to EAC3,
+// exercising the encoder and muxer the proxy relies on.
+var selfTestArgs = []string{
+	"-hide_banner", "-loglevel", "error", "-nostdin",
+	"-f", "lavfi", "-i", "sine=frequency=1000:duration=0.2",
+	"-c:a", "eac3", "-b:a", "192k",
+	"-f", "mpegts", "pipe:1",
+}
+
+// CheckResult is the outcome of a single check.
+type CheckResult struct {
+	Name      string    `json:"name"`
+	Status    string    `json:"status"`
+	LatencyMS float64   `json:"latency_ms"`
+	CheckedAt time.Time `json:"checked_at"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Report is the JSON body of /healthz and /readyz.
+type Report struct {
+	Status string        `json:"status"`
+	Checks []CheckResult `json:"checks"`
+}
+
+// Options configure a Monitor.
+type Options struct {
+	Interval   time.Duration               // How often the device is probed and FFmpeg self-tested
+	Device     func(context.Context) error // Fetches discover.json from the device
+	Status     interfaces.StatusReporter   // Lineup, streams and admission limits
+	FFmpegPath string
+	Runner     interfaces.ProcessRunner // Runs the FFmpeg self-test
+}
+
+// Monitor probes the device and FFmpeg in the background and answers health
+// checks from the latest results.
+type Monitor struct {
+	opts    Options
+	logger  interfaces.Logger
+	ping    chan chan struct{}
+	refresh chan struct{} // Requests a probe before the next interval
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+
+	mutex    sync.Mutex
+	device   CheckResult // Last device probe
+	deviceOK time.Time   // When the device last answered
+	ffmpeg   CheckResult // Last FFmpeg self-test
+}
+
+// New creates a monitor. Call Start to begin probing.
+func New(opts Options, log interfaces.Logger) *Monitor {
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultInterval
+	}
+	return &Monitor{
+		opts:    opts,
+		logger:  log,
+		ping:    make(chan chan struct{}),
+		refresh: make(chan struct{}, 1),
+		device:  CheckResult{Name: CheckDevice, Status: StatusFail, Error: "not checked yet"},
+		ffmpeg:  CheckResult{Name: CheckFFmpeg, Status: StatusFail, Error: "not checked yet"},
+	}
+}
+
+// Start probes the device and self-tests FFmpeg now and then every interval,
+// and starts answering heartbeat pings.
+func (m *Monitor) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+
+	m.wg.Add(2)
+	go func() {
+		defer m.wg.Done()
+		for {
+			select {
+			case reply := <-m.ping:
+				close(reply)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		defer m.wg.Done()
+		ticker := time.NewTicker(m.opts.Interval)
+		defer ticker.Stop()
+		for {
+			m.probeDevice(ctx)
+			m.selfTestFFmpeg(ctx)
+			select {
+			case <-ticker.C:
+			case <-m.refresh:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Refresh probes the device and self-tests FFmpeg again without waiting for
+// the next interval, e.g. once the device has come online.
+func (m *Monitor) Refresh() {
+	select {
+	case m.refresh <- struct{}{}:
+	default:
+	}
+}
+
+// Stop ends the background probes.
+func (m *Monitor) Stop() {
+	if m.cancel != nil {
+		m.cancel()
+		m.wg.Wait()
+	}
+}
+
+// Handler serves GET /healthz and GET /readyz. Both answer 200 when every
+// check passes and 503 otherwise.
+func (m *Monitor) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, _ *http.Request) {
+		writeReport(w, m.Liveness())
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, _ *http.Request) {
+		writeReport(w, m.Readiness())
+	})
+	return mux
+}
+
+// Liveness checks that the monitor goroutine and the transcoder respond.
+func (m *Monitor) Liveness() Report {
+	_, transcoder := m.statusSnapshot()
+	return newReport(m.heartbeat(), transcoder)
+}
+
+// Readiness checks that the device, lineup, FFmpeg and admission limits allow
+// a new stream.
+func (m *Monitor) Readiness() Report {
+	status, snapshot := m.statusSnapshot()
+
+	m.mutex.Lock()
+	device := m.device
+	if device.Status == StatusOK && time.Since(m.deviceOK) > staleProbes*m.opts.Interval {
+		device.Status = StatusFail
+		device.Error = fmt.Sprintf("no answer since %s", m.deviceOK.Format(time.RFC3339))
+	}
+	ffmpeg := m.ffmpeg
+	m.mutex.Unlock()
+
+	lineup := CheckResult{Name: CheckLineup, LatencyMS: snapshot.LatencyMS, CheckedAt: snapshot.CheckedAt}
+	capacity := CheckResult{Name: CheckCapacity, LatencyMS: snapshot.LatencyMS, CheckedAt: snapshot.CheckedAt}
+	if status == nil {
+		lineup.Status, lineup.Error = StatusFail, snapshot.Error
+		capacity.Status, capacity.Error = StatusFail, snapshot.Error
+	} else {
+		lineup.Status = StatusOK
+		if len(status.Lineup) == 0 {
+			lineup.Status, lineup.Error = StatusFail, "lineup not loaded"
+		}
+		capacity.Status = StatusOK
+		if err := saturation(status); err != nil {
+			capacity.Status, capacity.Error = StatusFail, err.Error()
+		}
+	}
+
+	return newReport(device, lineup, ffmpeg, capacity)
+}
+
+// heartbeat pings the monitor goroutine and times the answer.
+func (m *Monitor) heartbeat() CheckResult {
+	result := CheckResult{Name: CheckHeartbeat, CheckedAt: time.Now()}
+	reply := make(chan struct{})
+	timeout := time.NewTimer(responseTimeout)
+	defer timeout.Stop()
+
+	select {
+	case m.ping <- reply:
+		<-reply
+		result.Status = StatusOK
+	case <-timeout.C:
+		result.Status, result.Error = StatusFail, "monitor goroutine did not answer"
+	}
+	result.LatencyMS = milliseconds(time.Since(result.CheckedAt))
+	return result
+}
+
+// statusSnapshot reads the transcoder's status, failing the check when the
+// transcoder does not answer in time, e.g. because it is deadlocked.
+func (m *Monitor) statusSnapshot() (*interfaces.Status, CheckResult) {
+	result := CheckResult{Name: CheckTranscoder, CheckedAt: time.Now()}
+	snapshot := make(chan interfaces.Status, 1)
+	go func() { snapshot <- m.opts.Status.Status() }()
+
+	timeout := time.NewTimer(responseTimeout)
+	defer timeout.Stop()
+	select {
+	case status := <-snapshot:
+		result.Status = StatusOK
+		result.LatencyMS = milliseconds(time.Since(result.CheckedAt))
+		return &status, result
+	case <-timeout.C:
+		result.Status, result.Error = StatusFail, "transcoder status did not answer"
+		result.LatencyMS = milliseconds(time.Since(result.CheckedAt))
+		return nil, result
+	}
+}
+
+// saturation reports which limit, if any, would refuse a new stream.
+func saturation(status *interfaces.Status) error {
+	streams, transcodes := len(status.Sessions), 0
+	for _, s := range status.Sessions {
+		if s.Mode == string(session.ModeTranscode) {
+			transcodes++
+		}
+	}
+
+	switch {
+	case status.Draining:
+		return errors.New("draining")
+	case status.Limits.MaxTotal > 0 && streams >= status.Limits.MaxTotal:
+		return fmt.Errorf("all %d streams in use", status.Limits.MaxTotal)
+	case status.Device.TunerCount > 0 && len(status.Streams) >= status.Device.TunerCount:
+		return fmt.Errorf("all %d tuners in use", status.Device.TunerCount)
+	case status.Limits.MaxTranscodes > 0 && transcodes >= status.Limits.MaxTranscodes:
+		return fmt.Errorf("all %d transcodes in use", status.Limits.MaxTranscodes)
+	}
+	return nil
+}
+
+// probeDevice fetches discover.json and records the outcome.
+func (m *Monitor) probeDevice(ctx context.Context) {
+	result := CheckResult{Name: CheckDevice, CheckedAt: time.Now()}
+	err := m.opts.Device(ctx)
+	result.LatencyMS = milliseconds(time.Since(result.CheckedAt))
+	result.Status = StatusOK
+	if err != nil {
+		result.Status, result.Error = StatusFail, err.Error()
+	}
+
+	m.mutex.Lock()
+	previous := m.device
+	m.device = result
+	if err == nil {
+		m.deviceOK = result.CheckedAt
+	}
+	m.mutex.Unlock()
+
+	switch {
+	case err != nil && (previous.Status == StatusOK || previous.CheckedAt.IsZero()):
+		m.logger.Warn("💔 HDHomeRun is not answering discovery", logger.ErrorField("error", err))
+	case err == nil && previous.Status != StatusOK:
+		m.logger.Debug("💚 HDHomeRun answered discovery",
+			logger.Duration("latency", time.Since(result.CheckedAt)))
+	}
+}
+
+// selfTestFFmpeg runs ffmpeg -version and a short synthetic transcode and
+// records the outcome.
+func (m *Monitor) selfTestFFmpeg(ctx context.Context) {
+	result := CheckResult{Name: CheckFFmpeg, CheckedAt: time.Now()}
+	err := m.runFFmpeg(ctx, []string{"-version"}, func(stdout []byte) error {
+		if !bytes.HasPrefix(stdout, []byte("ffmpeg version")) {
+			return fmt.Errorf("unexpected -version output %q", firstLine(stdout))
+		}
+		return nil
+	})
+	if err == nil {
+		err = m.runFFmpeg(ctx, selfTestArgs, nil)
+	}
+	result.LatencyMS = milliseconds(time.Since(result.CheckedAt))
+	result.Status = StatusOK
+	if err != nil {
+		result.Status, result.Error = StatusFail, err.Error()
+	}
+
+	m.mutex.Lock()
+	previous := m.ffmpeg
+	m.ffmpeg = result
+	m.mutex.Unlock()
+
+	switch {
+	case ctx.Err() != nil:
+		// Stopped mid-test
+	case err != nil && (previous.Status == StatusOK || previous.CheckedAt.IsZero()):
+		m.logger.Error("❌ FFmpeg self-test failed", logger.ErrorField("error", err))
+	case err == nil && previous.Status != StatusOK:
+		m.logger.Debug("💚 FFmpeg self-test passed",
+			logger.Duration("duration", time.Since(result.CheckedAt)))
+	}
+}
+
+// runFFmpeg runs FFmpeg with args and checks its output with verify.
+func (m *Monitor) runFFmpeg(ctx context.Context, args []string, verify func(stdout []byte) error) error {
+	ctx, cancel := context.WithTimeout(ctx, selfTestTimeout)
+	defer cancel()
+
+	proc, err := m.opts.Runner.Start(ctx, m.opts.FFmpegPath, args...)
+	if err != nil {
+		return fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+	proc.Stdin().Close()
+
+	stderr := make(chan []byte, 1)
+	go func() {
+		data, _ := io.ReadAll(proc.Stderr())
+		stderr <- data
+	}()
+	stdout, _ := io.ReadAll(proc.Stdout())
+	errOutput := <-stderr
+
+	if err := proc.Wait(); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("ffmpeg %s timed out", args[0])
+		}
+		return fmt.Errorf("ffmpeg %s failed: %w: %s", args[0], err, firstLine(errOutput))
+	}
+	if verify != nil {
+		return verify(stdout)
+	}
+	return nil
+}
+
+// newReport combines check results; the report passes when every check does.
+func newReport(checks ...CheckResult) Report {
+	report := Report{Status: StatusOK, Checks: checks}
+	for _, check := range checks {
+		if check.Status != StatusOK {
+			report.Status = StatusFail
+		}
+	}
+	return report
+}
+
+// writeReport writes a report as JSON with 200 when it passes and 503 otherwise.
+func writeReport(w http.ResponseWriter, report Report) {
+	code := http.StatusOK
+	if report.Status != StatusOK {
+		code = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", constants.ContentTypeJSON)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(report)
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+func firstLine(output []byte) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(string(output)), "\n")
+	return line
+}
@@ -0,0 +1,182 @@
+//go:build !windows
+
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/attaebra/hdhr-proxy/internal/interfaces"
+	"github.com/attaebra/hdhr-proxy/internal/logger"
+	"github.com/attaebra/hdhr-proxy/internal/media/process"
+)
+
+// fakeReporter returns a fixed status, or blocks until released when stuck.
+type fakeReporter struct {
+	status interfaces.Status
+	stuck  chan struct{}
+}
+
+func (f *fakeReporter) Status() interfaces.Status {
+	if f.stuck != nil {
+		<-f.stuck
+	}
+	return f.status
+}
+
+// script writes an executable shell script standing in for FFmpeg.
+func script(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ffmpeg")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0o755); err != nil {
+		t.Fatalf("Failed to write script: %v", err)
+	}
+	return path
+}
+
+const workingFFmpeg = `[ "$1" = "-version" ] && echo "ffmpeg version test"; exit 0`
+
+func healthyStatus() interfaces.Status {
+	return interfaces.Status{
+		Device: interfaces.DeviceStatus{TunerCount: 2},
+		Lineup: []interfaces.ChannelStatus{{GuideNumber: "5.1"}},
+	}
+}
+
+func newMonitor(t *testing.T, device error, reporter *fakeReporter, ffmpeg string) *Monitor {
+	t.Helper()
+	return New(Options{
+		Interval:   time.Minute,
+		Device:     func(context.Context) error { return device },
+		Status:     reporter,
+		FFmpegPath: script(t, ffmpeg),
+		Runner:     process.NewRunner(time.Second),
+	}, logger.NewZapLogger(logger.LevelDebug))
+}
+
+// failed returns the names of the failing checks.
+func failed(report Report) []string {
+	var names []string
+	for _, check := range report.Checks {
+		if check.Status != StatusOK {
+			names = append(names, check.Name)
+		}
+	}
+	return names
+}
+
+func TestReadiness(t *testing.T) {
+	saturated := healthyStatus()
+	saturated.Streams = []interfaces.StreamStatus{{Channel: "5.1"}, {Channel: "7.1"}}
+	transcodes := healthyStatus()
+	transcodes.Limits.MaxTranscodes = 1
+	transcodes.Sessions = []interfaces.SessionInfo{{Mode: "transcode"}}
+	draining := healthyStatus()
+	draining.Draining = true
+
+	tests := []struct {
+		name   string
+		device error
+		status interfaces.Status
+		ffmpeg string
+		failed string
+	}{
+		{"ready", nil, healthyStatus(), workingFFmpeg, ""},
+		{"device offline", errors.New("connection refused"), healthyStatus(), workingFFmpeg, CheckDevice},
+		{"lineup not loaded", nil, interfaces.Status{}, workingFFmpeg, CheckLineup},
+		{"ffmpeg broken", nil, healthyStatus(), "echo 'Unknown encoder' >&2; exit 1", CheckFFmpeg},
+		{"ffmpeg not ffmpeg", nil, healthyStatus(), "echo hello", CheckFFmpeg},
+		{"tuners in use", nil, saturated, workingFFmpeg, CheckCapacity},
+		{"transcodes in use", nil, transcodes, workingFFmpeg, CheckCapacity},
+		{"draining", nil, draining, workingFFmpeg, CheckCapacity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMonitor(t, tt.device, &fakeReporter{status: tt.status}, tt.ffmpeg)
+			m.probeDevice(context.Background())
+			m.selfTestFFmpeg(context.Background())
+
+			report := m.Readiness()
+			got := failed(report)
+			switch {
+			case tt.failed == "" && (report.Status != StatusOK || len(got) != 0):
+				t.Errorf("Expected every check to pass, got %+v", report)
+			case tt.failed != "" && (report.Status != StatusFail || len(got) != 1 || got[0] != tt.failed):
+				t.Errorf("Expected only %s to fail, got %+v", tt.failed, report)
+			}
+		})
+	}
+}
+
+func TestReadinessBeforeFirstProbe(t *testing.T) {
+	m := newMonitor(t, nil, &fakeReporter{status: healthyStatus()}, workingFFmpeg)
+	if got := failed(m.Readiness()); len(got) != 2 || got[0] != CheckDevice || got[1] != CheckFFmpeg {
+		t.Errorf("Expected the device and FFmpeg to be unchecked, got %v", got)
+	}
+}
+
+func TestStaleDeviceAnswer(t *testing.T) {
+	m := newMonitor(t, nil, &fakeReporter{status: healthyStatus()}, workingFFmpeg)
+	m.probeDevice(context.Background())
+	m.deviceOK = time.Now().Add(-staleProbes*m.opts.Interval - time.Second)
+
+	for _, check := range m.Readiness().Checks {
+		if check.Name == CheckDevice && check.Status != StatusFail {
+			t.Errorf("Expected an old device answer to fail the check, got %+v", check)
+		}
+	}
+}
+
+func TestLiveness(t *testing.T) {
+	reporter := &fakeReporter{status: healthyStatus()}
+	m := newMonitor(t, nil, reporter, workingFFmpeg)
+	m.Start()
+	defer m.Stop()
+
+	if report := m.Liveness(); report.Status != StatusOK {
+		t.Errorf("Expected a responsive monitor to be live, got %+v", report)
+	}
+
+	// A transcoder that never answers is reported as stuck
+	reporter.stuck = make(chan struct{})
+	defer close(reporter.stuck)
+	if got := failed(m.Liveness()); len(got) != 1 || got[0] != CheckTranscoder {
+		t.Errorf("Expected the stuck transcoder to fail liveness, got %v", got)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	m := newMonitor(t, errors.New("no route to host"), &fakeReporter{status: healthyStatus()}, workingFFmpeg)
+	m.Start()
+	defer m.Stop()
+	handler := m.Handler()
+
+	tests := []struct {
+		path string
+		code int
+	}{
+		{"/healthz", http.StatusOK},
+		{"/readyz", http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != tt.code {
+			t.Errorf("Expected %s to return %d, got %d", tt.path, tt.code, rec.Code)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Expected JSON from %s, got %q", tt.path, ct)
+		}
+		var report Report
+		if err := json.NewDecoder(rec.Body).Decode(&report); err != nil || len(report.Checks) == 0 {
+			t.Errorf("Expected a report from %s, got %v", tt.path, err)
+		}
+	}
+}
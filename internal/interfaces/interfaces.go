@@ -5,6 +5,9 @@ import (
 	"context"
 	"io"
 	"net/http"
+	"time"
+
+	"github.com/attaebra/hdhr-proxy/internal/publicurl"
 )
 
 // Client defines the contract for HTTP client implementations.
@@ -30,23 +33,164 @@ type Streamer interface {
 
 // Proxy defines the contract for HDHomeRun proxy implementations.
 type Proxy interface {
-	FetchDeviceID() error
+	FetchDeviceID(ctx context.Context) error
 	DeviceID() string
 	ReverseDeviceID() string
+	TunerCount() int
 	APIHandler() http.Handler
+	Handle(pattern string, handler http.Handler)
 	ProxyRequest(w http.ResponseWriter, r *http.Request)
 	GetHDHRIP() string
+	SetHDHRIP(hdhrIP string)
+	LockDeviceID(id string)
+	LockedDeviceID() string
+	SetPublicURLs(urls *publicurl.Resolver)
+	SetDraining(draining bool)
+	SetDeviceOffline(offline bool)
+}
+
+// ProcessRunner starts external processes such as FFmpeg.
+type ProcessRunner interface {
+	// Start runs path with args. The process is stopped when ctx is canceled.
+	Start(ctx context.Context, path string, args ...string) (TranscodeProcess, error)
+}
+
+// TranscodeProcess is a running process started by a ProcessRunner, together
+// with the children it spawns.
+type TranscodeProcess interface {
+	Pid() int
+	Stdin() io.WriteCloser
+	Stdout() io.Reader
+	Stderr() io.Reader
+	// Wait blocks until the process exits and returns its exit error. Call it
+	// after reading the output; it may be called more than once.
+	Wait() error
+	// ExitCode returns the exit code, or -1 while running or after a signal.
+	ExitCode() int
+	// ExitReason returns why the process exited, or "" while it is running.
+	ExitReason() ExitReason
+	// Stop closes stdin, asks the process to exit, kills it after a grace
+	// period and waits for it. Stopping an exited process only releases its
+	// output.
+	Stop() error
 }
 
+// ExitReason classifies how a process ended.
+type ExitReason string
+
+// Process exit reasons.
+const (
+	ExitGraceful ExitReason = "graceful" // Finished cleanly or stopped within the grace period
+	ExitForced   ExitReason = "forced"   // Killed after the grace period ran out
+	ExitCrashed  ExitReason = "crashed"  // Failed or died without being stopped
+)
+
 // Transcoder defines the contract for transcoding implementations.
 type Transcoder interface {
 	TranscodeChannel(w http.ResponseWriter, r *http.Request, channel string) error
 	DirectStreamChannel(w http.ResponseWriter, r *http.Request, channel string) error
+	ServeChannel(w http.ResponseWriter, r *http.Request, channel string, profile string) error
 	MediaHandler() http.Handler
+	RegisterStatusProvider(provider StatusProvider)
 	StopAllTranscoding()
 	Shutdown()
 }
 
+// SessionInfo describes an active stream for administration.
+type SessionInfo struct {
+	ID           string    `json:"id"`
+	Channel      string    `json:"channel"`
+	ClientIP     string    `json:"client_ip"`
+	Mode         string    `json:"mode"`
+	Class        string    `json:"class,omitempty"`
+	Priority     int       `json:"priority"`
+	StartTime    time.Time `json:"start_time"`
+	Duration     string    `json:"duration"`
+	BytesSent    int64     `json:"bytes_sent"`
+	Bitrate      float64   `json:"bitrate_bps"` // Averaged over the last few seconds
+	FFmpegPID    int       `json:"ffmpeg_pid,omitempty"`
+	FFmpegStderr []string  `json:"ffmpeg_stderr,omitempty"` // Recent FFmpeg output, in session details only
+}
+
+// StreamAdmin gives operators runtime control over active streams.
+type StreamAdmin interface {
+	Sessions() []SessionInfo
+	Session(id string) (SessionInfo, bool)
+	StopSession(id string) bool
+	RefreshLineup(ctx context.Context) error
+	StopAllTranscoding()
+}
+
+// Drainer puts the proxy into drain mode ahead of an exit: new streams are
+// refused while running ones continue until they end or a deadline passes.
+type Drainer interface {
+	StartDrain()
+	DrainStatus() DrainStatus
+}
+
+// DrainStatus describes a drain in progress.
+type DrainStatus struct {
+	Draining      bool       `json:"draining"`
+	Since         *time.Time `json:"since,omitempty"`
+	Deadline      *time.Time `json:"deadline,omitempty"`
+	ActiveStreams int        `json:"active_streams"`
+}
+
+// Status is a snapshot of the proxy shared by the /status page and the dashboard.
+type Status struct {
+	Device         DeviceStatus     `json:"device"`
+	Lineup         []ChannelStatus  `json:"lineup"`
+	Streams        []StreamStatus   `json:"streams"`
+	Sessions       []SessionInfo    `json:"sessions"`
+	Limits         LimitStatus      `json:"limits"`
+	Rejections     map[string]int64 `json:"rejections"`
+	Preemptions    int64            `json:"preemptions"`
+	LastPreemption string           `json:"last_preemption,omitempty"`
+	FFmpegExits    map[string]int64 `json:"ffmpeg_exits"`   // FFmpeg processes ended, by ExitReason
+	Draining       bool             `json:"draining"`       // New streams are refused ahead of an exit
+	DeviceOffline  bool             `json:"device_offline"` // New streams are refused until the device is reached
+}
+
+// DeviceStatus describes the HDHomeRun device behind the proxy.
+type DeviceStatus struct {
+	IP         string `json:"ip"`
+	DeviceID   string `json:"device_id"`
+	TunerCount int    `json:"tuner_count"`
+	FFmpegPath string `json:"ffmpeg_path"`
+}
+
+// ChannelStatus is a lineup entry with its audio format.
+type ChannelStatus struct {
+	GuideNumber string `json:"guide_number"`
+	GuideName   string `json:"guide_name"`
+	AC4         bool   `json:"ac4"`
+	Active      bool   `json:"active"`
+}
+
+// StreamStatus is a tuned channel.
+type StreamStatus struct {
+	Channel     string    `json:"channel"`
+	StartTime   time.Time `json:"start_time"`
+	Transcoding bool      `json:"transcoding"`
+}
+
+// LimitStatus holds the admission limits; zero means unlimited.
+type LimitStatus struct {
+	MaxTranscodes int `json:"max_transcodes"`
+	MaxPerClient  int `json:"max_per_client"`
+	MaxTotal      int `json:"max_total"`
+}
+
+// StatusReporter returns a snapshot of the proxy's state.
+type StatusReporter interface {
+	Status() Status
+}
+
+// StatusProvider contributes a plain-text section to the /status page.
+type StatusProvider interface {
+	WriteStatus(w io.Writer)
+}
+
 // ChannelInfo represents channel information from HDHomeRun.
 type ChannelInfo struct {
 	GuideNumber string `json:"GuideNumber"`
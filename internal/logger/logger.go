@@ -14,6 +14,7 @@ import (
 // ZapLogger implements the Logger interface with Zap.
 type ZapLogger struct {
 	logger *zap.Logger
+	level  zap.AtomicLevel // Shared with child loggers so level changes apply everywhere
 }
 
 // Global logger instance for backwards compatibility.
@@ -69,11 +70,12 @@ func NewZapLogger(level LogLevel) interfaces.Logger {
 
 	var config zap.Config
 	var samplingConfig *zap.SamplingConfig
+	atomicLevel := zap.NewAtomicLevelAt(zapLevelFromLogLevel(level))
 
 	if isDevelopment {
 		// Development config with beautiful colors and human-readable format
 		config = zap.Config{
-			Level:       zap.NewAtomicLevelAt(zapLevelFromLogLevel(level)),
+			Level:       atomicLevel,
 			Development: true,
 			Encoding:    "console",
 			EncoderConfig: zapcore.EncoderConfig{
@@ -96,7 +98,7 @@ func NewZapLogger(level LogLevel) interfaces.Logger {
 	} else {
 		// Production config with JSON structured logging and sampling
 		config = zap.Config{
-			Level:       zap.NewAtomicLevelAt(zapLevelFromLogLevel(level)),
+			Level:       atomicLevel,
 			Development: false,
 			Encoding:    "json",
 			EncoderConfig: zapcore.EncoderConfig{
@@ -139,7 +141,7 @@ func NewZapLogger(level LogLevel) interfaces.Logger {
 	if err != nil {
 		// Fallback to a minimal logger configuration that should never fail
 		fallbackConfig := zap.NewDevelopmentConfig()
-		fallbackConfig.Level = zap.NewAtomicLevelAt(zapLevelFromLogLevel(level))
+		fallbackConfig.Level = atomicLevel
 		logger, fallbackErr := fallbackConfig.Build(zap.AddCallerSkip(1))
 		if fallbackErr != nil {
 			// Last resort: use a no-op logger to prevent crashes
@@ -152,7 +154,7 @@ func NewZapLogger(level LogLevel) interfaces.Logger {
 		}
 	}
 
-	zapLogger := &ZapLogger{logger: logger}
+	zapLogger := &ZapLogger{logger: logger, level: atomicLevel}
 
 	// Set as global logger for backwards compatibility
 	globalLogger = zapLogger
@@ -205,9 +207,15 @@ func (z *ZapLogger) Fatal(msg string, fields ...interfaces.Field) {
 func (z *ZapLogger) With(fields ...interfaces.Field) interfaces.Logger {
 	return &ZapLogger{
 		logger: z.logger.With(fieldsToZap(fields)...),
+		level:  z.level,
 	}
 }
 
+// SetLevel changes the level of this logger and every logger derived from it.
+func (z *ZapLogger) SetLevel(level LogLevel) {
+	z.level.SetLevel(zapLevelFromLogLevel(level))
+}
+
 // Sync flushes any buffered log entries.
 func (z *ZapLogger) Sync() error {
 	return z.logger.Sync()
@@ -225,10 +233,14 @@ func fieldsToZap(fields []interfaces.Field) []zap.Field {
 // Backwards compatibility functions for existing code.
 var currentLevel = LevelInfo
 
-// SetLevel sets the current logging level (backwards compatibility).
+// SetLevel sets the current logging level. The global logger is adjusted in
+// place so loggers already handed out pick up the new level.
 func SetLevel(level LogLevel) {
 	currentLevel = level
-	// Reinitialize global logger with new level
+	if zapLogger, ok := globalLogger.(*ZapLogger); ok {
+		zapLogger.SetLevel(level)
+		return
+	}
 	globalLogger = NewZapLogger(level)
 }
 
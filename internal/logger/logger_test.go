@@ -67,6 +67,21 @@ func TestZapLoggerCreation(t *testing.T) {
 	}
 }
 
+func TestSetLevelAppliesToDerivedLoggers(t *testing.T) {
+	parent := NewZapLogger(LevelInfo).(*ZapLogger)
+	child := parent.With(String("component", "test")).(*ZapLogger)
+
+	SetLevel(LevelDebug)
+	if !child.logger.Core().Enabled(zapLevelFromLogLevel(LevelDebug)) {
+		t.Error("Expected derived logger to follow the global level change")
+	}
+
+	parent.SetLevel(LevelError)
+	if child.logger.Core().Enabled(zapLevelFromLogLevel(LevelWarn)) {
+		t.Error("Expected derived logger to share its parent's level")
+	}
+}
+
 func TestStructuredLogging(_ *testing.T) {
 	// Test that structured logging functions don't panic
 	SetLevel(LevelDebug)
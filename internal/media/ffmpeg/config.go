@@ -5,40 +5,40 @@ import "github.com/attaebra/hdhr-proxy/internal/interfaces"
 // Config contains optimized FFmpeg parameters.
 type Config struct {
 	// Input/output configuration
-	InputSource  string
-	OutputTarget string
+	InputSource  string `yaml:"input_source"`
+	OutputTarget string `yaml:"output_target"`
 
 	// Video settings
-	VideoCodec string
+	VideoCodec string `yaml:"video_codec"`
 
 	// Audio settings
-	AudioCodec      string
-	AudioProfile    string
-	AudioBitrate    string
-	AudioChannels   string
-	AudioSampleRate string
+	AudioCodec      string `yaml:"audio_codec"`
+	AudioProfile    string `yaml:"audio_profile"`
+	AudioBitrate    string `yaml:"audio_bitrate"`
+	AudioChannels   string `yaml:"audio_channels"`
+	AudioSampleRate string `yaml:"audio_sample_rate"`
 
 	// Buffer and streaming settings
-	BufferSize         string
-	MaxRate            string
-	Preset             string
-	Tune               string
-	ThreadQueueSize    string
-	MaxMuxingQueueSize string
-	Threads            string
-	Format             string
+	BufferSize         string `yaml:"buffer_size"`
+	MaxRate            string `yaml:"max_rate"`
+	Preset             string `yaml:"preset"`
+	Tune               string `yaml:"tune"`
+	ThreadQueueSize    string `yaml:"thread_queue_size"`
+	MaxMuxingQueueSize string `yaml:"max_muxing_queue_size"`
+	Threads            string `yaml:"threads"`
+	Format             string `yaml:"format"`
 
 	// Input analysis settings (anti-stuttering)
-	AnalyzeDuration string
-	ProbeSize       string
-	FPSProbeSize    string
-	FPSMode         string
+	AnalyzeDuration string `yaml:"analyze_duration"`
+	ProbeSize       string `yaml:"probe_size"`
+	FPSProbeSize    string `yaml:"fps_probe_size"`
+	FPSMode         string `yaml:"fps_mode"`
 
 	// Error resilience settings
-	ErrorDetection   string
-	SkipFrame        string
-	StrictLevel      string
-	ReconnectOptions bool
+	ErrorDetection   string `yaml:"error_detection"`
+	SkipFrame        string `yaml:"skip_frame"`
+	StrictLevel      string `yaml:"strict_level"`
+	ReconnectOptions bool   `yaml:"reconnect_options"`
 }
 
 // Ensure Config implements the Config interface.
@@ -0,0 +1,255 @@
+// Package process runs external programs such as FFmpeg in their own process
+// group, so stopping one also ends any children it spawned, and reaps them
+// reliably.
+package process
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/attaebra/hdhr-proxy/internal/interfaces"
+)
+
+// DefaultGrace is how long a stopped process has to exit before it is killed.
+const DefaultGrace = 5 * time.Second
+
+// Runner starts processes that are stopped in stages: stdin is closed and
+// the process is interrupted, then terminated halfway through the grace
+// period, then killed once it runs out.
+type Runner struct {
+	grace time.Duration
+}
+
+// Ensure Runner implements the ProcessRunner interface.
+var _ interfaces.ProcessRunner = (*Runner)(nil)
+
+// NewRunner creates a runner whose processes get grace to exit before they
+// are killed.
+func NewRunner(grace time.Duration) *Runner {
+	return &Runner{grace: grace}
+}
+
+// Process is a running program and its process group.
+type Process struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *os.File
+	stderr *os.File
+	grace  time.Duration
+
+	done     chan struct{} // Closed once the process has been reaped
+	mutex    sync.Mutex    // Guards reaped, so the group is never signaled after it
+	reaped   bool          // The process ID, and with it the group ID, may be reused
+	waitErr  error
+	reason   interfaces.ExitReason
+	stopping atomic.Bool // Stop was called before the process exited
+	killed   atomic.Bool // The grace period ran out
+	release  sync.Once
+}
+
+// Ensure Process implements the TranscodeProcess interface.
+var _ interfaces.TranscodeProcess = (*Process)(nil)
+
+// Start runs path with args in a new process group. The process is stopped
+// when ctx is canceled.
+func (r *Runner) Start(ctx context.Context, path string, args ...string) (interfaces.TranscodeProcess, error) {
+	cmd := exec.Command(path, args...)
+	setProcessGroup(cmd)
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stdin pipe: %w", err)
+	}
+
+	// Output goes through pipes owned here rather than by exec.Cmd, so reaping
+	// the process does not discard output that has not been read yet
+	stdout, stdoutWriter, err := os.Pipe()
+	if err != nil {
+		stdin.Close()
+		return nil, fmt.Errorf("failed to get stdout pipe: %w", err)
+	}
+	stderr, stderrWriter, err := os.Pipe()
+	if err != nil {
+		stdin.Close()
+		stdout.Close()
+		stdoutWriter.Close()
+		return nil, fmt.Errorf("failed to get stderr pipe: %w", err)
+	}
+	cmd.Stdout = stdoutWriter
+	cmd.Stderr = stderrWriter
+
+	startErr := cmd.Start()
+	stdoutWriter.Close()
+	stderrWriter.Close()
+	if startErr != nil {
+		stdin.Close()
+		stdout.Close()
+		stderr.Close()
+		return nil, startErr
+	}
+
+	p := &Process{
+		cmd:    cmd,
+		stdin:  stdin,
+		stdout: stdout,
+		stderr: stderr,
+		grace:  r.grace,
+		done:   make(chan struct{}),
+	}
+	go func() {
+		p.waitErr = p.reap()
+		p.reason = p.classify()
+		close(p.done)
+	}()
+	go func() {
+		select {
+		case <-ctx.Done():
+			p.Stop()
+		case <-p.done:
+		}
+	}()
+	return p, nil
+}
+
+// Pid returns the process ID.
+func (p *Process) Pid() int {
+	return p.cmd.Process.Pid
+}
+
+// Stdin returns the process's standard input.
+func (p *Process) Stdin() io.WriteCloser {
+	return p.stdin
+}
+
+// Stdout returns the process's standard output.
+func (p *Process) Stdout() io.Reader {
+	return p.stdout
+}
+
+// Stderr returns the process's standard error.
+func (p *Process) Stderr() io.Reader {
+	return p.stderr
+}
+
+// Wait blocks until the process exits and returns its exit error.
+func (p *Process) Wait() error {
+	<-p.done
+	p.releaseOutput()
+	return p.waitErr
+}
+
+// ExitCode returns the exit code, or -1 while running or after a signal.
+func (p *Process) ExitCode() int {
+	select {
+	case <-p.done:
+		return p.cmd.ProcessState.ExitCode()
+	default:
+		return -1
+	}
+}
+
+// ExitReason returns why the process exited, or "" while it is running.
+func (p *Process) ExitReason() interfaces.ExitReason {
+	select {
+	case <-p.done:
+		return p.reason
+	default:
+		return ""
+	}
+}
+
+// classify works out the exit reason once the process has been reaped.
+func (p *Process) classify() interfaces.ExitReason {
+	switch {
+	case p.killed.Load():
+		return interfaces.ExitForced
+	case p.stopping.Load(), p.waitErr == nil:
+		return interfaces.ExitGraceful
+	default:
+		return interfaces.ExitCrashed
+	}
+}
+
+// Stop closes stdin and interrupts the process group, so FFmpeg can flush its
+// output, terminates the group halfway through the grace period, kills it
+// when the process is still running at the end, and waits for the process to
+// be reaped.
+func (p *Process) Stop() error {
+	var err error
+	select {
+	case <-p.done:
+	default:
+		p.stopping.Store(true)
+		p.stdin.Close()
+		err = p.signal(interrupt)
+		if !p.exitsWithin(p.grace / 2) {
+			err = p.signal(terminate)
+			if !p.exitsWithin(p.grace - p.grace/2) {
+				p.killed.Store(true)
+				err = p.signal(kill)
+				<-p.done
+			}
+		}
+	}
+
+	p.releaseOutput()
+	return err
+}
+
+// reap waits for the process to exit and reaps it. Where the exit can be
+// observed first, children outliving the process are killed while the group
+// ID still belongs to it; they would otherwise keep the output pipes open.
+func (p *Process) reap() error {
+	if !waitExited(p.cmd.Process) {
+		err := p.cmd.Wait()
+		p.mutex.Lock()
+		p.reaped = true
+		p.mutex.Unlock()
+		return err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	kill(p.cmd.Process)
+	err := p.cmd.Wait()
+	p.reaped = true
+	return err
+}
+
+// signal sends a signal to the process group unless the process has been
+// reaped, after which the group ID may belong to an unrelated process.
+func (p *Process) signal(send func(*os.Process) error) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.reaped {
+		return nil
+	}
+	return send(p.cmd.Process)
+}
+
+// exitsWithin reports whether the process exits within d.
+func (p *Process) exitsWithin(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-p.done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// releaseOutput closes the read ends of the output pipes once the output is
+// no longer needed.
+func (p *Process) releaseOutput() {
+	p.release.Do(func() {
+		p.stdout.Close()
+		p.stderr.Close()
+	})
+}
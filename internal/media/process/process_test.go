@@ -0,0 +1,193 @@
+//go:build !windows
+
+package process
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/attaebra/hdhr-proxy/internal/interfaces"
+)
+
+// alive reports whether pid is a running process rather than gone or a zombie
+// waiting for a parent that no longer reaps it.
+func alive(pid int) bool {
+	if err := syscall.Kill(pid, 0); errors.Is(err, syscall.ESRCH) {
+		return false
+	}
+	stat, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	return err != nil || !strings.Contains(string(stat), ") Z ")
+}
+
+func TestStartPipesOutput(t *testing.T) {
+	proc, err := NewRunner(time.Second).Start(context.Background(), "sh", "-c", "cat; echo oops >&2; exit 3")
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+
+	proc.Stdin().Write([]byte("transport stream"))
+	proc.Stdin().Close()
+	stdout, _ := io.ReadAll(proc.Stdout())
+	stderr, _ := io.ReadAll(proc.Stderr())
+	if err := proc.Wait(); err == nil {
+		t.Error("Expected a non-zero exit to be reported")
+	}
+
+	if string(stdout) != "transport stream" || string(stderr) != "oops\n" {
+		t.Errorf("Unexpected output %q and %q", stdout, stderr)
+	}
+	if proc.ExitCode() != 3 {
+		t.Errorf("Expected exit code 3, got %d", proc.ExitCode())
+	}
+	if proc.ExitReason() != interfaces.ExitCrashed {
+		t.Errorf("Expected a failure without Stop to be a crash, got %q", proc.ExitReason())
+	}
+}
+
+func TestStopEscalatesToKill(t *testing.T) {
+	grace := 200 * time.Millisecond
+	proc, err := NewRunner(grace).Start(context.Background(), "sh", "-c", `trap "" INT TERM; echo ready; sleep 60`)
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	bufio.NewReader(proc.Stdout()).ReadString('\n')
+	if proc.ExitCode() != -1 {
+		t.Errorf("Expected no exit code while running, got %d", proc.ExitCode())
+	}
+
+	began := time.Now()
+	if err := proc.Stop(); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+	if elapsed := time.Since(began); elapsed < grace || elapsed > 5*time.Second {
+		t.Errorf("Expected the process to be killed after the grace period, took %v", elapsed)
+	}
+	if alive(proc.Pid()) {
+		t.Errorf("Expected process %d to be gone", proc.Pid())
+	}
+	if proc.ExitReason() != interfaces.ExitForced {
+		t.Errorf("Expected a forced exit, got %q", proc.ExitReason())
+	}
+}
+
+func TestStopClosesStdin(t *testing.T) {
+	// The process ignores signals but exits once its input ends
+	proc, err := NewRunner(5*time.Second).Start(context.Background(), "sh", "-c", `trap "" INT TERM; echo ready; cat >/dev/null`)
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	bufio.NewReader(proc.Stdout()).ReadString('\n')
+
+	began := time.Now()
+	proc.Stop()
+	if elapsed := time.Since(began); elapsed > 2*time.Second {
+		t.Errorf("Expected closing stdin to end the process, took %v", elapsed)
+	}
+	if proc.ExitReason() != interfaces.ExitGraceful || proc.ExitCode() != 0 {
+		t.Errorf("Expected a graceful exit, got %q with code %d", proc.ExitReason(), proc.ExitCode())
+	}
+}
+
+func TestStopEndsChildren(t *testing.T) {
+	proc, err := NewRunner(time.Second).Start(context.Background(), "sh", "-c", "sleep 60 & echo $!; wait")
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	line, _ := bufio.NewReader(proc.Stdout()).ReadString('\n')
+	child, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil {
+		t.Fatalf("Expected the child's PID, got %q", line)
+	}
+
+	proc.Stop()
+	deadline := time.Now().Add(5 * time.Second)
+	for alive(child) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected child process %d to be stopped with its parent", child)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestExitEndsChildren(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("exits are only observed before reaping on Linux")
+	}
+	proc, err := NewRunner(time.Second).Start(context.Background(), "sh", "-c", "sleep 60 & echo $!")
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	line, _ := bufio.NewReader(proc.Stdout()).ReadString('\n')
+	child, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil {
+		t.Fatalf("Expected the child's PID, got %q", line)
+	}
+
+	proc.Wait()
+	deadline := time.Now().Add(5 * time.Second)
+	for alive(child) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected child process %d to be stopped when its parent exited", child)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestNoSignalAfterReap(t *testing.T) {
+	started, err := NewRunner(time.Second).Start(context.Background(), "true")
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	proc := started.(*Process)
+	proc.Wait()
+
+	signaled := false
+	proc.signal(func(*os.Process) error {
+		signaled = true
+		return nil
+	})
+	if signaled {
+		t.Error("Expected a reaped process group not to be signaled, as its ID may be reused")
+	}
+	if err := proc.Stop(); err != nil {
+		t.Errorf("Expected stopping an exited process to succeed, got %v", err)
+	}
+}
+
+func TestCancelStops(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	proc, err := NewRunner(time.Second).Start(ctx, "sleep", "60")
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+
+	cancel()
+	waited := make(chan error, 1)
+	go func() { waited <- proc.Wait() }()
+	select {
+	case err := <-waited:
+		if err == nil {
+			t.Error("Expected the stopped process to report its signal")
+		}
+		if proc.ExitReason() != interfaces.ExitGraceful {
+			t.Errorf("Expected an interrupted process to exit gracefully, got %q", proc.ExitReason())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected canceling the context to stop the process")
+	}
+}
+
+func TestStartMissingExecutable(t *testing.T) {
+	if _, err := NewRunner(time.Second).Start(context.Background(), "/nonexistent/ffmpeg"); err == nil {
+		t.Error("Expected an error starting a missing executable")
+	}
+}
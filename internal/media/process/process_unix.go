@@ -0,0 +1,39 @@
+//go:build !windows
+
+package process
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup starts the command as the leader of a new process group.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// interrupt asks every process in the group to finish up and exit, as if
+// Ctrl-C was pressed.
+func interrupt(p *os.Process) error {
+	return signalGroup(p, syscall.SIGINT)
+}
+
+// terminate asks every process in the group to exit.
+func terminate(p *os.Process) error {
+	return signalGroup(p, syscall.SIGTERM)
+}
+
+// kill ends every process in the group.
+func kill(p *os.Process) error {
+	return signalGroup(p, syscall.SIGKILL)
+}
+
+func signalGroup(p *os.Process, sig syscall.Signal) error {
+	err := syscall.Kill(-p.Pid, sig)
+	if errors.Is(err, syscall.ESRCH) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,32 @@
+//go:build windows
+
+package process
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// setProcessGroup is a no-op; Windows has no process groups to signal.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// interrupt is a no-op; closing stdin is the only way Windows can ask the
+// process to exit.
+func interrupt(p *os.Process) error {
+	return nil
+}
+
+// terminate is a no-op, like interrupt.
+func terminate(p *os.Process) error {
+	return nil
+}
+
+// kill ends the process.
+func kill(p *os.Process) error {
+	err := p.Kill()
+	if errors.Is(err, os.ErrProcessDone) {
+		return nil
+	}
+	return err
+}
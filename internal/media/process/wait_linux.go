@@ -0,0 +1,25 @@
+package process
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// pPID selects a single process by ID for waitid.
+const pPID = 1
+
+// waitExited blocks until p exits without reaping it, so its process group
+// can still be signaled safely: the ID of an unreaped process is not reused.
+// It reports whether the exit was observed this way.
+func waitExited(p *os.Process) bool {
+	var info [128]byte // siginfo_t, not inspected
+	for {
+		_, _, errno := syscall.Syscall6(syscall.SYS_WAITID, pPID, uintptr(p.Pid),
+			uintptr(unsafe.Pointer(&info)), syscall.WEXITED|syscall.WNOWAIT, 0, 0)
+		if !errors.Is(errno, syscall.EINTR) {
+			return errno == 0
+		}
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package process
+
+import "os"
+
+// waitExited cannot wait without reaping on this platform, so the process
+// group is not signaled once the process has exited.
+func waitExited(p *os.Process) bool {
+	return false
+}
@@ -0,0 +1,81 @@
+package session
+
+import (
+	"net"
+
+	"github.com/attaebra/hdhr-proxy/internal/access"
+	"github.com/attaebra/hdhr-proxy/internal/config"
+)
+
+// DefaultClass is the class name given to clients that match no priority class.
+const DefaultClass = "default"
+
+// Classifier maps clients to priority classes by IP address or API token.
+type Classifier struct {
+	classes []priorityClass
+}
+
+// priorityClass is a parsed config.PriorityClass.
+type priorityClass struct {
+	name     string
+	priority int
+	networks []*net.IPNet
+	tokens   map[string]bool
+}
+
+// NewClassifier builds a classifier from the configured priority classes.
+// Invalid client entries are skipped; config.Validate reports them.
+func NewClassifier(classes []config.PriorityClass) *Classifier {
+	c := &Classifier{}
+	for _, class := range classes {
+		pc := priorityClass{
+			name:     class.Name,
+			priority: class.Priority,
+			tokens:   make(map[string]bool, len(class.Tokens)),
+		}
+		for _, client := range class.Clients {
+			if network := access.ParseNetwork(client); network != nil {
+				pc.networks = append(pc.networks, network)
+			}
+		}
+		for _, token := range class.Tokens {
+			pc.tokens[token] = true
+		}
+		c.classes = append(c.classes, pc)
+	}
+	return c
+}
+
+// Classify returns the class name and priority for a client. When several classes
+// match, the one with the highest priority wins. Unmatched clients get DefaultClass
+// with priority 0.
+func (c *Classifier) Classify(clientIP, token string) (string, int) {
+	name, priority, matched := DefaultClass, 0, false
+	ip := net.ParseIP(clientIP)
+
+	for _, class := range c.classes {
+		if !class.matches(ip, token) {
+			continue
+		}
+		if !matched || class.priority > priority {
+			name, priority, matched = class.name, class.priority, true
+		}
+	}
+	return name, priority
+}
+
+// matches reports whether the client IP or token belongs to this class.
+func (pc *priorityClass) matches(ip net.IP, token string) bool {
+	if token != "" && pc.tokens[token] {
+		return true
+	}
+	if ip == nil {
+		return false
+	}
+	for _, network := range pc.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,347 @@
+// Package session tracks active media sessions and enforces admission limits.
+package session
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Mode describes how a session's stream is delivered to the client.
+type Mode string
+
+// Stream delivery modes.
+const (
+	ModeDirect    Mode = "direct"
+	ModeTranscode Mode = "transcode"
+)
+
+// Limits holds the admission limits. A value of zero or less means unlimited.
+type Limits struct {
+	MaxTranscodes int // Maximum concurrent FFmpeg transcodes
+	MaxPerClient  int // Maximum concurrent streams per client IP
+	MaxTotal      int // Maximum concurrent streams overall
+}
+
+// Reason identifies which limit caused a rejection.
+type Reason string
+
+// Rejection reasons.
+const (
+	ReasonTranscodeLimit Reason = "transcode_limit"
+	ReasonClientLimit    Reason = "client_limit"
+	ReasonTotalLimit     Reason = "total_limit"
+	ReasonDraining       Reason = "draining"       // The proxy is draining before an exit
+	ReasonDeviceOffline  Reason = "device_offline" // The device has not been reached yet
+)
+
+// RejectError is returned by Admit when a limit would be exceeded.
+type RejectError struct {
+	Reason Reason
+	Limit  int
+}
+
+// Error implements the error interface.
+func (e *RejectError) Error() string {
+	switch e.Reason {
+	case ReasonDraining:
+		return "stream rejected: proxy is draining"
+	case ReasonDeviceOffline:
+		return "stream rejected: HDHomeRun device is not available yet"
+	}
+	return fmt.Sprintf("stream rejected: %s reached (limit %d)", e.Reason, e.Limit)
+}
+
+// Request describes a stream asking for admission.
+type Request struct {
+	Channel  string
+	ClientIP string
+	Mode     Mode
+	Class    string // Priority class name
+	Priority int
+}
+
+// Session represents a single client stream.
+type Session struct {
+	ID        string
+	Channel   string
+	ClientIP  string
+	Mode      Mode
+	Class     string
+	Priority  int
+	StartTime time.Time
+
+	done chan struct{} // Closed when the session is released
+}
+
+// Done returns a channel that is closed once the session has been released.
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+// contextKey is the type for session values stored in a context.
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying the session.
+func NewContext(ctx context.Context, s *Session) context.Context {
+	return context.WithValue(ctx, contextKey{}, s)
+}
+
+// FromContext returns the session stored in ctx, if any.
+func FromContext(ctx context.Context) (*Session, bool) {
+	s, ok := ctx.Value(contextKey{}).(*Session)
+	return s, ok
+}
+
+// Registry tracks active sessions and applies admission limits.
+type Registry struct {
+	mutex         sync.Mutex
+	limits        Limits
+	sessions      map[string]*Session
+	evicted       map[string]*Session // Preempted sessions whose streams are still ending
+	rejections    map[Reason]int64
+	nextID        uint64
+	draining      bool
+	deviceOffline bool
+
+	preemptions    int64
+	lastPreemption string
+}
+
+// NewRegistry creates a session registry with the given limits.
+func NewRegistry(limits Limits) *Registry {
+	return &Registry{
+		limits:     limits,
+		sessions:   make(map[string]*Session),
+		evicted:    make(map[string]*Session),
+		rejections: make(map[Reason]int64),
+	}
+}
+
+// SetLimits replaces the admission limits. Active sessions are not affected.
+func (r *Registry) SetLimits(limits Limits) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.limits = limits
+}
+
+// Limits returns the current admission limits.
+func (r *Registry) Limits() Limits {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.limits
+}
+
+// SetDraining makes Admit refuse every new session while draining is true.
+// Active sessions are not affected.
+func (r *Registry) SetDraining(draining bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.draining = draining
+}
+
+// Draining reports whether new sessions are refused for a drain.
+func (r *Registry) Draining() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.draining
+}
+
+// SetDeviceOffline makes Admit refuse every new session while offline is
+// true, e.g. until the device and its lineup have been reached at startup.
+func (r *Registry) SetDeviceOffline(offline bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.deviceOffline = offline
+}
+
+// DeviceOffline reports whether new sessions are refused because the device
+// is not available.
+func (r *Registry) DeviceOffline() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.deviceOffline
+}
+
+// Admit registers a new session if no limit would be exceeded.
+// On rejection it returns a *RejectError and records the rejection.
+func (r *Registry) Admit(req Request) (*Session, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if err := r.checkLimits(req.ClientIP, req.Mode); err != nil {
+		r.rejections[err.Reason]++
+		return nil, err
+	}
+	return r.add(req), nil
+}
+
+// AdmitPreempting admits a request like Admit, but when the total stream limit
+// is reached it evicts the lowest priority session below the request's
+// priority and hands its slot to the new session in the same step, so no
+// other request can take the slot in between. The evicted victim no longer
+// counts against the limits; the caller must end its stream, and releasing it
+// closes its Done channel. victim is nil when no session was evicted.
+func (r *Registry) AdmitPreempting(req Request) (s *Session, victim *Session, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	rejectErr := r.checkLimits(req.ClientIP, req.Mode)
+	if rejectErr != nil && rejectErr.Reason == ReasonTotalLimit {
+		if victim = r.preemptionCandidate(req.Priority); victim != nil {
+			delete(r.sessions, victim.ID)
+			if rejectErr = r.checkLimits(req.ClientIP, req.Mode); rejectErr != nil {
+				// Another limit refuses the request even with the slot free
+				r.sessions[victim.ID] = victim
+				victim = nil
+			}
+		}
+	}
+	if rejectErr != nil {
+		r.rejections[rejectErr.Reason]++
+		return nil, nil, rejectErr
+	}
+
+	if victim != nil {
+		r.evicted[victim.ID] = victim
+	}
+	return r.add(req), victim, nil
+}
+
+// add registers a session for an admitted request. The caller must hold the mutex.
+func (r *Registry) add(req Request) *Session {
+	r.nextID++
+	s := &Session{
+		ID:        strconv.FormatUint(r.nextID, 10),
+		Channel:   req.Channel,
+		ClientIP:  req.ClientIP,
+		Mode:      req.Mode,
+		Class:     req.Class,
+		Priority:  req.Priority,
+		StartTime: time.Now(),
+		done:      make(chan struct{}),
+	}
+	r.sessions[s.ID] = s
+	return s
+}
+
+// checkLimits reports which limit, if any, a new session would exceed.
+// The caller must hold the mutex.
+func (r *Registry) checkLimits(clientIP string, mode Mode) *RejectError {
+	if r.draining {
+		return &RejectError{Reason: ReasonDraining}
+	}
+	if r.deviceOffline {
+		return &RejectError{Reason: ReasonDeviceOffline}
+	}
+
+	total, transcodes, perClient := 0, 0, 0
+	for _, s := range r.sessions {
+		total++
+		if s.Mode == ModeTranscode {
+			transcodes++
+		}
+		if s.ClientIP == clientIP {
+			perClient++
+		}
+	}
+
+	switch {
+	case r.limits.MaxTotal > 0 && total >= r.limits.MaxTotal:
+		return &RejectError{Reason: ReasonTotalLimit, Limit: r.limits.MaxTotal}
+	case r.limits.MaxPerClient > 0 && perClient >= r.limits.MaxPerClient:
+		return &RejectError{Reason: ReasonClientLimit, Limit: r.limits.MaxPerClient}
+	case mode == ModeTranscode && r.limits.MaxTranscodes > 0 && transcodes >= r.limits.MaxTranscodes:
+		return &RejectError{Reason: ReasonTranscodeLimit, Limit: r.limits.MaxTranscodes}
+	}
+	return nil
+}
+
+// Release removes a session from the registry. Releasing twice is a no-op.
+func (r *Registry) Release(s *Session) {
+	if s == nil {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, exists := r.sessions[s.ID]; exists {
+		delete(r.sessions, s.ID)
+		close(s.done)
+	} else if _, exists := r.evicted[s.ID]; exists {
+		delete(r.evicted, s.ID)
+		close(s.done)
+	}
+}
+
+// PreemptionCandidate returns the lowest priority session whose priority is
+// strictly below the given priority, or nil if there is none. Among sessions
+// with equal priority the most recently started one is chosen.
+func (r *Registry) PreemptionCandidate(priority int) *Session {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.preemptionCandidate(priority)
+}
+
+// preemptionCandidate implements PreemptionCandidate. The caller must hold the mutex.
+func (r *Registry) preemptionCandidate(priority int) *Session {
+	var victim *Session
+	for _, s := range r.sessions {
+		if s.Priority >= priority {
+			continue
+		}
+		if victim == nil || s.Priority < victim.Priority ||
+			(s.Priority == victim.Priority && s.StartTime.After(victim.StartTime)) {
+			victim = s
+		}
+	}
+	return victim
+}
+
+// RecordPreemption counts a preemption and remembers a description of it for status output.
+func (r *Registry) RecordPreemption(victim *Session, req Request) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.preemptions++
+	r.lastPreemption = fmt.Sprintf("%s: channel %s (%s, priority %d) preempted by %s (%s, priority %d) for channel %s",
+		time.Now().Format(time.RFC3339), victim.Channel, victim.ClientIP, victim.Priority,
+		req.ClientIP, req.Class, req.Priority, req.Channel)
+}
+
+// Preemptions returns the number of preemptions and a description of the latest one.
+func (r *Registry) Preemptions() (int64, string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.preemptions, r.lastPreemption
+}
+
+// List returns a snapshot of the active sessions ordered by start time.
+func (r *Registry) List() []Session {
+	r.mutex.Lock()
+	list := make([]Session, 0, len(r.sessions))
+	for _, s := range r.sessions {
+		list = append(list, *s)
+	}
+	r.mutex.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].StartTime.Before(list[j].StartTime)
+	})
+	return list
+}
+
+// Rejections returns a copy of the rejection counters by reason.
+func (r *Registry) Rejections() map[Reason]int64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	counts := make(map[Reason]int64, len(r.rejections))
+	for reason, n := range r.rejections {
+		counts[reason] = n
+	}
+	return counts
+}
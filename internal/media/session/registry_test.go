@@ -0,0 +1,250 @@
+package session
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/attaebra/hdhr-proxy/internal/config"
+)
+
+func TestAdmitUnlimited(t *testing.T) {
+	registry := NewRegistry(Limits{})
+
+	for i := 0; i < 10; i++ {
+		if _, err := registry.Admit(Request{Channel: "5.1", ClientIP: "10.0.0.1", Mode: ModeTranscode}); err != nil {
+			t.Fatalf("Expected admission with no limits, got %v", err)
+		}
+	}
+
+	if got := len(registry.List()); got != 10 {
+		t.Errorf("Expected 10 sessions, got %d", got)
+	}
+}
+
+func TestAdmitLimits(t *testing.T) {
+	testCases := []struct {
+		name     string
+		limits   Limits
+		existing []Session
+		clientIP string
+		mode     Mode
+		expected Reason
+	}{
+		{
+			name:     "total limit",
+			limits:   Limits{MaxTotal: 2},
+			existing: []Session{{ClientIP: "a", Mode: ModeDirect}, {ClientIP: "b", Mode: ModeDirect}},
+			clientIP: "c",
+			mode:     ModeDirect,
+			expected: ReasonTotalLimit,
+		},
+		{
+			name:     "per client limit",
+			limits:   Limits{MaxPerClient: 1},
+			existing: []Session{{ClientIP: "a", Mode: ModeDirect}},
+			clientIP: "a",
+			mode:     ModeDirect,
+			expected: ReasonClientLimit,
+		},
+		{
+			name:     "transcode limit",
+			limits:   Limits{MaxTranscodes: 1},
+			existing: []Session{{ClientIP: "a", Mode: ModeTranscode}},
+			clientIP: "b",
+			mode:     ModeTranscode,
+			expected: ReasonTranscodeLimit,
+		},
+		{
+			name:     "transcode limit ignores direct streams",
+			limits:   Limits{MaxTranscodes: 1},
+			existing: []Session{{ClientIP: "a", Mode: ModeTranscode}},
+			clientIP: "b",
+			mode:     ModeDirect,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			registry := NewRegistry(tc.limits)
+			for _, s := range tc.existing {
+				if _, err := registry.Admit(Request{Channel: "1.1", ClientIP: s.ClientIP, Mode: s.Mode}); err != nil {
+					t.Fatalf("Unexpected rejection while seeding: %v", err)
+				}
+			}
+
+			_, err := registry.Admit(Request{Channel: "2.1", ClientIP: tc.clientIP, Mode: tc.mode})
+			if tc.expected == "" {
+				if err != nil {
+					t.Fatalf("Expected admission, got %v", err)
+				}
+				return
+			}
+
+			var rejectErr *RejectError
+			if !errors.As(err, &rejectErr) {
+				t.Fatalf("Expected RejectError, got %v", err)
+			}
+			if rejectErr.Reason != tc.expected {
+				t.Errorf("Expected reason %s, got %s", tc.expected, rejectErr.Reason)
+			}
+			if registry.Rejections()[tc.expected] != 1 {
+				t.Errorf("Expected rejection to be counted")
+			}
+		})
+	}
+}
+
+func TestAdmitWhileDraining(t *testing.T) {
+	registry := NewRegistry(Limits{})
+	active, err := registry.Admit(Request{Channel: "1.1", ClientIP: "a", Mode: ModeDirect})
+	if err != nil {
+		t.Fatalf("Unexpected rejection: %v", err)
+	}
+
+	registry.SetDraining(true)
+	_, err = registry.Admit(Request{Channel: "2.1", ClientIP: "b", Mode: ModeDirect})
+	var rejectErr *RejectError
+	if !errors.As(err, &rejectErr) || rejectErr.Reason != ReasonDraining {
+		t.Fatalf("Expected a draining rejection, got %v", err)
+	}
+	if registry.Rejections()[ReasonDraining] != 1 {
+		t.Error("Expected the rejection to be counted")
+	}
+	if len(registry.List()) != 1 || registry.List()[0].ID != active.ID {
+		t.Error("Expected the active session to keep running")
+	}
+
+	registry.SetDraining(false)
+	if _, err := registry.Admit(Request{Channel: "2.1", ClientIP: "b", Mode: ModeDirect}); err != nil {
+		t.Errorf("Expected admission after draining ends, got %v", err)
+	}
+}
+
+func TestRelease(t *testing.T) {
+	registry := NewRegistry(Limits{MaxTotal: 1})
+
+	s, err := registry.Admit(Request{Channel: "5.1", ClientIP: "10.0.0.1", Mode: ModeDirect})
+	if err != nil {
+		t.Fatalf("Expected admission, got %v", err)
+	}
+
+	if _, err := registry.Admit(Request{Channel: "5.1", ClientIP: "10.0.0.2", Mode: ModeDirect}); err == nil {
+		t.Fatal("Expected rejection while the only slot is in use")
+	}
+
+	registry.Release(s)
+	registry.Release(s) // Releasing twice must be harmless
+
+	select {
+	case <-s.Done():
+	default:
+		t.Error("Expected Done channel to be closed after release")
+	}
+
+	if _, err := registry.Admit(Request{Channel: "5.1", ClientIP: "10.0.0.2", Mode: ModeDirect}); err != nil {
+		t.Errorf("Expected admission after release, got %v", err)
+	}
+}
+
+func TestPreemptionCandidate(t *testing.T) {
+	registry := NewRegistry(Limits{})
+
+	low, _ := registry.Admit(Request{Channel: "1.1", ClientIP: "a", Priority: 10})
+	newerLow, _ := registry.Admit(Request{Channel: "2.1", ClientIP: "b", Priority: 10})
+	newerLow.StartTime = low.StartTime.Add(time.Second)
+	registry.Admit(Request{Channel: "3.1", ClientIP: "c", Priority: 50})
+
+	if victim := registry.PreemptionCandidate(10); victim != nil {
+		t.Errorf("Expected no candidate for equal priority, got channel %s", victim.Channel)
+	}
+
+	victim := registry.PreemptionCandidate(100)
+	if victim == nil || victim.ID != newerLow.ID {
+		t.Fatalf("Expected the most recent lowest priority session to be chosen, got %+v", victim)
+	}
+
+	registry.RecordPreemption(victim, Request{Channel: "4.1", ClientIP: "d", Class: "dvr", Priority: 100})
+	count, last := registry.Preemptions()
+	if count != 1 || last == "" {
+		t.Errorf("Expected one recorded preemption, got %d (%q)", count, last)
+	}
+}
+
+func TestAdmitPreempting(t *testing.T) {
+	registry := NewRegistry(Limits{MaxTotal: 1})
+
+	low, _ := registry.Admit(Request{Channel: "1.1", ClientIP: "a", Priority: 10})
+	if _, victim, err := registry.AdmitPreempting(Request{Channel: "2.1", ClientIP: "b", Priority: 10}); err == nil || victim != nil {
+		t.Fatalf("Expected equal priority to be refused without a victim, got %v, %v", victim, err)
+	}
+
+	high, victim, err := registry.AdmitPreempting(Request{Channel: "2.1", ClientIP: "b", Priority: 50})
+	if err != nil || victim == nil || victim.ID != low.ID {
+		t.Fatalf("Expected the low priority session to be evicted, got %v, %v", victim, err)
+	}
+
+	// The slot went to the new session, so nothing else is admitted while
+	// the victim's stream is still ending
+	if _, err := registry.Admit(Request{Channel: "3.1", ClientIP: "c"}); err == nil {
+		t.Error("Expected the freed slot to be taken by the preempting session")
+	}
+	if list := registry.List(); len(list) != 1 || list[0].ID != high.ID {
+		t.Errorf("Expected only the preempting session to be listed, got %+v", list)
+	}
+
+	registry.Release(victim)
+	select {
+	case <-victim.Done():
+	default:
+		t.Error("Expected releasing the victim to close its Done channel")
+	}
+}
+
+func TestClassifier(t *testing.T) {
+	classifier := NewClassifier([]config.PriorityClass{
+		{Name: "dvr", Priority: 100, Clients: []string{"192.168.1.10"}, Tokens: []string{"recorder"}},
+		{Name: "tablet", Priority: 10, Clients: []string{"192.168.1.0/24"}},
+	})
+
+	testCases := []struct {
+		clientIP string
+		token    string
+		class    string
+		priority int
+	}{
+		{"192.168.1.10", "", "dvr", 100}, // Matches both, highest priority wins
+		{"192.168.1.50", "", "tablet", 10},
+		{"10.0.0.5", "recorder", "dvr", 100},
+		{"10.0.0.5", "", DefaultClass, 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.clientIP+tc.token, func(t *testing.T) {
+			class, priority := classifier.Classify(tc.clientIP, tc.token)
+			if class != tc.class || priority != tc.priority {
+				t.Errorf("Classify(%s, %q) = %s/%d; expected %s/%d",
+					tc.clientIP, tc.token, class, priority, tc.class, tc.priority)
+			}
+		})
+	}
+}
+
+func TestAdmitWhileDeviceOffline(t *testing.T) {
+	registry := NewRegistry(Limits{})
+	registry.SetDeviceOffline(true)
+
+	_, err := registry.Admit(Request{Channel: "1.1", ClientIP: "a", Mode: ModeTranscode})
+	var rejectErr *RejectError
+	if !errors.As(err, &rejectErr) || rejectErr.Reason != ReasonDeviceOffline {
+		t.Fatalf("Expected a device offline rejection, got %v", err)
+	}
+	if err.Error() != "stream rejected: HDHomeRun device is not available yet" {
+		t.Errorf("Unexpected message %q", err.Error())
+	}
+
+	registry.SetDeviceOffline(false)
+	if _, err := registry.Admit(Request{Channel: "1.1", ClientIP: "a", Mode: ModeTranscode}); err != nil {
+		t.Errorf("Expected admission once the device is reachable, got %v", err)
+	}
+}
@@ -3,7 +3,9 @@ package stream
 
 import (
 	"context"
+	"errors"
 	"io"
+	"sync"
 	"time"
 
 	"github.com/attaebra/hdhr-proxy/internal/interfaces"
@@ -20,6 +22,34 @@ func NewHelper() *Helper {
 	return &Helper{}
 }
 
+// errCopyAbandoned is returned to a copy that outlives its canceled caller.
+var errCopyAbandoned = errors.New("copy abandoned after cancellation")
+
+// guardedWriter passes writes through until it is closed. A copy goroutine
+// left running after cancellation must not write to dst once the caller has
+// returned, since dst is often a ResponseWriter that is no longer valid.
+type guardedWriter struct {
+	mu     sync.Mutex
+	dst    io.Writer
+	closed bool
+}
+
+func (g *guardedWriter) Write(p []byte) (int, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed {
+		return 0, errCopyAbandoned
+	}
+	return g.dst.Write(p)
+}
+
+// close waits for a write in progress and rejects the ones after it.
+func (g *guardedWriter) close() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.closed = true
+}
+
 // Copy performs simple copying with context cancellation support.
 func (h *Helper) Copy(ctx context.Context, dst io.Writer, src io.Reader) (int64, error) {
 	// Use a goroutine to handle the copy and make it cancellable
@@ -29,14 +59,16 @@ func (h *Helper) Copy(ctx context.Context, dst io.Writer, src io.Reader) (int64,
 	}
 
 	resultCh := make(chan result, 1)
+	guarded := &guardedWriter{dst: dst}
 
 	go func() {
-		n, err := io.Copy(dst, src)
+		n, err := io.Copy(guarded, src)
 		resultCh <- result{n, err}
 	}()
 
 	select {
 	case <-ctx.Done():
+		guarded.close()
 		return 0, ctx.Err()
 	case res := <-resultCh:
 		return res.n, res.err
@@ -52,9 +84,10 @@ func (h *Helper) CopyWithActivityUpdate(ctx context.Context, dst io.Writer, src
 	}
 
 	resultCh := make(chan result, 1)
+	guarded := &guardedWriter{dst: dst}
 
 	go func() {
-		n, err := io.Copy(dst, src)
+		n, err := io.Copy(guarded, src)
 		resultCh <- result{n, err}
 	}()
 
@@ -65,6 +98,7 @@ func (h *Helper) CopyWithActivityUpdate(ctx context.Context, dst io.Writer, src
 	for {
 		select {
 		case <-ctx.Done():
+			guarded.close()
 			return 0, ctx.Err()
 		case <-activityTicker.C:
 			activityCallback()
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -73,3 +74,63 @@ func TestStreamHelperWithActivityUpdate(t *testing.T) {
 		t.Errorf("Expected 'test data', got '%s'", dst.String())
 	}
 }
+
+// endlessReader yields data until the test ends.
+type endlessReader struct{}
+
+func (endlessReader) Read(p []byte) (int, error) {
+	time.Sleep(time.Millisecond)
+	return len(p), nil
+}
+
+// recordingWriter counts writes and flags any after the copy returned.
+type recordingWriter struct {
+	mu       sync.Mutex
+	returned bool
+	late     int
+}
+
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.returned {
+		w.late++
+	}
+	return len(p), nil
+}
+
+func TestStreamHelperNoWritesAfterCancel(t *testing.T) {
+	helper := NewHelper()
+
+	for name, copyFn := range map[string]func(context.Context, *recordingWriter) error{
+		"Copy": func(ctx context.Context, dst *recordingWriter) error {
+			_, err := helper.Copy(ctx, dst, endlessReader{})
+			return err
+		},
+		"CopyWithActivityUpdate": func(ctx context.Context, dst *recordingWriter) error {
+			_, err := helper.CopyWithActivityUpdate(ctx, dst, endlessReader{}, func() {})
+			return err
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dst := &recordingWriter{}
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+
+			if err := copyFn(ctx, dst); err != context.DeadlineExceeded {
+				t.Fatalf("Expected context.DeadlineExceeded, got %v", err)
+			}
+			dst.mu.Lock()
+			dst.returned = true
+			dst.mu.Unlock()
+
+			// The abandoned copy keeps reading but must not write
+			time.Sleep(20 * time.Millisecond)
+			dst.mu.Lock()
+			defer dst.mu.Unlock()
+			if dst.late != 0 {
+				t.Errorf("Expected no writes after the copy returned, got %d", dst.late)
+			}
+		})
+	}
+}
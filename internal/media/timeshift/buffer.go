@@ -0,0 +1,430 @@
+// Package timeshift provides per-channel ring buffers of MPEG-TS data that let
+// clients start behind the live edge or resume where they dropped.
+package timeshift
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// chunk is a run of whole TS packets written to the buffer at once.
+type chunk struct {
+	offset    int64     // Absolute byte offset of the first byte
+	length    int64     // Number of bytes
+	data      []byte    // Whole TS packets, nil when they are stored on disk
+	seg       *segment  // Segment file holding the packets on disk
+	segOffset int64     // Offset of the packets in the segment file
+	wall      time.Time // When the chunk was written
+	pcr       float64   // First PCR in the chunk, in seconds
+	hasPCR    bool
+}
+
+// readAt copies the chunk's bytes from off into p.
+func (c *chunk) readAt(p []byte, off int64) (int, error) {
+	if c.seg == nil {
+		return copy(p, c.data[off:]), nil
+	}
+	if rest := c.length - off; int64(len(p)) > rest {
+		p = p[:rest]
+	}
+	return c.seg.file.ReadAt(p, c.segOffset+off)
+}
+
+// FeedError describes why the feeder for a buffer could not deliver a stream.
+type FeedError struct {
+	Status int
+	Header http.Header
+	Err    error
+}
+
+// Error implements the error interface.
+func (e *FeedError) Error() string {
+	if e.Err != nil {
+		return e.Err.Error()
+	}
+	return http.StatusText(e.Status)
+}
+
+// Buffer is a time-bounded ring of TS packets for one channel. A single feeder
+// writes to it while any number of readers consume it at their own position.
+type Buffer struct {
+	mutex   sync.Mutex
+	window  time.Duration
+	chunks  []chunk
+	start   int64 // Offset of the oldest retained byte
+	end     int64 // Offset just past the newest byte
+	pending []byte
+	pcr     pcrTracker
+	updated chan struct{} // Closed and replaced whenever the buffer changes
+	disk    *diskStore    // Where packets are stored, nil keeps them in memory
+
+	feeding    bool
+	feedErr    error
+	stopFeeder context.CancelFunc
+	readers    int
+}
+
+// NewBuffer creates a buffer that keeps roughly window worth of stream.
+func NewBuffer(window time.Duration) *Buffer {
+	return &Buffer{
+		window:  window,
+		updated: make(chan struct{}),
+	}
+}
+
+// notify wakes up waiting readers. The caller must hold the mutex.
+func (b *Buffer) notify() {
+	close(b.updated)
+	b.updated = make(chan struct{})
+}
+
+// Write appends stream data. Partial packets are held until they are complete
+// and data before the first sync byte is discarded.
+func (b *Buffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.pending = append(b.pending, p...)
+	b.pending = b.pending[resync(b.pending):]
+
+	n := len(b.pending) / PacketSize * PacketSize
+	if n == 0 {
+		return len(p), nil
+	}
+
+	data := make([]byte, n)
+	copy(data, b.pending[:n])
+	b.pending = append(b.pending[:0], b.pending[n:]...)
+
+	c := chunk{offset: b.end, length: int64(n), data: data, wall: time.Now()}
+	for i := 0; i+PacketSize <= len(data); i += PacketSize {
+		if raw, ok := packetPCR(data[i : i+PacketSize]); ok {
+			c.pcr = b.pcr.unwrap(raw)
+			c.hasPCR = true
+			break
+		}
+	}
+	if b.disk != nil {
+		seg, offset, err := b.disk.write(data)
+		if err != nil {
+			return 0, err
+		}
+		c.data, c.seg, c.segOffset = nil, seg, offset
+	}
+
+	b.chunks = append(b.chunks, c)
+	b.end += int64(n)
+	b.trim(c.wall)
+	b.notify()
+	return len(p), nil
+}
+
+// resync returns the index of the first byte that looks like a packet start.
+func resync(data []byte) int {
+	for i := 0; i < len(data); i++ {
+		if data[i] != syncByte {
+			continue
+		}
+		if i+PacketSize >= len(data) || data[i+PacketSize] == syncByte {
+			return i
+		}
+	}
+	return len(data)
+}
+
+// trim drops chunks older than the window, always keeping the newest chunk.
+// The caller must hold the mutex.
+func (b *Buffer) trim(now time.Time) {
+	cutoff := now.Add(-b.window)
+	drop := 0
+	for drop < len(b.chunks)-1 && b.chunks[drop].wall.Before(cutoff) {
+		drop++
+	}
+	if drop > 0 {
+		if b.disk != nil {
+			for _, c := range b.chunks[:drop] {
+				b.disk.release(c.seg)
+			}
+		}
+		b.chunks = append(b.chunks[:0], b.chunks[drop:]...)
+		b.start = b.chunks[0].offset
+	}
+}
+
+// free drops every retained byte and deletes the buffer's files. The buffer
+// must not be fed or read afterwards.
+func (b *Buffer) free() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.chunks = nil
+	b.pending = nil
+	b.start = b.end
+	if b.disk != nil {
+		b.disk.close()
+		b.disk = nil
+	}
+}
+
+// idle reports whether the buffer has neither a feeder nor readers.
+func (b *Buffer) idle() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return !b.feeding && b.readers == 0
+}
+
+// LivePosition returns the offset of the newest chunk, where live viewers start.
+func (b *Buffer) LivePosition() int64 {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if len(b.chunks) == 0 {
+		return b.end
+	}
+	return b.chunks[len(b.chunks)-1].offset
+}
+
+// End returns the offset just past the newest byte, where only new data will appear.
+func (b *Buffer) End() int64 {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.end
+}
+
+// PositionAt returns the offset of the chunk closest to the given time behind
+// the live edge. PCR timestamps are used when the stream carries them; wall
+// clock write times are used otherwise. Positions older than the buffer are
+// clamped to the oldest retained chunk.
+func (b *Buffer) PositionAt(behind time.Duration) int64 {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if len(b.chunks) == 0 {
+		return b.end
+	}
+
+	latestPCR, hasPCR := 0.0, false
+	for i := len(b.chunks) - 1; i >= 0; i-- {
+		if b.chunks[i].hasPCR {
+			latestPCR, hasPCR = b.chunks[i].pcr, true
+			break
+		}
+	}
+
+	if hasPCR {
+		target := latestPCR - behind.Seconds()
+		for _, c := range b.chunks {
+			if c.hasPCR && c.pcr >= target {
+				return c.offset
+			}
+		}
+	} else {
+		target := b.chunks[len(b.chunks)-1].wall.Add(-behind)
+		for _, c := range b.chunks {
+			if !c.wall.Before(target) {
+				return c.offset
+			}
+		}
+	}
+	return b.chunks[len(b.chunks)-1].offset
+}
+
+// Align rounds a position down to a packet boundary, clamped to the retained range.
+func (b *Buffer) Align(pos int64) int64 {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if pos <= b.start || len(b.chunks) == 0 {
+		return b.start
+	}
+	if pos >= b.end {
+		return b.end
+	}
+	c := b.chunks[b.chunkIndex(pos)]
+	return c.offset + (pos-c.offset)/PacketSize*PacketSize
+}
+
+// chunkIndex returns the index of the chunk containing pos. The caller must hold the mutex.
+func (b *Buffer) chunkIndex(pos int64) int {
+	i := sort.Search(len(b.chunks), func(i int) bool {
+		return b.chunks[i].offset+b.chunks[i].length > pos
+	})
+	if i >= len(b.chunks) {
+		return len(b.chunks) - 1
+	}
+	return i
+}
+
+// Duration returns how much stream time the buffer currently holds.
+func (b *Buffer) Duration() time.Duration {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if len(b.chunks) < 2 {
+		return 0
+	}
+	first, last := b.chunks[0], b.chunks[len(b.chunks)-1]
+	if first.hasPCR && last.hasPCR {
+		return time.Duration((last.pcr - first.pcr) * float64(time.Second))
+	}
+	return last.wall.Sub(first.wall)
+}
+
+// Size returns the number of bytes currently retained.
+func (b *Buffer) Size() int64 {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.end - b.start
+}
+
+// StartFeeding marks the buffer as fed. It returns false if a feeder is
+// already running. stop is called to end the feeder when it becomes idle.
+func (b *Buffer) StartFeeding(stop context.CancelFunc) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.feeding {
+		return false
+	}
+	b.feeding = true
+	b.feedErr = nil
+	b.stopFeeder = stop
+	b.pending = b.pending[:0]
+	b.notify()
+	return true
+}
+
+// StopFeeding marks the feeder as finished. Readers drain what is buffered
+// and then see io.EOF. A non-nil err is reported to readers waiting for data.
+func (b *Buffer) StopFeeding(err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.feeding = false
+	b.feedErr = err
+	b.stopFeeder = nil
+	b.notify()
+}
+
+// Feeding reports whether a feeder is currently writing to the buffer.
+func (b *Buffer) Feeding() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.feeding
+}
+
+// WaitForData blocks until data newer than pos is available, the feeder
+// fails, or ctx ends.
+func (b *Buffer) WaitForData(ctx context.Context, pos int64) error {
+	for {
+		b.mutex.Lock()
+		switch {
+		case b.end > pos:
+			b.mutex.Unlock()
+			return nil
+		case !b.feeding && b.feedErr != nil:
+			err := b.feedErr
+			b.mutex.Unlock()
+			return err
+		case !b.feeding:
+			b.mutex.Unlock()
+			return io.EOF
+		}
+		updated := b.updated
+		b.mutex.Unlock()
+
+		select {
+		case <-updated:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// AddReader registers a reader and returns the reader count.
+func (b *Buffer) AddReader() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.readers++
+	return b.readers
+}
+
+// RemoveReader unregisters a reader and returns the remaining reader count.
+func (b *Buffer) RemoveReader() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.readers--
+	return b.readers
+}
+
+// Readers returns the number of active readers.
+func (b *Buffer) Readers() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.readers
+}
+
+// StopIfIdle ends the feeder if no readers are left.
+func (b *Buffer) StopIfIdle() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.readers > 0 || b.stopFeeder == nil {
+		return false
+	}
+	b.stopFeeder()
+	return true
+}
+
+// NewReader returns a reader starting at pos that follows the live edge until
+// the feeder stops or ctx ends.
+func (b *Buffer) NewReader(ctx context.Context, pos int64) *Reader {
+	return &Reader{buffer: b, ctx: ctx, pos: pos}
+}
+
+// Reader reads a buffer from a position, blocking for new data at the live edge.
+type Reader struct {
+	buffer *Buffer
+	ctx    context.Context
+	pos    int64
+}
+
+// Position returns the absolute offset of the next byte to be read.
+func (r *Reader) Position() int64 {
+	return r.pos
+}
+
+// Read implements io.Reader. Readers that fall behind the retained window skip
+// ahead to the oldest retained data.
+func (r *Reader) Read(p []byte) (int, error) {
+	b := r.buffer
+	for {
+		b.mutex.Lock()
+		if r.pos < b.start {
+			r.pos = b.start
+		}
+		if r.pos < b.end && len(b.chunks) > 0 {
+			c := b.chunks[b.chunkIndex(r.pos)]
+			n, err := c.readAt(p, r.pos-c.offset)
+			r.pos += int64(n)
+			b.mutex.Unlock()
+			return n, err
+		}
+		if !b.feeding {
+			b.mutex.Unlock()
+			return 0, io.EOF
+		}
+		updated := b.updated
+		b.mutex.Unlock()
+
+		select {
+		case <-updated:
+		case <-r.ctx.Done():
+			return 0, r.ctx.Err()
+		}
+	}
+}
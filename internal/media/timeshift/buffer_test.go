@@ -0,0 +1,242 @@
+package timeshift
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+// tsPacket builds a TS packet, carrying a PCR when pcr is not negative.
+func tsPacket(pcr int64, fill byte) []byte {
+	p := bytes.Repeat([]byte{fill}, PacketSize)
+	p[0] = syncByte
+	p[1], p[2] = 0x01, 0x00
+	p[3] = 0x10 // Payload only
+	if pcr >= 0 {
+		base, ext := pcr/300, pcr%300
+		p[3] = 0x30 // Adaptation field and payload
+		p[4] = 7
+		p[5] = 0x10 // PCR flag
+		p[6] = byte(base >> 25)
+		p[7] = byte(base >> 17)
+		p[8] = byte(base >> 9)
+		p[9] = byte(base >> 1)
+		p[10] = byte(base<<7) | 0x7e | byte(ext>>8)
+		p[11] = byte(ext)
+	}
+	return p
+}
+
+func TestPacketPCR(t *testing.T) {
+	want := int64(123456789) * 300
+	got, ok := packetPCR(tsPacket(want, 0xff))
+	if !ok || got != want {
+		t.Errorf("Expected PCR %d, got %d (%v)", want, got, ok)
+	}
+	if _, ok := packetPCR(tsPacket(-1, 0xff)); ok {
+		t.Error("Expected no PCR in payload-only packet")
+	}
+
+	var tracker pcrTracker
+	before := tracker.unwrap(pcrWrap - pcrClock)
+	after := tracker.unwrap(pcrClock)
+	if after-before < 1.9 || after-before > 2.1 {
+		t.Errorf("Expected PCR to unwrap across the 33-bit boundary, got %.2fs", after-before)
+	}
+}
+
+func TestBufferPositionAtUsesPCR(t *testing.T) {
+	buf := NewBuffer(time.Hour)
+	for second := int64(0); second < 10; second++ {
+		if _, err := buf.Write(tsPacket(second*pcrClock, byte(second))); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+
+	if got := buf.Duration(); got != 9*time.Second {
+		t.Errorf("Expected 9s buffered, got %v", got)
+	}
+	if got := buf.PositionAt(3 * time.Second); got != 6*PacketSize {
+		t.Errorf("Expected position of the packet 3s back, got %d", got)
+	}
+	if got := buf.PositionAt(time.Hour); got != 0 {
+		t.Errorf("Expected offsets past the window to clamp to the oldest data, got %d", got)
+	}
+	if got := buf.Align(6*PacketSize + 17); got != 6*PacketSize {
+		t.Errorf("Expected position aligned to a packet boundary, got %d", got)
+	}
+}
+
+func TestBufferResyncsAndHoldsPartialPackets(t *testing.T) {
+	buf := NewBuffer(time.Hour)
+	packet := tsPacket(-1, 0xaa)
+
+	// Garbage before the first packet is dropped and half a packet is held
+	if _, err := buf.Write(append([]byte{0x00, 0x01}, packet[:100]...)); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if buf.Size() != 0 {
+		t.Errorf("Expected partial packet to be held, got %d bytes", buf.Size())
+	}
+	if _, err := buf.Write(packet[100:]); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if buf.Size() != PacketSize {
+		t.Errorf("Expected one packet, got %d bytes", buf.Size())
+	}
+}
+
+func TestBufferTrimsToWindow(t *testing.T) {
+	buf := NewBuffer(50 * time.Millisecond)
+	if _, err := buf.Write(tsPacket(-1, 1)); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, err := buf.Write(tsPacket(-1, 2)); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	if buf.Size() != PacketSize {
+		t.Errorf("Expected old packet to be trimmed, got %d bytes", buf.Size())
+	}
+
+	// A reader positioned in trimmed data skips ahead to the oldest retained packet
+	reader := buf.NewReader(context.Background(), 0)
+	data := make([]byte, PacketSize)
+	if _, err := io.ReadFull(reader, data); err != nil || data[PacketSize-1] != 2 {
+		t.Errorf("Expected reader to skip to retained data, got %v", err)
+	}
+}
+
+func TestReaderFollowsLiveEdge(t *testing.T) {
+	buf := NewBuffer(time.Hour)
+	buf.StartFeeding(func() {})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	reader := buf.NewReader(ctx, buf.End())
+
+	go func() {
+		for i := 0; i < 3; i++ {
+			_, _ = buf.Write(tsPacket(-1, byte(i)))
+			time.Sleep(10 * time.Millisecond)
+		}
+		buf.StopFeeding(nil)
+	}()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	if len(data) != 3*PacketSize {
+		t.Errorf("Expected 3 packets, got %d bytes", len(data))
+	}
+}
+
+func TestWaitForDataReportsFeedError(t *testing.T) {
+	buf := NewBuffer(time.Hour)
+	buf.StartFeeding(func() {})
+	buf.StopFeeding(&FeedError{Status: 503})
+
+	if err := buf.WaitForData(context.Background(), 0); err == nil {
+		t.Error("Expected feed error")
+	}
+}
+
+func TestManagerResumeAndChannels(t *testing.T) {
+	m := NewManager(time.Minute, []string{"5.1"}, "")
+	if !m.Enabled("5.1") || m.Enabled("7.1") {
+		t.Error("Expected only configured channels to be enabled")
+	}
+	if !NewManager(time.Minute, nil, "").Enabled("7.1") {
+		t.Error("Expected every channel to be enabled without a channel list")
+	}
+
+	m.SetChannels([]string{"7.1"})
+	if m.Enabled("5.1") || !m.Enabled("7.1") {
+		t.Error("Expected reloaded channel list to apply")
+	}
+	m.SetChannels([]string{"5.1"})
+
+	if _, _, ok := m.ResumePosition("10.0.0.1", "5.1"); ok {
+		t.Error("Expected no resume position before saving")
+	}
+	m.SavePosition("10.0.0.1", "5.1", 4*PacketSize)
+	if pos, _, ok := m.ResumePosition("10.0.0.1", "5.1"); !ok || pos != 4*PacketSize {
+		t.Errorf("Expected saved resume position, got %d (%v)", pos, ok)
+	}
+	first, _ := m.Acquire("5.1")
+	second, _ := m.Acquire("5.1")
+	if first != second || first.Readers() != 2 {
+		t.Error("Expected the same buffer for a channel")
+	}
+}
+
+func TestManagerEvictsIdleBuffers(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		dir  string
+	}{
+		{name: "memory"},
+		{name: "disk", dir: t.TempDir()},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := NewManager(time.Hour, nil, tc.dir)
+			buf, err := m.Acquire("5.1")
+			if err != nil {
+				t.Fatalf("Acquire failed: %v", err)
+			}
+			buf.StartFeeding(func() {})
+			for i := 0; i < 10; i++ {
+				if _, err := buf.Write(tsPacket(-1, byte(i))); err != nil {
+					t.Fatalf("Write failed: %v", err)
+				}
+			}
+
+			// Buffered packets read back the same from memory or disk
+			data := make([]byte, 2*PacketSize)
+			if _, err := io.ReadFull(buf.NewReader(context.Background(), 8*PacketSize), data); err != nil || data[PacketSize-1] != 8 || data[2*PacketSize-1] != 9 {
+				t.Errorf("Expected the last two packets, got %v", err)
+			}
+			m.SavePosition("10.0.0.1", "5.1", 4*PacketSize)
+
+			// A buffer with a feeder or readers is kept
+			if m.Evict("5.1", buf) {
+				t.Fatal("Expected a fed buffer to be kept")
+			}
+			buf.StopFeeding(nil)
+			if m.Evict("5.1", buf) {
+				t.Fatal("Expected a read buffer to be kept")
+			}
+			if count, size := m.Usage(); count != 1 || size != 10*PacketSize {
+				t.Errorf("Expected one buffer of 10 packets, got %d of %d bytes", count, size)
+			}
+
+			buf.RemoveReader()
+			if !m.Evict("5.1", buf) {
+				t.Fatal("Expected the idle buffer to be evicted")
+			}
+			if count, size := m.Usage(); count != 0 || size != 0 {
+				t.Errorf("Expected no buffers after eviction, got %d of %d bytes", count, size)
+			}
+			if buf.Size() != 0 {
+				t.Errorf("Expected the evicted buffer to be freed, got %d bytes", buf.Size())
+			}
+			if _, _, ok := m.ResumePosition("10.0.0.1", "5.1"); ok {
+				t.Error("Expected resume positions into the evicted buffer to be forgotten")
+			}
+			if tc.dir != "" {
+				if entries, _ := os.ReadDir(tc.dir); len(entries) != 0 {
+					t.Errorf("Expected the buffer's files to be deleted, found %d entries", len(entries))
+				}
+			}
+
+			if next, _ := m.Acquire("5.1"); next == buf {
+				t.Error("Expected a new buffer after eviction")
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package timeshift
+
+import (
+	"fmt"
+	"os"
+)
+
+// segmentSize is how much stream a disk-backed buffer writes to one file
+// before starting the next, so trimming can delete whole files.
+const segmentSize = 16 << 20
+
+// segment is one file of a disk-backed buffer.
+type segment struct {
+	file   *os.File
+	size   int64 // Bytes written to the file
+	chunks int   // Retained chunks stored in the file
+}
+
+// diskStore keeps the packets of a buffer in segment files under dir instead
+// of in memory. It is used under the buffer's mutex.
+type diskStore struct {
+	dir     string
+	current *segment
+}
+
+// newDiskStore creates a store in a new directory under parent.
+func newDiskStore(parent, channel string) (*diskStore, error) {
+	if err := os.MkdirAll(parent, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create timeshift directory: %w", err)
+	}
+	dir, err := os.MkdirTemp(parent, "channel-"+safeName(channel)+"-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create timeshift directory: %w", err)
+	}
+	return &diskStore{dir: dir}, nil
+}
+
+// write appends data to the current segment, starting a new one when it is
+// full, and returns where the data was stored.
+func (d *diskStore) write(data []byte) (*segment, int64, error) {
+	if d.current == nil || d.current.size >= segmentSize {
+		file, err := os.CreateTemp(d.dir, "*.ts")
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to create timeshift segment: %w", err)
+		}
+		if d.current != nil && d.current.chunks == 0 {
+			d.current.remove()
+		}
+		d.current = &segment{file: file}
+	}
+
+	seg := d.current
+	offset := seg.size
+	if _, err := seg.file.WriteAt(data, offset); err != nil {
+		return nil, 0, fmt.Errorf("failed to write timeshift segment: %w", err)
+	}
+	seg.size += int64(len(data))
+	seg.chunks++
+	return seg, offset, nil
+}
+
+// release forgets a trimmed chunk, deleting its segment once no retained chunk
+// is stored in it and it is no longer written to.
+func (d *diskStore) release(seg *segment) {
+	seg.chunks--
+	if seg.chunks == 0 && seg != d.current {
+		seg.remove()
+	}
+}
+
+// close deletes every segment and the store's directory.
+func (d *diskStore) close() {
+	if d.current != nil {
+		d.current.file.Close()
+	}
+	os.RemoveAll(d.dir)
+}
+
+// remove closes and deletes the segment's file.
+func (s *segment) remove() {
+	s.file.Close()
+	os.Remove(s.file.Name())
+}
+
+// safeName replaces the characters of a channel number that do not belong in
+// a file name.
+func safeName(channel string) string {
+	name := []byte(channel)
+	for i, c := range name {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '.' || c == '-') {
+			name[i] = '_'
+		}
+	}
+	return string(name)
+}
@@ -0,0 +1,196 @@
+package timeshift
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/attaebra/hdhr-proxy/internal/interfaces"
+)
+
+// resumeKey identifies a client's position on a channel.
+type resumeKey struct {
+	clientIP string
+	channel  string
+}
+
+// resumePoint is where a client stopped reading.
+type resumePoint struct {
+	position int64
+	saved    time.Time
+}
+
+// Manager owns the timeshift buffers and remembers where clients dropped.
+type Manager struct {
+	window time.Duration
+	dir    string // Where buffers keep their packets, empty keeps them in memory
+
+	mutex     sync.Mutex
+	channels  map[string]bool // nil means every channel is buffered
+	buffers   map[string]*Buffer
+	positions map[resumeKey]resumePoint
+}
+
+// Ensure Manager can contribute to the status page.
+var _ interfaces.StatusProvider = (*Manager)(nil)
+
+// NewManager creates a manager that buffers window worth of stream for the
+// given channels, or for every channel when channels is empty. Buffers are
+// kept in files under dir, or in memory when dir is empty.
+func NewManager(window time.Duration, channels []string, dir string) *Manager {
+	m := &Manager{
+		window:    window,
+		dir:       dir,
+		buffers:   make(map[string]*Buffer),
+		positions: make(map[resumeKey]resumePoint),
+	}
+	m.SetChannels(channels)
+	return m
+}
+
+// SetChannels replaces the buffered channel list; an empty list buffers every
+// channel. Buffers already feeding viewers keep running until they go idle.
+func (m *Manager) SetChannels(channels []string) {
+	var enabled map[string]bool
+	if len(channels) > 0 {
+		enabled = make(map[string]bool, len(channels))
+		for _, channel := range channels {
+			enabled[channel] = true
+		}
+	}
+
+	m.mutex.Lock()
+	m.channels = enabled
+	m.mutex.Unlock()
+}
+
+// Window returns how much stream each buffer keeps.
+func (m *Manager) Window() time.Duration {
+	return m.window
+}
+
+// Enabled reports whether a channel is served through a timeshift buffer.
+func (m *Manager) Enabled(channel string) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.channels == nil || m.channels[channel]
+}
+
+// Acquire returns the buffer for a channel, creating it if needed, and
+// registers a reader on it so it is not evicted. The caller must remove the
+// reader when done.
+func (m *Manager) Acquire(channel string) (*Buffer, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	buf, ok := m.buffers[channel]
+	if !ok {
+		buf = NewBuffer(m.window)
+		if m.dir != "" {
+			disk, err := newDiskStore(m.dir, channel)
+			if err != nil {
+				return nil, err
+			}
+			buf.disk = disk
+		}
+		m.buffers[channel] = buf
+	}
+	buf.AddReader()
+	return buf, nil
+}
+
+// Evict frees a channel's buffer, and forgets the resume positions into it,
+// once it has neither a feeder nor readers. It reports whether the buffer was
+// freed; the next viewer of the channel starts a new one.
+func (m *Manager) Evict(channel string, buf *Buffer) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.buffers[channel] != buf || !buf.idle() {
+		return false
+	}
+	delete(m.buffers, channel)
+	for key := range m.positions {
+		if key.channel == channel {
+			delete(m.positions, key)
+		}
+	}
+	buf.free()
+	return true
+}
+
+// Usage returns the number of buffers and the bytes they retain.
+func (m *Manager) Usage() (int, int64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var size int64
+	for _, buf := range m.buffers {
+		size += buf.Size()
+	}
+	return len(m.buffers), size
+}
+
+// SavePosition remembers where a client stopped reading a channel.
+func (m *Manager) SavePosition(clientIP, channel string, position int64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now()
+	m.positions[resumeKey{clientIP, channel}] = resumePoint{position: position, saved: now}
+
+	// Positions older than the window can never be resumed
+	for key, point := range m.positions {
+		if now.Sub(point.saved) > m.window {
+			delete(m.positions, key)
+		}
+	}
+}
+
+// ResumePosition returns where and when a client stopped reading a channel,
+// if the position is still within the window.
+func (m *Manager) ResumePosition(clientIP, channel string) (int64, time.Time, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	point, ok := m.positions[resumeKey{clientIP, channel}]
+	if !ok || time.Since(point.saved) > m.window {
+		return 0, time.Time{}, false
+	}
+	return point.position, point.saved, true
+}
+
+// WriteStatus writes the timeshift section of the status page.
+func (m *Manager) WriteStatus(w io.Writer) {
+	m.mutex.Lock()
+	channels := make([]string, 0, len(m.buffers))
+	buffers := make(map[string]*Buffer, len(m.buffers))
+	for channel, buf := range m.buffers {
+		channels = append(channels, channel)
+		buffers[channel] = buf
+	}
+	m.mutex.Unlock()
+	sort.Strings(channels)
+
+	storage := "memory"
+	if m.dir != "" {
+		storage = m.dir
+	}
+	fmt.Fprintf(w, "Timeshift Buffers (%s window, in %s)\n", m.window, storage)
+	fmt.Fprintf(w, "-----------------------------------\n")
+	if len(channels) == 0 {
+		fmt.Fprintf(w, "No buffered channels\n")
+		return
+	}
+	for _, channel := range channels {
+		buf := buffers[channel]
+		state := "idle"
+		if buf.Feeding() {
+			state = "live"
+		}
+		fmt.Fprintf(w, "Channel %-10s %-4s %8s buffered, %d bytes, %d readers\n",
+			channel, state, buf.Duration().Round(time.Second), buf.Size(), buf.Readers())
+	}
+}
@@ -0,0 +1,55 @@
+package timeshift
+
+// MPEG transport stream constants.
+const (
+	// PacketSize is the size of an MPEG-TS packet.
+	PacketSize = 188
+
+	// syncByte starts every MPEG-TS packet.
+	syncByte = 0x47
+
+	// pcrClock is the PCR clock rate (27 MHz).
+	pcrClock = 27_000_000
+
+	// pcrWrap is the PCR value at which the 33-bit base wraps around (~26.5 hours).
+	pcrWrap = (1 << 33) * 300
+)
+
+// packetPCR extracts the program clock reference from a TS packet, in 27 MHz ticks.
+func packetPCR(packet []byte) (int64, bool) {
+	if len(packet) < PacketSize || packet[0] != syncByte {
+		return 0, false
+	}
+
+	// adaptation_field_control: 2 = adaptation only, 3 = adaptation + payload
+	if (packet[3]>>4)&0x2 == 0 {
+		return 0, false
+	}
+
+	adaptationLength := int(packet[4])
+	if adaptationLength < 7 || packet[5]&0x10 == 0 {
+		return 0, false
+	}
+
+	base := int64(packet[6])<<25 | int64(packet[7])<<17 | int64(packet[8])<<9 |
+		int64(packet[9])<<1 | int64(packet[10])>>7
+	extension := int64(packet[10]&0x01)<<8 | int64(packet[11])
+	return base*300 + extension, true
+}
+
+// pcrTracker turns raw PCR values into a monotonic clock that survives wraparound.
+type pcrTracker struct {
+	last   int64
+	offset int64
+	seen   bool
+}
+
+// unwrap returns a monotonic PCR value in seconds for a raw PCR.
+func (p *pcrTracker) unwrap(raw int64) float64 {
+	if p.seen && raw < p.last && p.last-raw > pcrWrap/2 {
+		p.offset += pcrWrap
+	}
+	p.last = raw
+	p.seen = true
+	return float64(raw+p.offset) / pcrClock
+}
@@ -0,0 +1,138 @@
+package transcoder
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/attaebra/hdhr-proxy/internal/interfaces"
+	"github.com/attaebra/hdhr-proxy/internal/logger"
+	"github.com/attaebra/hdhr-proxy/internal/media/session"
+)
+
+// stderrTailLines is how many lines of FFmpeg output are kept per session.
+const stderrTailLines = 50
+
+// Ensure Impl can be administered at runtime.
+var _ interfaces.StreamAdmin = (*Impl)(nil)
+
+// stderrTail keeps the most recent lines of an FFmpeg process's output.
+type stderrTail struct {
+	mutex sync.Mutex
+	lines []string
+}
+
+// add records a line, dropping the oldest once the tail is full.
+func (s *stderrTail) add(line string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.lines = append(s.lines, line)
+	if len(s.lines) > stderrTailLines {
+		s.lines = s.lines[len(s.lines)-stderrTailLines:]
+	}
+}
+
+// snapshot returns a copy of the recorded lines, oldest first.
+func (s *stderrTail) snapshot() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string(nil), s.lines...)
+}
+
+// trackStderr starts recording FFmpeg output for the session carried by ctx.
+// It returns nil when the stream has no session.
+func (t *Impl) trackStderr(ctx context.Context) *stderrTail {
+	sess, ok := session.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	tail := &stderrTail{}
+	t.mutex.Lock()
+	t.ffmpegStderr[sess.ID] = tail
+	t.mutex.Unlock()
+	return tail
+}
+
+// Sessions lists the active streams, oldest first.
+func (t *Impl) Sessions() []interfaces.SessionInfo {
+	sessions := t.sessions.List()
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	infos := make([]interfaces.SessionInfo, 0, len(sessions))
+	for i := range sessions {
+		infos = append(infos, t.sessionInfo(&sessions[i]))
+	}
+	return infos
+}
+
+// Session returns an active stream with its recent FFmpeg output.
+func (t *Impl) Session(id string) (interfaces.SessionInfo, bool) {
+	for _, sess := range t.sessions.List() {
+		if sess.ID != id {
+			continue
+		}
+
+		t.mutex.Lock()
+		info := t.sessionInfo(&sess)
+		tail := t.ffmpegStderr[id]
+		t.mutex.Unlock()
+
+		if tail != nil {
+			info.FFmpegStderr = tail.snapshot()
+		}
+		return info, true
+	}
+	return interfaces.SessionInfo{}, false
+}
+
+// sessionInfo converts a session for administration. t.mutex must be held.
+func (t *Impl) sessionInfo(sess *session.Session) interfaces.SessionInfo {
+	info := interfaces.SessionInfo{
+		ID:        sess.ID,
+		Channel:   sess.Channel,
+		ClientIP:  sess.ClientIP,
+		Mode:      string(sess.Mode),
+		Class:     sess.Class,
+		Priority:  sess.Priority,
+		StartTime: sess.StartTime,
+		Duration:  time.Since(sess.StartTime).Round(time.Second).String(),
+	}
+	if meter := t.meters[sess.ID]; meter != nil {
+		info.BytesSent, info.Bitrate = meter.read(time.Now())
+	}
+	if sess.Mode == session.ModeTranscode {
+		if proc, ok := t.sessionProcesses[sess.ID]; ok {
+			info.FFmpegPID = proc.Pid()
+		}
+	}
+	return info
+}
+
+// StopSession ends the stream of an active session. Each session has its own
+// upstream request and FFmpeg process, so other sessions on the same channel
+// keep running.
+func (t *Impl) StopSession(id string) bool {
+	for _, sess := range t.sessions.List() {
+		if sess.ID == id {
+			t.logger.Info("🛑 Stopping session on request",
+				logger.String("session_id", id),
+				logger.String("channel", sess.Channel),
+				logger.String("client_ip", sess.ClientIP))
+			t.stopSession(&sess)
+			return true
+		}
+	}
+	return false
+}
+
+// RefreshLineup re-reads the channel lineup from the device. The request is
+// abandoned when ctx is cancelled or the transcoder shuts down.
+func (t *Impl) RefreshLineup(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(t.ctx, cancel)
+	defer stop()
+	return t.fetchAC4Channels(ctx)
+}
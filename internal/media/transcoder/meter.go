@@ -0,0 +1,68 @@
+package transcoder
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// meterWindow is how many whole seconds the live bitrate is averaged over.
+const meterWindow = 5
+
+// byteMeter counts the bytes sent to a client and their recent rate.
+type byteMeter struct {
+	mutex   sync.Mutex
+	total   int64
+	buckets [meterWindow + 1]int64 // Bytes sent in each second, indexed by Unix second
+	seconds [meterWindow + 1]int64 // The Unix second each bucket holds
+}
+
+// add records n bytes sent at now.
+func (m *byteMeter) add(n int, now time.Time) {
+	second := now.Unix()
+	i := second % int64(len(m.buckets))
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.seconds[i] != second {
+		m.seconds[i] = second
+		m.buckets[i] = 0
+	}
+	m.buckets[i] += int64(n)
+	m.total += int64(n)
+}
+
+// read returns the total bytes and the bitrate in bits per second over the
+// last meterWindow whole seconds before now.
+func (m *byteMeter) read(now time.Time) (int64, float64) {
+	current := now.Unix()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var recent int64
+	for i, second := range m.seconds {
+		if second < current && second >= current-meterWindow {
+			recent += m.buckets[i]
+		}
+	}
+	return m.total, float64(recent*8) / meterWindow
+}
+
+// meteredWriter counts the bytes written to a client.
+type meteredWriter struct {
+	http.ResponseWriter
+	meter *byteMeter
+}
+
+// Write implements io.Writer.
+func (w *meteredWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.meter.add(n, time.Now())
+	return n, err
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController.
+func (w *meteredWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,115 @@
+package transcoder
+
+import (
+	"time"
+
+	"github.com/attaebra/hdhr-proxy/internal/config"
+	"github.com/attaebra/hdhr-proxy/internal/interfaces"
+	"github.com/attaebra/hdhr-proxy/internal/logger"
+	"github.com/attaebra/hdhr-proxy/internal/media/session"
+	"github.com/attaebra/hdhr-proxy/internal/utils"
+)
+
+// Reload applies the reloadable settings from cfg. Only new streams see the
+// changes; streams already running keep the FFmpeg arguments and priority
+// class they were started with.
+func (t *Impl) Reload(cfg *config.Config) {
+	t.mutex.Lock()
+	t.FFmpegConfig = cfg.FFmpegConfig()
+	t.classifier = session.NewClassifier(cfg.PriorityClasses)
+	t.mutex.Unlock()
+
+	t.activityMutex.Lock()
+	t.maxInactivityDuration = cfg.MaxInactivityDuration
+	t.activityMutex.Unlock()
+
+	if t.timeshift != nil {
+		t.timeshift.SetChannels(cfg.TimeshiftChannels)
+	}
+
+	t.SetLineupRefreshInterval(cfg.LineupRefreshInterval)
+
+	t.logger.Info("🔄 Transcoder settings reloaded",
+		logger.Int("priority_classes", len(cfg.PriorityClasses)),
+		logger.Duration("max_inactivity", cfg.MaxInactivityDuration),
+		logger.Duration("lineup_refresh_interval", cfg.LineupRefreshInterval))
+}
+
+// currentSettings returns the FFmpeg parameters and classifier for a new stream.
+func (t *Impl) currentSettings() (interfaces.Config, *session.Classifier) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.FFmpegConfig, t.classifier
+}
+
+// SetDeviceAddress points new streams at the device's new address. The
+// lineup is fetched through the proxy, which follows the device on its own.
+// Streams already running keep their connection.
+func (t *Impl) SetDeviceAddress(hdhrIP string) {
+	t.addressMutex.Lock()
+	defer t.addressMutex.Unlock()
+	t.InputURL = "http://" + utils.DeviceMediaHost(hdhrIP, t.deviceMediaPort)
+}
+
+// inputURL returns the device's media base URL.
+func (t *Impl) inputURL() string {
+	t.addressMutex.RLock()
+	defer t.addressMutex.RUnlock()
+	return t.InputURL
+}
+
+// SetLineupRefreshInterval changes how often the channel lineup is re-read
+// from the device. Zero disables periodic refreshes.
+func (t *Impl) SetLineupRefreshInterval(interval time.Duration) {
+	// Replace any interval the refresher has not picked up yet
+	select {
+	case <-t.lineupRefresh:
+	default:
+	}
+	select {
+	case t.lineupRefresh <- interval:
+	default:
+	}
+}
+
+// startLineupRefresher runs refreshLineup in the background until Shutdown.
+func (t *Impl) startLineupRefresher(interval time.Duration) {
+	t.background.Add(1)
+	go func() {
+		defer t.background.Done()
+		t.refreshLineup(interval)
+	}()
+}
+
+// refreshLineup periodically re-fetches the lineup so channels added or
+// re-tuned on the device are classified without a restart.
+func (t *Impl) refreshLineup(interval time.Duration) {
+	var ticker *time.Ticker
+	var tick <-chan time.Time
+	reset := func(interval time.Duration) {
+		if ticker != nil {
+			ticker.Stop()
+			ticker, tick = nil, nil
+		}
+		if interval > 0 {
+			ticker = time.NewTicker(interval)
+			tick = ticker.C
+		}
+	}
+	reset(interval)
+	defer reset(0)
+
+	for {
+		select {
+		case <-tick:
+			if err := t.fetchAC4Channels(t.ctx); err != nil {
+				t.logger.Warn("⚠️  Failed to refresh channel lineup", logger.ErrorField("error", err))
+			}
+		case interval := <-t.lineupRefresh:
+			reset(interval)
+			t.logger.Debug("📡 Lineup refresh interval updated", logger.Duration("interval", interval))
+		case <-t.ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package transcoder
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/attaebra/hdhr-proxy/internal/interfaces"
+	"github.com/attaebra/hdhr-proxy/internal/logger"
+	"github.com/attaebra/hdhr-proxy/internal/media/session"
+)
+
+// Ensure Impl reports its state to the dashboard.
+var _ interfaces.StatusReporter = (*Impl)(nil)
+
+// Status returns a snapshot of the device, lineup, streams and admission state.
+func (t *Impl) Status() interfaces.Status {
+	status := interfaces.Status{
+		Device: interfaces.DeviceStatus{
+			IP:         t.proxy.GetHDHRIP(),
+			DeviceID:   t.proxy.DeviceID(),
+			TunerCount: t.proxy.TunerCount(),
+			FFmpegPath: t.FFmpegPath,
+		},
+		Sessions:      t.Sessions(),
+		Rejections:    make(map[string]int64),
+		FFmpegExits:   make(map[string]int64),
+		Draining:      t.sessions.Draining(),
+		DeviceOffline: t.sessions.DeviceOffline(),
+	}
+
+	t.mutex.Lock()
+	for reason, count := range t.ffmpegExits {
+		status.FFmpegExits[string(reason)] = count
+	}
+	for channel, startTime := range t.activeStreams {
+		status.Streams = append(status.Streams, interfaces.StreamStatus{
+			Channel:     channel,
+			StartTime:   startTime,
+			Transcoding: t.ac4Channels[channel],
+		})
+	}
+	for _, channel := range t.lineup {
+		_, active := t.activeStreams[channel.GuideNumber]
+		status.Lineup = append(status.Lineup, interfaces.ChannelStatus{
+			GuideNumber: channel.GuideNumber,
+			GuideName:   channel.GuideName,
+			AC4:         t.ac4Channels[channel.GuideNumber],
+			Active:      active,
+		})
+	}
+	t.mutex.Unlock()
+
+	sort.Slice(status.Streams, func(i, j int) bool {
+		return status.Streams[i].StartTime.Before(status.Streams[j].StartTime)
+	})
+
+	limits := t.sessions.Limits()
+	status.Limits = interfaces.LimitStatus{
+		MaxTranscodes: limits.MaxTranscodes,
+		MaxPerClient:  limits.MaxPerClient,
+		MaxTotal:      limits.MaxTotal,
+	}
+	for reason, count := range t.sessions.Rejections() {
+		status.Rejections[string(reason)] = count
+	}
+	status.Preemptions, status.LastPreemption = t.sessions.Preemptions()
+
+	return status
+}
+
+// serveStatus writes the plain-text status page.
+func (t *Impl) serveStatus(w http.ResponseWriter, _ *http.Request) {
+	t.logger.Info("📊 Status endpoint accessed")
+
+	// Write output and log it at debug level
+	writeOutput := func(w http.ResponseWriter, format string, args ...interface{}) {
+		msg := fmt.Sprintf(format, args...)
+		t.logger.Debug("📊 Status output", logger.String("content", strings.TrimSpace(msg)))
+		fmt.Fprint(w, msg)
+	}
+
+	status := t.Status()
+
+	ac4Count := 0
+	for _, channel := range status.Lineup {
+		if channel.AC4 {
+			ac4Count++
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	writeOutput(w, "HDHomeRun AC4 Proxy Status\n")
+	writeOutput(w, "=========================\n")
+	if status.Draining {
+		writeOutput(w, "Draining: new streams are refused until the proxy exits\n")
+	}
+	if status.DeviceOffline {
+		writeOutput(w, "Device offline: new streams are refused until the HDHomeRun is reached\n")
+	}
+	writeOutput(w, "Active Streams: %d\n", len(status.Streams))
+	writeOutput(w, "Total Channels: %d\n", len(status.Lineup))
+	writeOutput(w, "AC4 Audio Channels: %d\n\n", ac4Count)
+
+	writeOutput(w, "Limits: transcodes=%s per_client=%s total=%s\n",
+		formatLimit(status.Limits.MaxTranscodes), formatLimit(status.Limits.MaxPerClient), formatLimit(status.Limits.MaxTotal))
+	writeOutput(w, "Rejected Streams: transcode_limit=%d client_limit=%d total_limit=%d\n",
+		status.Rejections[string(session.ReasonTranscodeLimit)],
+		status.Rejections[string(session.ReasonClientLimit)],
+		status.Rejections[string(session.ReasonTotalLimit)])
+	writeOutput(w, "FFmpeg Exits: graceful=%d forced=%d crashed=%d\n",
+		status.FFmpegExits[string(interfaces.ExitGraceful)],
+		status.FFmpegExits[string(interfaces.ExitForced)],
+		status.FFmpegExits[string(interfaces.ExitCrashed)])
+	writeOutput(w, "Preemptions: %d\n", status.Preemptions)
+	if status.LastPreemption != "" {
+		writeOutput(w, "Last Preemption: %s\n", status.LastPreemption)
+	}
+	writeOutput(w, "\n")
+
+	if len(status.Streams) > 0 {
+		writeOutput(w, "Channel    Duration (s)  Transcoding\n")
+		writeOutput(w, "-----------------------------------\n")
+		for _, stream := range status.Streams {
+			transcoding := "No"
+			if stream.Transcoding {
+				transcoding = "Yes (AC4→EAC3)"
+			}
+			writeOutput(w, "%-10s %-12.2f %s\n", stream.Channel, time.Since(stream.StartTime).Seconds(), transcoding)
+		}
+		writeOutput(w, "\n")
+	}
+
+	if len(status.Sessions) > 0 {
+		writeOutput(w, "Session  Channel    Client           Mode       Duration   Bitrate\n")
+		writeOutput(w, "------------------------------------------------------------------\n")
+		for _, s := range status.Sessions {
+			writeOutput(w, "%-8s %-10s %-16s %-10s %-10s %.2f Mbps\n",
+				s.ID, s.Channel, s.ClientIP, s.Mode, s.Duration, s.Bitrate/1e6)
+		}
+		writeOutput(w, "\n")
+	}
+
+	// Write system information
+	writeOutput(w, "HDHomeRun Device: %s\n", status.Device.IP)
+	writeOutput(w, "FFmpeg Path: %s\n", status.Device.FFmpegPath)
+	writeOutput(w, "Stream Timeout: None (streams indefinitely)\n")
+
+	// Append sections from registered status providers
+	t.mutex.Lock()
+	providers := append([]interfaces.StatusProvider(nil), t.statusProviders...)
+	t.mutex.Unlock()
+	for _, provider := range providers {
+		writeOutput(w, "\n")
+		provider.WriteStatus(w)
+	}
+}
@@ -0,0 +1,61 @@
+// Command fakeffmpeg stands in for FFmpeg in transcoder tests. It copies stdin
+// to stdout and is scripted through environment variables:
+//
+//	FAKE_FFMPEG_ARGS            file to write the command line arguments to, one per line
+//	FAKE_FFMPEG_PID             file to write the process ID to
+//	FAKE_FFMPEG_STDERR          lines written to stderr before copying, separated by "\n"
+//	FAKE_FFMPEG_LIMIT           stop after copying this many bytes
+//	FAKE_FFMPEG_EXIT            exit code once copying stops
+//	FAKE_FFMPEG_HANG            "1" to block forever instead of copying
+//	FAKE_FFMPEG_IGNORE_SIGNALS  "1" to ignore SIGINT and SIGTERM
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+func main() {
+	if os.Getenv("FAKE_FFMPEG_IGNORE_SIGNALS") == "1" {
+		signal.Ignore(syscall.SIGINT, syscall.SIGTERM)
+	}
+	if len(os.Args) > 1 && os.Args[1] == "-version" {
+		fmt.Println("ffmpeg version fake Copyright (c) the hdhr-proxy tests")
+		return
+	}
+
+	if path := os.Getenv("FAKE_FFMPEG_ARGS"); path != "" {
+		os.WriteFile(path, []byte(strings.Join(os.Args[1:], "\n")), 0o644)
+	}
+	if path := os.Getenv("FAKE_FFMPEG_PID"); path != "" {
+		os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())), 0o644)
+	}
+	if lines := os.Getenv("FAKE_FFMPEG_STDERR"); lines != "" {
+		for _, line := range strings.Split(lines, "\n") {
+			fmt.Fprintln(os.Stderr, line)
+		}
+	}
+
+	if os.Getenv("FAKE_FFMPEG_HANG") == "1" {
+		// Sleep rather than block on select {}, which the runtime reports
+		// as a deadlock
+		for {
+			time.Sleep(time.Hour)
+		}
+	}
+
+	var src io.Reader = os.Stdin
+	if limit, err := strconv.ParseInt(os.Getenv("FAKE_FFMPEG_LIMIT"), 10, 64); err == nil {
+		src = io.LimitReader(os.Stdin, limit)
+	}
+	io.Copy(os.Stdout, src)
+
+	code, _ := strconv.Atoi(os.Getenv("FAKE_FFMPEG_EXIT"))
+	os.Exit(code)
+}
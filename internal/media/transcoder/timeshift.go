@@ -0,0 +1,258 @@
+package transcoder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/attaebra/hdhr-proxy/internal/constants"
+	"github.com/attaebra/hdhr-proxy/internal/events"
+	"github.com/attaebra/hdhr-proxy/internal/logger"
+	"github.com/attaebra/hdhr-proxy/internal/media/timeshift"
+	"github.com/attaebra/hdhr-proxy/internal/utils"
+)
+
+// feederIdleTimeout is how long a channel keeps its tuner and buffer after
+// the last timeshift viewer leaves, so short reconnects do not retune.
+var feederIdleTimeout = 30 * time.Second
+
+const (
+	// reconnectResumeWindow is how soon a client must reconnect to a channel to
+	// resume automatically where it dropped, without asking for ?resume=1.
+	reconnectResumeWindow = 10 * time.Second
+
+	// timeshiftCopyBufferSize is the chunk size used to send buffered stream data.
+	timeshiftCopyBufferSize = 32 * 1024
+)
+
+// feedWriter adapts a timeshift buffer to http.ResponseWriter so the feeder can
+// use the same streaming pipeline as media clients.
+type feedWriter struct {
+	mutex  sync.Mutex
+	buffer *timeshift.Buffer
+	header http.Header
+	status int
+}
+
+func newFeedWriter(buffer *timeshift.Buffer) *feedWriter {
+	return &feedWriter{buffer: buffer, header: make(http.Header)}
+}
+
+// Header returns the response headers set by the pipeline.
+func (f *feedWriter) Header() http.Header {
+	return f.header
+}
+
+// WriteHeader records the status code. Error responses are not buffered.
+func (f *feedWriter) WriteHeader(status int) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.status == 0 {
+		f.status = status
+	}
+}
+
+// Write appends stream data to the buffer.
+func (f *feedWriter) Write(p []byte) (int, error) {
+	f.mutex.Lock()
+	if f.status == 0 {
+		f.status = http.StatusOK
+	}
+	status := f.status
+	f.mutex.Unlock()
+
+	if status >= http.StatusBadRequest {
+		return len(p), nil // Discard error bodies
+	}
+	return f.buffer.Write(p)
+}
+
+// feedError reports a refused or failed tune to waiting viewers. A stream that
+// ends after delivering data is not an error; viewers drain the buffer instead.
+func (f *feedWriter) feedError(err error) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.status >= http.StatusBadRequest {
+		return &timeshift.FeedError{Status: f.status, Header: f.header.Clone(), Err: err}
+	}
+	return nil
+}
+
+// serveTimeshift streams a channel from its timeshift buffer. A single feeder
+// session per channel tunes the device and fills the buffer, and every viewer
+// reads from the buffer at the live edge, at ?offset=-seconds behind it, or
+// from where it dropped when it reconnects.
+func (t *Impl) serveTimeshift(w http.ResponseWriter, r *http.Request, channel string) error {
+	clientIP := utils.ClientIP(r)
+	behind, err := parseOffset(r.URL.Query().Get("offset"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+	resume, _ := strconv.ParseBool(r.URL.Query().Get("resume"))
+
+	buf, err := t.timeshift.Acquire(channel)
+	if err != nil {
+		t.logger.Error("❌ Failed to create timeshift buffer",
+			logger.String("channel", channel),
+			logger.ErrorField("error", err))
+		http.Error(w, "Failed to create timeshift buffer", http.StatusInternalServerError)
+		return err
+	}
+	defer func() {
+		if buf.RemoveReader() == 0 {
+			time.AfterFunc(feederIdleTimeout, func() {
+				if buf.StopIfIdle() {
+					t.logger.Info("⏹️  Timeshift feeder stopped (no viewers)", logger.String("channel", channel))
+				}
+				t.evictTimeshift(channel, buf)
+			})
+		}
+	}()
+
+	started := t.ensureFeeder(buf, r, channel)
+
+	// Pick the starting position: a saved resume point, an offset behind the
+	// live edge, or the live edge itself
+	var start int64
+	resumed := false
+	savedPos, savedAt, hasSaved := t.timeshift.ResumePosition(clientIP, channel)
+	switch {
+	case hasSaved && behind == 0 && (resume || time.Since(savedAt) < reconnectResumeWindow):
+		start = buf.Align(savedPos)
+		resumed = true
+	case behind > 0:
+		if err := buf.WaitForData(r.Context(), 0); err != nil {
+			return t.timeshiftUnavailable(w, channel, clientIP, err)
+		}
+		start = buf.PositionAt(behind)
+	case started:
+		start = buf.End()
+	default:
+		start = buf.LivePosition()
+	}
+
+	if err := buf.WaitForData(r.Context(), start); err != nil {
+		return t.timeshiftUnavailable(w, channel, clientIP, err)
+	}
+
+	t.logger.Info("⏪ Timeshift stream started",
+		logger.String("channel", channel),
+		logger.String("client_ip", clientIP),
+		logger.Duration("offset", behind),
+		logger.Any("resumed", resumed),
+		logger.Int64("position", start))
+
+	reader := buf.NewReader(r.Context(), start)
+	defer func() {
+		t.timeshift.SavePosition(clientIP, channel, reader.Position())
+	}()
+
+	w.Header().Set("Content-Type", "video/MP2T")
+	written, err := io.CopyBuffer(w, reader, make([]byte, timeshiftCopyBufferSize))
+
+	t.logger.Info("⏹️  Timeshift stream ended",
+		logger.String("channel", channel),
+		logger.String("client_ip", clientIP),
+		logger.Int64("bytes", written))
+
+	if err == nil || errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
+
+// ensureFeeder starts the session that fills a channel's buffer if none is
+// running. The feeder is admitted on behalf of the viewer that started it, so
+// it is classified and counted against that viewer's limits. It reports
+// whether a new feeder was started.
+func (t *Impl) ensureFeeder(buf *timeshift.Buffer, r *http.Request, channel string) bool {
+	ctx, cancel := context.WithCancel(context.Background())
+	if !buf.StartFeeding(cancel) {
+		cancel()
+		return false
+	}
+
+	t.logger.Info("📼 Timeshift feeder starting",
+		logger.String("channel", channel),
+		logger.String("client_ip", utils.ClientIP(r)))
+
+	// A buffer that already holds stream is re-tuning after its feed dropped
+	if buf.End() > 0 {
+		t.events.Publish(events.Event{
+			Type:     events.UpstreamReconnect,
+			Channel:  channel,
+			ClientIP: utils.ClientIP(r),
+			Details:  map[string]interface{}{"buffered_bytes": buf.Size()},
+		})
+	}
+
+	feedReq := r.Clone(ctx)
+	writer := newFeedWriter(buf)
+
+	go func() {
+		defer cancel()
+		err := t.ServeChannel(writer, feedReq, channel, ProfileAuto)
+		buf.StopFeeding(writer.feedError(err))
+
+		t.logger.Info("📼 Timeshift feeder ended",
+			logger.String("channel", channel),
+			logger.ErrorField("error", err))
+		t.evictTimeshift(channel, buf)
+	}()
+	return true
+}
+
+// evictTimeshift frees a channel's buffer once its feeder has stopped and no
+// viewer is left. An idle feeder is only stopped feederIdleTimeout after the
+// last viewer left, well past the reconnectResumeWindow.
+func (t *Impl) evictTimeshift(channel string, buf *timeshift.Buffer) {
+	if t.timeshift.Evict(channel, buf) {
+		t.logger.Debug("🧹 Timeshift buffer freed", logger.String("channel", channel))
+	}
+}
+
+// timeshiftUnavailable answers a viewer whose feeder could not deliver a stream,
+// passing through the feeder's rejection status and headers.
+func (t *Impl) timeshiftUnavailable(w http.ResponseWriter, channel, clientIP string, err error) error {
+	if errors.Is(err, context.Canceled) {
+		return err
+	}
+
+	var feedErr *timeshift.FeedError
+	if errors.As(err, &feedErr) {
+		for _, name := range []string{"Retry-After", constants.HeaderHDHomeRunError} {
+			if value := feedErr.Header.Get(name); value != "" {
+				w.Header().Set(name, value)
+			}
+		}
+		http.Error(w, http.StatusText(feedErr.Status), feedErr.Status)
+		return err
+	}
+
+	t.logger.Warn("⚠️  Timeshift stream unavailable",
+		logger.String("channel", channel),
+		logger.String("client_ip", clientIP),
+		logger.ErrorField("error", err))
+	http.Error(w, "Failed to fetch stream from HDHomeRun", http.StatusBadGateway)
+	return err
+}
+
+// parseOffset parses a ?offset= value in seconds. Offsets are zero or negative
+// (behind the live edge) and are returned as a positive duration.
+func parseOffset(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds > 0 {
+		return 0, fmt.Errorf("invalid offset %q: must be zero or a negative number of seconds", value)
+	}
+	return time.Duration(-seconds) * time.Second, nil
+}
@@ -4,20 +4,25 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"os"
-	"os/exec"
 	"runtime/debug"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/attaebra/hdhr-proxy/internal/config"
+	"github.com/attaebra/hdhr-proxy/internal/constants"
+	"github.com/attaebra/hdhr-proxy/internal/events"
 	"github.com/attaebra/hdhr-proxy/internal/interfaces"
 	"github.com/attaebra/hdhr-proxy/internal/logger"
+	"github.com/attaebra/hdhr-proxy/internal/media/process"
+	"github.com/attaebra/hdhr-proxy/internal/media/session"
+	"github.com/attaebra/hdhr-proxy/internal/media/timeshift"
 
 	"github.com/attaebra/hdhr-proxy/internal/utils"
 )
@@ -32,15 +37,29 @@ type Dependencies struct {
 	StreamHelper      interfaces.Streamer
 	HDHRProxy         interfaces.Proxy
 	SecurityValidator interfaces.SecurityValidator
+	Sessions          *session.Registry
+	Classifier        *session.Classifier
+	Timeshift         *timeshift.Manager       // Optional; nil disables timeshift buffering
+	Events            *events.Bus              // Optional; nil discards lifecycle events
+	Runner            interfaces.ProcessRunner // Optional; nil runs FFmpeg with a process.Runner
 }
 
+const (
+	// rejectRetryAfter is the Retry-After hint sent with admission rejections.
+	rejectRetryAfter = 10 * time.Second
+
+	// preemptionTimeout bounds how long a preempting request waits for the victim to release its tuner.
+	preemptionTimeout = 5 * time.Second
+)
+
 // Impl manages the FFmpeg process for transcoding AC4 to EAC3.
 type Impl struct {
 	FFmpegPath            string
-	InputURL              string
+	InputURL              string       // Device media base URL, guarded by addressMutex once serving
+	addressMutex          sync.RWMutex // Guards InputURL, which follows the device to new addresses
+	deviceMediaPort       int
 	ctx                   context.Context
 	cancel                context.CancelFunc
-	cmd                   *exec.Cmd
 	mutex                 sync.Mutex
 	activeStreams         map[string]time.Time // Track active streams by channel ID
 	proxy                 interfaces.Proxy     // Reference to the proxy for API access
@@ -50,8 +69,22 @@ type Impl struct {
 	maxInactivityDuration time.Duration
 	activityMutex         sync.Mutex
 	stopActivityCheck     context.CancelFunc
-	ffmpegProcesses       map[string]int // Map channel to PID (changed from int->string to string->int)
-	monitoringActive      bool           // Flag to track if monitoring is active
+	ffmpegProcesses       map[string]interfaces.TranscodeProcess // Running FFmpeg process by channel
+	sessionProcesses      map[string]interfaces.TranscodeProcess // Running FFmpeg process by session ID
+	ffmpegExits           map[interfaces.ExitReason]int64        // FFmpeg processes ended, by reason
+	monitoringActive      bool                                   // Flag to track if monitoring is active
+	sessions              *session.Registry                      // Admission control and per-client session tracking
+	classifier            *session.Classifier                    // Maps clients to priority classes
+	sessionCancels        map[string]context.CancelFunc          // Cancels each session's stream by session ID
+	statusProviders       []interfaces.StatusProvider            // Extra sections for the status page
+	timeshift             *timeshift.Manager                     // Per-channel timeshift buffers, nil when disabled
+	lineupRefresh         chan time.Duration                     // Delivers a new lineup refresh interval to the refresher
+	background            sync.WaitGroup                         // The connection monitor and lineup refresher, awaited by Shutdown
+	ffmpegStderr          map[string]*stderrTail                 // Recent FFmpeg output by session ID
+	meters                map[string]*byteMeter                  // Bytes sent by session ID
+	lineup                []interfaces.ChannelInfo               // Last lineup read from the device
+	events                *events.Bus                            // Lifecycle events, nil when not published
+	runner                interfaces.ProcessRunner               // Starts FFmpeg processes
 
 	// Injected dependencies
 	logger            interfaces.Logger            // Structured logger via DI
@@ -75,8 +108,8 @@ func Transcoder(deps *Dependencies) (interfaces.Transcoder, error) {
 	// Create context for the activity checker
 	ctx, cancel := context.WithCancel(context.Background())
 
-	// Ensure the input URL is correctly formatted
-	baseURL := fmt.Sprintf("http://%s:%d", deps.Config.HDHomeRunIP, deps.Config.MediaPort)
+	// Streams are read from the device's media port, not the proxy's
+	baseURL := "http://" + utils.DeviceMediaHost(deps.Config.HDHomeRunIP, deps.Config.DeviceMediaPort)
 	// Note: we'll use the injected logger after t is created
 
 	t := &Impl{
@@ -84,14 +117,26 @@ func Transcoder(deps *Dependencies) (interfaces.Transcoder, error) {
 		proxy:                 deps.HDHRProxy,
 		activeStreams:         make(map[string]time.Time),
 		ac4Channels:           make(map[string]bool),
-		ffmpegProcesses:       make(map[string]int),
+		ffmpegProcesses:       make(map[string]interfaces.TranscodeProcess),
+		sessionProcesses:      make(map[string]interfaces.TranscodeProcess),
+		ffmpegExits:           make(map[interfaces.ExitReason]int64),
 		InputURL:              baseURL,
+		deviceMediaPort:       deps.Config.DeviceMediaPort,
 		connectionActivity:    make(map[string]time.Time),
 		activityCheckInterval: deps.Config.ActivityCheckInterval,
 		maxInactivityDuration: deps.Config.MaxInactivityDuration,
 		ctx:                   ctx,
 		cancel:                cancel,
 		monitoringActive:      false,
+		sessions:              deps.Sessions,
+		classifier:            deps.Classifier,
+		sessionCancels:        make(map[string]context.CancelFunc),
+		timeshift:             deps.Timeshift,
+		events:                deps.Events,
+		runner:                deps.Runner,
+		lineupRefresh:         make(chan time.Duration, 1),
+		ffmpegStderr:          make(map[string]*stderrTail),
+		meters:                make(map[string]*byteMeter),
 
 		// Initialize injected dependencies
 		logger:            deps.Logger,
@@ -102,8 +147,19 @@ func Transcoder(deps *Dependencies) (interfaces.Transcoder, error) {
 		securityValidator: deps.SecurityValidator,
 	}
 
+	// Fall back to an unlimited registry when none is injected
+	if t.sessions == nil {
+		t.sessions = session.NewRegistry(session.Limits{})
+	}
+	if t.classifier == nil {
+		t.classifier = session.NewClassifier(nil)
+	}
+	if t.runner == nil {
+		t.runner = process.NewRunner(deps.Config.FFmpegStopGrace)
+	}
+
 	// Fetch the channel lineup to identify AC4 channels
-	err := t.fetchAC4Channels()
+	err := t.fetchAC4Channels(t.ctx)
 	if err != nil {
 		t.logger.Warn("⚠️  Failed to fetch AC4 channels", logger.ErrorField("error", err))
 	}
@@ -114,15 +170,18 @@ func Transcoder(deps *Dependencies) (interfaces.Transcoder, error) {
 	// Start the connection monitor
 	t.startConnectionMonitor()
 
+	// Keep the AC4 channel list current
+	t.startLineupRefresher(deps.Config.LineupRefreshInterval)
+
 	return t, nil
 }
 
 // fetchAC4Channels fetches the lineup from the HDHomeRun and identifies channels with AC4 audio.
-func (t *Impl) fetchAC4Channels() error {
+func (t *Impl) fetchAC4Channels(ctx context.Context) error {
 	defer utils.TimeOperation("Fetch AC4 channels")()
 
-	// Create the request
-	req, err := http.NewRequest("GET", fmt.Sprintf("http://%s/lineup.json", t.proxy.GetHDHRIP()), nil)
+	// Create the request, abandoned with ctx so it does not wait for a hung device
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("http://%s/lineup.json", t.proxy.GetHDHRIP()), nil)
 	if err != nil {
 		return utils.LogAndWrapError(err, "failed to create request")
 	}
@@ -142,27 +201,20 @@ func (t *Impl) fetchAC4Channels() error {
 	}
 
 	// Parse the response body
-	var lineup []struct {
-		GuideNumber string `json:"GuideNumber"`
-		GuideName   string `json:"GuideName"`
-		URL         string `json:"URL"`
-		HD          int    `json:"HD"`
-		Favorite    int    `json:"Favorite"`
-		AudioCodec  string `json:"AudioCodec"` // Audio codec field
-		VideoCodec  string `json:"VideoCodec"` // Video codec field
-	}
+	var lineup []interfaces.ChannelInfo
 
 	if err := json.NewDecoder(resp.Body).Decode(&lineup); err != nil {
 		return utils.LogAndWrapError(err, "failed to parse lineup")
 	}
 
 	ac4Count := 0
+	ac4Channels := make(map[string]bool, len(lineup))
 	// Check for AC4 audio codec
 	for _, channel := range lineup {
 		// Use AudioCodec field to directly identify AC4 channels
 		hasAC4 := strings.ToUpper(channel.AudioCodec) == "AC4"
 
-		t.ac4Channels[channel.GuideNumber] = hasAC4
+		ac4Channels[channel.GuideNumber] = hasAC4
 
 		if hasAC4 {
 			ac4Count++
@@ -180,6 +232,19 @@ func (t *Impl) fetchAC4Channels() error {
 		}
 	}
 
+	t.mutex.Lock()
+	previous := t.ac4Channels
+	t.ac4Channels = ac4Channels
+	t.lineup = lineup
+	t.mutex.Unlock()
+
+	if added, removed, changed := diffLineup(previous, ac4Channels); len(previous) > 0 && added+removed+changed > 0 {
+		t.events.Publish(events.Event{
+			Type:    events.LineupChanged,
+			Details: map[string]interface{}{"added": added, "removed": removed, "audio_changed": changed, "total": len(lineup)},
+		})
+	}
+
 	t.logger.Info("📊 Channel lineup analyzed",
 		logger.Int("ac4_channels", ac4Count),
 		logger.Int("total_channels", len(lineup)))
@@ -187,6 +252,26 @@ func (t *Impl) fetchAC4Channels() error {
 	return nil
 }
 
+// diffLineup counts channels added, removed and with a changed audio format
+// between two lineups keyed by guide number.
+func diffLineup(previous, current map[string]bool) (added, removed, changed int) {
+	for channel, isAC4 := range current {
+		wasAC4, existed := previous[channel]
+		switch {
+		case !existed:
+			added++
+		case wasAC4 != isAC4:
+			changed++
+		}
+	}
+	for channel := range previous {
+		if _, exists := current[channel]; !exists {
+			removed++
+		}
+	}
+	return added, removed, changed
+}
+
 // getDefaultString returns the default value if the input is empty.
 func getDefaultString(input, defaultVal string) string {
 	if input == "" {
@@ -237,40 +322,52 @@ func (t *Impl) setupStreamConnection(w http.ResponseWriter, r *http.Request, cha
 		logger.String("channel", channel),
 		logger.Int("active_streams", activeCount))
 	t.logger.Debug("🔗 Stream connection",
-		logger.String("input_url", fmt.Sprintf("%s/auto/v%s", t.InputURL, channel)))
+		logger.String("input_url", fmt.Sprintf("%s/auto/v%s", t.inputURL(), channel)))
 
 	// Create a context that will be canceled when the client disconnects
 	ctx, cancel := context.WithCancel(r.Context())
 
-	// Use the streaming client (no timeout) for media streaming operations
-	client := t.streamClient
-	t.logger.Debug("🚰 Using streaming client with no timeout")
+	// Register the cancel func so StopActiveStream can end this session
+	sess, hasSession := session.FromContext(r.Context())
+	if hasSession {
+		t.mutex.Lock()
+		t.sessionCancels[sess.ID] = cancel
+		t.mutex.Unlock()
+	}
 
 	// Create the request
-	sourceURL := fmt.Sprintf("%s/auto/v%s", t.InputURL, channel)
-	t.logger.Debug("🌐 Connecting to source", logger.String("url", sourceURL))
-	req, err := http.NewRequestWithContext(ctx, "GET", sourceURL, nil)
+	sourceURL := fmt.Sprintf("%s/auto/v%s", t.inputURL(), channel)
+	resp, err := t.requestUpstream(ctx, sourceURL)
 	if err != nil {
 		cancel()
-		t.logger.Error("❌ Failed to create HTTP request", logger.ErrorField("error", err))
-		http.Error(w, "Failed to create HTTP request", http.StatusInternalServerError)
-		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+		t.logger.Error("❌ Failed to fetch stream", logger.ErrorField("error", err))
+		http.Error(w, "Failed to fetch stream from HDHomeRun", http.StatusBadGateway)
+		return nil, fmt.Errorf("failed to fetch stream: %w", err)
 	}
 
-	// Add default headers
-	req.Header.Set("User-Agent", "hdhr-proxy/1.0")
+	// The device refused the tune because all tuners are busy; try to free one
+	// by preempting a lower priority session and tune again
+	if resp.StatusCode == http.StatusServiceUnavailable && hasSession {
+		if victim := t.sessions.PreemptionCandidate(sess.Priority); victim != nil && t.preempt(victim, requestFromSession(sess)) {
+			resp.Body.Close()
+			t.logger.Info("🔁 Retrying tune after preemption", logger.String("channel", channel))
+			resp, err = t.requestUpstream(ctx, sourceURL)
+			if err != nil {
+				cancel()
+				t.logger.Error("❌ Failed to fetch stream", logger.ErrorField("error", err))
+				http.Error(w, "Failed to fetch stream from HDHomeRun", http.StatusBadGateway)
+				return nil, fmt.Errorf("failed to fetch stream: %w", err)
+			}
+		}
+	}
 
-	// Execute the request
-	t.logger.Debug("📡 Sending request to HDHomeRun...")
-	connStart := time.Now()
-	resp, err := client.Do(req)
-	if err != nil {
+	// Pass the device's tuner exhaustion through as a capacity refusal
+	if resp.StatusCode == http.StatusServiceUnavailable {
+		resp.Body.Close()
 		cancel()
-		t.logger.Error("❌ Failed to fetch stream", logger.ErrorField("error", err))
-		http.Error(w, "Failed to fetch stream from HDHomeRun", http.StatusBadGateway)
-		return nil, fmt.Errorf("failed to fetch stream: %w", err)
+		t.rejectStream(w, channel, utils.ClientIP(r), &session.RejectError{Reason: session.ReasonTotalLimit})
+		return nil, fmt.Errorf("all tuners busy on HDHomeRun")
 	}
-	t.logger.Debug("✅ Connected to HDHomeRun", logger.Duration("connect_time", time.Since(connStart)))
 
 	// Check response status
 	t.logger.Debug("📨 Received response", logger.Int("status_code", resp.StatusCode))
@@ -299,7 +396,7 @@ func (t *Impl) setupStreamConnection(w http.ResponseWriter, r *http.Request, cha
 		<-clientCtx.Done()
 		t.logger.Debug("🔌 Client disconnected, cleaning up resources",
 			logger.String("channel", channel))
-		t.StopActiveStream(channel)
+		t.stopStream(ctx, channel)
 	}()
 
 	return &StreamSetup{
@@ -311,6 +408,113 @@ func (t *Impl) setupStreamConnection(w http.ResponseWriter, r *http.Request, cha
 	}, nil
 }
 
+// startedKey is the context key for the func that announces a running stream.
+type startedKey struct{}
+
+// withStarted returns a copy of ctx whose stream calls started, at most once,
+// when its pipeline is running.
+func withStarted(ctx context.Context, started func()) context.Context {
+	var once sync.Once
+	return context.WithValue(ctx, startedKey{}, func() { once.Do(started) })
+}
+
+// markStarted reports that the stream on ctx tuned the channel and, when
+// transcoding, started FFmpeg.
+func markStarted(ctx context.Context) {
+	if started, ok := ctx.Value(startedKey{}).(func()); ok {
+		started()
+	}
+}
+
+// requestUpstream opens the stream request to the HDHomeRun using the streaming client.
+func (t *Impl) requestUpstream(ctx context.Context, sourceURL string) (*http.Response, error) {
+	// Use the streaming client (no timeout) for media streaming operations
+	t.logger.Debug("🌐 Connecting to source", logger.String("url", sourceURL))
+	req, err := http.NewRequestWithContext(ctx, "GET", sourceURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+
+	// Add default headers
+	req.Header.Set("User-Agent", "hdhr-proxy/1.0")
+
+	// Execute the request
+	t.logger.Debug("📡 Sending request to HDHomeRun...")
+	connStart := time.Now()
+	resp, err := t.streamClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	t.logger.Debug("✅ Connected to HDHomeRun", logger.Duration("connect_time", time.Since(connStart)))
+	return resp, nil
+}
+
+// admit applies admission control to a new stream. When the total stream limit is
+// reached, a lower priority session is evicted and its slot given to the
+// request, then the victim's stream is ended before the request tunes.
+func (t *Impl) admit(req session.Request) (*session.Session, error) {
+	sess, victim, err := t.sessions.AdmitPreempting(req)
+	if err != nil || victim == nil {
+		return sess, err
+	}
+
+	if !t.preempt(victim, req) {
+		t.sessions.Release(sess)
+		return nil, &session.RejectError{Reason: session.ReasonTotalLimit, Limit: t.sessions.Limits().MaxTotal}
+	}
+	return sess, nil
+}
+
+// preempt ends a lower priority session and waits for it to release its tuner.
+// Other sessions on the victim's channel keep running. It reports whether the
+// session was released in time.
+func (t *Impl) preempt(victim *session.Session, req session.Request) bool {
+	t.logger.Warn("⚔️  Preempting lower priority stream",
+		logger.String("victim_channel", victim.Channel),
+		logger.String("victim_client_ip", victim.ClientIP),
+		logger.String("victim_class", victim.Class),
+		logger.Int("victim_priority", victim.Priority),
+		logger.String("channel", req.Channel),
+		logger.String("client_ip", req.ClientIP),
+		logger.String("class", req.Class),
+		logger.Int("priority", req.Priority))
+
+	t.sessions.RecordPreemption(victim, req)
+	t.stopSession(victim)
+
+	select {
+	case <-victim.Done():
+		return true
+	case <-time.After(preemptionTimeout):
+		t.logger.Warn("⚠️  Preempted stream did not release its tuner in time",
+			logger.String("victim_channel", victim.Channel),
+			logger.Duration("timeout", preemptionTimeout))
+		return false
+	}
+}
+
+// releaseSession forgets a session's cancel func and frees its admission slot.
+func (t *Impl) releaseSession(sess *session.Session) {
+	t.mutex.Lock()
+	delete(t.sessionCancels, sess.ID)
+	delete(t.ffmpegStderr, sess.ID)
+	delete(t.meters, sess.ID)
+	t.mutex.Unlock()
+
+	t.sessions.Release(sess)
+}
+
+// requestFromSession rebuilds the admission request that created a session.
+func requestFromSession(s *session.Session) session.Request {
+	return session.Request{
+		Channel:  s.Channel,
+		ClientIP: s.ClientIP,
+		Mode:     s.Mode,
+		Class:    s.Class,
+		Priority: s.Priority,
+	}
+}
+
 // cleanupStream handles cleanup after streaming is complete.
 func (t *Impl) cleanupStream(setup *StreamSetup, channel string, streamType string) {
 	if r := recover(); r != nil {
@@ -350,6 +554,7 @@ func (t *Impl) DirectStreamChannel(w http.ResponseWriter, r *http.Request, chann
 
 	// Cleanup when done
 	defer t.cleanupStream(setup, channel, "Direct streaming")
+	markStarted(setup.Context)
 
 	// Use our stream copy instead of simple io.Copy
 	t.logger.Debug("📺 Starting direct stream copy", logger.String("channel", channel))
@@ -365,7 +570,7 @@ func (t *Impl) DirectStreamChannel(w http.ResponseWriter, r *http.Request, chann
 				logger.String("channel", channel),
 				logger.ErrorField("error", err))
 			// Ensure we clean up resources when the client disconnects
-			t.StopActiveStream(channel)
+			t.stopStream(r.Context(), channel)
 			return nil // Client disconnection is not an error we need to report
 		}
 		t.logger.Error("❌ Stream copy error", logger.ErrorField("error", err))
@@ -418,6 +623,138 @@ func (t *Impl) Stop() {
 	t.activeStreams = make(map[string]time.Time)
 }
 
+// Stream profiles select how ServeChannel delivers a channel.
+const (
+	ProfileAuto      = "auto"      // Transcode AC4 channels, pass everything else through
+	ProfileDirect    = "direct"    // Always pass the stream through untouched
+	ProfileTranscode = "transcode" // Always transcode through FFmpeg
+)
+
+// ServeChannel runs admission control and streams a channel to w using the
+// direct or transcoding pipeline selected by the profile. It is the shared
+// entry point for media clients and internal consumers such as the DVR.
+func (t *Impl) ServeChannel(w http.ResponseWriter, r *http.Request, channel string, profile string) (err error) {
+	// Decide the delivery mode and apply admission control before tuning
+	var transcode bool
+	switch profile {
+	case ProfileDirect:
+		transcode = false
+	case ProfileTranscode:
+		transcode = true
+	default:
+		transcode = t.isAC4Channel(channel)
+	}
+
+	mode := session.ModeDirect
+	if transcode {
+		mode = session.ModeTranscode
+	}
+
+	clientIP := utils.ClientIP(r)
+	_, classifier := t.currentSettings()
+	class, priority := classifier.Classify(clientIP, utils.RequestToken(r))
+	sess, err := t.admit(session.Request{
+		Channel:  channel,
+		ClientIP: clientIP,
+		Mode:     mode,
+		Class:    class,
+		Priority: priority,
+	})
+	if err != nil {
+		t.rejectStream(w, channel, clientIP, err)
+		return err
+	}
+
+	// Announce the stream once the pipeline is running, so a failed tune
+	// is reported as a failure rather than a start and an end
+	var started atomic.Bool
+	ctx := session.NewContext(r.Context(), sess)
+	r = r.WithContext(withStarted(ctx, func() {
+		started.Store(true)
+		t.events.Publish(events.Event{
+			Type:      events.StreamStarted,
+			Channel:   channel,
+			ClientIP:  clientIP,
+			SessionID: sess.ID,
+			Details:   map[string]interface{}{"mode": string(mode), "class": class, "priority": priority},
+		})
+	}))
+
+	// Count what the client receives for the live bitrate
+	meter := &byteMeter{}
+	t.mutex.Lock()
+	t.meters[sess.ID] = meter
+	t.mutex.Unlock()
+	w = &meteredWriter{ResponseWriter: w, meter: meter}
+
+	defer func() {
+		// Release first so subscribers reading the state see the stream gone
+		t.releaseSession(sess)
+		if !started.Load() {
+			reason := "stream ended before it started"
+			if err != nil {
+				reason = err.Error()
+			}
+			t.events.Publish(events.Event{
+				Type:      events.StreamFailed,
+				Channel:   channel,
+				ClientIP:  clientIP,
+				SessionID: sess.ID,
+				Details:   map[string]interface{}{"reason": reason},
+			})
+			return
+		}
+		bytesSent, _ := meter.read(time.Now())
+		t.events.Publish(events.Event{
+			Type:      events.StreamEnded,
+			Channel:   channel,
+			ClientIP:  clientIP,
+			SessionID: sess.ID,
+			Details: map[string]interface{}{
+				"duration_seconds": time.Since(sess.StartTime).Seconds(),
+				"bytes_sent":       bytesSent,
+			},
+		})
+	}()
+
+	// Check if this channel has AC4 audio needing transcoding
+	if transcode {
+		t.logger.Info("🎵 AC4 transcoding started",
+			logger.String("channel", channel),
+			logger.String("from", "AC4"),
+			logger.String("to", "EAC3"))
+		if err := t.TranscodeChannel(w, r, channel); err != nil {
+			t.logger.Error("❌ Transcoding error",
+				logger.String("channel", channel),
+				logger.ErrorField("error", err))
+			// Error already sent to client by TranscodeChannel
+			return err
+		}
+		return nil
+	}
+
+	// For channels without AC4 audio, stream directly without transcoding
+	t.logger.Info("📡 Direct streaming",
+		logger.String("channel", channel),
+		logger.String("mode", "pass-through"),
+		logger.String("reason", "non-AC4 audio"))
+	if err := t.DirectStreamChannel(w, r, channel); err != nil {
+		t.logger.Error("❌ Direct streaming error",
+			logger.String("channel", channel),
+			logger.ErrorField("error", err))
+		// Error already handled by DirectStreamChannel
+		return err
+	}
+	return nil
+}
+
+// RegisterStatusProvider adds a section to the /status output.
+func (t *Impl) RegisterStatusProvider(provider interfaces.StatusProvider) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.statusProviders = append(t.statusProviders, provider)
+}
+
 // MediaHandler returns a http.Handler for the media endpoints.
 func (t *Impl) MediaHandler() http.Handler {
 	mux := http.NewServeMux()
@@ -447,30 +784,17 @@ func (t *Impl) MediaHandler() http.Handler {
 			return
 		}
 
-		// Check if this channel has AC4 audio needing transcoding
-		if t.isAC4Channel(channel) {
-			t.logger.Info("🎵 AC4 transcoding started",
-				logger.String("channel", channel),
-				logger.String("from", "AC4"),
-				logger.String("to", "EAC3"))
-			if err := t.TranscodeChannel(w, r, channel); err != nil {
-				t.logger.Error("❌ Transcoding error",
-					logger.String("channel", channel),
-					logger.ErrorField("error", err))
-				// Error already sent to client by TranscodeChannel
-			}
+		// Channels with a timeshift buffer are served from the buffer
+		var err error
+		if t.timeshift != nil && t.timeshift.Enabled(channel) {
+			err = t.serveTimeshift(w, r, channel)
 		} else {
-			// For channels without AC4 audio, stream directly without transcoding
-			t.logger.Info("📡 Direct streaming",
+			err = t.ServeChannel(w, r, channel, ProfileAuto)
+		}
+		if err != nil {
+			t.logger.Debug("❌ Channel stream ended with error",
 				logger.String("channel", channel),
-				logger.String("mode", "pass-through"),
-				logger.String("reason", "non-AC4 audio"))
-			if err := t.DirectStreamChannel(w, r, channel); err != nil {
-				t.logger.Error("❌ Direct streaming error",
-					logger.String("channel", channel),
-					logger.ErrorField("error", err))
-				// Error already handled by DirectStreamChannel
-			}
+				logger.ErrorField("error", err))
 		}
 
 		t.logger.Debug("✅ Media handler completed",
@@ -478,97 +802,72 @@ func (t *Impl) MediaHandler() http.Handler {
 			logger.String("client_ip", remoteAddr))
 	})
 
-	// Add a helper function to write output and log it at debug level
-	writeOutput := func(w http.ResponseWriter, format string, args ...interface{}) {
-		msg := fmt.Sprintf(format, args...)
-		t.logger.Debug("📊 Status output", logger.String("content", strings.TrimSpace(msg)))
-		fmt.Fprint(w, msg)
-	}
-
 	// Status endpoint handler
-	mux.HandleFunc("/status", func(w http.ResponseWriter, _ *http.Request) {
-		t.logger.Info("📊 Status endpoint accessed")
+	mux.HandleFunc("/status", t.serveStatus)
 
-		t.mutex.Lock()
-		activeStreams := len(t.activeStreams)
-
-		// Create a copy of the active streams data for display
-		streams := make(map[string]float64)
-		channelIsAC4 := make(map[string]bool)
-
-		for channel, startTime := range t.activeStreams {
-			streams[channel] = time.Since(startTime).Seconds()
-			channelIsAC4[channel] = t.ac4Channels[channel]
-		}
-
-		// Count AC4 channels
-		ac4Count := 0
-		for _, isAC4 := range t.ac4Channels {
-			if isAC4 {
-				ac4Count++
-			}
-		}
-
-		totalChannels := len(t.ac4Channels)
-		t.mutex.Unlock()
+	return mux
+}
 
-		w.Header().Set("Content-Type", "text/plain")
-		writeOutput(w, "HDHomeRun AC4 Proxy Status\n")
-		writeOutput(w, "=========================\n")
-		writeOutput(w, "Active Streams: %d\n", activeStreams)
-		writeOutput(w, "Total Channels: %d\n", totalChannels)
-		writeOutput(w, "AC4 Audio Channels: %d\n\n", ac4Count)
-
-		if activeStreams > 0 {
-			writeOutput(w, "Channel    Duration (s)  Transcoding\n")
-			writeOutput(w, "-----------------------------------\n")
-			for channel, duration := range streams {
-				isAC4 := channelIsAC4[channel]
-				transcoding := "No"
-				if isAC4 {
-					transcoding = "Yes (AC4→EAC3)"
-				}
-				writeOutput(w, "%-10s %-12.2f %s\n", channel, duration, transcoding)
-			}
-			writeOutput(w, "\n")
-		}
+// rejectStream answers a refused media request with 503, a Retry-After hint and an
+// HDHomeRun-style error header so clients can tell a capacity refusal from a failure.
+func (t *Impl) rejectStream(w http.ResponseWriter, channel, clientIP string, err error) {
+	hdhrError := constants.HDHomeRunErrorSystemBusy
+	var rejectErr *session.RejectError
+	if errors.As(err, &rejectErr) && rejectErr.Reason == session.ReasonTotalLimit {
+		hdhrError = constants.HDHomeRunErrorAllTunersInUse
+	}
 
-		// Write system information
-		writeOutput(w, "HDHomeRun Device: %s\n", t.proxy.GetHDHRIP())
-		writeOutput(w, "FFmpeg Path: %s\n", t.FFmpegPath)
-		writeOutput(w, "Stream Timeout: None (streams indefinitely)\n")
+	t.logger.Warn("🚫 Stream rejected",
+		logger.String("channel", channel),
+		logger.String("client_ip", clientIP),
+		logger.String("hdhr_error", hdhrError),
+		logger.ErrorField("error", err))
+
+	t.events.Publish(events.Event{
+		Type:     events.TunerBusy,
+		Channel:  channel,
+		ClientIP: clientIP,
+		Details:  map[string]interface{}{"hdhr_error": hdhrError, "reason": err.Error()},
 	})
 
-	return mux
+	w.Header().Set("Retry-After", strconv.Itoa(int(rejectRetryAfter.Seconds())))
+	w.Header().Set(constants.HeaderHDHomeRunError, hdhrError)
+	http.Error(w, hdhrError, http.StatusServiceUnavailable)
+}
+
+// formatLimit renders an admission limit for the status page.
+func formatLimit(limit int) string {
+	if limit <= 0 {
+		return "unlimited"
+	}
+	return strconv.Itoa(limit)
 }
 
-// StopAllTranscoding stops any running transcoding processes.
+// StopAllTranscoding ends every active stream and its FFmpeg process.
 func (t *Impl) StopAllTranscoding() {
 	t.mutex.Lock()
-	defer t.mutex.Unlock()
+	channels := make([]string, 0, len(t.activeStreams))
+	for channel := range t.activeStreams {
+		channels = append(channels, channel)
+	}
+	t.mutex.Unlock()
 
-	activeStreams := len(t.activeStreams)
 	t.logger.Info("🛑 Stopping all transcoding processes",
-		logger.Int("active_streams", activeStreams))
-
-	if t.cancel != nil {
-		t.cancel()
-		t.cancel = nil
+		logger.Int("active_streams", len(channels)))
+
+	// Stop the streams in parallel so their FFmpeg grace periods overlap
+	var wg sync.WaitGroup
+	for _, channel := range channels {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			t.StopActiveStream(channel)
+		}()
 	}
+	wg.Wait()
 
-	if t.cmd != nil && t.cmd.Process != nil {
-		pid := t.cmd.Process.Pid
-		t.logger.Debug("🔫 Killing ffmpeg process", logger.Int("pid", pid))
-
-		killErr := t.cmd.Process.Kill()
-		if killErr != nil {
-			t.logger.Error("❌ Error killing ffmpeg process", logger.ErrorField("error", killErr))
-		} else {
-			t.logger.Debug("✅ Successfully killed ffmpeg process", logger.Int("pid", pid))
-		}
-
-		t.cmd = nil
-	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
 
 	// Clear active streams
 	t.activeStreams = make(map[string]time.Time)
@@ -596,7 +895,9 @@ func (t *Impl) startConnectionMonitor() {
 		logger.Duration("check_interval", t.activityCheckInterval),
 		logger.Duration("max_inactivity", t.maxInactivityDuration))
 
+	t.background.Add(1)
 	go func() {
+		defer t.background.Done()
 		ticker := time.NewTicker(t.activityCheckInterval)
 		defer ticker.Stop()
 
@@ -654,89 +955,79 @@ func (t *Impl) startFFmpeg(ctx context.Context, w http.ResponseWriter, r io.Read
 	}
 
 	// Use the optimized FFmpeg config with improved parameters
-	cmd := exec.CommandContext(ctx, t.FFmpegPath, t.FFmpegConfig.BuildArgs()...)
-
-	// Get pipes for stdin, stdout, and stderr
-	stdin, err := cmd.StdinPipe()
-	if err != nil {
-		t.logger.Error("❌ Failed to get stdin pipe", logger.ErrorField("error", err))
-		http.Error(w, "Failed to start ffmpeg", http.StatusInternalServerError)
-		return fmt.Errorf("failed to get stdin pipe: %w", err)
-	}
-
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		t.logger.Error("❌ Failed to get stdout pipe", logger.ErrorField("error", err))
-		http.Error(w, "Failed to start ffmpeg", http.StatusInternalServerError)
-		return fmt.Errorf("failed to get stdout pipe: %w", err)
-	}
-
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		t.logger.Error("❌ Failed to get stderr pipe", logger.ErrorField("error", err))
-		http.Error(w, "Failed to start ffmpeg", http.StatusInternalServerError)
-		return fmt.Errorf("failed to get stderr pipe: %w", err)
-	}
+	ffmpegConfig, _ := t.currentSettings()
 
 	// Start FFmpeg
 	t.logger.Debug("🚀 Starting ffmpeg process...")
 	ffmpegStart := time.Now()
-	if err := cmd.Start(); err != nil {
+	proc, err := t.runner.Start(ctx, t.FFmpegPath, ffmpegConfig.BuildArgs()...)
+	if err != nil {
 		t.logger.Error("❌ Failed to start ffmpeg", logger.ErrorField("error", err))
 		http.Error(w, "Failed to start ffmpeg", http.StatusInternalServerError)
 		return fmt.Errorf("failed to start ffmpeg: %w", err)
 	}
+	stdin, stdout, stderr := proc.Stdin(), proc.Stdout(), proc.Stderr()
 
-	ffmpegPid := cmd.Process.Pid
+	ffmpegPid := proc.Pid()
 	t.logger.Debug("✅ ffmpeg process started",
 		logger.Int("pid", ffmpegPid),
 		logger.Duration("startup_time", time.Since(ffmpegStart)))
 
-	// Store the ffmpeg process ID
+	// Track the process so the stream can be stopped by channel or session
+	var sessionID string
+	if sess, ok := session.FromContext(ctx); ok {
+		sessionID = sess.ID
+	}
 	t.mutex.Lock()
-	t.ffmpegProcesses[channel] = ffmpegPid // Changed to store PID by channel
+	t.ffmpegProcesses[channel] = proc
+	if sessionID != "" {
+		t.sessionProcesses[sessionID] = proc
+	}
 	t.mutex.Unlock()
+	markStarted(ctx)
+	t.events.Publish(events.Event{
+		Type:      events.TranscodeStarted,
+		Channel:   channel,
+		SessionID: sessionID,
+		Details:   map[string]interface{}{"pid": ffmpegPid},
+	})
+
+	var ac4ErrorCount int32 // Total AC4 errors for logging
 
-	// Set up a defer to kill the ffmpeg process if needed
-	var cleanupDone int32 // Atomic flag to prevent double cleanup
+	// Stop the ffmpeg process, and any children it spawned, when the stream
+	// ends, and record how it exited
 	defer func() {
-		// Use atomic CAS to ensure cleanup only happens once
-		if atomic.CompareAndSwapInt32(&cleanupDone, 0, 1) {
-			t.logger.Debug("🔫 Cleaning up ffmpeg process", logger.Int("pid", ffmpegPid))
-
-			// Check if process is still alive before attempting to kill
-			if process := cmd.Process; process != nil {
-				// Try to get process state first
-				if processState := cmd.ProcessState; processState == nil || !processState.Exited() {
-					if err := process.Kill(); err != nil {
-						// Only log error if it's not "process already finished"
-						if !strings.Contains(err.Error(), "process already finished") &&
-							!strings.Contains(err.Error(), "no such process") {
-							t.logger.Error("❌ Failed to kill ffmpeg process", logger.ErrorField("error", err))
-						}
-					} else {
-						t.logger.Debug("✅ Successfully killed ffmpeg process", logger.Int("pid", ffmpegPid))
-					}
-				}
-			}
+		t.logger.Debug("🔫 Cleaning up ffmpeg process", logger.Int("pid", ffmpegPid))
+		if err := proc.Stop(); err != nil {
+			t.logger.Error("❌ Failed to stop ffmpeg process", logger.ErrorField("error", err))
+		}
 
-			t.mutex.Lock()
+		t.mutex.Lock()
+		if t.ffmpegProcesses[channel] == proc {
 			delete(t.ffmpegProcesses, channel)
-			t.mutex.Unlock()
 		}
+		delete(t.sessionProcesses, sessionID)
+		t.mutex.Unlock()
+
+		t.recordExit(channel, sessionID, proc, int(atomic.LoadInt32(&ac4ErrorCount)))
 	}()
 
 	// Create a scanner to read from stderr for debugging
 	scanner := bufio.NewScanner(stderr)
-	var ac4ErrorCount int32                     // Total AC4 errors for logging
+	tail := t.trackStderr(ctx)
 	var consecutiveErrors int32                 // Consecutive errors in a short timeframe
 	var lastErrorTime int64                     // Timestamp of last error (Unix nanoseconds)
 	const errorResetInterval = 30 * time.Second // Reset consecutive counter after 30 seconds
 	const maxConsecutiveErrors = 20             // Allow up to 20 consecutive errors before warning
 
+	stderrDone := make(chan struct{})
 	go func() {
+		defer close(stderrDone)
 		for scanner.Scan() {
 			line := scanner.Text()
+			if tail != nil {
+				tail.add(line)
+			}
 			t.logger.Debug("🎬 ffmpeg output",
 				logger.Int("pid", ffmpegPid),
 				logger.String("output", line))
@@ -792,6 +1083,18 @@ func (t *Impl) startFFmpeg(ctx context.Context, w http.ResponseWriter, r io.Read
 						logger.Int("total_errors", int(totalCount)),
 						logger.Int("consecutive", int(consecutiveCount)))
 				default:
+					if consecutiveCount == maxConsecutiveErrors+1 {
+						t.events.Publish(events.Event{
+							Type:      events.AC4ErrorBurst,
+							Channel:   channel,
+							SessionID: sessionID,
+							Details: map[string]interface{}{
+								"consecutive": int(consecutiveCount),
+								"total":       int(totalCount),
+								"error_type":  errorType,
+							},
+						})
+					}
 					t.logger.Error("🚨 High AC4 error rate - stream quality issues",
 						logger.String("channel", channel),
 						logger.String("error_type", errorType),
@@ -864,7 +1167,7 @@ func (t *Impl) startFFmpeg(ctx context.Context, w http.ResponseWriter, r io.Read
 		<-clientCtx.Done()
 		t.logger.Debug("🔌 Client disconnected, cleaning up FFmpeg resources",
 			logger.String("channel", channel))
-		t.StopActiveStream(channel)
+		t.stopStream(ctx, channel)
 	}()
 
 	// Make sure we cancel the client context when we're done
@@ -884,7 +1187,7 @@ func (t *Impl) startFFmpeg(ctx context.Context, w http.ResponseWriter, r io.Read
 				logger.String("channel", channel),
 				logger.ErrorField("error", err))
 			// Ensure we clean up resources when the client disconnects
-			t.StopActiveStream(channel)
+			t.stopStream(ctx, channel)
 			return nil // Client disconnection is not an error we need to report
 		}
 		t.logger.Error("❌ FFmpeg → Client copy error", logger.ErrorField("error", err))
@@ -895,8 +1198,9 @@ func (t *Impl) startFFmpeg(ctx context.Context, w http.ResponseWriter, r io.Read
 		logger.String("channel", channel),
 		logger.Int64("bytes_copied", bytesCopied))
 
-	// Wait for ffmpeg to exit
-	if err := cmd.Wait(); err != nil {
+	// Wait for ffmpeg to exit, after its last stderr lines have been counted
+	<-stderrDone
+	if err := proc.Wait(); err != nil {
 		// For AC4 streams, decoding errors are common and expected in live TV
 		// We should never terminate the stream just because of AC4 decoding errors
 		finalErrorCount := atomic.LoadInt32(&ac4ErrorCount)
@@ -919,54 +1223,152 @@ func (t *Impl) startFFmpeg(ctx context.Context, w http.ResponseWriter, r io.Read
 	return nil
 }
 
+// recordExit logs and counts how an FFmpeg process ended and publishes its
+// exit event.
+func (t *Impl) recordExit(channel, sessionID string, proc interfaces.TranscodeProcess, ac4Errors int) {
+	reason := proc.ExitReason()
+
+	t.mutex.Lock()
+	t.ffmpegExits[reason]++
+	t.mutex.Unlock()
+
+	fields := []interfaces.Field{
+		logger.String("channel", channel),
+		logger.Int("pid", proc.Pid()),
+		logger.String("reason", string(reason)),
+		logger.Int("exit_code", proc.ExitCode()),
+	}
+	switch reason {
+	case interfaces.ExitForced:
+		t.logger.Warn("🔪 FFmpeg process killed after the grace period", fields...)
+	case interfaces.ExitCrashed:
+		t.logger.Warn("💥 FFmpeg process crashed", fields...)
+	default:
+		t.logger.Debug("🏁 FFmpeg process exited", fields...)
+	}
+
+	t.events.Publish(events.Event{
+		Type:      events.FFmpegExited,
+		Channel:   channel,
+		SessionID: sessionID,
+		Details: map[string]interface{}{
+			"pid":        proc.Pid(),
+			"exit_code":  proc.ExitCode(),
+			"reason":     string(reason),
+			"ac4_errors": ac4Errors,
+		},
+	})
+}
+
 // StopActiveStream stops and cleans up resources for a specific channel stream.
 func (t *Impl) StopActiveStream(channel string) {
 	t.mutex.Lock()
-	defer t.mutex.Unlock()
 
 	// Check if the stream is still active
 	_, streamActive := t.activeStreams[channel]
 	if !streamActive {
 		// Stream already stopped
+		t.mutex.Unlock()
 		return
 	}
 
 	// Remove the active stream
 	delete(t.activeStreams, channel)
 
-	// Look for any ffmpeg processes for this channel and stop them
-	if pid, exists := t.ffmpegProcesses[channel]; exists {
+	// Cancel the stream contexts of every session on this channel
+	for _, sess := range t.sessions.List() {
+		if cancel, exists := t.sessionCancels[sess.ID]; exists && sess.Channel == channel {
+			cancel()
+		}
+	}
+
+	proc, hasProcess := t.ffmpegProcesses[channel]
+	delete(t.ffmpegProcesses, channel)
+	t.mutex.Unlock()
+
+	// Stop the channel's ffmpeg process outside the lock, as it may take the
+	// whole grace period to exit
+	if hasProcess {
 		t.logger.Debug("🔫 Stopping ffmpeg process",
-			logger.Int("pid", pid),
+			logger.Int("pid", proc.Pid()),
 			logger.String("channel", channel))
-		process, err := os.FindProcess(pid)
-		if err == nil {
-			if killErr := process.Kill(); killErr != nil {
-				// Only log error if it's not "process already finished"
-				if !strings.Contains(killErr.Error(), "process already finished") &&
-					!strings.Contains(killErr.Error(), "no such process") {
-					t.logger.Error("❌ Error killing ffmpeg process", logger.ErrorField("error", killErr))
-				}
-			} else {
-				t.logger.Debug("✅ Successfully killed ffmpeg process", logger.Int("pid", pid))
-			}
+		if err := proc.Stop(); err != nil {
+			t.logger.Error("❌ Error stopping ffmpeg process", logger.ErrorField("error", err))
+		} else {
+			t.logger.Debug("✅ Successfully stopped ffmpeg process", logger.Int("pid", proc.Pid()))
 		}
-		delete(t.ffmpegProcesses, channel)
 	}
 
 	t.logger.Info("⏹️  Stream stopped",
 		logger.String("channel", channel))
 }
 
+// stopStream ends the stream of the session in ctx, or of every stream on the
+// channel when it was started without a session.
+func (t *Impl) stopStream(ctx context.Context, channel string) {
+	if sess, ok := session.FromContext(ctx); ok {
+		t.stopSession(sess)
+		return
+	}
+	t.StopActiveStream(channel)
+}
+
+// stopSession ends one session's stream and FFmpeg process. Every session
+// opens its own upstream request, so other sessions on the same channel keep
+// running; the channel stops being active once none are left.
+func (t *Impl) stopSession(sess *session.Session) {
+	t.mutex.Lock()
+	if cancel, exists := t.sessionCancels[sess.ID]; exists {
+		cancel()
+	}
+	proc, hasProcess := t.sessionProcesses[sess.ID]
+	delete(t.sessionProcesses, sess.ID)
+	if t.ffmpegProcesses[sess.Channel] == proc {
+		delete(t.ffmpegProcesses, sess.Channel)
+	}
+
+	channelActive := false
+	for _, other := range t.sessions.List() {
+		if _, exists := t.sessionCancels[other.ID]; exists && other.ID != sess.ID && other.Channel == sess.Channel {
+			channelActive = true
+			break
+		}
+	}
+	if !channelActive {
+		delete(t.activeStreams, sess.Channel)
+	}
+	t.mutex.Unlock()
+
+	if hasProcess {
+		t.logger.Debug("🔫 Stopping ffmpeg process",
+			logger.Int("pid", proc.Pid()),
+			logger.String("session_id", sess.ID))
+		if err := proc.Stop(); err != nil {
+			t.logger.Error("❌ Error stopping ffmpeg process", logger.ErrorField("error", err))
+		}
+	}
+
+	t.logger.Info("⏹️  Session stopped",
+		logger.String("session_id", sess.ID),
+		logger.String("channel", sess.Channel))
+}
+
 // Shutdown performs a graceful shutdown of the transcoder and all its resources.
 func (t *Impl) Shutdown() {
 	defer utils.TimeOperation("Shutdown transcoder")()
 	t.logger.Info("🛑 Stopping transcoder gracefully")
 
-	// Stop the activity checker
+	// Stop the activity checker and lineup refresher
 	if t.stopActivityCheck != nil {
 		t.stopActivityCheck()
 	}
+	t.mutex.Lock()
+	if t.cancel != nil {
+		t.cancel()
+		t.cancel = nil
+	}
+	t.mutex.Unlock()
+	t.background.Wait()
 
 	// Stop all processes
 	t.StopAllTranscoding()
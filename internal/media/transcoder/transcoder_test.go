@@ -3,58 +3,60 @@ package transcoder
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
 
+	"github.com/attaebra/hdhr-proxy/internal/config"
+	"github.com/attaebra/hdhr-proxy/internal/events"
+	"github.com/attaebra/hdhr-proxy/internal/hdhrsim"
+	"github.com/attaebra/hdhr-proxy/internal/interfaces"
 	"github.com/attaebra/hdhr-proxy/internal/logger"
 	"github.com/attaebra/hdhr-proxy/internal/media/ffmpeg"
+	"github.com/attaebra/hdhr-proxy/internal/media/process"
+	"github.com/attaebra/hdhr-proxy/internal/media/session"
 	"github.com/attaebra/hdhr-proxy/internal/media/stream"
+	"github.com/attaebra/hdhr-proxy/internal/media/timeshift"
 	"github.com/attaebra/hdhr-proxy/internal/proxy"
 	"github.com/attaebra/hdhr-proxy/internal/utils"
 )
 
-// Mock HTTP server to simulate HDHomeRun.
-type mockHDHR struct {
-	server *httptest.Server
+// fakeProcess stands in for a running FFmpeg process that is never started.
+type fakeProcess struct {
+	interfaces.TranscodeProcess
+	pid     int
+	stopped bool
 }
 
-func newMockHDHR() *mockHDHR {
-	mock := &mockHDHR{}
+func (p *fakeProcess) Pid() int { return p.pid }
 
-	// Create a test server
-	handler := http.NewServeMux()
-
-	// Add auto/v endpoint
-	handler.HandleFunc("/auto/v", func(w http.ResponseWriter, _ *http.Request) {
-		w.Header().Set("Content-Type", "video/mp2t")
-		w.Write([]byte("test video data"))
-	})
-
-	// Add discover.json endpoint
-	handler.HandleFunc("/discover.json", func(w http.ResponseWriter, _ *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		w.Write([]byte(`{"DeviceID":"ABCDEF12","LocalIP":"192.168.1.100"}`))
-	})
-
-	// Create the test server
-	mock.server = httptest.NewServer(handler)
-
-	return mock
+func (p *fakeProcess) Stop() error {
+	p.stopped = true
+	return nil
 }
 
-func (m *mockHDHR) Close() {
-	if m.server != nil {
-		m.server.Close()
+// newDevice serves a simulated HDHomeRun with four tuners and the default
+// AC4 and AC3 channels until the test ends.
+func newDevice(t *testing.T) *httptest.Server {
+	t.Helper()
+	sim, err := hdhrsim.New(hdhrsim.Options{DeviceID: "ABCDEF12", TunerCount: 4})
+	if err != nil {
+		t.Fatalf("Failed to create simulator: %v", err)
 	}
-}
-
-func (m *mockHDHR) URL() string {
-	return m.server.URL
+	server := httptest.NewServer(sim)
+	t.Cleanup(server.Close)
+	return server
 }
 
 // NewForTesting creates a transcoder instance for testing purposes.
@@ -64,19 +66,29 @@ func NewForTesting(ffmpegPath string, hdhrIP string) *Impl {
 	baseURL := fmt.Sprintf("http://%s:%d", hdhrIP, 5004)
 	ctx, cancel := context.WithCancel(context.Background())
 
-	return &Impl{
+	t := &Impl{
 		FFmpegPath:            ffmpegPath,
 		proxy:                 proxy.NewForTesting(hdhrIP),
 		activeStreams:         make(map[string]time.Time),
 		ac4Channels:           make(map[string]bool),
-		ffmpegProcesses:       make(map[string]int),
+		ffmpegProcesses:       make(map[string]interfaces.TranscodeProcess),
+		sessionProcesses:      make(map[string]interfaces.TranscodeProcess),
+		ffmpegExits:           make(map[interfaces.ExitReason]int64),
 		InputURL:              baseURL,
+		deviceMediaPort:       5004,
 		connectionActivity:    make(map[string]time.Time),
 		activityCheckInterval: 30 * time.Second,
 		maxInactivityDuration: 2 * time.Minute,
 		ctx:                   ctx,
 		cancel:                cancel,
 		monitoringActive:      false,
+		sessions:              session.NewRegistry(session.Limits{}),
+		classifier:            session.NewClassifier(nil),
+		sessionCancels:        make(map[string]context.CancelFunc),
+		lineupRefresh:         make(chan time.Duration, 1),
+		ffmpegStderr:          make(map[string]*stderrTail),
+		meters:                make(map[string]*byteMeter),
+		runner:                process.NewRunner(process.DefaultGrace),
 		logger:                logger.NewZapLogger(logger.LevelDebug),
 		FFmpegConfig:          ffmpeg.New(),
 		StreamHelper:          stream.NewHelper(),
@@ -84,6 +96,10 @@ func NewForTesting(ffmpegPath string, hdhrIP string) *Impl {
 		streamClient:          utils.HTTPClient(0),
 		securityValidator:     utils.NewSecurityValidator(),
 	}
+
+	// Like Transcoder, start the lineup refresher, idle until an interval is set
+	t.startLineupRefresher(0)
+	return t
 }
 
 func TestNewForTesting(t *testing.T) {
@@ -107,6 +123,12 @@ func TestNewForTesting(t *testing.T) {
 		t.Error("Expected activeStreams to be initialized")
 	}
 
+	// The media URL follows the device to a new address, keeping the media port
+	transcoder.SetDeviceAddress("192.168.1.120:8080")
+	if got := transcoder.inputURL(); got != "http://192.168.1.120:5004" {
+		t.Errorf("Expected the new device address, got %s", got)
+	}
+
 	// Test new activity tracking fields
 	if transcoder.connectionActivity == nil {
 		t.Error("Expected connectionActivity to be initialized")
@@ -222,14 +244,13 @@ func TestTranscodeChannelNoFFmpeg(t *testing.T) {
 		t.Fatalf("Failed to create mock request: %v", err)
 	}
 
-	// Use a mock HDHomeRun
-	mockHdhr := newMockHDHR()
-	defer mockHdhr.Close()
+	// Use a simulated HDHomeRun
+	device := newDevice(t)
 
-	// Update transcoder to use mock server
-	transcoder.InputURL = mockHdhr.URL()
+	// Update transcoder to use the device
+	transcoder.InputURL = device.URL
 
-	// This should fail because the mock server returns 404 for the specific URL pattern
+	// This should fail because the ffmpeg binary does not exist
 	err = transcoder.TranscodeChannel(w, req, "5.1")
 
 	// We expect an error
@@ -290,3 +311,612 @@ func TestActivityTracking(t *testing.T) {
 	// Shutdown to stop the activity checker
 	transcoder.Shutdown()
 }
+
+// TestMediaHandlerRejectsOverLimit tests that admission control answers with 503 and HDHomeRun headers.
+func TestMediaHandlerRejectsOverLimit(t *testing.T) {
+	transcoder := NewForTesting("/path/to/ffmpeg", "192.168.1.100")
+	defer transcoder.Shutdown()
+
+	transcoder.sessions.SetLimits(session.Limits{MaxTotal: 1})
+	if _, err := transcoder.sessions.Admit(session.Request{Channel: "7.1", ClientIP: "10.0.0.1", Mode: session.ModeDirect}); err != nil {
+		t.Fatalf("Failed to seed session: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/auto/v5.1", nil)
+	recorder := httptest.NewRecorder()
+	transcoder.MediaHandler().ServeHTTP(recorder, req)
+
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503, got %d", recorder.Code)
+	}
+	if recorder.Header().Get("Retry-After") == "" {
+		t.Error("Expected Retry-After header to be set")
+	}
+	if got := recorder.Header().Get("X-HDHomeRun-Error"); got != "805 All Tuners In Use" {
+		t.Errorf("Expected X-HDHomeRun-Error 805, got %q", got)
+	}
+
+	// The rejection should be reported on the status page
+	recorder = httptest.NewRecorder()
+	transcoder.MediaHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/status", nil))
+	if !strings.Contains(recorder.Body.String(), "total_limit=1") {
+		t.Errorf("Expected status to count the rejection, got:\n%s", recorder.Body.String())
+	}
+}
+
+// TestStreamStartedAfterTune tests that a stream is announced only once the
+// channel is tuned, and that a failed tune is reported as a failure.
+func TestStreamStartedAfterTune(t *testing.T) {
+	sim, err := hdhrsim.New(hdhrsim.Options{DeviceID: "ABCDEF12", TunerCount: 4})
+	if err != nil {
+		t.Fatalf("Failed to create simulator: %v", err)
+	}
+	device := httptest.NewServer(sim)
+	defer device.Close()
+
+	transcoder := NewForTesting("/path/to/ffmpeg", "192.168.1.100")
+	defer transcoder.Shutdown()
+	transcoder.InputURL = device.URL
+	transcoder.events = events.NewBus()
+	lifecycle, cancel := transcoder.events.Subscribe(events.StreamStarted, events.StreamEnded, events.StreamFailed)
+	defer cancel()
+
+	next := func() events.Type {
+		t.Helper()
+		select {
+		case e := <-lifecycle:
+			return e.Type
+		case <-time.After(time.Second):
+			t.Fatal("Expected a stream event")
+			return ""
+		}
+	}
+
+	// Every tuner is busy, so the tune fails
+	sim.SetAllTunersBusy(true)
+	if err := transcoder.ServeChannel(httptest.NewRecorder(), httptest.NewRequest("GET", "/auto/v7.1", nil), "7.1", ProfileDirect); err == nil {
+		t.Fatal("Expected the tune to fail")
+	}
+	if got := next(); got != events.StreamFailed {
+		t.Errorf("Expected %s for a failed tune, got %s", events.StreamFailed, got)
+	}
+
+	// The tune succeeds and the device ends the stream
+	sim.SetAllTunersBusy(false)
+	sim.SetDisconnectAfter(hdhrsim.PacketSize * 100)
+	transcoder.ServeChannel(httptest.NewRecorder(), httptest.NewRequest("GET", "/auto/v7.1", nil), "7.1", ProfileDirect)
+	for _, want := range []events.Type{events.StreamStarted, events.StreamEnded} {
+		if got := next(); got != want {
+			t.Errorf("Expected %s, got %s", want, got)
+		}
+	}
+}
+
+// TestAdmitPreemptsLowerPriority tests that a higher priority request ends the lowest priority stream.
+func TestAdmitPreemptsLowerPriority(t *testing.T) {
+	transcoder := NewForTesting("/path/to/ffmpeg", "192.168.1.100")
+	defer transcoder.Shutdown()
+
+	transcoder.sessions.SetLimits(session.Limits{MaxTotal: 1})
+	victim, err := transcoder.sessions.Admit(session.Request{Channel: "7.1", ClientIP: "10.0.0.2", Class: "tablet", Priority: 10})
+	if err != nil {
+		t.Fatalf("Failed to seed session: %v", err)
+	}
+
+	// Simulate the victim's running stream: canceling it releases the session
+	transcoder.mutex.Lock()
+	transcoder.activeStreams["7.1"] = time.Now()
+	transcoder.sessionCancels[victim.ID] = func() { transcoder.sessions.Release(victim) }
+	transcoder.mutex.Unlock()
+
+	// An equal priority request must not preempt
+	if _, err := transcoder.admit(session.Request{Channel: "5.1", ClientIP: "10.0.0.3", Priority: 10}); err == nil {
+		t.Fatal("Expected equal priority request to be rejected")
+	}
+
+	sess, err := transcoder.admit(session.Request{Channel: "5.1", ClientIP: "10.0.0.1", Class: "dvr", Priority: 100})
+	if err != nil {
+		t.Fatalf("Expected higher priority request to be admitted, got %v", err)
+	}
+	if sess.Channel != "5.1" {
+		t.Errorf("Expected admitted session for channel 5.1, got %s", sess.Channel)
+	}
+
+	if count, _ := transcoder.sessions.Preemptions(); count != 1 {
+		t.Errorf("Expected 1 preemption, got %d", count)
+	}
+}
+
+// TestPreemptionSparesSameChannel tests that preempting a session leaves a
+// higher priority session on the same channel running.
+func TestPreemptionSparesSameChannel(t *testing.T) {
+	transcoder := NewForTesting("/path/to/ffmpeg", "192.168.1.100")
+	defer transcoder.Shutdown()
+
+	transcoder.sessions.SetLimits(session.Limits{MaxTotal: 2})
+	low, err := transcoder.sessions.Admit(session.Request{Channel: "7.1", ClientIP: "10.0.0.2", Mode: session.ModeTranscode, Priority: 10})
+	if err != nil {
+		t.Fatalf("Failed to seed session: %v", err)
+	}
+	high, err := transcoder.sessions.Admit(session.Request{Channel: "7.1", ClientIP: "10.0.0.3", Mode: session.ModeTranscode, Priority: 50})
+	if err != nil {
+		t.Fatalf("Failed to seed session: %v", err)
+	}
+
+	lowProc, highProc := &fakeProcess{pid: 1}, &fakeProcess{pid: 2}
+	var highCanceled bool
+	transcoder.mutex.Lock()
+	transcoder.activeStreams["7.1"] = time.Now()
+	transcoder.sessionProcesses[low.ID] = lowProc
+	transcoder.sessionProcesses[high.ID] = highProc
+	transcoder.sessionCancels[low.ID] = func() { transcoder.sessions.Release(low) }
+	transcoder.sessionCancels[high.ID] = func() { highCanceled = true }
+	transcoder.mutex.Unlock()
+
+	if _, err := transcoder.admit(session.Request{Channel: "5.1", ClientIP: "10.0.0.1", Priority: 100}); err != nil {
+		t.Fatalf("Expected higher priority request to be admitted, got %v", err)
+	}
+
+	if !lowProc.stopped {
+		t.Error("Expected the preempted session's FFmpeg process to be stopped")
+	}
+	transcoder.mutex.Lock()
+	defer transcoder.mutex.Unlock()
+	if highCanceled || highProc.stopped {
+		t.Error("Expected the higher priority session on the same channel to keep running")
+	}
+	if _, active := transcoder.activeStreams["7.1"]; !active {
+		t.Error("Expected the channel to stay active while a session remains")
+	}
+}
+
+func TestTimeshiftPassesThroughRejection(t *testing.T) {
+	transcoder := NewForTesting("/path/to/ffmpeg", "192.168.1.100")
+	defer transcoder.Shutdown()
+
+	transcoder.timeshift = timeshift.NewManager(time.Minute, []string{"5.1"}, "")
+	transcoder.sessions.SetLimits(session.Limits{MaxTotal: 1})
+	if _, err := transcoder.sessions.Admit(session.Request{Channel: "7.1", ClientIP: "10.0.0.1", Mode: session.ModeDirect}); err != nil {
+		t.Fatalf("Failed to seed session: %v", err)
+	}
+
+	recorder := httptest.NewRecorder()
+	transcoder.MediaHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/auto/v5.1?offset=-300", nil))
+
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503, got %d", recorder.Code)
+	}
+	if got := recorder.Header().Get("X-HDHomeRun-Error"); got != "805 All Tuners In Use" {
+		t.Errorf("Expected X-HDHomeRun-Error 805, got %q", got)
+	}
+
+	recorder = httptest.NewRecorder()
+	transcoder.MediaHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/auto/v5.1?offset=300", nil))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a positive offset, got %d", recorder.Code)
+	}
+}
+
+func TestTimeshiftBufferFreedWhenIdle(t *testing.T) {
+	feederIdleTimeout = 50 * time.Millisecond
+	defer func() { feederIdleTimeout = 30 * time.Second }()
+
+	scriptFFmpeg(t, nil)
+
+	device := newDevice(t)
+	transcoder := NewForTesting(fakeFFmpeg, "192.168.1.100")
+	defer transcoder.Shutdown()
+	transcoder.InputURL = device.URL
+	transcoder.timeshift = timeshift.NewManager(time.Minute, nil, t.TempDir())
+
+	// A viewer watches until some stream is buffered, then leaves
+	ctx, cancel := context.WithCancel(context.Background())
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		writer := &pipeResponseWriter{header: make(http.Header), w: pw}
+		transcoder.MediaHandler().ServeHTTP(writer, httptest.NewRequest("GET", "/auto/v7.1", nil).WithContext(ctx))
+		pw.Close()
+	}()
+	if _, err := io.ReadFull(pr, make([]byte, 10*hdhrsim.PacketSize)); err != nil {
+		t.Fatalf("Failed to read the timeshift stream: %v", err)
+	}
+	if count, size := transcoder.timeshift.Usage(); count != 1 || size == 0 {
+		t.Errorf("Expected one filled buffer while watching, got %d of %d bytes", count, size)
+	}
+	cancel()
+	go io.Copy(io.Discard, pr)
+	<-done
+
+	// The feeder stops after the idle timeout and the buffer is freed
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		count, size := transcoder.timeshift.Usage()
+		if count == 0 && size == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the idle buffer to be freed, still %d of %d bytes", count, size)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// pipeResponseWriter streams a response body into a pipe.
+type pipeResponseWriter struct {
+	header http.Header
+	w      io.Writer
+}
+
+func (p *pipeResponseWriter) Header() http.Header         { return p.header }
+func (p *pipeResponseWriter) WriteHeader(int)             {}
+func (p *pipeResponseWriter) Write(b []byte) (int, error) { return p.w.Write(b) }
+
+func TestReloadAppliesToNewStreams(t *testing.T) {
+	device := newDevice(t)
+
+	transcoder := NewForTesting("/path/to/ffmpeg", strings.TrimPrefix(device.URL, "http://"))
+	defer transcoder.Shutdown()
+	transcoder.timeshift = timeshift.NewManager(time.Minute, []string{"5.1"}, "")
+
+	cfg := config.DefaultConfig()
+	cfg.FFmpeg.AudioBitrate = "256k"
+	cfg.PriorityClasses = []config.PriorityClass{{Name: "dvr", Priority: 100, Clients: []string{"10.0.0.9"}}}
+	cfg.TimeshiftChannels = []string{"7.1"}
+	cfg.MaxInactivityDuration = time.Minute
+	cfg.LineupRefreshInterval = 10 * time.Millisecond
+	transcoder.Reload(cfg)
+
+	ffmpegConfig, classifier := transcoder.currentSettings()
+	if !strings.Contains(strings.Join(ffmpegConfig.BuildArgs(), " "), "256k") {
+		t.Errorf("Expected reloaded FFmpeg parameters, got %v", ffmpegConfig.BuildArgs())
+	}
+	if class, _ := classifier.Classify("10.0.0.9", ""); class != "dvr" {
+		t.Errorf("Expected reloaded priority classes, got class %q", class)
+	}
+	if transcoder.timeshift.Enabled("5.1") || !transcoder.timeshift.Enabled("7.1") {
+		t.Error("Expected reloaded timeshift channels")
+	}
+
+	// The refresher picks up the new interval and reads the lineup
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		transcoder.mutex.Lock()
+		_, known := transcoder.ac4Channels["7.1"]
+		transcoder.mutex.Unlock()
+		if known {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the lineup to be refreshed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !transcoder.isAC4Channel("5.1") || transcoder.isAC4Channel("7.1") {
+		t.Error("Expected AC4 channels from the refreshed lineup")
+	}
+}
+
+func TestAdminSessions(t *testing.T) {
+	transcoder := NewForTesting("/path/to/ffmpeg", "192.168.1.100")
+	defer transcoder.Shutdown()
+
+	sess, err := transcoder.sessions.Admit(session.Request{Channel: "5.1", ClientIP: "10.0.0.1", Mode: session.ModeTranscode})
+	if err != nil {
+		t.Fatalf("Failed to seed session: %v", err)
+	}
+	other, err := transcoder.sessions.Admit(session.Request{Channel: "5.1", ClientIP: "10.0.0.2", Mode: session.ModeTranscode})
+	if err != nil {
+		t.Fatalf("Failed to seed session: %v", err)
+	}
+	canceled := make(chan struct{})
+	var otherCanceled bool
+	transcoder.mutex.Lock()
+	transcoder.activeStreams["5.1"] = time.Now()
+	proc, otherProc := &fakeProcess{pid: 4242}, &fakeProcess{pid: 4243}
+	transcoder.ffmpegProcesses["5.1"] = otherProc
+	transcoder.sessionProcesses[sess.ID] = proc
+	transcoder.sessionProcesses[other.ID] = otherProc
+	var cancelOnce sync.Once
+	transcoder.sessionCancels[sess.ID] = func() { cancelOnce.Do(func() { close(canceled) }) }
+	transcoder.sessionCancels[other.ID] = func() { otherCanceled = true }
+	transcoder.mutex.Unlock()
+
+	tail := transcoder.trackStderr(session.NewContext(context.Background(), sess))
+	for i := 0; i < stderrTailLines+5; i++ {
+		tail.add(fmt.Sprintf("line %d", i))
+	}
+
+	if list := transcoder.Sessions(); len(list) != 2 || list[0].FFmpegPID != 4242 || list[1].FFmpegPID != 4243 || list[0].FFmpegStderr != nil {
+		t.Errorf("Unexpected session list: %+v", list)
+	}
+
+	info, ok := transcoder.Session(sess.ID)
+	if !ok || len(info.FFmpegStderr) != stderrTailLines || info.FFmpegStderr[0] != "line 5" {
+		t.Errorf("Expected the last %d FFmpeg lines, got %v", stderrTailLines, info.FFmpegStderr)
+	}
+
+	if transcoder.StopSession("missing") {
+		t.Error("Expected unknown session not to be stopped")
+	}
+	if !transcoder.StopSession(sess.ID) {
+		t.Fatal("Expected session to be stopped")
+	}
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Error("Expected the session's stream to be canceled")
+	}
+	if !proc.stopped {
+		t.Error("Expected the session's FFmpeg process to be stopped")
+	}
+
+	// The other viewer of the channel keeps watching
+	transcoder.mutex.Lock()
+	defer transcoder.mutex.Unlock()
+	if otherCanceled || otherProc.stopped {
+		t.Error("Expected the other session on the channel to keep running")
+	}
+}
+
+func TestByteMeter(t *testing.T) {
+	meter := &byteMeter{}
+	start := time.Unix(1000, 0)
+
+	// One megabit per second for ten seconds
+	for i := 0; i < 10; i++ {
+		meter.add(125_000, start.Add(time.Duration(i)*time.Second))
+	}
+
+	total, bitrate := meter.read(start.Add(10 * time.Second))
+	if total != 1_250_000 || bitrate != 1_000_000 {
+		t.Errorf("Expected 1250000 bytes at 1 Mbps, got %d bytes at %.0f bps", total, bitrate)
+	}
+
+	// The rate falls to zero once the stream stalls
+	if _, bitrate := meter.read(start.Add(30 * time.Second)); bitrate != 0 {
+		t.Errorf("Expected no bitrate after a stall, got %.0f", bitrate)
+	}
+}
+
+func TestStatusModel(t *testing.T) {
+	device := newDevice(t)
+
+	transcoder := NewForTesting("/path/to/ffmpeg", strings.TrimPrefix(device.URL, "http://"))
+	defer transcoder.Shutdown()
+	if err := transcoder.RefreshLineup(context.Background()); err != nil {
+		t.Fatalf("Failed to read lineup: %v", err)
+	}
+
+	transcoder.mutex.Lock()
+	transcoder.activeStreams["5.1"] = time.Now()
+	transcoder.mutex.Unlock()
+
+	status := transcoder.Status()
+	if len(status.Lineup) != 2 || !status.Lineup[0].AC4 || !status.Lineup[0].Active || status.Lineup[1].AC4 {
+		t.Errorf("Unexpected lineup: %+v", status.Lineup)
+	}
+	if len(status.Streams) != 1 || !status.Streams[0].Transcoding {
+		t.Errorf("Unexpected streams: %+v", status.Streams)
+	}
+
+	recorder := httptest.NewRecorder()
+	transcoder.MediaHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/status", nil))
+	for _, want := range []string{"Active Streams: 1", "Total Channels: 2", "AC4 Audio Channels: 1"} {
+		if !strings.Contains(recorder.Body.String(), want) {
+			t.Errorf("Expected status page to contain %q, got:\n%s", want, recorder.Body.String())
+		}
+	}
+}
+
+func TestDiffLineup(t *testing.T) {
+	previous := map[string]bool{"5.1": true, "7.1": false, "9.1": false}
+	current := map[string]bool{"5.1": true, "7.1": true, "11.1": false}
+
+	added, removed, changed := diffLineup(previous, current)
+	if added != 1 || removed != 1 || changed != 1 {
+		t.Errorf("Expected 1 added, 1 removed, 1 changed, got %d, %d, %d", added, removed, changed)
+	}
+}
+
+// fakeFFmpeg is the path of the scripted FFmpeg stand-in built by TestMain
+// from testdata/fakeffmpeg.
+var fakeFFmpeg string
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "fakeffmpeg")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create temp dir: %v\n", err)
+		os.Exit(1)
+	}
+	fakeFFmpeg = filepath.Join(dir, "ffmpeg")
+	build := exec.Command("go", "build", "-o", fakeFFmpeg, "./testdata/fakeffmpeg")
+	build.Stderr = os.Stderr
+	if err := build.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to build fake ffmpeg: %v\n", err)
+		os.RemoveAll(dir)
+		os.Exit(1)
+	}
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// scriptFFmpeg sets the fake FFmpeg's behavior for the rest of the test.
+func scriptFFmpeg(t *testing.T, script map[string]string) {
+	t.Helper()
+	for _, key := range []string{"ARGS", "PID", "STDERR", "LIMIT", "EXIT", "HANG", "IGNORE_SIGNALS"} {
+		t.Setenv("FAKE_FFMPEG_"+key, script[key])
+	}
+}
+
+func TestFFmpegLifecycle(t *testing.T) {
+	argsFile := filepath.Join(t.TempDir(), "args")
+	scriptFFmpeg(t, map[string]string{"ARGS": argsFile})
+
+	transcoder := NewForTesting(fakeFFmpeg, "192.168.1.100")
+	defer transcoder.Shutdown()
+	transcoder.events = events.NewBus()
+	exited, cancel := transcoder.events.Subscribe(events.FFmpegExited)
+	defer cancel()
+
+	input := bytes.Repeat([]byte("transport stream "), 10000)
+	recorder := httptest.NewRecorder()
+	if err := transcoder.startFFmpeg(context.Background(), recorder, bytes.NewReader(input), "5.1"); err != nil {
+		t.Fatalf("startFFmpeg failed: %v", err)
+	}
+
+	if !bytes.Equal(recorder.Body.Bytes(), input) {
+		t.Errorf("Expected FFmpeg output to reach the client, got %d of %d bytes", recorder.Body.Len(), len(input))
+	}
+	args, err := os.ReadFile(argsFile)
+	if err != nil {
+		t.Fatalf("Fake ffmpeg did not record its arguments: %v", err)
+	}
+	if !strings.Contains(string(args), "-i\npipe:0\n") || !strings.Contains(string(args), "-c:a\neac3\n") {
+		t.Errorf("Unexpected ffmpeg arguments:\n%s", args)
+	}
+
+	select {
+	case e := <-exited:
+		if e.Channel != "5.1" || e.Details["exit_code"] != 0 || e.Details["reason"] != "graceful" {
+			t.Errorf("Unexpected exit event: %+v", e)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected an ffmpeg_exited event")
+	}
+
+	transcoder.mutex.Lock()
+	defer transcoder.mutex.Unlock()
+	if len(transcoder.ffmpegProcesses) != 0 {
+		t.Errorf("Expected the process to be forgotten, got %v", transcoder.ffmpegProcesses)
+	}
+}
+
+func TestFFmpegErrorClassification(t *testing.T) {
+	overread := "[ac4 @ 0x5581] substream audio data overread: 3"
+	cases := []struct {
+		name    string
+		stderr  string
+		exit    string
+		wantErr bool
+		burst   bool
+		reason  interfaces.ExitReason
+	}{
+		{name: "clean exit", exit: "0", reason: interfaces.ExitGraceful},
+		{name: "AC4 errors are tolerated", stderr: overread + "\n[ac4 @ 0x5581] Invalid data found when processing input", exit: "1", reason: interfaces.ExitCrashed},
+		{name: "AC4 error burst", stderr: strings.Repeat(overread+"\n", 25), exit: "1", burst: true, reason: interfaces.ExitCrashed},
+		{name: "fatal error", stderr: "Error while decoding stream #0:1: Invalid argument", exit: "1", wantErr: true, reason: interfaces.ExitCrashed},
+		{name: "crash without output", exit: "139", wantErr: true, reason: interfaces.ExitCrashed},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			scriptFFmpeg(t, map[string]string{"STDERR": strings.TrimSuffix(tc.stderr, "\n"), "EXIT": tc.exit})
+
+			transcoder := NewForTesting(fakeFFmpeg, "192.168.1.100")
+			defer transcoder.Shutdown()
+			transcoder.events = events.NewBus()
+			bursts, cancel := transcoder.events.Subscribe(events.AC4ErrorBurst)
+			defer cancel()
+
+			err := transcoder.startFFmpeg(context.Background(), httptest.NewRecorder(), strings.NewReader("input"), "5.1")
+			if (err != nil) != tc.wantErr {
+				t.Errorf("Expected error %v, got %v", tc.wantErr, err)
+			}
+			if err != nil && !strings.Contains(err.Error(), "ffmpeg process failed") {
+				t.Errorf("Expected ffmpeg process failure, got %v", err)
+			}
+
+			select {
+			case <-bursts:
+				if !tc.burst {
+					t.Error("Unexpected AC4 error burst event")
+				}
+			default:
+				if tc.burst {
+					t.Error("Expected an AC4 error burst event")
+				}
+			}
+			if exits := transcoder.Status().FFmpegExits; exits[string(tc.reason)] != 1 {
+				t.Errorf("Expected a %s exit to be counted, got %v", tc.reason, exits)
+			}
+		})
+	}
+}
+
+func TestFFmpegStoppedOnCancel(t *testing.T) {
+	cases := []struct {
+		name   string
+		script map[string]string
+		reason interfaces.ExitReason
+	}{
+		{name: "interrupted", script: map[string]string{"HANG": "1"}, reason: interfaces.ExitGraceful},
+		{name: "killed after the grace period", script: map[string]string{"HANG": "1", "IGNORE_SIGNALS": "1"}, reason: interfaces.ExitForced},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			pidFile := filepath.Join(t.TempDir(), "pid")
+			tc.script["PID"] = pidFile
+			scriptFFmpeg(t, tc.script)
+
+			transcoder := NewForTesting(fakeFFmpeg, "192.168.1.100")
+			defer transcoder.Shutdown()
+			transcoder.runner = process.NewRunner(200 * time.Millisecond)
+			transcoder.events = events.NewBus()
+			exited, unsubscribe := transcoder.events.Subscribe(events.FFmpegExited)
+			defer unsubscribe()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			source, sourceWriter := io.Pipe()
+			defer sourceWriter.Close()
+			done := make(chan error, 1)
+			go func() {
+				done <- transcoder.startFFmpeg(ctx, httptest.NewRecorder(), source, "5.1")
+			}()
+
+			var pid int
+			deadline := time.Now().Add(5 * time.Second)
+			for pid == 0 {
+				if time.Now().After(deadline) {
+					t.Fatal("Timed out waiting for fake ffmpeg to start")
+				}
+				data, _ := os.ReadFile(pidFile)
+				pid, _ = strconv.Atoi(string(data))
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			cancel()
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("startFFmpeg did not return after the stream was canceled")
+			}
+
+			// The process was stopped and reaped
+			if err := syscall.Kill(pid, 0); !errors.Is(err, syscall.ESRCH) {
+				t.Errorf("Expected ffmpeg process %d to be gone, got %v", pid, err)
+			}
+			select {
+			case e := <-exited:
+				if e.Details["reason"] != string(tc.reason) {
+					t.Errorf("Expected exit reason %s, got %+v", tc.reason, e)
+				}
+			case <-time.After(time.Second):
+				t.Error("Expected an ffmpeg_exited event")
+			}
+			if exits := transcoder.Status().FFmpegExits; exits[string(tc.reason)] != 1 || len(exits) != 1 {
+				t.Errorf("Expected one %s exit to be counted, got %v", tc.reason, exits)
+			}
+
+			transcoder.mutex.Lock()
+			defer transcoder.mutex.Unlock()
+			if len(transcoder.ffmpegProcesses) != 0 {
+				t.Errorf("Expected the process to be forgotten, got %v", transcoder.ffmpegProcesses)
+			}
+		})
+	}
+}
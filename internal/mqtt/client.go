@@ -0,0 +1,285 @@
+// Package mqtt publishes proxy state to an MQTT broker, with Home Assistant
+// discovery so the proxy shows up as a device with sensors.
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MQTT 3.1.1 control packet types (high nibble of the fixed header).
+const (
+	packetConnect    = 1
+	packetConnack    = 2
+	packetPublish    = 3
+	packetPingreq    = 12
+	packetPingresp   = 13
+	packetDisconnect = 14
+)
+
+// writeTimeout bounds how long a single packet write may block.
+const writeTimeout = 10 * time.Second
+
+// Message is an MQTT application message. Messages are sent at QoS 0.
+type Message struct {
+	Topic   string
+	Payload []byte
+	Retain  bool
+}
+
+// Options configure a broker connection.
+type Options struct {
+	Broker    string // host:port, optionally prefixed with mqtt:// or tcp://
+	ClientID  string
+	Username  string
+	Password  string
+	KeepAlive time.Duration
+	Will      *Message // Published by the broker if the connection drops
+}
+
+// Client is a minimal MQTT 3.1.1 publisher. It only sends QoS 0 messages, which
+// is all retained state and discovery payloads need.
+type Client struct {
+	conn  net.Conn
+	mutex sync.Mutex // Serializes packet writes
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// BrokerAddress returns the host:port of a broker setting.
+func BrokerAddress(broker string) string {
+	for _, scheme := range []string{"mqtt://", "tcp://"} {
+		broker = strings.TrimPrefix(broker, scheme)
+	}
+	if _, _, err := net.SplitHostPort(broker); err != nil {
+		return net.JoinHostPort(broker, "1883")
+	}
+	return broker
+}
+
+// Dial connects to the broker and completes the MQTT handshake.
+func Dial(ctx context.Context, opts Options) (*Client, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", BrokerAddress(opts.Broker))
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{conn: conn, done: make(chan struct{})}
+	if err := c.write(packetConnect<<4, connectPacket(opts)); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to send CONNECT: %w", err)
+	}
+
+	// The broker must answer with CONNACK before anything else
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetReadDeadline(deadline)
+	}
+	reader := bufio.NewReader(conn)
+	header, body, err := readPacket(reader)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to read CONNACK: %w", err)
+	}
+	if header>>4 != packetConnack || len(body) != 2 {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected packet type %d waiting for CONNACK", header>>4)
+	}
+	if code := body[1]; code != 0 {
+		conn.Close()
+		return nil, fmt.Errorf("broker refused connection: %s", connackReason(code))
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+
+	go c.readLoop(reader)
+	if opts.KeepAlive > 0 {
+		go c.keepAlive(opts.KeepAlive)
+	}
+	return c, nil
+}
+
+// Publish sends a message at QoS 0.
+func (c *Client) Publish(m Message) error {
+	flags := byte(packetPublish << 4)
+	if m.Retain {
+		flags |= 0x01
+	}
+	body := appendString(nil, m.Topic)
+	body = append(body, m.Payload...)
+	return c.write(flags, body)
+}
+
+// Done is closed when the connection is lost or closed.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Close sends DISCONNECT, so the broker discards the will, and closes the connection.
+func (c *Client) Close() error {
+	err := c.write(packetDisconnect<<4, nil)
+	c.shutdown()
+	return err
+}
+
+// shutdown closes the connection once.
+func (c *Client) shutdown() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+// write sends one packet.
+func (c *Client) write(header byte, body []byte) error {
+	packet := append([]byte{header}, encodeLength(len(body))...)
+	packet = append(packet, body...)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	_ = c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err := c.conn.Write(packet)
+	if err != nil {
+		c.shutdown()
+	}
+	return err
+}
+
+// readLoop drains packets from the broker (PINGRESP is the only one expected)
+// until the connection fails.
+func (c *Client) readLoop(reader *bufio.Reader) {
+	defer c.shutdown()
+	for {
+		if _, _, err := readPacket(reader); err != nil {
+			return
+		}
+	}
+}
+
+// keepAlive pings the broker so it does not drop an idle connection.
+func (c *Client) keepAlive(interval time.Duration) {
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			if err := c.write(packetPingreq<<4, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// connectPacket builds the CONNECT variable header and payload.
+func connectPacket(opts Options) []byte {
+	flags := byte(0x02) // Clean session
+	if opts.Will != nil {
+		flags |= 0x04
+		if opts.Will.Retain {
+			flags |= 0x20
+		}
+	}
+	if opts.Username != "" {
+		flags |= 0x80
+		if opts.Password != "" {
+			flags |= 0x40
+		}
+	}
+
+	body := appendString(nil, "MQTT")
+	body = append(body, 4, flags) // Protocol level 4 is MQTT 3.1.1
+	body = binary.BigEndian.AppendUint16(body, uint16(opts.KeepAlive/time.Second))
+	body = appendString(body, opts.ClientID)
+	if opts.Will != nil {
+		body = appendString(body, opts.Will.Topic)
+		body = binary.BigEndian.AppendUint16(body, uint16(len(opts.Will.Payload)))
+		body = append(body, opts.Will.Payload...)
+	}
+	if opts.Username != "" {
+		body = appendString(body, opts.Username)
+		if opts.Password != "" {
+			body = appendString(body, opts.Password)
+		}
+	}
+	return body
+}
+
+// readPacket reads one packet, returning its first header byte and its body.
+func readPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		if i == 4 {
+			return 0, nil, errors.New("malformed remaining length")
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length += int(b&0x7f) * multiplier
+		if b&0x80 == 0 {
+			break
+		}
+		multiplier *= 128
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return header, body, nil
+}
+
+// encodeLength encodes a remaining length as a variable byte integer.
+func encodeLength(n int) []byte {
+	var out []byte
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		out = append(out, b)
+		if n == 0 {
+			return out
+		}
+	}
+}
+
+// appendString appends a length-prefixed UTF-8 string.
+func appendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+// connackReason describes a CONNACK return code.
+func connackReason(code byte) string {
+	switch code {
+	case 1:
+		return "unacceptable protocol version"
+	case 2:
+		return "client identifier rejected"
+	case 3:
+		return "server unavailable"
+	case 4:
+		return "bad user name or password"
+	case 5:
+		return "not authorized"
+	default:
+		return fmt.Sprintf("return code %d", code)
+	}
+}
@@ -0,0 +1,258 @@
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/attaebra/hdhr-proxy/internal/events"
+	"github.com/attaebra/hdhr-proxy/internal/interfaces"
+	"github.com/attaebra/hdhr-proxy/internal/logger"
+)
+
+// broker is an in-process MQTT broker stand-in that accepts connections and
+// keeps the last payload published to each topic.
+type broker struct {
+	listener   net.Listener
+	returnCode byte
+
+	mutex     sync.Mutex
+	clientID  string
+	username  string
+	willTopic string
+	retained  map[string]string
+	published chan Message
+}
+
+func newBroker(t *testing.T, returnCode byte) *broker {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	b := &broker{
+		listener:   listener,
+		returnCode: returnCode,
+		retained:   make(map[string]string),
+		published:  make(chan Message, 1024),
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(conn)
+		}
+	}()
+	return b
+}
+
+func (b *broker) addr() string {
+	return "mqtt://" + b.listener.Addr().String()
+}
+
+// serve handles one client connection.
+func (b *broker) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	header, body, err := readPacket(reader)
+	if err != nil || header>>4 != packetConnect {
+		return
+	}
+	b.parseConnect(body)
+	if _, err := conn.Write([]byte{packetConnack << 4, 2, 0, b.returnCode}); err != nil || b.returnCode != 0 {
+		return
+	}
+
+	for {
+		header, body, err := readPacket(reader)
+		if err != nil {
+			return
+		}
+		switch header >> 4 {
+		case packetPublish:
+			topicLength := int(binary.BigEndian.Uint16(body))
+			m := Message{
+				Topic:   string(body[2 : 2+topicLength]),
+				Payload: body[2+topicLength:],
+				Retain:  header&0x01 != 0,
+			}
+			b.mutex.Lock()
+			b.retained[m.Topic] = string(m.Payload)
+			b.mutex.Unlock()
+			b.published <- m
+		case packetPingreq:
+			_, _ = conn.Write([]byte{packetPingresp << 4, 0})
+		case packetDisconnect:
+			return
+		}
+	}
+}
+
+// parseConnect records the client ID, will topic and user name.
+func (b *broker) parseConnect(body []byte) {
+	next := func() string {
+		n := int(binary.BigEndian.Uint16(body))
+		s := string(body[2 : 2+n])
+		body = body[2+n:]
+		return s
+	}
+	next() // Protocol name
+	flags := body[1]
+	body = body[4:] // Level, flags and keep alive
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.clientID = next()
+	if flags&0x04 != 0 {
+		b.willTopic = next()
+		next() // Will payload
+	}
+	if flags&0x80 != 0 {
+		b.username = next()
+	}
+}
+
+// waitFor waits until topic holds payload.
+func (b *broker) waitFor(t *testing.T, topic, payload string) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		b.mutex.Lock()
+		got := b.retained[topic]
+		b.mutex.Unlock()
+		if got == payload {
+			return
+		}
+		select {
+		case <-b.published:
+		case <-timeout:
+			t.Fatalf("Timed out waiting for %s=%q, last %q", topic, payload, got)
+		}
+	}
+}
+
+func (b *broker) get(topic string) string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.retained[topic]
+}
+
+// fakeReporter returns a settable status.
+type fakeReporter struct {
+	mutex  sync.Mutex
+	status interfaces.Status
+}
+
+func (f *fakeReporter) Status() interfaces.Status {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.status
+}
+
+func (f *fakeReporter) set(status interfaces.Status) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.status = status
+}
+
+func TestDialRejected(t *testing.T) {
+	b := newBroker(t, 5)
+	_, err := Dial(context.Background(), Options{Broker: b.addr(), ClientID: "test"})
+	if err == nil || !strings.Contains(err.Error(), "not authorized") {
+		t.Errorf("Expected a not authorized error, got %v", err)
+	}
+}
+
+func TestBrokerAddress(t *testing.T) {
+	cases := map[string]string{
+		"mqtt://broker:1884": "broker:1884",
+		"tcp://10.0.0.2":     "10.0.0.2:1883",
+		"broker":             "broker:1883",
+	}
+	for broker, want := range cases {
+		if got := BrokerAddress(broker); got != want {
+			t.Errorf("BrokerAddress(%q) = %q, want %q", broker, got, want)
+		}
+	}
+}
+
+func TestPublisher(t *testing.T) {
+	b := newBroker(t, 0)
+	reporter := &fakeReporter{}
+	reporter.set(interfaces.Status{
+		Device: interfaces.DeviceStatus{DeviceID: "1050ABCD", TunerCount: 4},
+		Lineup: []interfaces.ChannelStatus{{GuideNumber: "5.1", GuideName: "WABC"}, {GuideNumber: "7.1", GuideName: "WXYZ"}},
+		Sessions: []interfaces.SessionInfo{
+			{ID: "1", Channel: "5.1", Mode: "transcode"},
+			{ID: "2", Channel: "5.1", Mode: "transcode"},
+		},
+	})
+
+	bus := events.NewBus()
+	publisher := NewPublisher(Settings{
+		Broker:          b.addr(),
+		Username:        "ha",
+		Password:        "secret",
+		TopicPrefix:     "hdhr-proxy",
+		DiscoveryPrefix: "homeassistant",
+	}, reporter, bus, logger.NewZapLogger(logger.LevelDebug))
+	publisher.Start()
+
+	base := "hdhr-proxy/1050abcd"
+	b.waitFor(t, base+"/channel/7.1", "OFF")
+	if got := b.get(base + "/availability"); got != online {
+		t.Errorf("Expected availability online, got %q", got)
+	}
+	if got := b.get(base + "/channel/5.1"); got != "ON" {
+		t.Errorf("Expected channel 5.1 to be watched, got %q", got)
+	}
+
+	var state State
+	if err := json.Unmarshal([]byte(b.get(base+"/state")), &state); err != nil {
+		t.Fatalf("Invalid state: %v", err)
+	}
+	if state.ActiveStreams != 2 || state.TunersInUse != 1 || state.TunerCount != 4 || state.Transcoding != 2 {
+		t.Errorf("Unexpected state: %+v", state)
+	}
+
+	var discovery map[string]interface{}
+	topic := "homeassistant/binary_sensor/hdhr_proxy_1050abcd/channel_5_1/config"
+	if err := json.Unmarshal([]byte(b.get(topic)), &discovery); err != nil {
+		t.Fatalf("Missing discovery config %s: %v", topic, err)
+	}
+	if discovery["state_topic"] != base+"/channel/5.1" || discovery["name"] != "Watching 5.1 WABC" {
+		t.Errorf("Unexpected discovery config: %v", discovery)
+	}
+	if b.get("homeassistant/sensor/hdhr_proxy_1050abcd/active_streams/config") == "" {
+		t.Error("Expected an active streams sensor config")
+	}
+
+	b.mutex.Lock()
+	if b.username != "ha" || b.willTopic != base+"/availability" || b.clientID != "hdhr-proxy-1050abcd" {
+		t.Errorf("Unexpected CONNECT: user %q will %q client %q", b.username, b.willTopic, b.clientID)
+	}
+	b.mutex.Unlock()
+
+	// Stream events republish the state, and a lineup change removes old channels
+	reporter.set(interfaces.Status{
+		Device: interfaces.DeviceStatus{DeviceID: "1050ABCD", TunerCount: 4},
+		Lineup: []interfaces.ChannelStatus{{GuideNumber: "5.1", GuideName: "WABC"}},
+	})
+	bus.Publish(events.Event{Type: events.LineupChanged})
+	b.waitFor(t, base+"/channel/5.1", "OFF")
+	b.waitFor(t, "homeassistant/binary_sensor/hdhr_proxy_1050abcd/channel_7_1/config", "")
+
+	publisher.Stop()
+	b.waitFor(t, base+"/availability", offline)
+}
@@ -0,0 +1,321 @@
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/attaebra/hdhr-proxy/internal/events"
+	"github.com/attaebra/hdhr-proxy/internal/interfaces"
+	"github.com/attaebra/hdhr-proxy/internal/logger"
+	"github.com/attaebra/hdhr-proxy/internal/media/session"
+)
+
+// Publisher timing. State is also republished on every stream event.
+const (
+	keepAlive        = 60 * time.Second
+	dialTimeout      = 10 * time.Second
+	stateInterval    = 30 * time.Second
+	maxReconnectWait = time.Minute
+)
+
+// reconnectDelay is the wait before the first reconnect; it doubles up to maxReconnectWait.
+var reconnectDelay = time.Second
+
+// Availability payloads.
+const (
+	online  = "online"
+	offline = "offline"
+)
+
+// Settings configure the publisher.
+type Settings struct {
+	Broker          string
+	Username        string
+	Password        string
+	TopicPrefix     string // State topics live under <TopicPrefix>/<device id>/
+	DiscoveryPrefix string // Home Assistant discovery prefix
+}
+
+// State is the JSON published to the state topic.
+type State struct {
+	ActiveStreams int      `json:"active_streams"`
+	TunersInUse   int      `json:"tuners_in_use"`
+	TunerCount    int      `json:"tuner_count"`
+	Transcoding   int      `json:"transcoding"`
+	Watching      []string `json:"watching"`
+}
+
+// Publisher keeps an MQTT broker up to date with the proxy's state: the active
+// stream count, tuner usage and whether each lineup channel is being watched.
+type Publisher struct {
+	settings Settings
+	reporter interfaces.StatusReporter
+	bus      *events.Bus
+	logger   interfaces.Logger
+
+	id         string          // Sanitized device ID used in topics, set on connect
+	discovered map[string]bool // Channels with a published discovery config
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewPublisher creates a publisher reading state from reporter and refreshing
+// it on stream events from bus.
+func NewPublisher(settings Settings, reporter interfaces.StatusReporter, bus *events.Bus, log interfaces.Logger) *Publisher {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Publisher{
+		settings:   settings,
+		reporter:   reporter,
+		bus:        bus,
+		logger:     log,
+		discovered: make(map[string]bool),
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+}
+
+// Start connects to the broker in the background, reconnecting as needed.
+func (p *Publisher) Start() {
+	updates, unsubscribe := p.bus.Subscribe(events.StreamStarted, events.StreamEnded, events.LineupChanged)
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer unsubscribe()
+
+		delay := reconnectDelay
+		for {
+			connected := p.connect(updates)
+			if p.ctx.Err() != nil {
+				return
+			}
+			if connected {
+				delay = reconnectDelay
+			}
+
+			select {
+			case <-time.After(delay):
+			case <-p.ctx.Done():
+				return
+			}
+			delay = min(delay*2, maxReconnectWait)
+		}
+	}()
+}
+
+// Stop marks the proxy offline and disconnects.
+func (p *Publisher) Stop() {
+	p.cancel()
+	p.wg.Wait()
+	p.logger.Info("📡 MQTT publisher stopped")
+}
+
+// connect runs one broker connection until it drops or the publisher stops.
+// It reports whether the connection was established.
+func (p *Publisher) connect(updates <-chan events.Event) bool {
+	p.id = sanitize(p.reporter.Status().Device.DeviceID)
+	if p.id == "" {
+		p.id = "proxy"
+	}
+	base := p.baseTopic()
+	ctx, cancel := context.WithTimeout(p.ctx, dialTimeout)
+	client, err := Dial(ctx, Options{
+		Broker:    p.settings.Broker,
+		ClientID:  "hdhr-proxy-" + p.id,
+		Username:  p.settings.Username,
+		Password:  p.settings.Password,
+		KeepAlive: keepAlive,
+		Will:      &Message{Topic: base + "/availability", Payload: []byte(offline), Retain: true},
+	})
+	cancel()
+	if err != nil {
+		p.logger.Warn("⚠️  MQTT connection failed",
+			logger.String("broker", p.settings.Broker),
+			logger.ErrorField("error", err))
+		return false
+	}
+	p.logger.Info("📡 MQTT connected", logger.String("broker", p.settings.Broker), logger.String("topic", base))
+
+	// Discovery configs are retained by the broker, but a restarted broker
+	// may have lost them, so publish everything again on each connection
+	clear(p.discovered)
+	err = p.publishAll(client, true)
+
+	ticker := time.NewTicker(stateInterval)
+	defer ticker.Stop()
+	for err == nil {
+		select {
+		case <-p.ctx.Done():
+			_ = client.Publish(Message{Topic: base + "/availability", Payload: []byte(offline), Retain: true})
+			_ = client.Close()
+			return true
+		case <-client.Done():
+			p.logger.Warn("⚠️  MQTT connection lost", logger.String("broker", p.settings.Broker))
+			return true
+		case e := <-updates:
+			err = p.publishAll(client, e.Type == events.LineupChanged)
+		case <-ticker.C:
+			err = p.publishAll(client, false)
+		}
+	}
+
+	p.logger.Warn("⚠️  MQTT publish failed", logger.ErrorField("error", err))
+	_ = client.Close()
+	return true
+}
+
+// publishAll publishes availability, the state and, when discover is set, the
+// discovery configs for any lineup changes.
+func (p *Publisher) publishAll(client *Client, discover bool) error {
+	status := p.reporter.Status()
+	base := p.baseTopic()
+
+	messages := []Message{{Topic: base + "/availability", Payload: []byte(online), Retain: true}}
+	if discover {
+		messages = append(messages, p.discoveryMessages(status)...)
+	}
+
+	state := stateOf(status)
+	payload, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	messages = append(messages, Message{Topic: base + "/state", Payload: payload, Retain: true})
+
+	watching := make(map[string]bool, len(state.Watching))
+	for _, channel := range state.Watching {
+		watching[channel] = true
+	}
+	for _, channel := range status.Lineup {
+		value := "OFF"
+		if watching[channel.GuideNumber] {
+			value = "ON"
+		}
+		messages = append(messages, Message{Topic: base + "/channel/" + channel.GuideNumber, Payload: []byte(value), Retain: true})
+	}
+
+	for _, m := range messages {
+		if err := client.Publish(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stateOf summarizes the status for the state topic. A tuner is in use for
+// each distinct channel with a session.
+func stateOf(status interfaces.Status) State {
+	state := State{
+		ActiveStreams: len(status.Sessions),
+		TunerCount:    status.Device.TunerCount,
+		Watching:      []string{},
+	}
+
+	seen := make(map[string]bool)
+	for _, s := range status.Sessions {
+		if s.Mode == string(session.ModeTranscode) {
+			state.Transcoding++
+		}
+		if !seen[s.Channel] {
+			seen[s.Channel] = true
+			state.Watching = append(state.Watching, s.Channel)
+		}
+	}
+	state.TunersInUse = len(state.Watching)
+	return state
+}
+
+// discoveryMessages returns Home Assistant discovery configs for the proxy's
+// sensors and each lineup channel, and removes channels no longer in the lineup.
+func (p *Publisher) discoveryMessages(status interfaces.Status) []Message {
+	base := p.baseTopic()
+	node := "hdhr_proxy_" + p.id
+	device := map[string]interface{}{
+		"identifiers":  []string{node},
+		"name":         "HDHomeRun Proxy " + status.Device.DeviceID,
+		"manufacturer": "hdhr-proxy",
+		"model":        "HDHomeRun AC4 proxy",
+	}
+	config := func(component, object string, fields map[string]interface{}) Message {
+		fields["unique_id"] = node + "_" + object
+		fields["object_id"] = node + "_" + object
+		fields["availability_topic"] = base + "/availability"
+		fields["device"] = device
+		payload, _ := json.Marshal(fields)
+		return Message{Topic: p.discoveryTopic(component, object), Payload: payload, Retain: true}
+	}
+
+	var messages []Message
+	for _, sensor := range []struct{ object, name, icon string }{
+		{"active_streams", "Active streams", "mdi:television-play"},
+		{"tuners_in_use", "Tuners in use", "mdi:antenna"},
+		{"transcoding", "Transcoding streams", "mdi:swap-horizontal"},
+	} {
+		messages = append(messages, config("sensor", sensor.object, map[string]interface{}{
+			"name":           sensor.name,
+			"icon":           sensor.icon,
+			"state_topic":    base + "/state",
+			"value_template": "{{ value_json." + sensor.object + " }}",
+			"state_class":    "measurement",
+		}))
+	}
+
+	current := make(map[string]bool, len(status.Lineup))
+	for _, channel := range status.Lineup {
+		current[channel.GuideNumber] = true
+		if p.discovered[channel.GuideNumber] {
+			continue
+		}
+		p.discovered[channel.GuideNumber] = true
+		messages = append(messages, config("binary_sensor", channelObject(channel.GuideNumber), map[string]interface{}{
+			"name":        "Watching " + strings.TrimSpace(channel.GuideNumber+" "+channel.GuideName),
+			"icon":        "mdi:television-classic",
+			"state_topic": base + "/channel/" + channel.GuideNumber,
+			"payload_on":  "ON",
+			"payload_off": "OFF",
+		}))
+	}
+
+	// An empty retained config removes the entity from Home Assistant
+	for channel := range p.discovered {
+		if !current[channel] {
+			delete(p.discovered, channel)
+			messages = append(messages, Message{Topic: p.discoveryTopic("binary_sensor", channelObject(channel)), Retain: true})
+		}
+	}
+	return messages
+}
+
+// discoveryTopic returns the Home Assistant config topic for an entity.
+func (p *Publisher) discoveryTopic(component, object string) string {
+	return p.settings.DiscoveryPrefix + "/" + component + "/hdhr_proxy_" + p.id + "/" + object + "/config"
+}
+
+// baseTopic returns the topic prefix for this proxy's state.
+func (p *Publisher) baseTopic() string {
+	return p.settings.TopicPrefix + "/" + p.id
+}
+
+// channelObject returns the entity object ID for a channel.
+func channelObject(channel string) string {
+	return "channel_" + sanitize(channel)
+}
+
+// sanitize lower-cases s and replaces anything but letters and digits with "_".
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + ('a' - 'A')
+		default:
+			return '_'
+		}
+	}, s)
+}
@@ -5,6 +5,7 @@ package proxy
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -12,10 +13,14 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/attaebra/hdhr-proxy/internal/constants"
 	"github.com/attaebra/hdhr-proxy/internal/interfaces"
 	"github.com/attaebra/hdhr-proxy/internal/logger"
+	"github.com/attaebra/hdhr-proxy/internal/publicurl"
 	"github.com/attaebra/hdhr-proxy/internal/utils"
 )
 
@@ -32,10 +37,37 @@ const (
 
 // HDHRProxy represents an HDHomeRun proxy instance.
 type HDHRProxy struct {
-	HDHRIP   string
-	deviceID string
-	Client   interfaces.Client
-	logger   interfaces.Logger
+	DeviceMediaPort int          // Port the device serves streams on
+	deviceMutex     sync.RWMutex // Guards the device fields, which change while serving
+	hdhrIP          string
+	deviceID        string
+	lockedID        string // The device ID every later answer must match
+	tunerCount      int
+	Client          interfaces.Client
+	logger          interfaces.Logger
+	routes          []route             // Additional API routes served before the catch-all proxy
+	urls            *publicurl.Resolver // Public URLs advertised in device responses
+	draining        atomic.Bool         // Discovery reports the proxy unavailable
+	deviceOffline   atomic.Bool         // The device has not been reached yet
+}
+
+// DeviceMismatchError is returned by FetchDeviceID when a device other than
+// the locked one answers at the address, e.g. after DHCP reassigned it.
+type DeviceMismatchError struct {
+	Address  string
+	Expected string
+	Found    string
+}
+
+// Error implements the error interface.
+func (e *DeviceMismatchError) Error() string {
+	return fmt.Sprintf("HDHomeRun at %s is %s, expected %s", e.Address, e.Found, e.Expected)
+}
+
+// route is an additional handler registered on the API server.
+type route struct {
+	pattern string
+	handler http.Handler
 }
 
 // Ensure HDHRProxy implements the HDHRProxy interface.
@@ -50,50 +82,112 @@ func NewForTesting(hdhrIP string) *HDHRProxy {
 	testLogger := logger.NewZapLogger(logger.LevelDebug)
 
 	return &HDHRProxy{
-		HDHRIP:   hdhrIP,
-		deviceID: "00ABCDEF", // Default device ID, will be updated
-		Client:   client,
-		logger:   testLogger,
+		hdhrIP:          hdhrIP,
+		DeviceMediaPort: constants.DefaultDeviceMediaPort,
+		deviceID:        "00ABCDEF", // Default device ID, will be updated
+		Client:          client,
+		logger:          testLogger,
+		urls:            &publicurl.Resolver{MediaPort: constants.DefaultMediaPort},
 	}
 }
 
 // New creates a new HDHomeRun proxy instance with injected dependencies.
-func New(hdhrIP string, httpClient interfaces.Client, logger interfaces.Logger) interfaces.Proxy {
+// deviceMediaPort is the port the device serves streams on.
+func New(hdhrIP string, deviceMediaPort int, httpClient interfaces.Client, logger interfaces.Logger) interfaces.Proxy {
 	return &HDHRProxy{
-		HDHRIP:   hdhrIP,
-		deviceID: "00ABCDEF", // Default device ID, will be updated
-		Client:   httpClient,
-		logger:   logger,
+		hdhrIP:          hdhrIP,
+		DeviceMediaPort: deviceMediaPort,
+		deviceID:        "00ABCDEF", // Default device ID, will be updated
+		Client:          httpClient,
+		logger:          logger,
+		urls:            &publicurl.Resolver{MediaPort: constants.DefaultMediaPort},
 	}
 }
 
+// SetPublicURLs sets how the URLs advertised in device responses are built.
+func (p *HDHRProxy) SetPublicURLs(urls *publicurl.Resolver) {
+	p.urls = urls
+}
+
+// SetDraining makes discovery report the proxy as unavailable while draining
+// is true, so media servers stop sending new tunes to it.
+func (p *HDHRProxy) SetDraining(draining bool) {
+	p.draining.Store(draining)
+}
+
+// SetDeviceOffline makes API requests fail with 503 while offline is true,
+// instead of timing out against a device that is not reachable yet.
+func (p *HDHRProxy) SetDeviceOffline(offline bool) {
+	p.deviceOffline.Store(offline)
+}
+
 // DeviceID returns the current device ID.
 func (p *HDHRProxy) DeviceID() string {
+	p.deviceMutex.RLock()
+	defer p.deviceMutex.RUnlock()
 	return p.deviceID
 }
 
+// TunerCount returns the number of tuners reported by the device, or 0 if unknown.
+func (p *HDHRProxy) TunerCount() int {
+	p.deviceMutex.RLock()
+	defer p.deviceMutex.RUnlock()
+	return p.tunerCount
+}
+
 // GetHDHRIP returns the HDHomeRun IP address.
 func (p *HDHRProxy) GetHDHRIP() string {
-	return p.HDHRIP
+	p.deviceMutex.RLock()
+	defer p.deviceMutex.RUnlock()
+	return p.hdhrIP
+}
+
+// SetHDHRIP points the proxy at the device's new address.
+func (p *HDHRProxy) SetHDHRIP(hdhrIP string) {
+	p.deviceMutex.Lock()
+	defer p.deviceMutex.Unlock()
+	p.hdhrIP = hdhrIP
+}
+
+// LockDeviceID makes FetchDeviceID fail with a *DeviceMismatchError when
+// another device answers. The first device ID fetched is locked otherwise.
+func (p *HDHRProxy) LockDeviceID(id string) {
+	p.deviceMutex.Lock()
+	defer p.deviceMutex.Unlock()
+	p.lockedID = strings.ToUpper(id)
+}
+
+// LockedDeviceID returns the device ID the proxy follows, or "" before any
+// device has answered.
+func (p *HDHRProxy) LockedDeviceID() string {
+	p.deviceMutex.RLock()
+	defer p.deviceMutex.RUnlock()
+	return p.lockedID
 }
 
 // ReverseDeviceID reverses the device ID string.
 func (p *HDHRProxy) ReverseDeviceID() string {
-	runes := []rune(p.deviceID)
+	runes := []rune(p.DeviceID())
 	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
 		runes[i], runes[j] = runes[j], runes[i]
 	}
 	return string(runes)
 }
 
-// FetchDeviceID retrieves the actual device ID from the HDHomeRun.
-func (p *HDHRProxy) FetchDeviceID() error {
+// FetchDeviceID retrieves the actual device ID from the HDHomeRun. The
+// request is abandoned when ctx is cancelled.
+func (p *HDHRProxy) FetchDeviceID(ctx context.Context) error {
 	defer utils.TimeOperation("Fetch device ID")()
+	hdhrIP := p.GetHDHRIP()
 	p.logger.Debug("📡 Fetching device ID from HDHomeRun",
-		logger.String("hdhr_ip", p.HDHRIP))
-	resp, err := p.Client.Get("http://" + p.HDHRIP + "/discover.json")
+		logger.String("hdhr_ip", hdhrIP))
+	req, err := http.NewRequestWithContext(ctx, "GET", "http://"+hdhrIP+"/discover.json", nil)
+	if err != nil {
+		return utils.LogAndWrapError(err, "failed to create request")
+	}
+	resp, err := p.Client.Do(req)
 	if err != nil {
-		return utils.LogAndWrapError(err, "failed to connect to HDHomeRun at %s", p.HDHRIP)
+		return utils.LogAndWrapError(err, "failed to connect to HDHomeRun at %s", hdhrIP)
 	}
 	defer resp.Body.Close()
 
@@ -112,7 +206,8 @@ func (p *HDHRProxy) FetchDeviceID() error {
 
 	// Parse JSON response
 	var discovery struct {
-		DeviceID string `json:"DeviceID"`
+		DeviceID   string `json:"DeviceID"`
+		TunerCount int    `json:"TunerCount"`
 	}
 
 	if err := json.NewDecoder(strings.NewReader(string(body))).Decode(&discovery); err != nil {
@@ -121,10 +216,27 @@ func (p *HDHRProxy) FetchDeviceID() error {
 		return nil // Don't fail if we can't parse, just use default
 	}
 
+	p.deviceMutex.Lock()
 	if discovery.DeviceID != "" {
+		if p.lockedID != "" && !strings.EqualFold(discovery.DeviceID, p.lockedID) {
+			p.deviceMutex.Unlock()
+			return &DeviceMismatchError{Address: hdhrIP, Expected: p.lockedID, Found: discovery.DeviceID}
+		}
 		p.deviceID = discovery.DeviceID
+		p.lockedID = strings.ToUpper(discovery.DeviceID)
+	}
+	if discovery.TunerCount > 0 {
+		p.tunerCount = discovery.TunerCount
+	}
+	p.deviceMutex.Unlock()
+
+	if discovery.DeviceID != "" {
 		p.logger.Debug("✅ Successfully updated device ID",
-			logger.String("device_id", p.deviceID))
+			logger.String("device_id", discovery.DeviceID))
+	}
+	if discovery.TunerCount > 0 {
+		p.logger.Debug("📶 Device tuner count",
+			logger.Int("tuner_count", discovery.TunerCount))
 	}
 
 	return nil
@@ -135,7 +247,7 @@ func (p *HDHRProxy) setupProxyRequest(r *http.Request) (*RequestSetup, error) {
 	// Create target URL
 	targetURL := &url.URL{
 		Scheme:   "http",
-		Host:     p.HDHRIP,
+		Host:     p.GetHDHRIP(),
 		Path:     r.URL.Path,
 		RawQuery: r.URL.RawQuery,
 	}
@@ -197,96 +309,88 @@ func (p *HDHRProxy) HandleAppRequest(w http.ResponseWriter, r *http.Request) {
 // to ensure compatibility with media servers and clients. It performs several transformations:
 // 1. Replaces the original device ID with the reversed version for client compatibility.
 // 2. Updates URLs to point to the proxy server instead of directly to the HDHomeRun.
-// 3. Adjusts port numbers and host information to maintain proper routing.
+// 3. Uses the public API and media URLs the client can reach (see publicurl.Resolver).
 //
 // Parameters:
 //   - body: The original response body from the HDHomeRun.
-//   - host: The host header from the original request (used for URL rewriting).
+//   - r: The original client request (used for URL rewriting).
 //
 // Returns the transformed response body as a byte slice.
-func (p *HDHRProxy) transformResponseBody(body []byte, host string) []byte {
-	content := string(body)
-
-	// Pre-calculate host parts to avoid repeated parsing
-	hostParts := strings.Split(host, ":")
-	hostName := hostParts[0]
-	hostPort := "80"
-	if len(hostParts) > 1 {
-		hostPort = hostParts[1]
-	}
-	hostWithPort := host
-	if hostPort == "80" {
-		hostWithPort = hostName
-	}
-
-	// Pre-calculate replacement strings to avoid repeated concatenation
-	reversedDeviceID := p.ReverseDeviceID()
-	hdhrIPWithPort := p.HDHRIP + ":5004"
-	hostNameWithPort := hostName + ":5004"
-
-	// Use strings.Builder for efficient string building
-	var result strings.Builder
-	result.Grow(len(content) + 256) // Pre-allocate with some extra space for expansions
-
-	// Process the content in a single pass with multiple replacements
-	// This is more efficient than multiple separate ReplaceAll calls
-	i := 0
-	for i < len(content) {
-		// Check for device ID replacement
-		if i <= len(content)-len(p.DeviceID()) && content[i:i+len(p.DeviceID())] == p.DeviceID() {
-			result.WriteString(reversedDeviceID)
-			i += len(p.DeviceID())
-			continue
-		}
-
-		// Check for HDHomeRun IP with port 5004 replacement
-		if i <= len(content)-len(hdhrIPWithPort) && content[i:i+len(hdhrIPWithPort)] == hdhrIPWithPort {
-			result.WriteString(hostNameWithPort)
-			i += len(hdhrIPWithPort)
-			continue
-		}
-
-		// Check for HDHomeRun IP replacement (be careful not to replace already processed URLs)
-		if i <= len(content)-len(p.HDHRIP) && content[i:i+len(p.HDHRIP)] == p.HDHRIP {
-			// Look ahead to see if this is followed by ":5004" (already handled above)
-			if i+len(p.HDHRIP) < len(content) && content[i+len(p.HDHRIP):i+len(p.HDHRIP)+1] == ":" {
-				// This might be the IP:port pattern, let it be handled by other cases
-				result.WriteByte(content[i])
-				i++
-				continue
-			}
-			result.WriteString(hostWithPort)
-			i += len(p.HDHRIP)
-			continue
-		}
+func (p *HDHRProxy) transformResponseBody(body []byte, r *http.Request) []byte {
+	return []byte(p.responseReplacer(r).Replace(string(body)))
+}
 
-		// Check for AC4 replacement
-		if i <= len(content)-3 && content[i:i+3] == "AC4" {
-			result.WriteString("AC3")
-			i += 3
-			continue
-		}
+// responseReplacer returns the replacements applied to device responses for a
+// client request. At any position the first matching pair wins, so full URLs
+// are listed before the bare addresses they start with.
+func (p *HDHRProxy) responseReplacer(r *http.Request) *strings.Replacer {
+	base := p.urls.Base(r)
+	media := p.urls.Media(r)
+
+	hdhrIP := p.GetHDHRIP()
+	deviceBase := "http://" + hdhrIP
+	deviceMediaHost := utils.DeviceMediaHost(hdhrIP, p.DeviceMediaPort)
+	deviceMedia := "http://" + deviceMediaHost
+
+	return strings.NewReplacer(
+		deviceMedia, media,
+		deviceBase+":80", base,
+		deviceBase, base,
+		deviceMediaHost, hostOf(media),
+		hdhrIP, hostOf(base),
+		p.DeviceID(), p.ReverseDeviceID(),
+		"AC4", "AC3",
+	)
+}
 
-		// No replacement needed, copy the character
-		result.WriteByte(content[i])
-		i++
+// hostOf returns the host[:port] part of a URL.
+func hostOf(rawURL string) string {
+	if _, host, found := strings.Cut(rawURL, "://"); found {
+		return host
 	}
-
-	return []byte(result.String())
+	return rawURL
 }
 
 // APIHandler returns a http.Handler for the API endpoints.
 func (p *HDHRProxy) APIHandler() http.Handler {
 	mux := http.NewServeMux()
 
-	// Handle all API requests
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		p.ProxyRequest(w, r)
+	// Register additional API routes (DVR, ...)
+	for _, rt := range p.routes {
+		mux.Handle(rt.pattern, rt.handler)
+	}
+
+	// Announce the proxy as unavailable while it drains
+	mux.HandleFunc("/discover.json", func(w http.ResponseWriter, r *http.Request) {
+		if p.draining.Load() {
+			http.Error(w, "Proxy is draining", http.StatusServiceUnavailable)
+			return
+		}
+		p.proxyToDevice(w, r)
 	})
 
+	// Handle all API requests
+	mux.HandleFunc("/", p.proxyToDevice)
+
 	return mux
 }
 
+// proxyToDevice proxies a request unless the device is known to be offline.
+func (p *HDHRProxy) proxyToDevice(w http.ResponseWriter, r *http.Request) {
+	if p.deviceOffline.Load() {
+		w.Header().Set("Retry-After", "5")
+		http.Error(w, "HDHomeRun device is not available yet, retrying in the background", http.StatusServiceUnavailable)
+		return
+	}
+	p.ProxyRequest(w, r)
+}
+
+// Handle registers an additional handler on the API server. Routes must be
+// registered before APIHandler is called.
+func (p *HDHRProxy) Handle(pattern string, handler http.Handler) {
+	p.routes = append(p.routes, route{pattern: pattern, handler: handler})
+}
+
 // ProxyRequest handles proxying a single HTTP request to the HDHomeRun
 // and transforms the response appropriately.
 func (p *HDHRProxy) ProxyRequest(w http.ResponseWriter, r *http.Request) {
@@ -315,16 +419,12 @@ func (p *HDHRProxy) ProxyRequest(w http.ResponseWriter, r *http.Request) {
 }
 
 // streamWithLimitedTransformation streams large responses with basic transformations.
-func (p *HDHRProxy) streamWithLimitedTransformation(w io.Writer, r io.Reader, host string) error {
+func (p *HDHRProxy) streamWithLimitedTransformation(w io.Writer, r io.Reader, req *http.Request) error {
 	// For large responses, we'll do basic streaming with line-by-line processing
 	// This is less efficient but prevents memory issues with very large responses
 
 	// Pre-compile replacements for better performance
-	replacer := strings.NewReplacer(
-		p.DeviceID(), p.ReverseDeviceID(),
-		p.HDHRIP+":5004", strings.Split(host, ":")[0]+":5004",
-		"AC4", "AC3",
-	)
+	replacer := p.responseReplacer(req)
 
 	scanner := bufio.NewScanner(r)
 	// Use a reasonable buffer size - 8KB is typically sufficient for API responses
@@ -377,18 +477,19 @@ func (p *HDHRProxy) streamResponse(w http.ResponseWriter, resp *http.Response, r
 
 	// If content is small (< 1MB) or size unknown, load and transform
 	if contentLength == -1 || contentLength < maxInMemorySize {
-		return p.transformSmallResponse(w, resp.Body, r.Host, contentLength)
+		return p.transformSmallResponse(w, resp.Body, r, contentLength)
 	}
 
 	// For large responses that need transformation, we'll stream with limited transformation
 	// This is a fallback - in practice, HDHomeRun API responses are typically small
 	p.logger.Debug("📦 Streaming large response with limited transformation")
-	return p.streamWithLimitedTransformation(w, resp.Body, r.Host)
+	return p.streamWithLimitedTransformation(w, resp.Body, r)
 }
 
 // needsTransformation checks if the content type requires transformation.
 func (p *HDHRProxy) needsTransformation(contentType string) bool {
 	return strings.Contains(contentType, "application/json") ||
+		strings.Contains(contentType, "application/xml") ||
 		strings.Contains(contentType, "text/html") ||
 		strings.Contains(contentType, "text/plain") ||
 		strings.Contains(contentType, "text/xml")
@@ -408,7 +509,7 @@ func (p *HDHRProxy) getContentLength(headers http.Header) int64 {
 }
 
 // transformSmallResponse handles transformation of small responses using buffer pool.
-func (p *HDHRProxy) transformSmallResponse(w http.ResponseWriter, body io.Reader, host string, contentLength int64) error {
+func (p *HDHRProxy) transformSmallResponse(w http.ResponseWriter, body io.Reader, r *http.Request, contentLength int64) error {
 	p.logger.Debug("💾 Loading response into memory for transformation",
 		logger.Int64("size_bytes", contentLength))
 
@@ -422,7 +523,7 @@ func (p *HDHRProxy) transformSmallResponse(w http.ResponseWriter, body io.Reader
 	}
 
 	// Transform the response
-	transformed := p.transformResponseBody(data, host)
+	transformed := p.transformResponseBody(data, r)
 
 	// Write the transformed response
 	_, err = w.Write(transformed)
@@ -1,58 +1,31 @@
 package proxy
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
 
+	"github.com/attaebra/hdhr-proxy/internal/hdhrsim"
 	"github.com/attaebra/hdhr-proxy/internal/logger"
+	"github.com/attaebra/hdhr-proxy/internal/publicurl"
 )
 
-// mockHDHR simulates an HDHomeRun device for testing purposes,
-// providing endpoints that mimic the actual device's behavior.
-type mockHDHR struct {
-	server *httptest.Server
-}
-
-// newMockHDHR creates and configures a new mock HDHomeRun server
-// with all the necessary endpoints for testing.
-func newMockHDHR() *mockHDHR {
-	mock := &mockHDHR{}
-
-	// Create a test server
-	handler := http.NewServeMux()
-
-	// Add a discover.json endpoint
-	handler.HandleFunc("/discover.json", func(w http.ResponseWriter, _ *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		w.Write([]byte(`{"DeviceID":"ABCDEF12","LocalIP":"192.168.1.100"}`))
-	})
-
-	// Add a lineup.json endpoint
-	handler.HandleFunc("/lineup.json", func(w http.ResponseWriter, _ *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		w.Write([]byte(`[
-			{"GuideNumber":"5.1","GuideName":"NBC","URL":"http://192.168.1.100:5004/auto/v5.1"},
-			{"GuideNumber":"7.1","GuideName":"ABC","URL":"http://192.168.1.100:5004/auto/v7.1"}
-		]`))
-	})
-
-	// Create the test server
-	mock.server = httptest.NewServer(handler)
-
-	return mock
-}
-
-func (m *mockHDHR) Close() {
-	if m.server != nil {
-		m.server.Close()
+// newDevice serves a simulated HDHomeRun with four tuners and the default
+// AC4 and AC3 channels until the test ends.
+func newDevice(t *testing.T) *httptest.Server {
+	t.Helper()
+	sim, err := hdhrsim.New(hdhrsim.Options{DeviceID: "ABCDEF12", TunerCount: 4})
+	if err != nil {
+		t.Fatalf("Failed to create simulator: %v", err)
 	}
-}
-
-func (m *mockHDHR) URL() string {
-	return m.server.URL
+	server := httptest.NewServer(sim)
+	t.Cleanup(server.Close)
+	return server
 }
 
 // TestNewForTesting tests the creation of a new HDHRProxy for testing.
@@ -61,8 +34,8 @@ func TestNewForTesting(t *testing.T) {
 
 	proxy := NewForTesting(hdhrIP)
 
-	if proxy.HDHRIP != "192.168.1.100" {
-		t.Errorf("Expected HDHRIP to be 192.168.1.100, got %s", proxy.HDHRIP)
+	if proxy.GetHDHRIP() != "192.168.1.100" {
+		t.Errorf("Expected HDHRIP to be 192.168.1.100, got %s", proxy.GetHDHRIP())
 	}
 
 	if proxy.DeviceID() != "00ABCDEF" {
@@ -74,6 +47,24 @@ func TestNewForTesting(t *testing.T) {
 	}
 }
 
+// TestFetchDeviceID tests that the device ID and tuner count are read from discover.json.
+func TestFetchDeviceID(t *testing.T) {
+	device := newDevice(t)
+
+	proxy := NewForTesting(strings.TrimPrefix(device.URL, "http://"))
+	if err := proxy.FetchDeviceID(context.Background()); err != nil {
+		t.Fatalf("FetchDeviceID failed: %v", err)
+	}
+
+	if proxy.DeviceID() != "ABCDEF12" {
+		t.Errorf("Expected DeviceID ABCDEF12, got %s", proxy.DeviceID())
+	}
+
+	if proxy.TunerCount() != 4 {
+		t.Errorf("Expected TunerCount 4, got %d", proxy.TunerCount())
+	}
+}
+
 // TestReverseDeviceID tests the device ID reversing method.
 func TestReverseDeviceID(t *testing.T) {
 	testCases := []struct {
@@ -100,16 +91,15 @@ func TestReverseDeviceID(t *testing.T) {
 
 // TestCreateAPIHandler tests the API handler creation.
 func TestAPIHandler(t *testing.T) {
-	// Start mock HDHomeRun server
-	mock := newMockHDHR()
-	defer mock.Close()
+	// Start a simulated HDHomeRun
+	device := newDevice(t)
 
-	// Get the host from the mock server URL (e.g., "127.0.0.1:12345")
+	// Get the host from the device URL (e.g., "127.0.0.1:12345")
 	// Remove the "http://" prefix
-	mockHost := mock.server.URL[7:]
+	deviceHost := device.URL[7:]
 
 	// Create a proxy instance
-	proxy := NewForTesting(mockHost)
+	proxy := NewForTesting(deviceHost)
 
 	// Create an API handler
 	handler := proxy.APIHandler()
@@ -143,6 +133,101 @@ func TestAPIHandler(t *testing.T) {
 	// fetch the real device ID from the HDHomeRun or use a different transformation
 }
 
+func TestDiscoveryWhileDraining(t *testing.T) {
+	device := newDevice(t)
+	proxy := NewForTesting(device.URL[7:])
+	handler := proxy.APIHandler()
+
+	proxy.SetDraining(true)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/discover.json", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 while draining, got %d", recorder.Code)
+	}
+
+	// The lineup stays available to clients already watching
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/lineup.json", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Expected the lineup to be served while draining, got %d", recorder.Code)
+	}
+
+	proxy.SetDraining(false)
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/discover.json", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Expected discovery to recover after draining, got %d", recorder.Code)
+	}
+}
+
+func TestRequestsWhileDeviceOffline(t *testing.T) {
+	device := newDevice(t)
+	proxy := NewForTesting(device.URL[7:])
+	proxy.Handle("/healthz", http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	handler := proxy.APIHandler()
+
+	proxy.SetDeviceOffline(true)
+	for _, path := range []string{"/discover.json", "/lineup.json"} {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+		if recorder.Code != http.StatusServiceUnavailable || !strings.Contains(recorder.Body.String(), "not available yet") {
+			t.Errorf("Expected 503 for %s while the device is offline, got %d %q", path, recorder.Code, recorder.Body.String())
+		}
+	}
+
+	// The proxy's own routes keep working
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/healthz", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Expected registered routes to be served while offline, got %d", recorder.Code)
+	}
+
+	proxy.SetDeviceOffline(false)
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/discover.json", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Expected discovery to recover once the device is reached, got %d", recorder.Code)
+	}
+}
+
+func TestFetchDeviceIDFollowsLockedDevice(t *testing.T) {
+	device := newDevice(t)
+	proxy := NewForTesting(device.URL[7:])
+
+	// The first device to answer is locked
+	if err := proxy.FetchDeviceID(context.Background()); err != nil || proxy.LockedDeviceID() != "ABCDEF12" {
+		t.Fatalf("Expected ABCDEF12 to be locked, got %q, %v", proxy.LockedDeviceID(), err)
+	}
+
+	// Another device now answers at the address
+	sim, err := hdhrsim.New(hdhrsim.Options{DeviceID: "10A00001"})
+	if err != nil {
+		t.Fatalf("Failed to create simulator: %v", err)
+	}
+	other := httptest.NewServer(sim)
+	defer other.Close()
+	proxy.SetHDHRIP(other.URL[7:])
+
+	var mismatch *DeviceMismatchError
+	if err := proxy.FetchDeviceID(context.Background()); !errors.As(err, &mismatch) || mismatch.Found != "10A00001" || mismatch.Expected != "ABCDEF12" {
+		t.Fatalf("Expected a device mismatch, got %v", err)
+	}
+	if proxy.DeviceID() != "ABCDEF12" {
+		t.Errorf("Expected the locked device ID to be kept, got %s", proxy.DeviceID())
+	}
+
+	// Back at the right device, requests go to the new address
+	proxy.SetHDHRIP(device.URL[7:])
+	if err := proxy.FetchDeviceID(context.Background()); err != nil {
+		t.Errorf("Expected the locked device to be accepted, got %v", err)
+	}
+	recorder := httptest.NewRecorder()
+	proxy.APIHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/lineup.json", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Expected the lineup from the new address, got %d", recorder.Code)
+	}
+}
+
 // LineupItem represents a channel in the lineup.
 type LineupItem struct {
 	GuideNumber string `json:"GuideNumber"`
@@ -155,16 +240,13 @@ func TestLineupModification(t *testing.T) {
 	// Initialize logger for tests
 	logger.SetLevel(logger.LevelDebug)
 
-	// Set up a mock HDHomeRun server
-	mock := newMockHDHR()
-	defer mock.Close()
+	// Set up a simulated HDHomeRun
+	device := newDevice(t)
 
-	// Create a proxy using the mock server URL
-	// Extract the host and port from the mock URL
-	mockURL := mock.URL()
-	mockHost := strings.TrimPrefix(mockURL, "http://")
+	// Create a proxy using the host and port of the device
+	deviceHost := strings.TrimPrefix(device.URL, "http://")
 
-	proxy := NewForTesting(mockHost)
+	proxy := NewForTesting(deviceHost)
 
 	// Create an API handler
 	handler := proxy.APIHandler()
@@ -199,21 +281,19 @@ func TestProxyRequest(t *testing.T) {
 	// Initialize logger for tests
 	logger.SetLevel(logger.LevelDebug)
 
-	// Set up a mock HDHomeRun server
-	mock := newMockHDHR()
-	defer mock.Close()
+	// Set up a simulated HDHomeRun
+	device := newDevice(t)
 
 	// The test server's URL gives us the host:port
-	mockURL := mock.URL()
-	hdhrURL := strings.TrimPrefix(mockURL, "http://")
+	hdhrURL := strings.TrimPrefix(device.URL, "http://")
 
 	// Create a proxy
 	proxy := NewForTesting(hdhrURL)
 
-	// Test a direct request to the mock server
-	resp, err := http.Get(mockURL + "/discover.json")
+	// Test a direct request to the device
+	resp, err := http.Get(device.URL + "/discover.json")
 	if err != nil {
-		t.Fatalf("Failed to make request to mock server: %v", err)
+		t.Fatalf("Failed to make request to the device: %v", err)
 	}
 	defer resp.Body.Close()
 
@@ -237,3 +317,72 @@ func TestProxyRequest(t *testing.T) {
 		t.Errorf("Expected Content-Type %s, got %s", originalContentType, proxyContentType)
 	}
 }
+
+// TestLineupURLRewriting tests that device URLs become the proxy's public URLs.
+func TestLineupURLRewriting(t *testing.T) {
+	proxy := NewForTesting("192.168.1.100")
+	body := []byte(`{"BaseURL":"http://192.168.1.100","URL":"http://192.168.1.100:5004/auto/v5.1","URLBase":"http://192.168.1.100:80"}`)
+
+	request := func(host string, secure bool) *http.Request {
+		req := httptest.NewRequest("GET", "/lineup.json", nil)
+		req.Host = host
+		if secure {
+			req.TLS = &tls.ConnectionState{}
+		}
+		return req
+	}
+
+	plain := string(proxy.transformResponseBody(body, request("proxy.local", false)))
+	if want := `{"BaseURL":"http://proxy.local","URL":"http://proxy.local:5004/auto/v5.1","URLBase":"http://proxy.local"}`; plain != want {
+		t.Errorf("Expected %s, got %s", want, plain)
+	}
+
+	// Over TLS without an HTTPS media port only the base URL moves to https://
+	secure := string(proxy.transformResponseBody(body, request("proxy.local:8443", true)))
+	if want := `{"BaseURL":"https://proxy.local:8443","URL":"http://proxy.local:5004/auto/v5.1","URLBase":"https://proxy.local:8443"}`; secure != want {
+		t.Errorf("Expected %s, got %s", want, secure)
+	}
+
+	proxy.SetPublicURLs(&publicurl.Resolver{MediaPort: 5004, SecureMediaPort: 5443})
+	secure = string(proxy.transformResponseBody(body, request("proxy.local:443", true)))
+	if want := `{"BaseURL":"https://proxy.local","URL":"https://proxy.local:5443/auto/v5.1","URLBase":"https://proxy.local"}`; secure != want {
+		t.Errorf("Expected %s, got %s", want, secure)
+	}
+
+	// Explicit public URLs win over the request
+	proxy.SetPublicURLs(&publicurl.Resolver{BaseURL: "https://tv.example.com", MediaURL: "http://192.168.1.5:15004", MediaPort: 5004})
+	public := string(proxy.transformResponseBody(body, request("proxy.local", false)))
+	if want := `{"BaseURL":"https://tv.example.com","URL":"http://192.168.1.5:15004/auto/v5.1","URLBase":"https://tv.example.com"}`; public != want {
+		t.Errorf("Expected %s, got %s", want, public)
+	}
+}
+
+func TestLineupURLRewritingNonDefaultPorts(t *testing.T) {
+	proxy := NewForTesting("192.168.1.100")
+	proxy.DeviceMediaPort = 15004
+	proxy.SetPublicURLs(&publicurl.Resolver{MediaPort: 6004})
+
+	body := []byte(`{"URL":"http://192.168.1.100:15004/auto/v5.1","Host":"192.168.1.100:15004","BaseURL":"http://192.168.1.100"}`)
+	req := httptest.NewRequest("GET", "/lineup.json", nil)
+	req.Host = "proxy.local"
+
+	got := string(proxy.transformResponseBody(body, req))
+	if want := `{"URL":"http://proxy.local:6004/auto/v5.1","Host":"proxy.local:6004","BaseURL":"http://proxy.local"}`; got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+func TestLineupURLRewritingDeviceAPIPort(t *testing.T) {
+	// hdhr_ip may carry the port of the device's API, which streams do not use
+	proxy := NewForTesting("192.168.1.100:8080")
+	proxy.SetPublicURLs(&publicurl.Resolver{MediaPort: 5004})
+
+	body := []byte(`{"BaseURL":"http://192.168.1.100:8080","URL":"http://192.168.1.100:5004/auto/v5.1"}`)
+	req := httptest.NewRequest("GET", "/lineup.json", nil)
+	req.Host = "proxy.local"
+
+	got := string(proxy.transformResponseBody(body, req))
+	if want := `{"BaseURL":"http://proxy.local","URL":"http://proxy.local:5004/auto/v5.1"}`; got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
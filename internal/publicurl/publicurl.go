@@ -0,0 +1,110 @@
+// Package publicurl works out the URLs clients should use to reach the proxy,
+// which differ from the addresses it listens on behind reverse proxies, port
+// mappings and TLS.
+package publicurl
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Resolver derives the public API and media URLs for a request.
+type Resolver struct {
+	BaseURL         string // Public API URL override, e.g. https://tv.example.com
+	MediaURL        string // Public media URL override, e.g. http://192.168.1.5:15004
+	MediaPort       int    // Plain HTTP media port
+	SecureMediaPort int    // HTTPS media port, 0 if there is none
+	TrustForwarded  bool   // Honor X-Forwarded-Host, -Proto and -Port
+}
+
+// Base returns the scheme and host the client used to reach the API, without
+// a trailing slash, e.g. "https://tv.example.com".
+func (r *Resolver) Base(req *http.Request) string {
+	if r.BaseURL != "" {
+		return strings.TrimSuffix(r.BaseURL, "/")
+	}
+	scheme, host, port := r.origin(req)
+	return scheme + "://" + joinHostPort(host, port, scheme)
+}
+
+// Media returns the scheme, host and port of the media server as seen by the
+// client, e.g. "http://tv.example.com:5004". Without an override the media
+// server is assumed to share the API's host name, using the HTTPS media port
+// for clients that arrived over HTTPS when there is one.
+func (r *Resolver) Media(req *http.Request) string {
+	if r.MediaURL != "" {
+		return strings.TrimSuffix(r.MediaURL, "/")
+	}
+
+	var scheme, host string
+	if r.BaseURL != "" {
+		if u, err := url.Parse(r.BaseURL); err == nil {
+			scheme, host = u.Scheme, u.Hostname()
+		}
+	} else {
+		scheme, host, _ = r.origin(req)
+	}
+
+	if scheme == "https" && r.SecureMediaPort > 0 {
+		return "https://" + joinHostPort(host, fmt.Sprint(r.SecureMediaPort), "https")
+	}
+	return "http://" + joinHostPort(host, fmt.Sprint(r.MediaPort), "http")
+}
+
+// origin returns the scheme, host name and port of the request as the client
+// sent it, taking forwarded headers into account when trusted.
+func (r *Resolver) origin(req *http.Request) (scheme, host, port string) {
+	scheme = "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	host, port = splitHostPort(req.Host)
+	if host == "" {
+		host = "localhost"
+	}
+
+	if r.TrustForwarded {
+		if proto := firstValue(req.Header.Get("X-Forwarded-Proto")); proto == "http" || proto == "https" {
+			if proto != scheme {
+				port = "" // The request's port belongs to the other scheme
+			}
+			scheme = proto
+		}
+		if forwarded := firstValue(req.Header.Get("X-Forwarded-Host")); forwarded != "" {
+			host, port = splitHostPort(forwarded)
+		}
+		if forwardedPort := firstValue(req.Header.Get("X-Forwarded-Port")); forwardedPort != "" {
+			port = forwardedPort
+		}
+	}
+	return scheme, host, port
+}
+
+// splitHostPort splits host[:port], leaving port empty when there is none.
+func splitHostPort(hostport string) (string, string) {
+	if host, port, err := net.SplitHostPort(hostport); err == nil {
+		return host, port
+	}
+	return strings.Trim(hostport, "[]"), ""
+}
+
+// joinHostPort joins a host and port, omitting the scheme's default port.
+func joinHostPort(host, port, scheme string) string {
+	if port == "" || (scheme == "http" && port == "80") || (scheme == "https" && port == "443") {
+		if strings.Contains(host, ":") {
+			return "[" + host + "]"
+		}
+		return host
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// firstValue returns the first entry of a comma-separated header added by a
+// chain of proxies.
+func firstValue(header string) string {
+	value, _, _ := strings.Cut(header, ",")
+	return strings.TrimSpace(value)
+}
@@ -0,0 +1,91 @@
+package publicurl
+
+import (
+	"crypto/tls"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResolver(t *testing.T) {
+	cases := []struct {
+		name      string
+		resolver  Resolver
+		host      string
+		secure    bool
+		headers   map[string]string
+		wantBase  string
+		wantMedia string
+	}{
+		{
+			name:      "plain request",
+			resolver:  Resolver{MediaPort: 5004},
+			host:      "192.168.1.5",
+			wantBase:  "http://192.168.1.5",
+			wantMedia: "http://192.168.1.5:5004",
+		},
+		{
+			name:      "TLS with an HTTPS media port",
+			resolver:  Resolver{MediaPort: 5004, SecureMediaPort: 5443},
+			host:      "proxy.vpn:8443",
+			secure:    true,
+			wantBase:  "https://proxy.vpn:8443",
+			wantMedia: "https://proxy.vpn:5443",
+		},
+		{
+			name:      "forwarded headers ignored unless trusted",
+			resolver:  Resolver{MediaPort: 5004},
+			host:      "10.0.0.2:8080",
+			headers:   map[string]string{"X-Forwarded-Host": "tv.example.com", "X-Forwarded-Proto": "https"},
+			wantBase:  "http://10.0.0.2:8080",
+			wantMedia: "http://10.0.0.2:5004",
+		},
+		{
+			name:      "trusted forwarded headers",
+			resolver:  Resolver{MediaPort: 5004, TrustForwarded: true},
+			host:      "10.0.0.2:8080",
+			headers:   map[string]string{"X-Forwarded-Host": "tv.example.com, traefik", "X-Forwarded-Proto": "https"},
+			wantBase:  "https://tv.example.com",
+			wantMedia: "http://tv.example.com:5004",
+		},
+		{
+			name:      "trusted forwarded port",
+			resolver:  Resolver{MediaPort: 5004, TrustForwarded: true},
+			host:      "10.0.0.2",
+			headers:   map[string]string{"X-Forwarded-Port": "8081"},
+			wantBase:  "http://10.0.0.2:8081",
+			wantMedia: "http://10.0.0.2:5004",
+		},
+		{
+			name:      "overrides",
+			resolver:  Resolver{BaseURL: "https://tv.example.com/", MediaURL: "http://192.168.1.5:15004/", MediaPort: 5004},
+			host:      "10.0.0.2",
+			wantBase:  "https://tv.example.com",
+			wantMedia: "http://192.168.1.5:15004",
+		},
+		{
+			name:      "media derived from base override",
+			resolver:  Resolver{BaseURL: "https://tv.example.com", MediaPort: 5004, SecureMediaPort: 5443},
+			host:      "10.0.0.2",
+			wantBase:  "https://tv.example.com",
+			wantMedia: "https://tv.example.com:5443",
+		},
+	}
+
+	for _, tc := range cases {
+		req := httptest.NewRequest("GET", "/lineup.json", nil)
+		req.Host = tc.host
+		if tc.secure {
+			req.TLS = &tls.ConnectionState{}
+		}
+		for name, value := range tc.headers {
+			req.Header.Set(name, value)
+		}
+
+		if got := tc.resolver.Base(req); got != tc.wantBase {
+			t.Errorf("%s: Base = %q, want %q", tc.name, got, tc.wantBase)
+		}
+		if got := tc.resolver.Media(req); got != tc.wantMedia {
+			t.Errorf("%s: Media = %q, want %q", tc.name, got, tc.wantMedia)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+// Package tlscert provides the certificate for the HTTPS listeners. It is read
+// from files, or generated once as a self-signed certificate and persisted, and
+// reloaded whenever the files change.
+package tlscert
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/attaebra/hdhr-proxy/internal/config"
+	"github.com/attaebra/hdhr-proxy/internal/interfaces"
+	"github.com/attaebra/hdhr-proxy/internal/logger"
+)
+
+// File names of the persisted self-signed certificate.
+const (
+	CertFileName = "cert.pem"
+	KeyFileName  = "key.pem"
+)
+
+// Self-signed certificates are valid for five years and replaced at startup
+// within 30 days of expiry.
+const (
+	selfSignedValidity = 5 * 365 * 24 * time.Hour
+	renewBefore        = 30 * 24 * time.Hour
+)
+
+// WatchInterval is how often the certificate files are checked for changes.
+var WatchInterval = 10 * time.Second
+
+// Settings select the certificate source.
+type Settings struct {
+	CertFile string   // PEM certificate chain; empty generates a self-signed certificate
+	KeyFile  string   // PEM private key for CertFile
+	Dir      string   // Where the self-signed certificate is kept
+	Hosts    []string // Extra host names and IPs for the self-signed certificate
+}
+
+// Source serves the current certificate to TLS handshakes.
+type Source struct {
+	certFile string
+	keyFile  string
+	cert     atomic.Pointer[tls.Certificate]
+	logger   interfaces.Logger
+}
+
+// New loads the configured certificate, generating and persisting a
+// self-signed one first when no files are configured.
+func New(settings Settings, log interfaces.Logger) (*Source, error) {
+	s := &Source{certFile: settings.CertFile, keyFile: settings.KeyFile, logger: log}
+
+	if s.certFile == "" {
+		if settings.Dir == "" {
+			return nil, errors.New("no certificate files or self-signed certificate directory configured")
+		}
+		s.certFile = filepath.Join(settings.Dir, CertFileName)
+		s.keyFile = filepath.Join(settings.Dir, KeyFileName)
+		if err := ensureSelfSigned(s.certFile, s.keyFile, settings.Hosts, time.Now(), log); err != nil {
+			return nil, fmt.Errorf("failed to create self-signed certificate: %w", err)
+		}
+	}
+
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload reads the certificate files again. On failure the previous
+// certificate stays in use.
+func (s *Source) Reload() error {
+	cert, err := tls.LoadX509KeyPair(s.certFile, s.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate %s: %w", s.certFile, err)
+	}
+
+	s.cert.Store(&cert)
+	s.logger.Info("🔐 TLS certificate loaded",
+		logger.String("cert_file", s.certFile),
+		logger.String("subject", cert.Leaf.Subject.CommonName),
+		logger.String("expires", cert.Leaf.NotAfter.Format(time.RFC3339)))
+	return nil
+}
+
+// TLSConfig returns a server configuration that always presents the current certificate.
+func (s *Source) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return s.cert.Load(), nil
+		},
+	}
+}
+
+// Watch reloads the certificate whenever either file changes, until ctx is
+// canceled. A certificate and key replaced one after the other are picked up
+// once both match.
+func (s *Source) Watch(ctx context.Context) {
+	reload := func() {
+		if err := s.Reload(); err != nil {
+			s.logger.Warn("⚠️  TLS certificate reload failed, keeping current certificate", logger.ErrorField("error", err))
+		}
+	}
+	go config.WatchFile(ctx, s.certFile, WatchInterval, reload)
+	go config.WatchFile(ctx, s.keyFile, WatchInterval, reload)
+}
+
+// ensureSelfSigned writes a new self-signed certificate unless a usable one
+// already exists.
+func ensureSelfSigned(certFile, keyFile string, hosts []string, now time.Time, log interfaces.Logger) error {
+	if cert, err := tls.LoadX509KeyPair(certFile, keyFile); err == nil && cert.Leaf.NotAfter.After(now.Add(renewBefore)) {
+		return nil
+	}
+
+	certPEM, keyPEM, err := SelfSigned(append(localHosts(), hosts...), now)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(certFile), 0o700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+		return err
+	}
+	if err := os.WriteFile(certFile, certPEM, 0o644); err != nil {
+		return err
+	}
+
+	log.Info("🔐 Generated self-signed TLS certificate", logger.String("cert_file", certFile))
+	return nil
+}
+
+// SelfSigned creates a PEM-encoded self-signed server certificate and key
+// for the given host names and IP addresses.
+func SelfSigned(hosts []string, now time.Time) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "hdhr-proxy", Organization: []string{"hdhr-proxy"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	seen := make(map[string]bool)
+	for _, host := range hosts {
+		if host == "" || seen[host] {
+			continue
+		}
+		seen[host] = true
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
+
+// localHosts returns the names and addresses this machine is likely reached by.
+func localHosts() []string {
+	hosts := []string{"localhost"}
+	if name, err := os.Hostname(); err == nil {
+		hosts = append(hosts, name)
+	}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if network, ok := addr.(*net.IPNet); ok {
+				hosts = append(hosts, network.IP.String())
+			}
+		}
+	}
+	return hosts
+}
@@ -0,0 +1,110 @@
+package tlscert
+
+import (
+	"context"
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/attaebra/hdhr-proxy/internal/logger"
+)
+
+// current returns the certificate the TLS config presents.
+func current(t *testing.T, s *Source) *tls.Certificate {
+	t.Helper()
+	cert, err := s.TLSConfig().GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil || cert == nil {
+		t.Fatalf("No certificate: %v", err)
+	}
+	return cert
+}
+
+func TestSelfSignedIsPersisted(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "tls")
+	log := logger.NewZapLogger(logger.LevelDebug)
+
+	first, err := New(Settings{Dir: dir, Hosts: []string{"proxy.vpn", "10.8.0.1"}}, log)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	leaf := current(t, first).Leaf
+	if err := leaf.VerifyHostname("proxy.vpn"); err != nil {
+		t.Errorf("Expected certificate for proxy.vpn: %v", err)
+	}
+	if err := leaf.VerifyHostname("10.8.0.1"); err != nil {
+		t.Errorf("Expected certificate for 10.8.0.1: %v", err)
+	}
+	if info, err := os.Stat(filepath.Join(dir, KeyFileName)); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("Expected private key with mode 0600, got %v (%v)", info, err)
+	}
+
+	// A restart reuses the persisted certificate
+	second, err := New(Settings{Dir: dir}, log)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if current(t, second).Leaf.SerialNumber.Cmp(leaf.SerialNumber) != 0 {
+		t.Error("Expected the persisted certificate to be reused")
+	}
+}
+
+func TestReloadOnFileChange(t *testing.T) {
+	WatchInterval = 10 * time.Millisecond
+	defer func() { WatchInterval = 10 * time.Second }()
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "proxy.crt"), filepath.Join(dir, "proxy.key")
+	write := func(host string) {
+		certPEM, keyPEM, err := SelfSigned([]string{host}, time.Now())
+		if err != nil {
+			t.Fatalf("SelfSigned failed: %v", err)
+		}
+		if err := os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(certFile, certPEM, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("old.example")
+
+	source, err := New(Settings{CertFile: certFile, KeyFile: keyFile}, logger.NewZapLogger(logger.LevelDebug))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	source.Watch(ctx)
+
+	// Let the watchers record the original files, then replace them
+	time.Sleep(50 * time.Millisecond)
+	write("new.example")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for current(t, source).Leaf.VerifyHostname("new.example") != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the certificate to be reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// A broken replacement keeps the current certificate
+	if err := os.WriteFile(certFile, []byte("not a certificate"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := source.Reload(); err == nil {
+		t.Error("Expected reload of an invalid certificate to fail")
+	}
+	if current(t, source).Leaf.VerifyHostname("new.example") != nil {
+		t.Error("Expected the previous certificate to stay in use")
+	}
+}
+
+func TestMissingFiles(t *testing.T) {
+	_, err := New(Settings{CertFile: "/nonexistent/cert.pem", KeyFile: "/nonexistent/key.pem"}, logger.NewZapLogger(logger.LevelDebug))
+	if err == nil {
+		t.Error("Expected an error for missing certificate files")
+	}
+}
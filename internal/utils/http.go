@@ -7,6 +7,7 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -65,14 +66,44 @@ func BuildAPIURL(host, path string) string {
 	return url
 }
 
-// BuildMediaURL constructs a URL for media streaming endpoints.
-func BuildMediaURL(host, channel string) string {
-	url := fmt.Sprintf("http://%s:%d/auto/v%s", host, constants.DefaultMediaPort, channel)
+// BuildMediaURL constructs a URL for a channel's stream on host's media port.
+func BuildMediaURL(host string, port int, channel string) string {
+	url := fmt.Sprintf("http://%s/auto/v%s", net.JoinHostPort(host, strconv.Itoa(port)), channel)
 	logger.Debug("🎬 Built media URL",
 		logger.String("url", url))
 	return url
 }
 
+// DeviceMediaHost returns the host:port a device serves streams on. The device
+// address may carry the port of the device's API, which is replaced.
+func DeviceMediaHost(device string, port int) string {
+	if host, _, err := net.SplitHostPort(device); err == nil {
+		device = host
+	}
+	return net.JoinHostPort(device, strconv.Itoa(port))
+}
+
+// ClientIP returns the IP address of the client that made the request.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// RequestToken returns the API token from the "token" query parameter or a
+// bearer Authorization header, or an empty string if none was sent.
+func RequestToken(r *http.Request) string {
+	if token := r.URL.Query().Get("token"); token != "" {
+		return token
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+	return ""
+}
+
 // SendRequest sends an HTTP request with timing and logging.
 func SendRequest(client *http.Client, method, url string, body io.Reader) (*http.Response, error) {
 	logger.Debug("📡 Sending HTTP request",
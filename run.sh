@@ -1,9 +1,8 @@
 #!/bin/bash
 
-# Make sure the HDHR_IP environment variable is set
+# Without HDHR_IP the device is discovered on the LAN (needs --network host)
 if [ -z "$HDHR_IP" ]; then
-  echo "HDHR_IP environment variable not set"
-  exit 1
+  echo "HDHR_IP environment variable not set, discovering the HDHomeRun${DEVICE_ID:+ $DEVICE_ID}"
 fi
 
 # Download the correct Emby version for this architecture
@@ -37,4 +36,4 @@ mv opt/emby-server/extra/lib/libOpenCL.so.* /usr/lib/ || true
 
 # Start the server
 echo "Starting hdhr-proxy..."
-/app/hdhr-proxy -hdhr-ip $HDHR_IP -log-level $LOG_LEVEL
\ No newline at end of file
+/app/hdhr-proxy -log-level $LOG_LEVEL
\ No newline at end of file